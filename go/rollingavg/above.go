@@ -0,0 +1,73 @@
+// above.go: rolling count of values above a threshold
+//
+// for exceedance analysis, -count-above lists columns, by name or index,
+// and thresholds, as col:threshold, e.g.
+//     -count-above "X:30,Y:-140"
+// the number of values of the column above the threshold in each row's
+// window is output, as a column named for them, e.g. "Count X>30",
+// following the input columns, see windowcols.go. values that aren't
+// numbers aren't counted
+
+
+package main
+
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+var countAbove string
+
+
+// the -count-above columns of an input header
+func countAboveColumns(header []string) []windowColumn {
+	var cols []windowColumn
+	for _, item := range strings.Split(countAbove, ",") {
+		i := strings.LastIndex(item, ":")
+		if i < 0 {
+			fatal("invalid count above, expected col:threshold", "count_above", item)
+		}
+		col := findColumn(header, strings.TrimSpace(item[:i]))
+		if col < 0 {
+			fatal("count above column not found in header", "column", item[:i])
+		}
+		t := strings.TrimSpace(item[i+1:])
+		threshold, err := rollingavg.ParseFloat(t)
+		if err != nil {
+			fatal("invalid count above threshold", "count_above", item)
+		}
+		cols = append(cols, windowColumn{col, "Count " + header[col] + ">" + t,
+			func() windowStat { return &aboveCounter{threshold: threshold} }})
+	}
+	return cols
+}
+
+
+// the count of values above a threshold in a window
+type aboveCounter struct {
+	threshold float64
+	n         int
+}
+
+
+func (c *aboveCounter) add(v string) {
+	if f, err := rollingavg.ParseFloat(v); err == nil && f > c.threshold {
+		c.n++
+	}
+}
+
+
+func (c *aboveCounter) remove(v string) {
+	if f, err := rollingavg.ParseFloat(v); err == nil && f > c.threshold {
+		c.n--
+	}
+}
+
+
+func (c *aboveCounter) value() string {
+	return strconv.Itoa(c.n)
+}
@@ -0,0 +1,164 @@
+// acf.go: autocorrelation and partial autocorrelation of a column
+//
+// to help choose window sizes, e.g. a window spanning the lags over which
+// values stay correlated, invoked as the acf subcommand, see commands.go:
+//     mdp acf [-v] -c col [-max-lag nrows] [-f inputfile]... [-o outputfile] [inputfile...]
+// the autocorrelation (ACF) and partial autocorrelation (PACF) of the -c
+// column's numeric values are output for each lag from 0 to -max-lag
+// (default 40), with the bound of the 95% confidence interval of a lag's
+// correlation for white noise, 1.96/sqrt(n), e.g.
+//     Lag,ACF,PACF,Bound
+//     0,1,1,0.062
+//     1,0.72,0.72,0.062
+//     2,0.51,-0.02,0.062
+// correlations beyond the bound are significant. a slowly decaying ACF
+// suggests a trend, see trend.go, and a PACF that cuts off after lag p an
+// AR(p) process, see forecast.go.
+// the ACF is the standard biased estimate, of the values less their mean,
+// and the PACF is by the Durbin-Levinson recursion. values that aren't
+// numbers are skipped, and the values are held in memory
+
+
+package main
+
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"flag"
+	"io"
+	"log/slog"
+	"math"
+	"strconv"
+)
+
+
+// the autocorrelations of values at lags 0 to maxLag
+func autocorrelations(values []float64, maxLag int) []float64 {
+	mean := 0.0
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	var c0 float64
+	for _, v := range values {
+		c0 += (v - mean) * (v - mean)
+	}
+	acf := make([]float64, maxLag+1)
+	for k := range acf {
+		var ck float64
+		for t := 0; t+k < len(values); t++ {
+			ck += (values[t] - mean) * (values[t+k] - mean)
+		}
+		acf[k] = ck / c0
+	}
+	return acf
+}
+
+
+// the partial autocorrelations of autocorrelations acf, by the
+// Durbin-Levinson recursion
+func partialAutocorrelations(acf []float64) []float64 {
+	pacf := make([]float64, len(acf))
+	pacf[0] = 1
+	// the coefficients of the AR fit of each order
+	phi := make([]float64, 0, len(acf))
+	for k := 1; k < len(acf); k++ {
+		num, den := acf[k], 1.0
+		for j, c := range phi {
+			num -= c * acf[k-j-1]
+			den -= c * acf[j+1]
+		}
+		if den == 0 {
+			break
+		}
+		pk := num / den
+		next := make([]float64, k)
+		for j := range phi {
+			next[j] = phi[j] - pk*phi[k-j-2]
+		}
+		next[k-1] = pk
+		phi = next
+		pacf[k] = pk
+	}
+	return pacf
+}
+
+
+func runACF(args []string) {
+	fs := flag.NewFlagSet("acf", flag.ExitOnError)
+	column := fs.String("c", "", "column (name or index) of the values")
+	maxLag := fs.Int("max-lag", 40, "largest lag, in rows")
+	commonFlags(fs, "the correlations at each lag")
+	fs.Parse(args)
+	infilenames = append(infilenames, fs.Args()...)
+	setupLogging()
+
+	switch {
+	case *column == "":
+		fatal("-c column is required")
+	case *maxLag < 0:
+		fatal("invalid maximum lag", "max-lag", *maxLag)
+	}
+
+	slog.Debug("autocorrelation of CSV column",
+		"inputs", infilenames, "output", outfilename, "column", *column, "max_lag", *maxLag)
+
+	infile := openInputs(context.Background(), infilenames, false, nil)
+	defer infile.Close()
+
+	oufl := createOutput(outfilename, "")
+	outfile := csv.NewWriter(bufio.NewWriter(oufl))
+
+	header, err := infile.Read()
+	if err != nil {
+		fatal("error reading header from csv", "err", err)
+	}
+	col := findColumn(header, *column)
+	if col < 0 {
+		fatal("column not found in header", "column", *column)
+	}
+
+	var values []float64
+	for {
+		record, err := infile.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fatal("error reading record from csv", "err", err)
+		}
+		if v := numericField(record, col); !math.IsNaN(v) {
+			values = append(values, v)
+		}
+	}
+	slog.Debug("read values", "values", len(values))
+	if len(values) <= *maxLag {
+		fatal("too few values for the maximum lag", "values", len(values), "max-lag", *maxLag)
+	}
+
+	acf := autocorrelations(values, *maxLag)
+	if math.IsNaN(acf[0]) {
+		fatal("the values have no spread to correlate", "column", *column)
+	}
+	pacf := partialAutocorrelations(acf)
+	bound := strconv.FormatFloat(1.96/math.Sqrt(float64(len(values))), 'f', -1, 64)
+	format := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	if err := outfile.Write([]string{"Lag", "ACF", "PACF", "Bound"}); err != nil {
+		fatal("error writing record to csv", "err", err)
+	}
+	for k := range acf {
+		if err := outfile.Write([]string{strconv.Itoa(k), format(acf[k]), format(pacf[k]), bound}); err != nil {
+			fatal("error writing record to csv", "err", err)
+		}
+	}
+
+	outfile.Flush()
+	if err := outfile.Error(); err != nil {
+		fatal("error writing csv", "err", err)
+	}
+	if err := oufl.Close(); err != nil {
+		fatal("error closing destination csv", "err", err)
+	}
+}
@@ -0,0 +1,40 @@
+// adaptive.go: adaptive window lengths
+//
+// with -adaptive nrows, row windows are adaptive, each row's window being
+// from that many rows to -n nrows, shrinking as A's values move in one
+// direction, e.g. in volatile swings, and growing as they're flat or noisy,
+// e.g. in quiet periods, by the efficiency ratio of KAMA over the -n rows
+// starting at the row, see rollingavg/adaptive.go, e.g.
+//     rollingavg -n 40 -adaptive 5 in.csv
+// each row is output with its window's length, in a Window Length column
+// following the input columns, e.g.
+//     X,Y,Z,Time,Window Length,Average A,Average B,Result
+// the windows of A and B are of the same length, so -nb can't be used,
+// and adaptive windows aren't wrapped for extra window columns, so -mode,
+// -distinct, -count-above, -window-bounds, extra -stats, -anchor and
+// -window-sample can't be used, nor can -parallel, whose chunks overlap by the window length, or
+// -append, which continues windows from the output rows
+
+
+package main
+
+
+var adaptiveMin int
+
+
+// check that -adaptive is valid with the current options
+func checkAdaptive() {
+	switch {
+	case adaptiveMin == 0:
+	case adaptiveMin < 0 || adaptiveMin > nrows:
+		fatal("invalid adaptive window, expected from 1 to -n rows", "adaptive", adaptiveMin, "n", nrows)
+	case windowUnit != "rows":
+		fatal("only row windows can be adaptive", "window-unit", windowUnit)
+	case nrowsB != 0 && nrowsB != nrows:
+		fatal("-adaptive can't be used with -nb")
+	case windowsWrapped():
+		fatal("-adaptive can't be used with -mode, -distinct, -count-above, -window-bounds, extra -stats, -anchor or -window-sample")
+	case parallelWorkers > 1 || appendFlag:
+		fatal("-adaptive can't be used with -parallel or -append")
+	}
+}
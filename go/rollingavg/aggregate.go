@@ -0,0 +1,195 @@
+// aggregate.go: roll CSV rows up to per-day or per-week summaries
+//
+// a companion to the row-level rolling averages, invoked as the aggregate
+// subcommand, see commands.go:
+//     rollingavg aggregate [-v] [-period day|week] [-time col] [-f inputfile]... [-o outputfile] [inputfile...]
+// for each period outputs the period start date, row count, and the
+// mean, min and max of every numeric column (other than the time column).
+// numeric columns are determined from the first data row.
+// weeks are ISO weeks, starting on Monday
+
+
+package main
+
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"flag"
+	"io"
+	"log/slog"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+// layout of the Date Time column, fractional seconds are accepted when parsing
+const timeLayout = rollingavg.TimeLayout
+
+
+// running summary of a single column over a period
+type colSummary struct {
+	sum float64
+	min float64
+	max float64
+}
+
+
+// summary of all numeric columns over a period
+type periodSummary struct {
+	count int
+	cols  []colSummary
+}
+
+
+func runAggregate(args []string) {
+	fs := flag.NewFlagSet("aggregate", flag.ExitOnError)
+	period := fs.String("period", "day", "aggregation period: day or week")
+	timecol := fs.String("time", "3", "timestamp column (name or index)")
+	commonFlags(fs, "aggregates")
+	fs.Parse(args)
+	infilenames = append(infilenames, fs.Args()...)
+	setupLogging()
+
+	if *period != "day" && *period != "week" {
+		fatal("invalid aggregation period", "period", *period)
+	}
+
+	slog.Debug("aggregate CSV rows by period",
+		"inputs", infilenames, "output", outfilename, "period", *period, "time_column", *timecol)
+
+	infile := openInputs(context.Background(), infilenames, false, nil)
+	defer infile.Close()
+
+	oufl := createOutput(outfilename, "")
+	outfile := csv.NewWriter(bufio.NewWriter(oufl))
+
+	header, err := infile.Read()
+	if err != nil {
+		fatal("error reading header from csv", "err", err)
+	}
+	tcol := findColumn(header, *timecol)
+	if tcol < 0 {
+		fatal("time column not found in header", "column", *timecol)
+	}
+
+	genAggregates(infile, outfile, header, tcol, *period)
+
+	outfile.Flush()
+	if err := outfile.Error(); err != nil {
+		fatal("error writing csv", "err", err)
+	}
+	if err := oufl.Close(); err != nil {
+		fatal("error closing destination csv", "err", err)
+	}
+}
+
+
+// return the start date of the period containing t
+func periodStart(t time.Time, period string) string {
+	if period == "week" {
+		// ISO weeks start on Monday
+		offset := (int(t.Weekday()) + 6) % 7
+		t = t.AddDate(0, 0, -offset)
+	}
+	return t.Format("2006-01-02")
+}
+
+
+// summarise incsv rows per period, and write one row per period to outcsv
+func genAggregates(incsv recordReader, outcsv *csv.Writer, header []string, tcol int, period string) {
+	var numcols []int
+	periods := make(map[string]*periodSummary)
+	n := 0
+	for {
+		record, err := incsv.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fatal("error reading record from csv", "err", err)
+		}
+
+		if verboseFlag {
+			slog.Debug("read record", "n", n, "record", record)
+		}
+
+		// numeric columns are those that parse as numbers in the first row
+		if numcols == nil {
+			numcols = []int{}
+			for i, v := range record {
+				if _, err := strconv.ParseFloat(v, 64); i != tcol && err == nil {
+					numcols = append(numcols, i)
+				}
+			}
+			slog.Debug("numeric columns", "columns", numcols)
+		}
+
+		t, err := rollingavg.ParseTime(record[tcol])
+		if err != nil {
+			fatal("invalid timestamp in csv", "err", err)
+		}
+		key := periodStart(t, period)
+
+		p, found := periods[key]
+		if !found {
+			p = &periodSummary{cols: make([]colSummary, len(numcols))}
+			for i := range p.cols {
+				p.cols[i].min = math.Inf(1)
+				p.cols[i].max = math.Inf(-1)
+			}
+			periods[key] = p
+		}
+
+		p.count++
+		for i, c := range numcols {
+			v, err := rollingavg.ParseFloat(record[c])
+			if err != nil {
+				fatal("invalid column value in csv", "err", err)
+			}
+			p.cols[i].sum += v
+			p.cols[i].min = math.Min(p.cols[i].min, v)
+			p.cols[i].max = math.Max(p.cols[i].max, v)
+		}
+		n++
+	}
+
+	outrec := []string{"Period", "Count"}
+	for _, c := range numcols {
+		outrec = append(outrec, header[c]+" Mean", header[c]+" Min", header[c]+" Max")
+	}
+	if err := outcsv.Write(outrec); err != nil {
+		fatal("error writing record to csv", "err", err)
+	}
+
+	// output periods in chronological order, which date strings sort into
+	keys := make([]string, 0, len(periods))
+	for k := range periods {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		p := periods[k]
+		outrec = []string{k, strconv.Itoa(p.count)}
+		for _, c := range p.cols {
+			mean := c.sum / float64(p.count)
+			outrec = append(outrec,
+				strconv.FormatFloat(mean, 'f', -1, 64),
+				strconv.FormatFloat(c.min, 'f', -1, 64),
+				strconv.FormatFloat(c.max, 'f', -1, 64))
+		}
+		if verboseFlag {
+			slog.Debug("write record", "record", outrec)
+		}
+		if err := outcsv.Write(outrec); err != nil {
+			fatal("error writing record to csv", "err", err)
+		}
+	}
+
+	slog.Debug("aggregated records", "records", n, "periods", len(periods))
+}
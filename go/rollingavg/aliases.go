@@ -0,0 +1,141 @@
+// aliases.go: short names for columns
+//
+// real headers have spaces and units, e.g. "Temperature (deg C)", which
+// are awkward to write in expressions. -alias gives a column a short name,
+// usable wherever a column is named: in the filter, drop and derive
+// expressions of config file stages, see stages.go, the row of a -script,
+// see script.go, and flags such as -group-by and -time, e.g.
+//     -alias "t=Temperature (deg C)" -alias "h=Rel Humidity"
+// with the stage
+//     - derive:
+//         column: Dew Point
+//         expr: row["t"] - (100 - row["h"]) / 5
+// aliases are simple names, of letters, digits and _, and may be given
+// comma separated, or in a -config file as columns aliases, e.g.
+//     columns:
+//       aliases:
+//         t: Temperature (deg C)
+// a header column of the same name takes precedence over an alias
+
+
+package main
+
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+var aliasSpecs stringList
+
+
+// the columns of the aliases, by alias, parsed from -alias on first use
+var columnAliases map[string]string
+
+
+// parse the -alias definitions, name=column, exiting if any are invalid
+func parseAliases() map[string]string {
+	aliases := make(map[string]string)
+	for _, spec := range aliasSpecs {
+		for _, def := range splitAliases(spec) {
+			name, col, ok := strings.Cut(def, "=")
+			name, col = strings.TrimSpace(name), strings.TrimSpace(col)
+			if !ok || !isAliasName(name) || col == "" {
+				fatal("invalid column alias, expected name=column", "alias", def)
+			}
+			if other, dup := aliases[name]; dup && other != col {
+				fatal("column alias given twice", "alias", name, "columns", []string{other, col})
+			}
+			aliases[name] = col
+		}
+	}
+	return aliases
+}
+
+
+// split comma separated alias definitions, the commas of column names
+// being those not followed by a name=
+func splitAliases(spec string) []string {
+	var defs []string
+	for _, part := range strings.Split(spec, ",") {
+		name, _, ok := strings.Cut(part, "=")
+		if len(defs) > 0 && !(ok && isAliasName(strings.TrimSpace(name))) {
+			defs[len(defs)-1] += "," + part
+			continue
+		}
+		defs = append(defs, part)
+	}
+	return defs
+}
+
+
+// whether a name can be an alias, a simple name as in filter expressions
+func isAliasName(name string) bool {
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		return false
+	}
+	for _, c := range name {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+
+// the aliases of the columns, by alias
+func aliases() map[string]string {
+	if columnAliases == nil {
+		columnAliases = parseAliases()
+	}
+	return columnAliases
+}
+
+
+// the column of an alias, or "" if name isn't one
+func aliasColumn(name string) string {
+	if len(aliasSpecs) == 0 {
+		return ""
+	}
+	return aliases()[name]
+}
+
+
+// the column a header column, or its alias, names, as for the package's
+// options
+func unalias(header []string, col string) string {
+	if rollingavg.FindColumn(header, col) < 0 {
+		if aliased := aliasColumn(col); aliased != "" {
+			return aliased
+		}
+	}
+	return col
+}
+
+
+// check the aliased columns are in the header
+func checkAliases(header []string) {
+	for name, col := range aliases() {
+		if findColumn(header, col) < 0 {
+			fatal("aliased column not found in header", "alias", name, "column", col)
+		}
+	}
+}
+
+
+// the aliases of the header's columns, by column index
+func headerAliases(header []string) map[int][]string {
+	byCol := make(map[int][]string)
+	for name, col := range aliases() {
+		if i := rollingavg.FindColumn(header, col); i >= 0 && rollingavg.FindColumn(header, name) < 0 {
+			byCol[i] = append(byCol[i], name)
+		}
+	}
+	for _, names := range byCol {
+		sort.Strings(names)
+	}
+	return byCol
+}
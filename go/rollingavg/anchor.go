@@ -0,0 +1,46 @@
+// anchor.go: the row a window's statistics are output with
+//
+// windows are forward looking, so each row is output with the statistics
+// of the window starting at it. -anchor end outputs each row with the
+// statistics of the window ending at it instead, a trailing window, and
+// -anchor center with those of the window centered on it, the row after
+// the middle of windows of an even number of rows, e.g. for n = 4 the
+// window of the 2 rows before and the row after.
+// with end or center, the rows without a complete window are those at the
+// start of each series, rather than at the end, and are dropped, so -tail
+// can't output them, and -append, which continues the windows of the rows
+// not yet output, can't be used.
+// windows are wrapped to keep their rows, see windowcols.go, so -anchor can
+// only be start, the default, with -parallel or -max-mem
+
+
+package main
+
+
+var anchor string
+
+
+// check that the -anchor is valid with the current options
+func checkAnchor() {
+	switch anchor {
+	case "start":
+	case "end", "center":
+		if tailPolicy != "drop" || appendFlag {
+			fatal("-anchor end or center can't be used with -tail or -append", "anchor", anchor)
+		}
+	default:
+		fatal("invalid anchor", "anchor", anchor)
+	}
+}
+
+
+// the index of the row a window of n rows is output with
+func anchorRow(n int) int {
+	switch anchor {
+	case "end":
+		return n - 1
+	case "center":
+		return n / 2
+	}
+	return 0
+}
@@ -0,0 +1,163 @@
+// append.go: append to an existing output, continuing the rolling windows
+//
+// a run only outputs rows once their (forward looking) window is complete,
+// so the last rows of each series are left buffered at the end of a run.
+// in append mode these rows are saved to a tail file alongside the output,
+//     outputfile.tail
+// and the next append run feeds them back in ahead of its own input, so a
+// series of daily incremental runs produce the same averages as one big
+// run over all the days. the output header is only written when the output
+// file is new or empty.
+// with -group-by, rows of different groups may be interleaved differently
+// than in one big run, but each row gets the same averages
+
+
+package main
+
+
+import (
+	"encoding/csv"
+	"log/slog"
+	"os"
+	"slices"
+	"sort"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+func tailFilename(outfilename string) string {
+	return outfilename + ".tail"
+}
+
+
+// read the rows left buffered by the previous run, checking the tail file's
+// header matches this run's input header. returns nil if there is no tail
+func readTail(outfilename string, header []string) [][]string {
+	fl, err := os.Open(tailFilename(outfilename))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		fatal("error opening tail file", "err", err)
+	}
+	defer fl.Close()
+
+	rows, err := csv.NewReader(fl).ReadAll()
+	if err != nil {
+		fatal("error reading tail file", "err", err)
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	if !slices.Equal(rows[0], header) {
+		fatal("header of tail file does not match input", "header", rows[0])
+	}
+	slog.Debug("seeding windows with rows from previous run", "rows", len(rows)-1)
+	return rows[1:]
+}
+
+
+// save the rows still buffered in the windows for the next run
+func writeTail(outfilename string, header []string, windows map[string]rollingavg.Window) {
+	fl, err := os.Create(tailFilename(outfilename))
+	if err != nil {
+		fatal("error creating tail file", "err", err)
+	}
+	w := csv.NewWriter(fl)
+	w.Write(header)
+
+	// write groups in a consistent order
+	keys := make([]string, 0, len(windows))
+	for k := range windows {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	n := 0
+	for _, k := range keys {
+		for _, record := range windows[k].Pending() {
+			w.Write(record)
+			n++
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		fatal("error writing tail file", "err", err)
+	}
+	if err := fl.Close(); err != nil {
+		fatal("error closing tail file", "err", err)
+	}
+	slog.Debug("saved buffered rows to tail file", "rows", n)
+}
+
+
+// returns the input header, then the seed rows, then the rest of the input
+type seededReader struct {
+	in      recordReader
+	seed    [][]string
+	started bool
+}
+
+func (s *seededReader) Read() ([]string, error) {
+	if !s.started {
+		s.started = true
+		return s.in.Read()
+	}
+	if len(s.seed) > 0 {
+		record := s.seed[0]
+		s.seed = s.seed[1:]
+		return record, nil
+	}
+	return s.in.Read()
+}
+
+
+// drops the first record written, the header, when appending to a
+// non-empty output
+type skipHeaderWriter struct {
+	recordWriter
+	skipped bool
+}
+
+func (s *skipHeaderWriter) Write(record []string) error {
+	if !s.skipped {
+		s.skipped = true
+		return nil
+	}
+	return s.recordWriter.Write(record)
+}
+
+
+// a CSV output appended to, rather than replacing, an existing file
+type appendCSVOutput struct {
+	*skipHeaderWriter
+	csvout *csvOutput
+}
+
+func (a *appendCSVOutput) Close() error {
+	return a.csvout.Close()
+}
+
+
+// open outfilename for appending, creating it if need be
+func openAppendOutput(outfilename string, compression string) recordWriteCloser {
+	if outfilename == "" || isRemote(outfilename) {
+		fatal("append mode requires a local output file")
+	}
+	fl, err := os.OpenFile(outfilename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		fatal("error opening destination csv", "err", err)
+	}
+	fi, err := fl.Stat()
+	if err != nil {
+		fatal("error opening destination csv", "err", err)
+	}
+
+	// concatenated gzip members and zstd frames are still valid streams
+	csvout := newCSVOutputFile(compressOutput(fl, outfilename, compression))
+	if fi.Size() == 0 {
+		return csvout
+	}
+	return &appendCSVOutput{&skipHeaderWriter{recordWriter: csvout}, csvout}
+}
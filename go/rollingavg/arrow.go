@@ -0,0 +1,232 @@
+// arrow.go: conversion between CSV records and Apache Arrow record batches
+//
+// used by the columnar input and output formats. output column types, also
+// of the protobuf and MessagePack formats, see records.go, are given by
+// -column-types as a list of name:type, where type is one of
+//     string, double, int64, bool, timestamp
+// columns not listed are typed from the first data row: double if the
+// value is a number, otherwise string. values that don't parse as their
+// column's type are written as nulls.
+// timestamps use the Date Time column layout, with millisecond precision
+
+
+package main
+
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+// number of rows per record batch written
+const arrowBatchSize = 64 * 1024
+
+
+// parse a -column-types list of name:type into a map of column name to type
+func parseColumnTypes(spec string) map[string]string {
+	types := make(map[string]string)
+	if spec == "" {
+		return types
+	}
+	for _, item := range strings.Split(spec, ",") {
+		name, typ, ok := strings.Cut(item, ":")
+		if !ok {
+			fatal("invalid column type, expected name:type", "item", item)
+		}
+		types[strings.TrimSpace(name)] = strings.TrimSpace(typ)
+	}
+	return types
+}
+
+
+// make the arrow data type for a column type name
+func arrowType(typ string) (arrow.DataType, error) {
+	switch typ {
+	case "string":
+		return arrow.BinaryTypes.String, nil
+	case "double":
+		return arrow.PrimitiveTypes.Float64, nil
+	case "int64":
+		return arrow.PrimitiveTypes.Int64, nil
+	case "bool":
+		return arrow.FixedWidthTypes.Boolean, nil
+	case "timestamp":
+		return &arrow.TimestampType{Unit: arrow.Millisecond}, nil
+	}
+	return nil, fmt.Errorf("invalid column type: %s", typ)
+}
+
+
+// the type of the i'th column, name, from the types map or, failing that,
+// from its value in the first data row
+func columnType(i int, name string, types map[string]string, first []string) string {
+	if typ, found := types[name]; found {
+		return typ
+	}
+	if i < len(first) {
+		if _, err := strconv.ParseFloat(first[i], 64); err == nil {
+			return "double"
+		}
+	}
+	return "string"
+}
+
+
+// make an arrow schema for the header, typing columns from the types map
+// or, failing that, from the values of the first data row
+func arrowSchema(header []string, types map[string]string, first []string) *arrow.Schema {
+	fields := make([]arrow.Field, len(header))
+	for i, name := range header {
+		dt, err := arrowType(columnType(i, name, types, first))
+		if err != nil {
+			fatal("invalid column type", "err", err)
+		}
+		fields[i] = arrow.Field{Name: name, Type: dt, Nullable: true}
+	}
+	return arrow.NewSchema(fields, nil)
+}
+
+
+// append a CSV value to an arrow column builder, as null if it doesn't parse
+func appendArrowValue(b array.Builder, v string) {
+	switch b := b.(type) {
+	case *array.StringBuilder:
+		b.Append(v)
+	case *array.Float64Builder:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			b.Append(f)
+			return
+		}
+		b.AppendNull()
+	case *array.Int64Builder:
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			b.Append(n)
+			return
+		}
+		b.AppendNull()
+	case *array.BooleanBuilder:
+		if t, err := strconv.ParseBool(v); err == nil {
+			b.Append(t)
+			return
+		}
+		b.AppendNull()
+	case *array.TimestampBuilder:
+		if t, err := time.Parse(timeLayout, v); err == nil {
+			b.Append(arrow.Timestamp(t.UnixMilli()))
+			return
+		}
+		b.AppendNull()
+	default:
+		b.AppendNull()
+	}
+}
+
+
+// format the i'th value of an arrow column as a CSV value, "" for nulls
+func arrowValueStr(arr arrow.Array, i int) string {
+	if arr.IsNull(i) {
+		return ""
+	}
+	switch a := arr.(type) {
+	case *array.Float64:
+		return strconv.FormatFloat(a.Value(i), 'f', -1, 64)
+	case *array.Float32:
+		return strconv.FormatFloat(float64(a.Value(i)), 'f', -1, 32)
+	case *array.Timestamp:
+		unit := a.DataType().(*arrow.TimestampType).Unit
+		return a.Value(i).ToTime(unit).UTC().Format(timeLayout + ".000")
+	}
+	return arr.ValueStr(i)
+}
+
+
+// return the records of an arrow record batch as CSV records
+func arrowRecords(rec arrow.RecordBatch) [][]string {
+	records := make([][]string, rec.NumRows())
+	for r := range records {
+		record := make([]string, rec.NumCols())
+		for c := range record {
+			record[c] = arrowValueStr(rec.Column(c), r)
+		}
+		records[r] = record
+	}
+	return records
+}
+
+
+// collects CSV records into arrow record batches, passing each full batch
+// to write. the first record is taken as the header, and the schema is
+// made once the first data row is seen
+type arrowBatcher struct {
+	types   map[string]string
+	header  []string
+	schema  *arrow.Schema
+	builder *array.RecordBuilder
+	rows    int
+	write   func(schema *arrow.Schema, rec arrow.RecordBatch) error
+}
+
+
+func newArrowBatcher(types map[string]string, write func(*arrow.Schema, arrow.RecordBatch) error) *arrowBatcher {
+	return &arrowBatcher{types: types, write: write}
+}
+
+
+func (a *arrowBatcher) add(record []string) error {
+	if a.header == nil {
+		a.header = make([]string, len(record))
+		copy(a.header, record)
+		return nil
+	}
+	if a.schema == nil {
+		a.schema = arrowSchema(a.header, a.types, record)
+		a.builder = array.NewRecordBuilder(memory.DefaultAllocator, a.schema)
+	}
+
+	for i, b := range a.builder.Fields() {
+		if i < len(record) {
+			appendArrowValue(b, record[i])
+		} else {
+			b.AppendNull()
+		}
+	}
+	a.rows++
+	if a.rows >= arrowBatchSize {
+		return a.flush()
+	}
+	return nil
+}
+
+
+// write any rows collected into a final, partial batch
+func (a *arrowBatcher) flush() error {
+	if a.builder == nil || a.rows == 0 {
+		return nil
+	}
+	rec := a.builder.NewRecordBatch()
+	defer rec.Release()
+	a.rows = 0
+	return a.write(a.schema, rec)
+}
+
+
+// the schema of the batches, made from the header alone if there were no rows
+func (a *arrowBatcher) finalSchema() *arrow.Schema {
+	if a.schema == nil {
+		a.schema = arrowSchema(a.header, a.types, nil)
+	}
+	return a.schema
+}
+
+
+func (a *arrowBatcher) release() {
+	if a.builder != nil {
+		a.builder.Release()
+	}
+}
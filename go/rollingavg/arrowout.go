@@ -0,0 +1,99 @@
+// arrowout.go: Apache Arrow IPC (Feather v2) file output
+//
+// with -format arrow, output is written as an Arrow IPC file, which is
+// the Feather v2 format, so results can be memory-mapped by pandas or
+// polars without parsing CSV. column types are as given by -column-types,
+// see arrow.go. the -z flag selects lz4 or zstd compression of the
+// record batch buffers, rather than of the whole file
+
+
+package main
+
+
+import (
+	"fmt"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+)
+
+
+// writes records to an Arrow IPC file
+type arrowOutput struct {
+	out         *outputFile
+	compression string
+	batcher     *arrowBatcher
+	fw          *ipc.FileWriter
+	err         error
+}
+
+
+// compression is "", "lz4" or "zstd"
+func newArrowOutput(outfilename string, types map[string]string, compression string) *arrowOutput {
+	a := &arrowOutput{out: createOutput(outfilename, ""), compression: compression}
+	a.batcher = newArrowBatcher(types, a.writeBatch)
+	return a
+}
+
+
+// write a record batch, creating the IPC writer with the first
+func (a *arrowOutput) writeBatch(schema *arrow.Schema, rec arrow.RecordBatch) error {
+	if a.fw == nil {
+		opts := []ipc.Option{ipc.WithSchema(schema)}
+		switch a.compression {
+		case "":
+		case "lz4":
+			opts = append(opts, ipc.WithLZ4())
+		case "zstd":
+			opts = append(opts, ipc.WithZstd())
+		default:
+			return fmt.Errorf("invalid arrow compression: %s", a.compression)
+		}
+		fw, err := ipc.NewFileWriter(a.out, opts...)
+		if err != nil {
+			return fmt.Errorf("creating arrow output: %w", err)
+		}
+		a.fw = fw
+	}
+	if rec == nil {
+		return nil
+	}
+	return a.fw.Write(rec)
+}
+
+
+func (a *arrowOutput) Write(record []string) error {
+	if a.err == nil {
+		a.out.recordWritten()
+		a.err = a.batcher.add(record)
+	}
+	return a.err
+}
+
+
+// rows are written in batches, so there is nothing to flush until Close
+func (a *arrowOutput) Flush() {}
+
+
+func (a *arrowOutput) Error() error {
+	return a.err
+}
+
+
+func (a *arrowOutput) Close() error {
+	defer a.batcher.release()
+	if a.err == nil {
+		a.err = a.batcher.flush()
+	}
+	if a.err == nil && a.fw == nil {
+		// no rows, write a file with just the schema
+		a.err = a.writeBatch(a.batcher.finalSchema(), nil)
+	}
+	if a.err != nil {
+		return a.err
+	}
+	if err := a.fw.Close(); err != nil {
+		return err
+	}
+	return a.out.Close()
+}
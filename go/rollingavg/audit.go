@@ -0,0 +1,99 @@
+// audit.go: an audit log of the output rows dropped or modified
+//
+// with -audit file, each output row that isn't written as computed is
+// logged, so the lineage of the output is kept, e.g. for compliance, as a
+// CSV of
+//     Row,Action,Reason,Original
+// where Row is the output row number, from 1, Action is dropped or
+// modified, Reason why, e.g. the columns modified, and Original the row as
+// computed, as a CSV line.
+// output rows are dropped or modified by -script, see script.go, and input
+// rows, before processing, by -outliers, see outliers.go, and modified by
+// -clamp, see clamp.go. those of input rows are numbered by input row, of
+// the rows read for processing, from 1, and their Reason starts with
+// input, e.g.
+//     12,modified,input clamped X,"501,-129,-2023,2015-11-12 15:44:40.861"
+// invalid rows stop the run rather than being skipped, and no values are
+// imputed. with -batch or -watch, the rows of all the files are logged,
+// numbered within their file. an empty log, of just the header, means no
+// rows were altered
+
+
+package main
+
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+
+var auditfile string
+
+// the audit log of the run, nil without -audit
+var audit *auditLog
+
+
+type auditLog struct {
+	mu  sync.Mutex
+	fl  io.WriteCloser
+	out *csv.Writer
+}
+
+
+// create the audit log
+func startAudit(filename string) {
+	fl := createOutput(filename, "")
+	audit = &auditLog{fl: fl, out: csv.NewWriter(fl)}
+	if err := audit.out.Write([]string{"Row", "Action", "Reason", "Original"}); err != nil {
+		fatal("error writing audit log", "err", err)
+	}
+}
+
+
+// log an output row dropped or modified
+func (a *auditLog) row(n int, action, reason string, original []string) {
+	if a == nil {
+		return
+	}
+	orig, _ := encodeMessage("csv", nil, original)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.out.Write([]string{strconv.Itoa(n), action, reason, string(orig)}); err != nil {
+		fatal("error writing audit log", "err", err)
+	}
+}
+
+
+// log an output row modified, with the names of the columns that differ
+func (a *auditLog) modified(n int, header, original, modified []string) {
+	if a == nil {
+		return
+	}
+	var changed []string
+	for i, name := range header {
+		if field(original, i) != field(modified, i) {
+			changed = append(changed, name)
+		}
+	}
+	if len(changed) > 0 {
+		a.row(n, "modified", "script changed "+strings.Join(changed, ", "), original)
+	}
+}
+
+
+func (a *auditLog) close() {
+	if a == nil {
+		return
+	}
+	a.out.Flush()
+	if err := a.out.Error(); err != nil {
+		fatal("error writing audit log", "err", err)
+	}
+	if err := a.fl.Close(); err != nil {
+		fatal("error closing audit log", "err", err)
+	}
+}
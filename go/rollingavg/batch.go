@@ -0,0 +1,199 @@
+// batch.go: process a glob or directory of CSVs, each into its own output
+//
+// the output filename for each input is made from a pattern, in which
+//     {dir}   is the directory of the input file
+//     {name}  is the input filename without its extension
+//     {ext}   is the input filename extension, including the "."
+// so the default pattern "{dir}/{name}.avg{ext}" writes data.csv's
+// output to data.avg.csv alongside it.
+// inputs that look like outputs of the same pattern are skipped, so a
+// batch can safely be re-run over the same directory
+// with -jobs N, up to N files are processed at once. a file that fails
+// doesn't stop the batch, the others are still processed. at the end, the
+// status, counts and time of each file are reported to stderr, and the exit
+// status is 1 if any failed. files not started when interrupted are
+// reported as skipped. with -state, files already processed, with the same
+// content and parameters, are reported as unchanged, see state.go
+
+
+package main
+
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+const defaultOutPattern = "{dir}/{name}.avg{ext}"
+
+var batchJobs int
+
+
+// expand a glob, or a directory to the CSV files it contains
+func batchInputs(glob string) []string {
+	if fi, err := os.Stat(glob); err == nil && fi.IsDir() {
+		glob = filepath.Join(glob, "*.csv")
+	}
+	matches, err := filepath.Glob(glob)
+	if err != nil {
+		fatal("invalid batch glob", "err", err)
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+
+// make the output filename for an input file from the naming pattern
+func batchOutputName(infilename string, pattern string) string {
+	ext := filepath.Ext(infilename)
+	base := filepath.Base(infilename)
+	r := strings.NewReplacer(
+		"{dir}", filepath.Dir(infilename),
+		"{name}", strings.TrimSuffix(base, ext),
+		"{ext}", ext)
+	return filepath.Clean(r.Replace(pattern))
+}
+
+
+// returns true if filename could have been output by the pattern
+// from some other input, e.g. data.avg.csv from data.csv
+func isBatchOutput(filename string, pattern string, inputs []string) bool {
+	for _, in := range inputs {
+		if in != filename && batchOutputName(in, pattern) == filepath.Clean(filename) {
+			return true
+		}
+	}
+	return false
+}
+
+
+// the outcome of processing one batch input file
+type batchStatus struct {
+	in      string
+	out     string
+	status  string // ok, failed, interrupted, skipped or unchanged
+	err     error
+	counts  rollingavg.Counts
+	elapsed time.Duration
+}
+
+
+// process each input file matching the glob into its own output file,
+// up to batchJobs at once, then report the status of each.
+// returns false if any failed
+func runBatch(ctx context.Context, glob string, pattern string) bool {
+	inputs := batchInputs(glob)
+	if len(inputs) == 0 {
+		fatal("no input files match batch glob", "glob", glob)
+	}
+	if batchJobs < 1 {
+		fatal("invalid number of batch jobs", "jobs", batchJobs)
+	}
+	if batchJobs > 1 && (progressFlag || checkpointfile != "") {
+		fatal("-jobs can't be used with -progress or -checkpoint")
+	}
+
+	var jobs []*batchStatus
+	for _, in := range inputs {
+		if isBatchOutput(in, pattern, inputs) {
+			slog.Debug("skip batch output file", "file", in)
+			continue
+		}
+		out := batchOutputName(in, pattern)
+		if out == filepath.Clean(in) {
+			fatal("batch output would overwrite input", "file", in)
+		}
+		jobs = append(jobs, &batchStatus{in: in, out: out, status: "skipped"})
+	}
+
+	// errors processing a file fail only its job
+	fatalPanics = true
+	defer func() { fatalPanics = false }()
+
+	queue := make(chan *batchStatus)
+	var wg sync.WaitGroup
+	for i := 0; i < batchJobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				runBatchJob(ctx, job)
+			}
+		}()
+	}
+	for _, job := range jobs {
+		if ctx.Err() != nil {
+			break
+		}
+		queue <- job
+	}
+	close(queue)
+	wg.Wait()
+
+	reportBatch(jobs)
+	for _, job := range jobs {
+		if job.status == "failed" {
+			return false
+		}
+	}
+	return true
+}
+
+
+// process one batch input file, recovering from any fatal error
+func runBatchJob(ctx context.Context, job *batchStatus) {
+	slog.Debug("batch process", "input", job.in, "output", job.out)
+	start := time.Now()
+	defer func() {
+		job.elapsed = time.Since(start)
+		if r := recover(); r != nil {
+			ferr, ok := r.(fatalError)
+			if !ok {
+				panic(r)
+			}
+			job.status, job.err = "failed", ferr
+		}
+	}()
+	key := processed.key(job.in, job.out)
+	if processed.done(key) {
+		job.status = "unchanged"
+		return
+	}
+	job.counts = runRollingAvg(ctx, []string{job.in}, job.out)
+	job.status = "ok"
+	if ctx.Err() != nil {
+		job.status = "interrupted"
+		return
+	}
+	processed.add(key, job.in, job.out)
+}
+
+
+// write a table of the status of each batch file, and the totals, to stderr
+func reportBatch(jobs []*batchStatus) {
+	tw := tabwriter.NewWriter(os.Stderr, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "INPUT\tOUTPUT\tSTATUS\tREAD\tWRITTEN\tTIME\tERROR")
+	totals := make(map[string]int)
+	for _, job := range jobs {
+		errmsg := ""
+		if job.err != nil {
+			errmsg = job.err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%s\t%s\n", job.in, job.out, job.status,
+			job.counts.Read, job.counts.Written, job.elapsed.Round(time.Millisecond), errmsg)
+		totals[job.status]++
+	}
+	tw.Flush()
+	fmt.Fprintf(os.Stderr, "batch: %d files, %d ok, %d failed, %d interrupted, %d skipped, %d unchanged\n",
+		len(jobs), totals["ok"], totals["failed"], totals["interrupted"], totals["skipped"], totals["unchanged"])
+}
@@ -0,0 +1,105 @@
+// bench.go: benchmark a run over the inputs
+//
+// with -bench, the inputs are processed as usual, then a report of the run
+// is written to stderr: wall time, records read and rows written, rows/sec,
+// heap allocations, GC cycles, and the peak heap in use, sampled every
+// 10ms. the output is discarded, unless -o is given, so that e.g. window
+// sizes, statistics, and input and output formats can be compared, e.g.
+//     rollingavg -bench -n 23 big.csv
+//     rollingavg -bench -n 1000 -stat median big.csv
+//     rollingavg -bench -format parquet -o /tmp/out.parquet big.csv
+// streaming, batch, watch and server modes can't be benchmarked
+
+
+package main
+
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	rmetrics "runtime/metrics"
+	"time"
+)
+
+
+var benchFlag bool
+
+const benchSampleEvery = 10 * time.Millisecond
+
+
+// run over the inputs, and report how long it took and the memory used
+func runBench(ctx context.Context, infilenames []string, outfilename string) {
+	if streaming() || watchDir != "" || batchGlob != "" {
+		fatal("-bench can't be combined with streaming input, -watch or -batch")
+	}
+	if outfilename == "" && pgConn == "" && duckdbTable == "" && kafkaOutTopic == "" {
+		outfilename = os.DevNull
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	peak := samplePeakHeap()
+
+	start := time.Now()
+	counts := runRollingAvg(ctx, infilenames, outfilename)
+	elapsed := time.Since(start)
+
+	peakHeap := peak()
+	runtime.ReadMemStats(&after)
+
+	w := os.Stderr
+	fmt.Fprintf(w, "bench: window %d %s, stat %s, format %s, output %s\n",
+		nrows, windowUnit, statName, outputFormat, outfilename)
+	fmt.Fprintf(w, "  wall time      %s\n", elapsed.Round(time.Microsecond))
+	fmt.Fprintf(w, "  rows read      %d\n", counts.Read)
+	fmt.Fprintf(w, "  rows written   %d\n", counts.Written)
+	fmt.Fprintf(w, "  rows/sec       %.0f\n", float64(counts.Read)/elapsed.Seconds())
+	fmt.Fprintf(w, "  allocations    %d (%s)\n", after.Mallocs-before.Mallocs, formatBytes(int64(after.TotalAlloc-before.TotalAlloc)))
+	if counts.Read > 0 {
+		fmt.Fprintf(w, "  allocs/row     %.1f (%s)\n", float64(after.Mallocs-before.Mallocs)/float64(counts.Read),
+			formatBytes(int64(after.TotalAlloc-before.TotalAlloc)/int64(counts.Read)))
+	}
+	fmt.Fprintf(w, "  GC cycles      %d\n", after.NumGC-before.NumGC)
+	fmt.Fprintf(w, "  peak heap      %s\n", formatBytes(int64(peakHeap)))
+	fmt.Fprintf(w, "  memory from OS %s\n", formatBytes(int64(after.Sys)))
+}
+
+
+// sample the heap in use until the returned function is called, which
+// returns the peak sampled
+func samplePeakHeap() func() uint64 {
+	sample := []rmetrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	var peak uint64
+	read := func() {
+		rmetrics.Read(sample)
+		if v := sample[0].Value.Uint64(); v > peak {
+			peak = v
+		}
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(benchSampleEvery)
+		defer ticker.Stop()
+		for {
+			read()
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return func() uint64 {
+		close(done)
+		<-stopped
+		read()
+		return peak
+	}
+}
@@ -0,0 +1,91 @@
+// bounds.go: window boundary timestamps
+//
+// so consumers know the period each row's statistics cover, -window-bounds
+// outputs the earliest and latest timestamps, of the -time column, of the
+// rows in each row's window, as the columns Window Start and Window End,
+// following any other extra window columns, see windowcols.go.
+// for calendar windows, these are the times of the first and last rows in
+// the period, rather than the period's bounds. timestamps that can't be
+// parsed are left out
+
+
+package main
+
+
+import (
+	"sort"
+	"time"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+var windowBounds bool
+
+
+// the -window-bounds columns of an input header
+func windowBoundsColumns(header []string) []windowColumn {
+	tcol := findColumn(header, timeCol)
+	if tcol < 0 {
+		fatal("time column not found in header", "column", timeCol)
+	}
+	return []windowColumn{
+		{tcol, "Window Start", func() windowStat { return &boundStat{} }},
+		{tcol, "Window End", func() windowStat { return &boundStat{end: true} }},
+	}
+}
+
+
+// a timestamp in a window, and as it was given
+type boundTime struct {
+	t time.Time
+	s string
+}
+
+
+// the earliest, or latest, timestamp in a window, from the timestamps kept
+// in order
+type boundStat struct {
+	end   bool
+	times []boundTime
+}
+
+
+// the index of the first timestamp not before t
+func (b *boundStat) search(t time.Time) int {
+	return sort.Search(len(b.times), func(i int) bool { return !b.times[i].t.Before(t) })
+}
+
+
+func (b *boundStat) add(v string) {
+	t, err := rollingavg.ParseTime(v)
+	if err != nil {
+		return
+	}
+	i := b.search(t)
+	b.times = append(b.times, boundTime{})
+	copy(b.times[i+1:], b.times[i:])
+	b.times[i] = boundTime{t, v}
+}
+
+
+func (b *boundStat) remove(v string) {
+	t, err := rollingavg.ParseTime(v)
+	if err != nil {
+		return
+	}
+	if i := b.search(t); i < len(b.times) && b.times[i].t.Equal(t) {
+		b.times = append(b.times[:i], b.times[i+1:]...)
+	}
+}
+
+
+func (b *boundStat) value() string {
+	switch {
+	case len(b.times) == 0:
+		return ""
+	case b.end:
+		return b.times[len(b.times)-1].s
+	}
+	return b.times[0].s
+}
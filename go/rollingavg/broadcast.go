@@ -0,0 +1,82 @@
+// broadcast.go: publish output rows to live subscribers
+//
+// used by the live result endpoints (WebSocket, SSE), each output row is
+// published as a JSON object keyed by the output header, to every
+// connected subscriber. a subscriber that falls too far behind has rows
+// dropped rather than holding up processing
+
+
+package main
+
+
+import (
+	"sync"
+)
+
+
+// number of rows buffered for each subscriber
+const subscriberBuffer = 256
+
+
+// the broadcaster of output rows, nil when no live endpoint is enabled
+var liveRows *rowBroadcaster
+
+
+// fans published rows out to subscribers
+type rowBroadcaster struct {
+	mu   sync.Mutex
+	subs map[chan []byte]bool
+}
+
+
+func newRowBroadcaster() *rowBroadcaster {
+	return &rowBroadcaster{subs: make(map[chan []byte]bool)}
+}
+
+
+// add a subscriber, which receives each row published from now on
+func (b *rowBroadcaster) subscribe() chan []byte {
+	ch := make(chan []byte, subscriberBuffer)
+	b.mu.Lock()
+	b.subs[ch] = true
+	b.mu.Unlock()
+	return ch
+}
+
+
+func (b *rowBroadcaster) unsubscribe(ch chan []byte) {
+	b.mu.Lock()
+	delete(b.subs, ch)
+	b.mu.Unlock()
+}
+
+
+// send a row to all subscribers, dropping it for any whose buffer is full
+func (b *rowBroadcaster) publish(row []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- row:
+		default:
+		}
+	}
+}
+
+
+// an output that also publishes each record written to a broadcaster
+type broadcastOutput struct {
+	recordWriteCloser
+	b      *rowBroadcaster
+	header []string
+}
+
+
+func (o *broadcastOutput) Write(record []string) error {
+	if o.header == nil {
+		o.header = append([]string{}, record...)
+	} else if row, err := encodeMessage("json", o.header, record); err == nil {
+		o.b.publish(row)
+	}
+	return o.recordWriteCloser.Write(record)
+}
@@ -0,0 +1,67 @@
+// calendar.go: holiday file and alignment of calendar-aware rolling windows
+//
+// windows can span a number of business days, calendar months, minutes,
+// hours or days rather than rows, see rollingavg/calendar.go. business days
+// skip weekends and the dates in the -holidays file.
+//
+// the holiday file contains one date (YYYY-MM-DD) per line,
+// blank lines and lines starting with # are ignored
+//
+// windows start at each row's time, so with -window-mode tumbling, at the
+// first row's. with -window-align, they instead end on the boundaries of
+// their unit, in the -window-tz timezone, UTC by default, so that outputs
+// are of reporting periods, e.g. of each hour from the top of the hour
+//     rollingavg -n 1 -window-unit hours -window-align -window-mode tumbling in.csv
+// or of each quarter, with -n 3 -window-unit months. minutes and hours are
+// counted from midnight, so -n must divide a day, and months from the
+// start of the year, so -n must divide 12. the timezone is an IANA name,
+// e.g. Australia/Brisbane, or Local. business days already end at midnight
+
+
+package main
+
+
+import (
+	"os"
+	"time"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+var windowAlign bool
+var windowTZ string
+
+
+// read a holiday file containing one YYYY-MM-DD date per line
+func loadHolidays(filename string) rollingavg.Holidays {
+	fl, err := os.Open(filename)
+	if err != nil {
+		fatal("error opening holiday file", "err", err)
+	}
+	defer fl.Close()
+
+	holidays, err := rollingavg.LoadHolidays(fl)
+	if err != nil {
+		fatal("error reading holiday file", "err", err)
+	}
+	return holidays
+}
+
+
+// check that -window-align can be used with the window unit
+func checkWindowAlign() {
+	if windowAlign && windowUnit == "rows" {
+		fatal("-window-align requires a -window-unit of time")
+	}
+}
+
+
+// the timezone of aligned windows
+func windowLocation() *time.Location {
+	loc, err := time.LoadLocation(windowTZ)
+	if err != nil {
+		fatal("invalid window timezone", "window-tz", windowTZ, "err", err)
+	}
+	return loc
+}
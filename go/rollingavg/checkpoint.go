@@ -0,0 +1,186 @@
+// checkpoint.go: periodically save processing state so a job can resume
+//
+// every n records, the output is flushed and the state of each group's
+// window (circular buffers and sums), the input file and byte offset
+// reached, and the output size, are saved to the checkpoint file.
+// when run with -resume, if the checkpoint file exists, the output is
+// truncated to the checkpointed size, the windows restored, and input
+// continues from the checkpointed offset, so an interrupted job picks up
+// where it left off. once a job completes the checkpoint file is removed.
+// checkpointing requires input files (not stdin) read in order (not
+// merged), and an uncompressed local CSV output file that is not rotated,
+// split or appended to.
+
+
+package main
+
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+// processing state saved in the checkpoint file
+type checkpointState struct {
+	Inputs  []string                   `json:"inputs"`
+	File    int                        `json:"file"`
+	Offset  int64                      `json:"offset"`
+	OutSize int64                      `json:"output_size"`
+	Rows    int                        `json:"rows"`
+	Windows map[string]json.RawMessage `json:"windows"`
+}
+
+
+// saves the processing state every so many rows
+type checkpointer struct {
+	filename    string
+	every       int
+	inputs      []string
+	in          *multiCSVReader
+	out         recordWriter
+	outfilename string
+	rows        int
+}
+
+
+// check that processing can be checkpointed with the current options
+func checkCheckpointable(infilenames []string, outfilename string) {
+	switch {
+	case len(infilenames) == 0:
+		fatal("checkpointing requires input files")
+	case mergeFlag && len(infilenames) > 1:
+		fatal("checkpointing can't be used with merged inputs")
+	case outfilename == "" || isRemote(outfilename) || pgConn != "":
+		fatal("checkpointing requires a local output file")
+	case outputCompression(outfilename, compressFlag) != "":
+		fatal("checkpointing requires an uncompressed output file")
+	case outputFormat != "csv":
+		fatal("checkpointing requires CSV output")
+	case rotateSize > 0 || rotateEvery > 0 || splitBy != "" || appendFlag:
+		fatal("checkpointing can't be used with rotated, split or appended output")
+	}
+}
+
+
+// load the checkpoint state, returning nil if there is no checkpoint file
+func loadCheckpoint(filename string, infilenames []string) *checkpointState {
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		fatal("error reading checkpoint file", "err", err)
+	}
+
+	state := &checkpointState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		fatal("invalid checkpoint file", "err", err)
+	}
+	if fmt.Sprint(state.Inputs) != fmt.Sprint(infilenames) {
+		fatal("checkpoint was made with different input files", "inputs", state.Inputs)
+	}
+	slog.Debug("resume from checkpoint", "file", state.File, "offset", state.Offset, "records", state.Rows)
+	return state
+}
+
+
+// truncate the output to its size at the checkpoint, discarding any rows
+// written after it
+func truncateOutput(outfilename string, size int64) {
+	if err := os.Truncate(outfilename, size); err != nil {
+		fatal("error truncating output to checkpoint", "err", err)
+	}
+}
+
+
+// restore each group's window from the checkpoint state
+func (state *checkpointState) restoreWindows(newWindow func() rollingavg.Window) map[string]rollingavg.Window {
+	windows := make(map[string]rollingavg.Window)
+	for key, raw := range state.Windows {
+		w := newWindow()
+		if err := json.Unmarshal(raw, w); err != nil {
+			fatal("invalid window state in checkpoint file", "err", err)
+		}
+		windows[key] = w
+	}
+	return windows
+}
+
+
+// called after each record is processed, n is the number of records
+// processed by this run. saves a checkpoint every c.every records
+func (c *checkpointer) rowDone(n int, windows map[string]rollingavg.Window) {
+	if c == nil || (c.rows+n)%c.every != 0 {
+		return
+	}
+	c.save(c.rows+n, windows)
+}
+
+
+// save the state after rows records have been processed
+func (c *checkpointer) save(rows int, windows map[string]rollingavg.Window) {
+	c.out.Flush()
+	if err := c.out.Error(); err != nil {
+		fatal("error writing csv", "err", err)
+	}
+	fi, err := os.Stat(c.outfilename)
+	if err != nil {
+		fatal("error checking output size for checkpoint", "err", err)
+	}
+
+	state := checkpointState{
+		Inputs:  c.inputs,
+		OutSize: fi.Size(),
+		Rows:    rows,
+		Windows: make(map[string]json.RawMessage),
+	}
+	state.File, state.Offset = c.in.position()
+	for key, w := range windows {
+		raw, err := json.Marshal(w)
+		if err != nil {
+			fatal("error saving window state", "err", err)
+		}
+		state.Windows[key] = raw
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		fatal("error saving checkpoint", "err", err)
+	}
+	// replace the checkpoint atomically, so a crash mid-write leaves the last one
+	tmp := c.filename + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		fatal("error writing checkpoint file", "err", err)
+	}
+	if err := os.Rename(tmp, c.filename); err != nil {
+		fatal("error writing checkpoint file", "err", err)
+	}
+	slog.Debug("checkpoint", "records", rows)
+}
+
+
+// save a checkpoint of the records processed so far when processing is
+// interrupted, so the run can be resumed
+func (c *checkpointer) interrupted(n int, windows map[string]rollingavg.Window) {
+	if c == nil {
+		return
+	}
+	c.save(c.rows+n, windows)
+	slog.Info("saved checkpoint for resuming", "file", c.filename)
+}
+
+
+// remove the checkpoint file once processing has completed
+func (c *checkpointer) finish() {
+	if c == nil {
+		return
+	}
+	if err := os.Remove(c.filename); err != nil && !os.IsNotExist(err) {
+		fatal("error removing checkpoint file", "err", err)
+	}
+}
@@ -0,0 +1,225 @@
+// clamp.go: clamping and winsorizing of input columns
+//
+// so a few wild readings don't swamp the windows' statistics, -clamp lists
+// bounds for input columns, by name or index, as col:lo:hi, e.g.
+//     -clamp "X:-500:500,Y:p1:p99,Z::0"
+// values below lo are replaced by lo, and above hi by hi, before
+// processing, after any -schema coercions and -convert conversions. a
+// bound is a number, pN for the Nth percentile of the column's values,
+// winsorizing them, or empty for none.
+// percentiles are estimated from the first -clamp-sample rows (default
+// 1000), which are held back until they're known, so they're from the
+// start of the data rather than all of it.
+// values that aren't numbers are left as they are. the number of values
+// clamped of each column is logged at the end, and included in the -report
+// summary, see report.go, and with -audit, the rows modified are logged,
+// see audit.go
+
+
+package main
+
+
+import (
+	"io"
+	"log/slog"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+var clampSpec string
+var clampSample int
+
+
+// a bound of a clamp, a value, or a percentile of the sampled values
+type clampBound struct {
+	set        bool
+	percentile bool
+	v          float64
+}
+
+
+func parseClampBound(s string) (clampBound, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return clampBound{}, true
+	}
+	if p, ok := strings.CutPrefix(s, "p"); ok {
+		v, err := strconv.ParseFloat(p, 64)
+		return clampBound{set: true, percentile: true, v: v}, err == nil && v >= 0 && v <= 100
+	}
+	v, err := rollingavg.ParseFloat(s)
+	return clampBound{set: true, v: v}, err == nil
+}
+
+
+// the bounds of a column's values
+type clamp struct {
+	col    int
+	name   string
+	lo, hi clampBound
+	n      int // values clamped
+}
+
+
+func (c *clamp) apply(v float64) (float64, bool) {
+	if c.lo.set && v < c.lo.v {
+		return c.lo.v, true
+	}
+	if c.hi.set && v > c.hi.v {
+		return c.hi.v, true
+	}
+	return v, false
+}
+
+
+// parse a -clamp list of col:lo:hi for input with the given header
+func parseClamps(spec string, header []string) []clamp {
+	var clamps []clamp
+	for _, item := range strings.Split(spec, ",") {
+		parts := strings.Split(item, ":")
+		if len(parts) < 3 {
+			fatal("invalid clamp, expected col:lo:hi", "clamp", item)
+		}
+		// the column name may itself contain a colon
+		name := strings.TrimSpace(strings.Join(parts[:len(parts)-2], ":"))
+		col := findColumn(header, name)
+		if col < 0 {
+			fatal("clamp column not found in header", "column", name)
+		}
+		lo, ok1 := parseClampBound(parts[len(parts)-2])
+		hi, ok2 := parseClampBound(parts[len(parts)-1])
+		switch {
+		case !ok1 || !ok2:
+			fatal("invalid clamp bound, expected a number, pN or empty", "clamp", item)
+		case !lo.percentile && !hi.percentile && lo.set && hi.set && lo.v > hi.v:
+			fatal("clamp lower bound above upper bound", "clamp", item)
+		}
+		clamps = append(clamps, clamp{col: col, name: header[col], lo: lo, hi: hi})
+	}
+	return clamps
+}
+
+
+// a record source whose values are clamped, in place
+type clampingReader struct {
+	in      recordReader
+	clamps  []clamp
+	sample  [][]string // the rows held back for estimating percentiles
+	sampled bool
+	done    bool
+	n       int // rows read
+}
+
+
+func newClampingReader(in recordReader, clamps []clamp, sample int) *clampingReader {
+	r := &clampingReader{in: in, clamps: clamps, sampled: true}
+	for _, c := range clamps {
+		if c.lo.percentile || c.hi.percentile {
+			r.sampled = sample <= 0
+		}
+	}
+	if !r.sampled {
+		r.sample = make([][]string, 0, sample)
+	}
+	return r
+}
+
+
+// read the sample rows, and set the percentile bounds from them
+func (r *clampingReader) readSample() error {
+	for len(r.sample) < cap(r.sample) {
+		record, err := r.in.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		r.sample = append(r.sample, append([]string(nil), record...))
+	}
+	for i := range r.clamps {
+		c := &r.clamps[i]
+		var values []float64
+		for _, record := range r.sample {
+			if v, err := rollingavg.ParseFloat(field(record, c.col)); err == nil {
+				values = append(values, v)
+			}
+		}
+		sort.Float64s(values)
+		for _, b := range []*clampBound{&c.lo, &c.hi} {
+			if b.percentile {
+				b.v = quantile(values, b.v/100)
+				b.set = !math.IsNaN(b.v)
+				b.percentile = false
+			}
+		}
+		slog.Debug("clamp bounds", "column", c.name, "sampled", len(values), "lo", c.lo.v, "hi", c.hi.v)
+	}
+	r.sampled = true
+	return nil
+}
+
+
+func (r *clampingReader) Read() ([]string, error) {
+	if !r.sampled {
+		if err := r.readSample(); err != nil {
+			return nil, err
+		}
+	}
+	var record []string
+	if len(r.sample) > 0 {
+		record, r.sample = r.sample[0], r.sample[1:]
+	} else {
+		var err error
+		if record, err = r.in.Read(); err != nil {
+			if err == io.EOF {
+				r.finish()
+			}
+			return record, err
+		}
+	}
+	r.n++
+	var original []string
+	if audit != nil {
+		original = slices.Clone(record)
+	}
+	var changed []string
+	for i := range r.clamps {
+		c := &r.clamps[i]
+		if c.col >= len(record) {
+			continue
+		}
+		if v, err := rollingavg.ParseFloat(record[c.col]); err == nil {
+			if cv, clamped := c.apply(v); clamped {
+				record[c.col] = strconv.FormatFloat(cv, 'f', -1, 64)
+				c.n++
+				changed = append(changed, c.name)
+			}
+		}
+	}
+	if len(changed) > 0 {
+		audit.row(r.n, "modified", "input clamped "+strings.Join(changed, ", "), original)
+	}
+	return record, nil
+}
+
+
+// log and report the number of values clamped
+func (r *clampingReader) finish() {
+	if r.done {
+		return
+	}
+	r.done = true
+	args := make([]any, 0, 2*len(r.clamps))
+	for _, c := range r.clamps {
+		args = append(args, c.name, c.n)
+		report.clamped(c.name, c.n)
+	}
+	slog.Info("clamped values", args...)
+}
@@ -0,0 +1,141 @@
+// coerce.go: coercion of column values by a schema
+//
+// field data often has values that aren't plain numbers, e.g. "12.3V",
+// "45%" or "yes". a column of a schema file, see csvcheck.go, can list
+// coercions, applied to its values in order until one applies, e.g.
+//     columns:
+//       - name: X
+//         type: float
+//         coerce: [units, percent]
+// the coercions are
+//     units    strip a unit suffix, e.g. "12.3V" or "12.3 kWh" to "12.3"
+//     percent  a percentage as a fraction, e.g. "45%" to "0.45"
+//     bool     true, yes, on, y or t to 1, and false, no, off, n or f to 0,
+//              in any case
+// values no coercion applies to are left as they are.
+// with -schema, rollavg coerces the input values before processing, and
+// csvcheck checks the values after coercion
+
+
+package main
+
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+var schemafile string
+
+
+// a coercion, returning the coerced value, or false if it doesn't apply
+type coercion func(v string) (string, bool)
+
+
+var coercions = map[string]coercion{
+	"units":   stripUnits,
+	"percent": parsePercent,
+	"bool":    parseBool,
+}
+
+
+// strip a unit suffix from a number
+func stripUnits(v string) (string, bool) {
+	end := len(v)
+	for end > 0 && !(v[end-1] >= '0' && v[end-1] <= '9' || v[end-1] == '.') {
+		end--
+	}
+	if end == len(v) || end == 0 {
+		return v, false
+	}
+	num := strings.TrimSpace(v[:end])
+	if _, err := rollingavg.ParseFloat(num); err != nil {
+		return v, false
+	}
+	return num, true
+}
+
+
+// a percentage as a fraction
+func parsePercent(v string) (string, bool) {
+	num, ok := strings.CutSuffix(strings.TrimSpace(v), "%")
+	if !ok {
+		return v, false
+	}
+	f, err := rollingavg.ParseFloat(strings.TrimSpace(num))
+	if err != nil {
+		return v, false
+	}
+	return strconv.FormatFloat(f/100, 'f', -1, 64), true
+}
+
+
+// a boolean as 1 or 0
+func parseBool(v string) (string, bool) {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "true", "yes", "on", "y", "t":
+		return "1", true
+	case "false", "no", "off", "n", "f":
+		return "0", true
+	}
+	return v, false
+}
+
+
+// the first of a column's coercions that isn't known, or "" if all are
+func unknownCoercion(c schemaColumn) string {
+	for _, name := range c.Coerce {
+		if coercions[name] == nil {
+			return name
+		}
+	}
+	return ""
+}
+
+
+// coerce a value of a column
+func coerce(c *schemaColumn, v string) string {
+	for _, name := range c.Coerce {
+		if cv, ok := coercions[name](v); ok {
+			return cv
+		}
+	}
+	return v
+}
+
+
+// a record source whose values are coerced by a schema, in place
+type coercingReader struct {
+	in   recordReader
+	cols []*schemaColumn // the schema column of each input column with coercions
+}
+
+
+// coerce the records of in, with the given header, by the schema
+func newCoercingReader(in recordReader, schema *csvSchema, header []string) *coercingReader {
+	r := &coercingReader{in: in, cols: make([]*schemaColumn, len(header))}
+	for i := range schema.Columns {
+		c := &schema.Columns[i]
+		if j := findColumn(header, c.Name); j >= 0 && len(c.Coerce) > 0 {
+			r.cols[j] = c
+		}
+	}
+	return r
+}
+
+
+func (r *coercingReader) Read() ([]string, error) {
+	record, err := r.in.Read()
+	if err != nil {
+		return record, err
+	}
+	for i, c := range r.cols {
+		if c != nil && i < len(record) {
+			record[i] = coerce(c, record[i])
+		}
+	}
+	return record, nil
+}
@@ -0,0 +1,158 @@
+// colstats.go: statistics of the columns of output CSVs
+//
+// with -column-stats comment, each output CSV ends with a footer of comment
+// lines of the count, mean, min and max of the numeric values of each of
+// its columns, both the input's raw values and the averaged statistics,
+// e.g.
+//     # rollingavg column statistics
+//     # Column,Count,Mean,Min,Max
+//     # X,978,27.1,-2,33
+//     # Average A,978,27.05,24.5,30.1
+// and starts with a comment line saying so, so that rollingavg's readers
+// skip the comment lines, as for -provenance, see provenance.go. the footer
+// can't be added to an appended file, so -append can't be used. with
+// -column-stats sidecar, the statistics are instead written as a CSV file
+// named for the output, with .stats.csv appended. values that aren't
+// numbers, e.g. timestamps, or the empty statistics of -tail rows, aren't
+// counted, and columns without any aren't included. stdout has no
+// sidecar. with rotation or splitting, each file has its own statistics.
+// for fuller statistics of a CSV, see the csvstats subcommand, csvstats.go
+
+
+package main
+
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+
+var columnStatsMode string
+
+// the first line of a column statistics footer
+const columnStatsMark = commentMark + "column statistics"
+
+
+// the statistics of the columns of an output file, the first record
+// written being its header
+type outputColumnStats struct {
+	names []string
+	cols  []*columnSummary
+}
+
+
+// check that the -column-stats mode is valid with the current options
+func checkColumnStats() {
+	switch columnStatsMode {
+	case "", "sidecar":
+	case "comment":
+		if appendFlag {
+			fatal("-column-stats comment can't be used with -append")
+		}
+	default:
+		fatal("invalid column stats, expected comment or sidecar", "column-stats", columnStatsMode)
+	}
+}
+
+
+// the statistics of an output file's columns, nil without -column-stats
+func newColumnStats() *outputColumnStats {
+	if columnStatsMode == "" {
+		return nil
+	}
+	return &outputColumnStats{}
+}
+
+
+// add the values of a record written
+func (s *outputColumnStats) add(record []string) {
+	if s == nil {
+		return
+	}
+	if s.names == nil {
+		s.names = append([]string(nil), record...)
+		s.cols = make([]*columnSummary, len(record))
+		for i := range s.cols {
+			s.cols[i] = &columnSummary{}
+		}
+		return
+	}
+	for i, v := range record {
+		if i >= len(s.cols) {
+			break
+		}
+		if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+			s.cols[i].add(f)
+		}
+	}
+}
+
+
+// the rows of the statistics, with a header
+func (s *outputColumnStats) rows() [][]string {
+	rows := [][]string{{"Column", "Count", "Mean", "Min", "Max"}}
+	for i, c := range s.cols {
+		if c.Count == 0 {
+			continue
+		}
+		rows = append(rows, []string{s.names[i], strconv.Itoa(c.Count),
+			strconv.FormatFloat(c.Mean, 'g', -1, 64),
+			strconv.FormatFloat(c.Min, 'g', -1, 64),
+			strconv.FormatFloat(c.Max, 'g', -1, 64)})
+	}
+	return rows
+}
+
+
+// write the comment line, with -column-stats comment, at the start of the
+// output file
+func (s *outputColumnStats) writeMark(w io.Writer) {
+	if s == nil || columnStatsMode != "comment" {
+		return
+	}
+	if _, err := fmt.Fprintln(w, commentMark+"column statistics follow the rows"); err != nil {
+		fatal("error writing column statistics", "err", err)
+	}
+}
+
+
+// write the footer, with -column-stats comment, after the output rows
+func (s *outputColumnStats) writeFooter(w io.Writer) error {
+	if s == nil || columnStatsMode != "comment" {
+		return nil
+	}
+	var sb strings.Builder
+	sb.WriteString(columnStatsMark + "\n")
+	cw := csv.NewWriter(&sb)
+	for _, row := range s.rows() {
+		sb.WriteString("# ")
+		cw.Write(row)
+		cw.Flush()
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+
+// write the sidecar, with -column-stats sidecar, once the output file is
+// complete
+func (s *outputColumnStats) writeSidecar(outfilename string) error {
+	if s == nil || columnStatsMode != "sidecar" || outfilename == "" {
+		return nil
+	}
+	fl, err := createDest(outfilename + ".stats.csv")
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(fl)
+	cw.WriteAll(s.rows())
+	if err := cw.Error(); err != nil {
+		fl.Close()
+		return err
+	}
+	return fl.Close()
+}
@@ -0,0 +1,156 @@
+// commands.go: the mdp umbrella command and its subcommands
+//
+// the misc-data-processing tools are subcommands of one binary, built as
+//     go build -o mdp ./go/rollingavg
+// and run as
+//     mdp rollavg ...     rolling averages, see rollingavg.go
+//     mdp aggregate ...   per-day or per-week summaries, see aggregate.go
+//     mdp resample ...    resample rows to a fixed interval, filling gaps, see resample.go
+//     mdp csvstats ...    per-column summary statistics, see csvstats.go
+//     mdp stats ...       the same as csvstats
+//     mdp quality ...     a data-quality report, see quality.go
+//     mdp csvcheck ...    validate CSV files against a schema, see csvcheck.go
+//     mdp validate ...    the same as csvcheck
+//     mdp csvjoin ...     join two CSVs on their timestamps, see csvjoin.go
+//     mdp pivot ...       pivot long format rows to wide, see pivot.go
+//     mdp unpivot ...     unpivot wide format rows to long, see pivot.go
+//     mdp csvfilter ...   keep or drop rows matching an expression, see csvfilter.go
+//     mdp csvcut ...      select, drop, reorder and rename columns, see csvcut.go
+//     mdp dedup ...       remove duplicate rows, see dedup.go
+//     mdp sort ...        sort rows of any size by columns, see csvsort.go
+//     mdp sample ...      random, systematic or reservoir samples of rows, see sample.go
+//     mdp csvsplit ...    split a CSV into parts by row count or size, see csvsplit.go
+//     mdp concat ...      concatenate CSVs, reconciling their headers, see concat.go
+//     mdp csvdiff ...     compare two CSVs, see csvdiff.go
+//     mdp histogram ...   histogram of a column's values, see histogram.go
+//     mdp view ...        page through the rolling averages in the terminal, see view.go
+//     mdp schema ...      infer the column types of a CSV, see schema.go
+//     mdp normalize ...   min-max or z-score normalise columns, see normalize.go
+//     mdp trend ...       fit global or piecewise linear trends to columns, see trend.go
+//     mdp forecast ...    AR and ARIMA forecasts of a column, see forecast.go
+//     mdp spectrum ...    FFT spectrum or periodogram of a column, see spectrum.go
+//     mdp xcorr ...       cross-correlation of two columns at lags, see xcorr.go
+//     mdp acf ...         autocorrelation and partial autocorrelation of a column, see acf.go
+//     mdp sessions ...    per-session summaries of rows split by inactivity gaps, see sessions.go
+// mdp with no arguments, or mdp help, lists the subcommands, and
+// mdp <subcommand> -h lists a subcommand's flags.
+// when the binary is named anything other than mdp, e.g. rollingavg,
+// the rolling average is run unless the first argument names another
+// subcommand, so existing rollingavg invocations keep working.
+// subcommands share the input and output handling of inputs.go and
+// outputs.go, and the -v, -f and -o flags, see commonFlags
+
+
+package main
+
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+
+// a subcommand, run with the arguments following its name
+type command struct {
+	name    string
+	summary string
+	run     func(args []string)
+}
+
+
+var commands = []command{
+	{"rollavg", "rolling averages over CSV rows", runRollAvg},
+	{"aggregate", "per-day or per-week summaries of CSV rows", runAggregate},
+	{"resample", "resample rows to a fixed interval, filling the gaps", runResample},
+	{"csvstats", "per-column summary statistics of CSV rows", runCSVStats},
+	{"stats", "the same as csvstats", runCSVStats},
+	{"quality", "a report of missing, invalid, out of order and duplicate values", runQuality},
+	{"csvcheck", "validate CSV files against a schema", runCSVCheck},
+	{"validate", "the same as csvcheck", runCSVCheck},
+	{"csvjoin", "join two CSVs on their timestamps, exactly or nearest", runCSVJoin},
+	{"pivot", "pivot long format rows of timestamp, series and value to wide", runPivot},
+	{"unpivot", "unpivot wide format rows to long", runUnpivot},
+	{"csvfilter", "keep or drop rows matching an expression", runCSVFilter},
+	{"csvcut", "select, drop, reorder and rename columns", runCSVCut},
+	{"dedup", "remove duplicate rows, or rows with duplicate keys", runDedup},
+	{"sort", "sort rows by columns, using temporary files for large inputs", runCSVSort},
+	{"sample", "random, systematic or reservoir samples of rows", runSample},
+	{"csvsplit", "split a CSV into parts of a number of rows or size", runCSVSplit},
+	{"concat", "concatenate CSVs, matching their columns by name", runConcat},
+	{"csvdiff", "report the rows and cells added, removed or changed between two CSVs", runCSVDiff},
+	{"histogram", "histogram of a column's values, as CSV or ascii bars", runHistogram},
+	{"view", "page through the rolling averages in the terminal, adjusting the window", runView},
+	{"schema", "infer column types from a sample of rows, as a csvcheck schema or -column-types", runSchema},
+	{"normalize", "min-max or z-score normalise columns, by csvstats output or streaming estimates", runNormalize},
+	{"trend", "fit global or piecewise linear trends to columns, with fitted values and residuals", runTrend},
+	{"forecast", "AR or ARIMA forecasts of a column, with prediction intervals", runForecast},
+	{"spectrum", "FFT amplitude spectrum and periodogram of a column, overall or per window", runSpectrum},
+	{"xcorr", "cross-correlation of two columns, or columns of two files, at lags", runXCorr},
+	{"acf", "autocorrelation and partial autocorrelation of a column, for choosing window sizes", runACF},
+	{"sessions", "per-session summaries of rows, sessions split by gaps of inactivity", runSessions},
+}
+
+
+// the subcommand with the given name, or nil if there isn't one
+func lookupCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+
+// run the subcommand named by the arguments. prog is the name the binary
+// was run as, which if not mdp, defaults the subcommand to rollavg
+func runCommand(prog string, args []string) {
+	prog = strings.TrimSuffix(filepath.Base(prog), ".exe")
+	umbrella := prog == "mdp"
+	if len(args) > 0 {
+		if cmd := lookupCommand(args[0]); cmd != nil {
+			flag.Usage = func() {
+				fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s %s:\n", prog, cmd.name)
+				flag.PrintDefaults()
+			}
+			cmd.run(args[1:])
+			return
+		}
+	}
+	if !umbrella {
+		runRollAvg(args)
+		return
+	}
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "-help" {
+		printCommands(prog)
+		return
+	}
+	fmt.Fprintln(os.Stderr, "unknown subcommand:", args[0])
+	printCommands(prog)
+	os.Exit(2)
+}
+
+
+// list the subcommands on stderr
+func printCommands(prog string) {
+	fmt.Fprintf(os.Stderr, "Usage: %s <subcommand> [flags] [inputfile...]\n\nsubcommands:\n", prog)
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "    %-12s%s\n", cmd.name, cmd.summary)
+	}
+}
+
+
+// register the -v, -f and -o flags shared by the subcommands. output
+// describes what the output CSV contains
+func commonFlags(fs *flag.FlagSet, output string) {
+	fs.BoolVar(&verboseFlag, "v", false, "verbose output for debugging, the same as -log-level debug")
+	fs.Var(&infilenames, "f", "CSV containing data to process (may be repeated)")
+	fs.StringVar(&outfilename, "o", "", "output CSV containing "+output)
+	fs.StringVar(&inputEncoding, "encoding", "utf-8", "character encoding of the input CSVs: utf-8, utf-16, utf-16le, utf-16be, latin1 or windows-1252")
+	fs.BoolVar(&lazyQuotes, "lazy-quotes", false, "allow stray quotes in the input CSVs")
+	fs.BoolVar(&raggedRows, "ragged", false, "allow input rows of any number of fields, padding or truncating them to the header's width")
+	logFlags(fs)
+}
@@ -0,0 +1,158 @@
+// compress.go: transparent gzip and zstd compression of inputs and outputs
+//
+// compressed inputs are detected from their magic bytes, so work for
+// stdin as well as files. outputs are compressed according to the
+// filename extension (.gz or .zst), or the -z flag, which is needed
+// to compress stdout. with -encrypt-to, they're then encrypted, see
+// encrypt.go
+
+
+package main
+
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+
+// a reader over decompressed input, which releases the decompressor on close
+type decompressReader struct {
+	io.Reader
+	closer func()
+}
+
+func (d *decompressReader) Close() error {
+	if d.closer != nil {
+		d.closer()
+	}
+	return nil
+}
+
+
+// wrap r in a decompressor if it starts with gzip or zstd magic bytes
+func decompress(r io.Reader) *decompressReader {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(zstdMagic))
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		slog.Debug("reading gzip compressed input")
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			fatal("error reading gzip input", "err", err)
+		}
+		return &decompressReader{zr, func() { zr.Close() }}
+
+	case bytes.HasPrefix(magic, zstdMagic):
+		slog.Debug("reading zstd compressed input")
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			fatal("error reading zstd input", "err", err)
+		}
+		return &decompressReader{zr, zr.Close}
+	}
+	return &decompressReader{br, nil}
+}
+
+
+// return the compression for an output file: the -z flag value if given,
+// otherwise gzip or zstd if the filename has a .gz or .zst extension
+func outputCompression(filename string, compression string) string {
+	if compression != "" {
+		return compression
+	}
+	switch filepath.Ext(strings.TrimSuffix(filename, encryptedExt(filename))) {
+	case ".gz":
+		return "gzip"
+	case ".zst":
+		return "zstd"
+	}
+	return ""
+}
+
+
+// an output file, possibly compressed. Close flushes the compressor and
+// closes the file, but never closes stdout
+type outputFile struct {
+	io.Writer
+	closers []io.Closer
+	entry   *manifestOutput // with -manifest, see manifest.go
+}
+
+func (o *outputFile) Close() error {
+	var err error
+	for _, c := range o.closers {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+
+// create the output file, or use stdout if filename is empty,
+// with compression as given by outputCompression
+func createOutput(filename string, compression string) *outputFile {
+	if filename == "" {
+		return compressOutput(nil, filename, compression)
+	}
+	fl, err := createDest(filename)
+	if err != nil {
+		fatal("error creating destination csv", "err", err)
+	}
+	fl, entry := manifest.track(filename, fl)
+	out := compressOutput(fl, filename, compression)
+	out.entry = entry
+	return out
+}
+
+
+// count a record written to the file, for the manifest
+func (o *outputFile) recordWritten() {
+	o.entry.recordWritten()
+}
+
+
+// wrap the opened output file fl (stdout if nil) in compression as given
+// by outputCompression
+func compressOutput(fl io.WriteCloser, filename string, compression string) *outputFile {
+	out := &outputFile{Writer: os.Stdout}
+	if fl != nil {
+		out.Writer = fl
+		out.closers = append(out.closers, fl)
+	}
+	if ew := encryptWriter(out.Writer); ew != nil {
+		out.Writer = ew
+		out.closers = append([]io.Closer{ew}, out.closers...)
+	}
+
+	switch outputCompression(filename, compression) {
+	case "":
+	case "gzip":
+		zw := gzip.NewWriter(out.Writer)
+		out.Writer = zw
+		out.closers = append([]io.Closer{zw}, out.closers...)
+	case "zstd":
+		zw, err := zstd.NewWriter(out.Writer)
+		if err != nil {
+			fatal("error creating zstd output", "err", err)
+		}
+		out.Writer = zw
+		out.closers = append([]io.Closer{zw}, out.closers...)
+	default:
+		fatal("invalid output compression", "compression", compression)
+	}
+	return out
+}
@@ -0,0 +1,143 @@
+// concat.go: concatenate CSVs, reconciling their headers
+//
+// unlike the inputs of rollingavg, see inputs.go, whose headers must match,
+// or cat, which breaks when exports change column order, invoked as the
+// concat subcommand, see commands.go:
+//     mdp concat [-v] [-columns col,...] [-strict] [-fill value]
+//         [-f inputfile]... [-o outputfile] inputfile...
+// the rows of the files are output in order, with their columns matched by
+// header name to the output header, which is -columns, or by default all
+// the columns of the files, in the order they're first seen. columns a
+// file doesn't have are filled with -fill (default empty), and its
+// columns not in -columns are dropped.
+// with -strict, all the files must have the same columns, in any order.
+// the headers are read before the rows, so the inputs must be files
+
+
+package main
+
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"flag"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+
+// read the header of a file
+func readHeader(filename string) []string {
+	in := openInputs(context.Background(), []string{filename}, false, nil)
+	defer in.Close()
+	header, err := in.Read()
+	if err != nil {
+		fatal("error reading header from csv", "file", filename, "err", err)
+	}
+	return append([]string(nil), header...)
+}
+
+
+func runConcat(args []string) {
+	fs := flag.NewFlagSet("concat", flag.ExitOnError)
+	columns := fs.String("columns", "", "comma separated output columns (default all the columns of the inputs)")
+	strict := fs.Bool("strict", false, "require all inputs to have the same columns, in any order")
+	fill := fs.String("fill", "", "value of columns missing from an input")
+	commonFlags(fs, "the rows of all the inputs")
+	fs.Parse(args)
+	infilenames = append(infilenames, fs.Args()...)
+	setupLogging()
+
+	if len(infilenames) == 0 {
+		fatal("concat requires input files")
+	}
+
+	slog.Debug("concatenate CSVs",
+		"inputs", infilenames, "output", outfilename, "columns", *columns, "strict", *strict)
+
+	// the output header, from -columns or the union of the headers
+	var header []string
+	if *columns != "" {
+		header = strings.Split(*columns, ",")
+	}
+	var first []string
+	for i, filename := range infilenames {
+		h := readHeader(filename)
+		if i == 0 {
+			first = slices.Clone(h)
+			slices.Sort(first)
+		} else if *strict {
+			sorted := slices.Clone(h)
+			slices.Sort(sorted)
+			if !slices.Equal(sorted, first) {
+				fatal("header columns do not match first input", "file", filename, "header", h)
+			}
+		}
+		if *columns == "" {
+			for _, name := range h {
+				if !slices.Contains(header, name) {
+					header = append(header, name)
+				}
+			}
+		}
+	}
+
+	oufl := createOutput(outfilename, "")
+	outfile := csv.NewWriter(bufio.NewWriter(oufl))
+	if err := outfile.Write(header); err != nil {
+		fatal("error writing record to csv", "err", err)
+	}
+
+	n := 0
+	outrec := make([]string, len(header))
+	for _, filename := range infilenames {
+		in := openInputs(context.Background(), []string{filename}, false, nil)
+		h, err := in.Read()
+		if err != nil {
+			fatal("error reading header from csv", "file", filename, "err", err)
+		}
+		// the input column of each output column, or -1 if it has none
+		cols := make([]int, len(header))
+		for j, name := range header {
+			cols[j] = slices.Index(h, name)
+		}
+		slog.Debug("concatenate file", "file", filename, "columns", cols)
+
+		for {
+			record, err := in.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				fatal("error reading record from csv", "file", filename, "err", err)
+			}
+			for j, i := range cols {
+				if i >= 0 && i < len(record) {
+					outrec[j] = record[i]
+				} else {
+					outrec[j] = *fill
+				}
+			}
+			if verboseFlag {
+				slog.Debug("write record", "n", n, "record", outrec)
+			}
+			if err := outfile.Write(outrec); err != nil {
+				fatal("error writing record to csv", "err", err)
+			}
+			n++
+		}
+		in.Close()
+	}
+	slog.Debug("concatenated records", "records", n, "files", len(infilenames), "columns", len(header))
+
+	outfile.Flush()
+	if err := outfile.Error(); err != nil {
+		fatal("error writing csv", "err", err)
+	}
+	if err := oufl.Close(); err != nil {
+		fatal("error closing destination csv", "err", err)
+	}
+}
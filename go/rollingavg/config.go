@@ -0,0 +1,211 @@
+// config.go: pipeline configuration file
+//
+// with -config, the inputs, column mappings, window, statistic, rule,
+// plugins and outputs of a run are read from a YAML file, so that complex
+// invocations can be kept with the data and reviewed, e.g.
+//     inputs: [jan.csv, feb.csv]
+//     columns:
+//       group_by: sensor
+//       time: Time
+//       aliases:
+//         t: Temperature (deg C)
+//     window:
+//       length: 50
+//       unit: bdays
+//       holidays: holidays.txt
+//     stat: median
+//     rule: threshold
+//     thresholds:
+//       a: -2
+//       b: -1400
+//     plugins: [myrules.so]
+//     output:
+//       file: out.csv.gz
+//       format: csv
+//     flags:
+//       follow: true
+//       metrics-addr: ":9100"
+// columns aliases are short names for columns, as -alias, see aliases.go.
+// window length_b is the length of B's row window, if it differs, as -nb.
+// flags has any other command line flags, by name without the -, and
+// stages has transforms of the input rows, see stages.go.
+// flags given on the command line, or by ROLLAVG_* environment variables,
+// see env.go, take precedence over the config file, and input files given
+// on the command line or environment replace its inputs.
+// filenames are relative to the current directory, not the config file
+
+
+package main
+
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+
+// the settings of a pipeline configuration file
+type pipelineConfig struct {
+	Inputs  []string `yaml:"inputs"`
+	Columns struct {
+		GroupBy  string            `yaml:"group_by"`
+		Time     string            `yaml:"time"`
+		Parquet  []string          `yaml:"parquet"`
+		Messages []string          `yaml:"messages"`
+		Aliases  map[string]string `yaml:"aliases"`
+	} `yaml:"columns"`
+	Window struct {
+		Length   int    `yaml:"length"`
+		LengthB  int    `yaml:"length_b"`
+		Unit     string `yaml:"unit"`
+		Holidays string `yaml:"holidays"`
+	} `yaml:"window"`
+	Stat       string `yaml:"stat"`
+	Rule       string `yaml:"rule"`
+	Thresholds struct {
+		A *float64 `yaml:"a"`
+		B *float64 `yaml:"b"`
+	} `yaml:"thresholds"`
+	Plugins []string `yaml:"plugins"`
+	Script  string   `yaml:"script"`
+	Output  struct {
+		File     string `yaml:"file"`
+		Format   string `yaml:"format"`
+		Compress string `yaml:"compress"`
+	} `yaml:"output"`
+	Flags  map[string]string `yaml:"flags"`
+	Stages []pipelineStage    `yaml:"stages"`
+}
+
+
+// read the pipeline configuration file, exiting if it can't be read
+func loadConfig(filename string) *pipelineConfig {
+	config, err := readConfig(filename)
+	if err != nil {
+		fatal("error loading config file", "err", err)
+	}
+	return config
+}
+
+
+// read the pipeline configuration file. unknown settings are an error,
+// so that misspellings aren't silently ignored
+func readConfig(filename string) (*pipelineConfig, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+	config := &pipelineConfig{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(config); err != nil {
+		return nil, fmt.Errorf("invalid config file: %s %w", filename, err)
+	}
+	return config, nil
+}
+
+
+// a flag value given by the config file
+type flagSetting struct {
+	name  string
+	value string
+}
+
+
+// the flag values given by the config file, in the order they are set
+func (c *pipelineConfig) settings() []flagSetting {
+	var settings []flagSetting
+	add := func(name, value string) {
+		if value != "" {
+			settings = append(settings, flagSetting{name, value})
+		}
+	}
+	addFloat := func(name string, value *float64) {
+		if value != nil {
+			add(name, strconv.FormatFloat(*value, 'g', -1, 64))
+		}
+	}
+
+	add("group-by", c.Columns.GroupBy)
+	add("time", c.Columns.Time)
+	add("parquet-columns", strings.Join(c.Columns.Parquet, ","))
+	add("message-header", strings.Join(c.Columns.Messages, ","))
+	aliases := make([]string, 0, len(c.Columns.Aliases))
+	for name, col := range c.Columns.Aliases {
+		aliases = append(aliases, name+"="+col)
+	}
+	sort.Strings(aliases)
+	for _, alias := range aliases {
+		add("alias", alias)
+	}
+	if c.Window.Length != 0 {
+		add("n", strconv.Itoa(c.Window.Length))
+	}
+	if c.Window.LengthB != 0 {
+		add("nb", strconv.Itoa(c.Window.LengthB))
+	}
+	add("window-unit", c.Window.Unit)
+	add("holidays", c.Window.Holidays)
+	add("stat", c.Stat)
+	add("rule", c.Rule)
+	addFloat("threshold-a", c.Thresholds.A)
+	addFloat("threshold-b", c.Thresholds.B)
+	for _, plugin := range c.Plugins {
+		add("plugin", plugin)
+	}
+	add("script", c.Script)
+	add("o", c.Output.File)
+	add("format", c.Output.Format)
+	add("z", c.Output.Compress)
+	names := make([]string, 0, len(c.Flags))
+	for name := range c.Flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		add(name, c.Flags[name])
+	}
+	return settings
+}
+
+
+// the names of the flags that have been set
+func givenFlags(fs *flag.FlagSet) map[string]bool {
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	return given
+}
+
+
+// set the flags from the config file that weren't given on the command
+// line or by the environment
+func (c *pipelineConfig) apply(fs *flag.FlagSet) {
+	given := givenFlags(fs)
+	if len(infilenames) == 0 {
+		infilenames = append(infilenames, c.Inputs...)
+	}
+	pipelineStages = c.Stages
+	for _, s := range c.settings() {
+		if s.name == "config" {
+			fatal("config files can't include other config files")
+		}
+		if given[s.name] {
+			continue
+		}
+		if fs.Lookup(s.name) == nil {
+			fatal("unknown flag in config file", "flag", s.name)
+		}
+		if err := fs.Set(s.name, s.value); err != nil {
+			fatal("invalid config file value", "flag", s.name, "err", err)
+		}
+		slog.Debug("set from config file", "flag", s.name, "value", s.value)
+	}
+}
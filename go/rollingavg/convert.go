@@ -0,0 +1,161 @@
+// convert.go: unit conversion of input columns
+//
+// so mixed-unit field data can be normalised in the same pass as it's
+// averaged, -convert lists conversions of input columns, by name or index,
+// as col:from>to, e.g.
+//     -convert "TempF:F>C,Dist:ft>m"
+// applied to the input values before processing, after any -schema
+// coercions, see coerce.go, e.g. to strip the units first. the units are
+//     temperature  C, F, K
+//     length       m, km, cm, mm, in, ft, yd, mi, nmi
+//     mass         kg, g, mg, t, lb, oz
+//     speed        m/s, km/h, mph, kn, ft/s
+//     pressure     Pa, hPa, kPa, bar, mbar, psi, atm, mmHg
+//     energy       J, kJ, Wh, kWh, cal, kcal
+//     volume       m3, L, mL, gal
+// and a conversion must be between units of the same quantity. values
+// that aren't numbers are left as they are.
+// the converted values are rounded to 12 significant digits, hiding the
+// rounding errors of the conversion, e.g. 1 ft is 0.3048 m, not
+// 0.30479999999999996
+
+
+package main
+
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+var convertSpec string
+
+
+// a unit, converted to its quantity's base unit as value*scale + offset
+type unit struct {
+	quantity string
+	scale    float64
+	offset   float64
+}
+
+
+var units = map[string]unit{
+	"K": {"temperature", 1, 0},
+	"C": {"temperature", 1, 273.15},
+	"F": {"temperature", 5.0 / 9, 273.15 - 32*5.0/9},
+
+	"m":   {"length", 1, 0},
+	"km":  {"length", 1000, 0},
+	"cm":  {"length", 0.01, 0},
+	"mm":  {"length", 0.001, 0},
+	"in":  {"length", 0.0254, 0},
+	"ft":  {"length", 0.3048, 0},
+	"yd":  {"length", 0.9144, 0},
+	"mi":  {"length", 1609.344, 0},
+	"nmi": {"length", 1852, 0},
+
+	"kg": {"mass", 1, 0},
+	"g":  {"mass", 0.001, 0},
+	"mg": {"mass", 1e-6, 0},
+	"t":  {"mass", 1000, 0},
+	"lb": {"mass", 0.45359237, 0},
+	"oz": {"mass", 0.028349523125, 0},
+
+	"m/s":  {"speed", 1, 0},
+	"km/h": {"speed", 1 / 3.6, 0},
+	"mph":  {"speed", 0.44704, 0},
+	"kn":   {"speed", 1852.0 / 3600, 0},
+	"ft/s": {"speed", 0.3048, 0},
+
+	"Pa":   {"pressure", 1, 0},
+	"hPa":  {"pressure", 100, 0},
+	"kPa":  {"pressure", 1000, 0},
+	"bar":  {"pressure", 1e5, 0},
+	"mbar": {"pressure", 100, 0},
+	"psi":  {"pressure", 6894.757293168, 0},
+	"atm":  {"pressure", 101325, 0},
+	"mmHg": {"pressure", 133.322387415, 0},
+
+	"J":    {"energy", 1, 0},
+	"kJ":   {"energy", 1000, 0},
+	"Wh":   {"energy", 3600, 0},
+	"kWh":  {"energy", 3.6e6, 0},
+	"cal":  {"energy", 4.184, 0},
+	"kcal": {"energy", 4184, 0},
+
+	"m3":  {"volume", 1, 0},
+	"L":   {"volume", 0.001, 0},
+	"mL":  {"volume", 1e-6, 0},
+	"gal": {"volume", 0.003785411784, 0},
+}
+
+
+// a conversion of a column's values between units
+type conversion struct {
+	col      int
+	from, to unit
+}
+
+
+func (c *conversion) convert(v float64) float64 {
+	base := v*c.from.scale + c.from.offset
+	v = (base - c.to.offset) / c.to.scale
+	v, _ = strconv.ParseFloat(strconv.FormatFloat(v, 'g', 12, 64), 64)
+	return v
+}
+
+
+// parse a -convert list of col:from>to for input with the given header
+func parseConversions(spec string, header []string) []conversion {
+	var convs []conversion
+	for _, item := range strings.Split(spec, ",") {
+		i := strings.LastIndex(item, ":")
+		from, to, ok := strings.Cut(item[i+1:], ">")
+		if i < 0 || !ok {
+			fatal("invalid conversion, expected col:from>to", "conversion", item)
+		}
+		col := findColumn(header, strings.TrimSpace(item[:i]))
+		if col < 0 {
+			fatal("conversion column not found in header", "column", item[:i])
+		}
+		fu, ok1 := units[strings.TrimSpace(from)]
+		tu, ok2 := units[strings.TrimSpace(to)]
+		switch {
+		case !ok1 || !ok2:
+			fatal("unknown unit in conversion", "conversion", item)
+		case fu.quantity != tu.quantity:
+			fatal("conversion between units of different quantities", "conversion", item,
+				"from", fu.quantity, "to", tu.quantity)
+		}
+		convs = append(convs, conversion{col, fu, tu})
+	}
+	return convs
+}
+
+
+// a record source whose values are converted between units, in place
+type convertingReader struct {
+	in    recordReader
+	convs []conversion
+}
+
+
+func (r *convertingReader) Read() ([]string, error) {
+	record, err := r.in.Read()
+	if err != nil {
+		return record, err
+	}
+	for i := range r.convs {
+		c := &r.convs[i]
+		if c.col >= len(record) {
+			continue
+		}
+		if v, err := rollingavg.ParseFloat(record[c.col]); err == nil {
+			record[c.col] = strconv.FormatFloat(c.convert(v), 'f', -1, 64)
+		}
+	}
+	return record, nil
+}
@@ -0,0 +1,275 @@
+// csvcheck.go: validate CSV files against a schema
+//
+// for checking data deliveries, e.g. in CI, invoked as the csvcheck, or
+// validate, subcommand, see commands.go:
+//     mdp csvcheck [-v] -schema file.yaml [-max-errors n] [-f inputfile]... [-o outputfile] [inputfile...]
+// the schema is a YAML file of the expected columns, e.g.
+//     columns:
+//       - name: X
+//         type: int
+//         required: true
+//         min: -50
+//         max: 50
+//       - name: Time
+//         type: time
+//         required: true
+//         monotonic: true
+// types are int, float, string (the default) and time, in the Date Time
+// layout. a required column must be in the header, with no empty values,
+// other columns are checked if present. min and max bound the values of
+// numeric columns, and monotonic columns, numeric or time, must not
+// decrease from row to row.
+// a schema can be inferred from a sample of the data, see schema.go.
+// values are checked after any coercions of their column, see coerce.go.
+// each input file is checked separately. the errors found are output as
+// JSON lines, e.g.
+//     {"file":"in.csv","row":12,"column":"X","value":"x","error":"not an int"}
+// with row 0 for the header, up to -max-errors (default 100) per file, and
+// the exit status is 1 if there were any
+
+
+package main
+
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+	"gopkg.in/yaml.v3"
+)
+
+
+// the schema of a csvcheck schema file
+type csvSchema struct {
+	Columns []schemaColumn `yaml:"columns"`
+}
+
+
+// an expected column
+type schemaColumn struct {
+	Name      string   `yaml:"name"`
+	Type      string   `yaml:"type"`
+	Required  bool     `yaml:"required,omitempty"`
+	Min       *float64 `yaml:"min,omitempty"`
+	Max       *float64 `yaml:"max,omitempty"`
+	Monotonic bool     `yaml:"monotonic,omitempty"`
+	Coerce    []string `yaml:"coerce,omitempty"`
+}
+
+
+// an error found by csvcheck
+type checkError struct {
+	File   string `json:"file"`
+	Row    int    `json:"row"`
+	Column string `json:"column,omitempty"`
+	Value  string `json:"value,omitempty"`
+	Error  string `json:"error"`
+}
+
+
+// read and check a schema file
+func loadSchema(filename string) *csvSchema {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		fatal("error reading schema", "err", err)
+	}
+	var schema csvSchema
+	if err := yaml.Unmarshal(data, &schema); err != nil {
+		fatal("error parsing schema", "file", filename, "err", err)
+	}
+	for i, c := range schema.Columns {
+		switch c.Type {
+		case "":
+			schema.Columns[i].Type = "string"
+		case "int", "float", "string", "time":
+		default:
+			fatal("invalid column type in schema", "column", c.Name, "type", c.Type)
+		}
+		if c.Name == "" {
+			fatal("column without a name in schema", "file", filename)
+		}
+		if name := unknownCoercion(c); name != "" {
+			fatal("unknown coercion in schema", "column", c.Name, "coercion", name)
+		}
+	}
+	return &schema
+}
+
+
+func runCSVCheck(args []string) {
+	fs := flag.NewFlagSet("csvcheck", flag.ExitOnError)
+	schemafile := fs.String("schema", "", "YAML schema file of the expected columns")
+	maxErrors := fs.Int("max-errors", 100, "maximum number of errors output per file")
+	commonFlags(fs, "the errors found, as JSON lines")
+	fs.Parse(args)
+	infilenames = append(infilenames, fs.Args()...)
+	setupLogging()
+
+	if *schemafile == "" {
+		fatal("-schema is required")
+	}
+	schema := loadSchema(*schemafile)
+
+	slog.Debug("check CSV files against schema",
+		"inputs", infilenames, "output", outfilename, "schema", *schemafile, "columns", len(schema.Columns))
+
+	oufl := createOutput(outfilename, "")
+	w := bufio.NewWriter(oufl)
+	enc := json.NewEncoder(w)
+
+	files := []string(infilenames)
+	if len(files) == 0 {
+		files = []string{""}
+	}
+	total := 0
+	for _, filename := range files {
+		var errs []checkError
+		n := checkFile(filename, schema, func(e checkError) {
+			if len(errs) < *maxErrors {
+				errs = append(errs, e)
+			}
+		}, &total)
+		for _, e := range errs {
+			if err := enc.Encode(e); err != nil {
+				fatal("error writing errors", "err", err)
+			}
+		}
+		slog.Debug("checked file", "file", filename, "records", n, "errors", len(errs))
+	}
+
+	if err := w.Flush(); err != nil {
+		fatal("error writing errors", "err", err)
+	}
+	if err := oufl.Close(); err != nil {
+		fatal("error closing output", "err", err)
+	}
+	if total > 0 {
+		slog.Info("CSV check failed", "errors", total)
+		os.Exit(1)
+	}
+}
+
+
+// check a file, stdin if "", against the schema, calling report with each
+// error found, and adding their number to total. returns the number of
+// records checked
+func checkFile(filename string, schema *csvSchema, report func(checkError), total *int) int {
+	var filenames []string
+	if filename != "" {
+		filenames = []string{filename}
+	}
+	infile := openInputs(context.Background(), filenames, false, nil)
+	defer infile.Close()
+	fail := func(row int, column, value, msg string) {
+		*total++
+		report(checkError{filename, row, column, value, msg})
+	}
+
+	header, err := infile.Read()
+	if err != nil {
+		fail(0, "", "", fmt.Sprintf("error reading header: %v", err))
+		return 0
+	}
+
+	// the schema column of each header column, if any
+	cols := make([]*schemaColumn, len(header))
+	for i := range schema.Columns {
+		c := &schema.Columns[i]
+		col := findColumn(header, c.Name)
+		if col < 0 {
+			if c.Required {
+				fail(0, c.Name, "", "required column missing")
+			}
+			continue
+		}
+		cols[col] = c
+	}
+	// the last value of each monotonic column, if seen
+	last := make([]float64, len(header))
+	lastTime := make([]time.Time, len(header))
+	seen := make([]bool, len(header))
+
+	n := 0
+	for {
+		record, err := infile.Read()
+		if err == io.EOF {
+			break
+		}
+		n++
+		if err != nil {
+			fail(n, "", "", fmt.Sprintf("error reading record: %v", err))
+			// rows with the wrong number of fields are skipped, other
+			// errors end the file
+			var perr *csv.ParseError
+			if errors.As(err, &perr) && perr.Err == csv.ErrFieldCount {
+				continue
+			}
+			break
+		}
+		if verboseFlag {
+			slog.Debug("read record", "n", n, "record", record)
+		}
+		for i, c := range cols {
+			if c == nil || i >= len(record) {
+				continue
+			}
+			v := coerce(c, record[i])
+			if v == "" {
+				if c.Required {
+					fail(n, c.Name, v, "required value missing")
+				}
+				continue
+			}
+			var f float64
+			switch c.Type {
+			case "int":
+				iv, err := strconv.ParseInt(v, 10, 64)
+				if err != nil {
+					fail(n, c.Name, v, "not an int")
+					continue
+				}
+				f = float64(iv)
+			case "float":
+				if f, err = rollingavg.ParseFloat(v); err != nil {
+					fail(n, c.Name, v, "not a float")
+					continue
+				}
+			case "time":
+				t, err := rollingavg.ParseTime(v)
+				if err != nil {
+					fail(n, c.Name, v, "not a time")
+					continue
+				}
+				if c.Monotonic && seen[i] && t.Before(lastTime[i]) {
+					fail(n, c.Name, v, "time decreases")
+				}
+				lastTime[i], seen[i] = t, true
+				continue
+			default:
+				continue
+			}
+			if c.Min != nil && f < *c.Min {
+				fail(n, c.Name, v, fmt.Sprintf("less than minimum %v", *c.Min))
+			}
+			if c.Max != nil && f > *c.Max {
+				fail(n, c.Name, v, fmt.Sprintf("greater than maximum %v", *c.Max))
+			}
+			if c.Monotonic && seen[i] && f < last[i] {
+				fail(n, c.Name, v, "value decreases")
+			}
+			last[i], seen[i] = f, true
+		}
+	}
+	return n
+}
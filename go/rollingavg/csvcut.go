@@ -0,0 +1,159 @@
+// csvcut.go: select, drop, reorder and rename CSV columns
+//
+// invoked as the csvcut subcommand, see commands.go, streaming from stdin to
+// stdout by default, so it can be piped to and from rollingavg:
+//     mdp csvcut [-v] [-c col,...] [-drop col,...] [-rename col:name,...]
+//         [-f inputfile]... [-o outputfile] [inputfile...]
+// columns are given by header name, or by 0-based index, or a range of
+// indexes, e.g. 0-2. -c selects the columns output, in the order given, so
+// may reorder or repeat them, default all the columns in order. -drop
+// leaves columns out, and -rename renames columns in the output header,
+// e.g.
+//     mdp csvcut -c Time,0-2 -rename "Time:Date Time" in.csv
+
+
+package main
+
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"flag"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+
+// the indexes of a comma separated list of columns of header, and ranges
+// of them
+func parseColumnList(header []string, list string) []int {
+	var cols []int
+	for _, col := range strings.Split(list, ",") {
+		if i := findColumn(header, col); i >= 0 {
+			cols = append(cols, i)
+			continue
+		}
+		from, to, found := strings.Cut(col, "-")
+		a, err1 := strconv.Atoi(from)
+		b, err2 := strconv.Atoi(to)
+		if !found || err1 != nil || err2 != nil || a < 0 || a > b || b >= len(header) {
+			fatal("column not found in header", "column", col)
+		}
+		for i := a; i <= b; i++ {
+			cols = append(cols, i)
+		}
+	}
+	return cols
+}
+
+
+func runCSVCut(args []string) {
+	fs := flag.NewFlagSet("csvcut", flag.ExitOnError)
+	selected := fs.String("c", "", "comma separated columns (names, indexes or index ranges) to output, in order")
+	dropped := fs.String("drop", "", "comma separated columns (names, indexes or index ranges) to leave out")
+	renames := fs.String("rename", "", "comma separated col:name renames of output columns")
+	commonFlags(fs, "the columns selected")
+	fs.Parse(args)
+	infilenames = append(infilenames, fs.Args()...)
+	setupLogging()
+
+	slog.Debug("cut CSV columns",
+		"inputs", infilenames, "output", outfilename, "columns", *selected, "drop", *dropped, "rename", *renames)
+
+	infile := openInputs(context.Background(), infilenames, false, nil)
+	defer infile.Close()
+
+	oufl := createOutput(outfilename, "")
+	outfile := csv.NewWriter(bufio.NewWriter(oufl))
+
+	header, err := infile.Read()
+	if err != nil {
+		fatal("error reading header from csv", "err", err)
+	}
+	header = append([]string(nil), header...)
+
+	var cols []int
+	if *selected != "" {
+		cols = parseColumnList(header, *selected)
+	} else {
+		for i := range header {
+			cols = append(cols, i)
+		}
+	}
+	if *dropped != "" {
+		drop := make(map[int]bool)
+		for _, i := range parseColumnList(header, *dropped) {
+			drop[i] = true
+		}
+		kept := cols[:0]
+		for _, i := range cols {
+			if !drop[i] {
+				kept = append(kept, i)
+			}
+		}
+		cols = kept
+	}
+
+	names := make(map[int]string)
+	if *renames != "" {
+		for _, r := range strings.Split(*renames, ",") {
+			// the new name follows the last colon, as the column may have them
+			i := strings.LastIndex(r, ":")
+			if i < 0 {
+				fatal("invalid rename, expected col:name", "rename", r)
+			}
+			col := findColumn(header, r[:i])
+			if col < 0 {
+				fatal("column not found in header", "column", r[:i])
+			}
+			names[col] = r[i+1:]
+		}
+	}
+
+	outrec := make([]string, len(cols))
+	for j, i := range cols {
+		outrec[j] = header[i]
+		if name, found := names[i]; found {
+			outrec[j] = name
+		}
+	}
+	if err := outfile.Write(outrec); err != nil {
+		fatal("error writing record to csv", "err", err)
+	}
+
+	n := 0
+	for {
+		record, err := infile.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fatal("error reading record from csv", "err", err)
+		}
+		for j, i := range cols {
+			outrec[j] = ""
+			if i < len(record) {
+				outrec[j] = record[i]
+			}
+		}
+		if verboseFlag {
+			slog.Debug("write record", "n", n, "record", outrec)
+		}
+		if err := outfile.Write(outrec); err != nil {
+			fatal("error writing record to csv", "err", err)
+		}
+		n++
+	}
+	slog.Debug("cut records", "records", n, "columns", len(cols))
+
+	outfile.Flush()
+	if err := outfile.Error(); err != nil {
+		fatal("error writing csv", "err", err)
+	}
+	if err := oufl.Close(); err != nil {
+		fatal("error closing destination csv", "err", err)
+	}
+}
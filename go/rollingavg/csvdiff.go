@@ -0,0 +1,257 @@
+// csvdiff.go: compare two CSVs
+//
+// e.g. to validate that pipeline changes didn't alter results, invoked as
+// the csvdiff subcommand, see commands.go:
+//     mdp csvdiff [-v] [-k col,...] [-tolerance t] [-o outputfile] old.csv new.csv
+// rows are matched by position, or with -k, by their values in the key
+// columns (names, indexes or index ranges, as for csvcut), and their
+// columns by header name. the differences are output as a CSV of
+//     Change,Row,Column,Old,New
+// where Change is removed or added, for rows only in the old or new CSV,
+// with their values as Old or New, or changed, for each cell that differs,
+// or column removed or column added. Row is the row number, from 1, or
+// with -k, the key values, comma separated.
+// numbers differing by no more than -tolerance (default 0) are the same,
+// e.g. -tolerance 1e-9 for the rounding differences of -parallel.
+// with -k, the new CSV's rows are kept in memory, and its rows added are
+// output last, in their order.
+// the exit status is 1 if there are differences, as for diff
+
+
+package main
+
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"flag"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+// compares the rows of two CSVs
+type csvDiffer struct {
+	out       *csv.Writer
+	oldHeader []string
+	newHeader []string
+	cols      [][2]int // the old and new index of each common column
+	tolerance float64
+	diffs     int
+}
+
+
+// report a difference
+func (d *csvDiffer) report(change, row, column, oldv, newv string) {
+	d.diffs++
+	if err := d.out.Write([]string{change, row, column, oldv, newv}); err != nil {
+		fatal("error writing record to csv", "err", err)
+	}
+}
+
+
+// whether two values are the same, numbers within the tolerance
+func (d *csvDiffer) same(a, b string) bool {
+	if a == b {
+		return true
+	}
+	if d.tolerance == 0 {
+		return false
+	}
+	x, err1 := rollingavg.ParseFloat(a)
+	y, err2 := rollingavg.ParseFloat(b)
+	return err1 == nil && err2 == nil && math.Abs(x-y) <= d.tolerance
+}
+
+
+// compare an old and new row
+func (d *csvDiffer) compare(row string, oldv, newv []string) {
+	for _, c := range d.cols {
+		a, b := field(oldv, c[0]), field(newv, c[1])
+		if !d.same(a, b) {
+			d.report("changed", row, d.oldHeader[c[0]], a, b)
+		}
+	}
+}
+
+
+// a whole row removed or added
+func (d *csvDiffer) row(change, row string, record []string) {
+	v := strings.Join(record, ",")
+	if change == "removed" {
+		d.report(change, row, "", v, "")
+	} else {
+		d.report(change, row, "", "", v)
+	}
+}
+
+
+// open a CSV, returning it and a copy of its header
+func openDiffCSV(filename string) (*multiCSVReader, []string) {
+	in := openInputs(context.Background(), []string{filename}, false, nil)
+	header, err := in.Read()
+	if err != nil {
+		fatal("error reading header from csv", "file", filename, "err", err)
+	}
+	return in, append([]string(nil), header...)
+}
+
+
+func runCSVDiff(args []string) {
+	fs := flag.NewFlagSet("csvdiff", flag.ExitOnError)
+	keys := fs.String("k", "", "comma separated key columns matching rows (default match rows by position)")
+	tolerance := fs.Float64("tolerance", 0, "largest difference between numbers that are the same")
+	commonFlags(fs, "the differences")
+	fs.Parse(args)
+	infilenames = append(infilenames, fs.Args()...)
+	setupLogging()
+
+	if len(infilenames) != 2 {
+		fatal("csvdiff requires two input files, old and new")
+	}
+
+	slog.Debug("compare CSVs",
+		"inputs", infilenames, "output", outfilename, "keys", *keys, "tolerance", *tolerance)
+
+	oldin, oldHeader := openDiffCSV(infilenames[0])
+	defer oldin.Close()
+	newin, newHeader := openDiffCSV(infilenames[1])
+	defer newin.Close()
+
+	oufl := createOutput(outfilename, "")
+	outfile := csv.NewWriter(bufio.NewWriter(oufl))
+	if err := outfile.Write([]string{"Change", "Row", "Column", "Old", "New"}); err != nil {
+		fatal("error writing record to csv", "err", err)
+	}
+
+	d := &csvDiffer{out: outfile, oldHeader: oldHeader, newHeader: newHeader, tolerance: *tolerance}
+	for i, name := range oldHeader {
+		if j := slices.Index(newHeader, name); j >= 0 {
+			d.cols = append(d.cols, [2]int{i, j})
+		} else {
+			d.report("column removed", "", name, "", "")
+		}
+	}
+	for _, name := range newHeader {
+		if !slices.Contains(oldHeader, name) {
+			d.report("column added", "", name, "", "")
+		}
+	}
+
+	var n int
+	if *keys != "" {
+		n = d.diffKeyed(oldin, newin, parseColumnList(oldHeader, *keys), parseColumnList(newHeader, *keys))
+	} else {
+		n = d.diffPositional(oldin, newin)
+	}
+	slog.Debug("compared records", "records", n, "differences", d.diffs)
+
+	outfile.Flush()
+	if err := outfile.Error(); err != nil {
+		fatal("error writing csv", "err", err)
+	}
+	if err := oufl.Close(); err != nil {
+		fatal("error closing destination csv", "err", err)
+	}
+	if d.diffs > 0 {
+		os.Exit(1)
+	}
+}
+
+
+// read a record, or nil at the end
+func readDiffRecord(in recordReader) []string {
+	record, err := in.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		fatal("error reading record from csv", "err", err)
+	}
+	return record
+}
+
+
+// compare rows by position, returning the number of old rows
+func (d *csvDiffer) diffPositional(oldin, newin recordReader) int {
+	n := 0
+	for {
+		oldv, newv := readDiffRecord(oldin), readDiffRecord(newin)
+		if oldv == nil && newv == nil {
+			return n
+		}
+		row := strconv.Itoa(n + 1)
+		switch {
+		case newv == nil:
+			d.row("removed", row, oldv)
+		case oldv == nil:
+			d.row("added", row, newv)
+		default:
+			d.compare(row, oldv, newv)
+		}
+		if oldv != nil {
+			n++
+		}
+	}
+}
+
+
+// compare rows by key, returning the number of old rows
+func (d *csvDiffer) diffKeyed(oldin, newin recordReader, oldkeys, newkeys []int) int {
+	rowKey := func(record []string, keys []int) string {
+		values := make([]string, len(keys))
+		for i, k := range keys {
+			values[i] = field(record, k)
+		}
+		return strings.Join(values, ",")
+	}
+
+	rows := make(map[string][]string)
+	var order []string
+	for {
+		record := readDiffRecord(newin)
+		if record == nil {
+			break
+		}
+		key := rowKey(record, newkeys)
+		if _, found := rows[key]; found {
+			fatal("duplicate key in new csv", "key", key)
+		}
+		rows[key] = record
+		order = append(order, key)
+	}
+
+	n := 0
+	seen := make(map[string]bool)
+	for {
+		oldv := readDiffRecord(oldin)
+		if oldv == nil {
+			break
+		}
+		n++
+		key := rowKey(oldv, oldkeys)
+		if seen[key] {
+			fatal("duplicate key in old csv", "key", key)
+		}
+		seen[key] = true
+		if newv, found := rows[key]; found {
+			d.compare(key, oldv, newv)
+		} else {
+			d.row("removed", key, oldv)
+		}
+	}
+	for _, key := range order {
+		if !seen[key] {
+			d.row("added", key, rows[key])
+		}
+	}
+	return n
+}
@@ -0,0 +1,427 @@
+// csvfilter.go: keep or drop CSV rows matching an expression
+//
+// invoked as the csvfilter subcommand, see commands.go, and streaming from
+// stdin to stdout by default, so it can be piped into rollingavg:
+//     mdp csvfilter [-v] -e expr [-drop] [-time col] [-f inputfile]... [-o outputfile] [inputfile...]
+// keeps the rows matching the expression, or with -drop, the rows that
+// don't, e.g.
+//     mdp csvfilter -e 'X > 0 && time >= 2024-01-01' in.csv | rollingavg
+// expressions compare column values, with ==, !=, <, <=, > and >=, and
+// combine comparisons with &&, || and !, and parentheses. columns are named
+// as in the header, or in backquotes, e.g. `Date Time`, if they aren't
+// simple names. time is the -time column (default 3), unless there's a
+// column named time. values are numbers, strings in double quotes, or
+// timestamps, e.g. 2024-01-01, 2024-01-01 09:30 or 2024-01-01 09:30:00.
+// comparisons with a timestamp, or of time, compare times, those with a
+// number compare numbers, and others compare numbers if both values are
+// numbers, and strings otherwise. a comparison of a value that isn't a
+// number or time, as needed, doesn't match
+
+
+package main
+
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+// a token of a filter expression
+type filterToken struct {
+	kind byte // 'n' number, 's' string, 't' timestamp, 'c' column, 'o' operator
+	text string
+}
+
+
+// an operand of a comparison, a column or a value
+type filterOperand struct {
+	col    int // the column, or -1 for a value
+	isTime bool
+	isNum  bool
+	text   string
+	num    float64
+	t      time.Time
+}
+
+
+// the parser of a filter expression, for a header
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+	header []string
+	tcol   int
+}
+
+
+// the layouts of timestamps in expressions, other than TimeLayout
+var filterTimeLayouts = []string{"2006-01-02 15:04", "2006-01-02"}
+
+
+// split an expression into tokens
+func tokenizeFilter(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+	isDigit := func(c byte) bool { return c >= '0' && c <= '9' }
+	isName := func(c byte) bool {
+		return c == '_' || c == '.' || isDigit(c) || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+	}
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '"' || c == '`':
+			j := strings.IndexByte(expr[i+1:], c)
+			if j < 0 {
+				return nil, fmt.Errorf("unterminated %c at %d", c, i)
+			}
+			kind := byte('s')
+			if c == '`' {
+				kind = 'c'
+			}
+			tokens = append(tokens, filterToken{kind, expr[i+1 : i+1+j]})
+			i += j + 2
+		case strings.HasPrefix(expr[i:], "&&") || strings.HasPrefix(expr[i:], "||") ||
+			strings.HasPrefix(expr[i:], "==") || strings.HasPrefix(expr[i:], "!=") ||
+			strings.HasPrefix(expr[i:], "<=") || strings.HasPrefix(expr[i:], ">="):
+			tokens = append(tokens, filterToken{'o', expr[i : i+2]})
+			i += 2
+		case strings.IndexByte("<>!()", c) >= 0:
+			tokens = append(tokens, filterToken{'o', expr[i : i+1]})
+			i++
+		case isDigit(c) || (c == '-' || c == '+') && i+1 < len(expr) && isDigit(expr[i+1]):
+			j := i + 1
+			for j < len(expr) && (isName(expr[j]) || expr[j] == '-' || expr[j] == '+' || expr[j] == ':') {
+				j++
+			}
+			// a date may be followed by a time of day
+			if j-i == 10 && j+3 < len(expr) && expr[j] == ' ' && isDigit(expr[j+1]) && isDigit(expr[j+2]) && expr[j+3] == ':' {
+				j++
+				for j < len(expr) && (isDigit(expr[j]) || expr[j] == ':' || expr[j] == '.') {
+					j++
+				}
+			}
+			text := expr[i:j]
+			if _, err := strconv.ParseFloat(text, 64); err == nil {
+				tokens = append(tokens, filterToken{'n', text})
+			} else {
+				tokens = append(tokens, filterToken{'t', text})
+			}
+			i = j
+		case isName(c):
+			j := i + 1
+			for j < len(expr) && isName(expr[j]) {
+				j++
+			}
+			tokens = append(tokens, filterToken{'c', expr[i:j]})
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected %q at %d", c, i)
+		}
+	}
+	return tokens, nil
+}
+
+
+// compile an expression for rows with the given header, with time
+// column tcol
+func compileFilter(expr string, header []string, tcol int) (func([]string) bool, error) {
+	tokens, err := tokenizeFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens, header: header, tcol: tcol}
+	match, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	return match, nil
+}
+
+
+// the next token, if it's the operator op
+func (p *filterParser) accept(op string) bool {
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == 'o' && p.tokens[p.pos].text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+
+func (p *filterParser) or() (func([]string) bool, error) {
+	left, err := p.and()
+	for err == nil && p.accept("||") {
+		var right func([]string) bool
+		if right, err = p.and(); err == nil {
+			l := left
+			left = func(r []string) bool { return l(r) || right(r) }
+		}
+	}
+	return left, err
+}
+
+
+func (p *filterParser) and() (func([]string) bool, error) {
+	left, err := p.not()
+	for err == nil && p.accept("&&") {
+		var right func([]string) bool
+		if right, err = p.not(); err == nil {
+			l := left
+			left = func(r []string) bool { return l(r) && right(r) }
+		}
+	}
+	return left, err
+}
+
+
+func (p *filterParser) not() (func([]string) bool, error) {
+	if p.accept("!") {
+		m, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return func(r []string) bool { return !m(r) }, nil
+	}
+	if p.accept("(") {
+		m, err := p.or()
+		if err == nil && !p.accept(")") {
+			err = fmt.Errorf("missing )")
+		}
+		return m, err
+	}
+	return p.comparison()
+}
+
+
+// a comparison of two operands
+func (p *filterParser) comparison() (func([]string) bool, error) {
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != 'o' {
+		return nil, fmt.Errorf("expected a comparison after %q", left.text)
+	}
+	op := p.tokens[p.pos].text
+	var test func(c int) bool
+	switch op {
+	case "==":
+		test = func(c int) bool { return c == 0 }
+	case "!=":
+		test = func(c int) bool { return c != 0 }
+	case "<":
+		test = func(c int) bool { return c < 0 }
+	case "<=":
+		test = func(c int) bool { return c <= 0 }
+	case ">":
+		test = func(c int) bool { return c > 0 }
+	case ">=":
+		test = func(c int) bool { return c >= 0 }
+	default:
+		return nil, fmt.Errorf("expected a comparison after %q", left.text)
+	}
+	p.pos++
+	right, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case left.isTime || right.isTime:
+		return func(r []string) bool {
+			a, ok1 := left.time(r)
+			b, ok2 := right.time(r)
+			return ok1 && ok2 && test(a.Compare(b))
+		}, nil
+	case left.isNum || right.isNum:
+		return func(r []string) bool {
+			a, ok1 := left.number(r)
+			b, ok2 := right.number(r)
+			return ok1 && ok2 && test(compareFloats(a, b))
+		}, nil
+	}
+	return func(r []string) bool {
+		a, b := left.value(r), right.value(r)
+		x, err1 := rollingavg.ParseFloat(a)
+		y, err2 := rollingavg.ParseFloat(b)
+		if err1 == nil && err2 == nil {
+			return test(compareFloats(x, y))
+		}
+		return test(strings.Compare(a, b))
+	}, nil
+}
+
+
+// a column or value operand
+func (p *filterParser) operand() (filterOperand, error) {
+	if p.pos >= len(p.tokens) {
+		return filterOperand{}, fmt.Errorf("unexpected end of expression")
+	}
+	tok := p.tokens[p.pos]
+	p.pos++
+	o := filterOperand{col: -1, text: tok.text}
+	switch tok.kind {
+	case 'n':
+		o.isNum = true
+		o.num, _ = strconv.ParseFloat(tok.text, 64)
+	case 's':
+	case 't':
+		t, ok := parseFilterTime(tok.text)
+		if !ok {
+			return o, fmt.Errorf("invalid timestamp %q", tok.text)
+		}
+		o.isTime, o.t = true, t
+	case 'c':
+		o.col = findColumn(p.header, tok.text)
+		if o.col < 0 && tok.text == "time" && p.tcol >= 0 {
+			o.col = p.tcol
+			o.isTime = true
+		}
+		if o.col < 0 {
+			return o, fmt.Errorf("column not found in header: %s", tok.text)
+		}
+	default:
+		return o, fmt.Errorf("unexpected %q", tok.text)
+	}
+	return o, nil
+}
+
+
+// parse a timestamp in any of the expression layouts
+func parseFilterTime(s string) (time.Time, bool) {
+	if t, err := rollingavg.ParseTime(s); err == nil {
+		return t, true
+	}
+	for _, layout := range filterTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+
+// compare two numbers, returning -1, 0 or 1
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+
+// the operand's value in a row
+func (o *filterOperand) value(r []string) string {
+	if o.col < 0 {
+		return o.text
+	}
+	if o.col >= len(r) {
+		return ""
+	}
+	return r[o.col]
+}
+
+
+// the operand's value in a row as a number, false if it isn't one
+func (o *filterOperand) number(r []string) (float64, bool) {
+	if o.col < 0 {
+		return o.num, o.isNum
+	}
+	f, err := rollingavg.ParseFloat(o.value(r))
+	return f, err == nil
+}
+
+
+// the operand's value in a row as a timestamp, false if it isn't one
+func (o *filterOperand) time(r []string) (time.Time, bool) {
+	if o.isTime && o.col < 0 {
+		return o.t, true
+	}
+	return parseFilterTime(o.value(r))
+}
+
+
+func runCSVFilter(args []string) {
+	fs := flag.NewFlagSet("csvfilter", flag.ExitOnError)
+	expr := fs.String("e", "", "expression rows are matched by, e.g. 'X > 0 && time >= 2024-01-01'")
+	drop := fs.Bool("drop", false, "drop the matching rows, rather than keeping them")
+	timecol := fs.String("time", "3", "timestamp column (name or index) of time in expressions")
+	commonFlags(fs, "the rows kept")
+	fs.Parse(args)
+	infilenames = append(infilenames, fs.Args()...)
+	setupLogging()
+
+	if *expr == "" {
+		fatal("-e expression is required")
+	}
+
+	slog.Debug("filter CSV rows",
+		"inputs", infilenames, "output", outfilename, "expr", *expr, "drop", *drop)
+
+	infile := openInputs(context.Background(), infilenames, false, nil)
+	defer infile.Close()
+
+	oufl := createOutput(outfilename, "")
+	outfile := csv.NewWriter(bufio.NewWriter(oufl))
+
+	header, err := infile.Read()
+	if err != nil {
+		fatal("error reading header from csv", "err", err)
+	}
+	header = append([]string(nil), header...)
+	if err := outfile.Write(header); err != nil {
+		fatal("error writing record to csv", "err", err)
+	}
+	match, err := compileFilter(*expr, header, findColumn(header, *timecol))
+	if err != nil {
+		fatal("invalid filter expression", "expr", *expr, "err", err)
+	}
+
+	n, kept := 0, 0
+	for {
+		record, err := infile.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fatal("error reading record from csv", "err", err)
+		}
+		n++
+		if match(record) == *drop {
+			continue
+		}
+		if verboseFlag {
+			slog.Debug("write record", "n", n, "record", record)
+		}
+		if err := outfile.Write(record); err != nil {
+			fatal("error writing record to csv", "err", err)
+		}
+		kept++
+	}
+	slog.Debug("filtered records", "records", n, "kept", kept)
+
+	outfile.Flush()
+	if err := outfile.Error(); err != nil {
+		fatal("error writing csv", "err", err)
+	}
+	if err := oufl.Close(); err != nil {
+		fatal("error closing destination csv", "err", err)
+	}
+}
@@ -0,0 +1,236 @@
+// csvjoin.go: join two CSVs on their timestamps
+//
+// to align e.g. sensor and reference data before rolling analysis, invoked
+// as the csvjoin subcommand, see commands.go:
+//     mdp csvjoin [-v] [-match exact|asof|nearest] [-tolerance duration] [-inner]
+//         [-left-time col] [-right-time col] [-o outputfile] left.csv right.csv
+// each row of the left CSV is output with the columns of a row of the
+// right CSV, other than its timestamp, matched by timestamp:
+//     exact    the right row with the same timestamp
+//     asof     the last right row at or before the left row's timestamp
+//     nearest  the right row with the closest timestamp, the earlier if two
+//              are as close
+// within -tolerance of the left row's timestamp, if given. when several
+// right rows have the matching timestamp, the last is used.
+// left rows without a match have empty right columns, or with -inner, are
+// dropped. right columns with the same name as a left column are prefixed
+// with "right ".
+// both CSVs must be sorted by time, and are streamed, so can be any size.
+// to join a CSV as of the input rows while processing them, see -join-asof,
+// join.go
+
+
+package main
+
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"flag"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+// a CSV sorted by time, read a row at a time
+type timedCSV struct {
+	name string
+	in   recordReader
+	tcol int
+	n    int
+}
+
+
+// a row of a timedCSV, and its timestamp
+type timedRow struct {
+	record []string
+	t      time.Time
+}
+
+
+// read the next row, or nil at the end
+func (c *timedCSV) next(prev *timedRow) *timedRow {
+	record, err := c.in.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		fatal("error reading record from csv", "file", c.name, "err", err)
+	}
+	c.n++
+	if verboseFlag {
+		slog.Debug("read record", "file", c.name, "n", c.n, "record", record)
+	}
+	if c.tcol >= len(record) {
+		fatal("missing time column in csv", "file", c.name, "row", c.n)
+	}
+	t, err := rollingavg.ParseTime(record[c.tcol])
+	if err != nil {
+		fatal("invalid timestamp in csv", "file", c.name, "row", c.n, "err", err)
+	}
+	if prev != nil && t.Before(prev.t) {
+		fatal("csv not sorted by time", "file", c.name, "row", c.n)
+	}
+	return &timedRow{record, t}
+}
+
+
+// open a CSV sorted by time, returning it and its header
+func openTimedCSV(filename string, timecol string) (*timedCSV, []string) {
+	in := openInputs(context.Background(), []string{filename}, false, nil)
+	header, err := in.Read()
+	if err != nil {
+		fatal("error reading header from csv", "file", filename, "err", err)
+	}
+	header = append([]string(nil), header...)
+	tcol := findColumn(header, timecol)
+	if tcol < 0 {
+		fatal("time column not found in header", "file", filename, "column", timecol)
+	}
+	return &timedCSV{name: filename, in: in, tcol: tcol}, header
+}
+
+
+func runCSVJoin(args []string) {
+	fs := flag.NewFlagSet("csvjoin", flag.ExitOnError)
+	match := fs.String("match", "exact", "timestamp matching: exact, asof or nearest")
+	tolerance := fs.Duration("tolerance", 0, "the furthest a matched right row's timestamp may be from the left row's (default no limit)")
+	inner := fs.Bool("inner", false, "drop left rows without a matching right row")
+	lefttime := fs.String("left-time", "3", "timestamp column (name or index) of the left CSV")
+	righttime := fs.String("right-time", "3", "timestamp column (name or index) of the right CSV")
+	commonFlags(fs, "the joined rows")
+	fs.Parse(args)
+	infilenames = append(infilenames, fs.Args()...)
+	setupLogging()
+
+	if len(infilenames) != 2 {
+		fatal("csvjoin requires two input files, left and right")
+	}
+	if *match != "exact" && *match != "asof" && *match != "nearest" {
+		fatal("invalid timestamp matching", "match", *match)
+	}
+
+	slog.Debug("join CSVs by timestamp",
+		"inputs", infilenames, "output", outfilename, "match", *match, "tolerance", *tolerance, "inner", *inner)
+
+	left, lheader := openTimedCSV(infilenames[0], *lefttime)
+	right, rheader := openTimedCSV(infilenames[1], *righttime)
+
+	oufl := createOutput(outfilename, "")
+	outfile := csv.NewWriter(bufio.NewWriter(oufl))
+
+	if err := outfile.Write(joinedHeader(lheader, rheader, right.tcol)); err != nil {
+		fatal("error writing record to csv", "err", err)
+	}
+
+	written := genJoin(left, right, outfile, len(rheader), *match, *tolerance, *inner)
+
+	outfile.Flush()
+	if err := outfile.Error(); err != nil {
+		fatal("error writing csv", "err", err)
+	}
+	if err := oufl.Close(); err != nil {
+		fatal("error closing destination csv", "err", err)
+	}
+	slog.Debug("joined records", "left", left.n, "right", right.n, "written", written)
+}
+
+
+// the header of joined rows, of the left columns and the right other than
+// time, prefixed with "right " if a left column has the same name
+func joinedHeader(lheader, rheader []string, rtcol int) []string {
+	names := make(map[string]bool)
+	outrec := append([]string(nil), lheader...)
+	for _, name := range lheader {
+		names[name] = true
+	}
+	for i, name := range rheader {
+		if i == rtcol {
+			continue
+		}
+		if names[name] {
+			name = "right " + name
+		}
+		outrec = append(outrec, name)
+	}
+	return outrec
+}
+
+
+// append the values of the right row r, with rcols columns, other than its
+// time column, to outrec, or empty values if r is nil
+func appendJoined(outrec []string, r *timedRow, rcols int, rtcol int) []string {
+	for i := 0; i < rcols; i++ {
+		if i == rtcol {
+			continue
+		}
+		v := ""
+		if r != nil && i < len(r.record) {
+			v = r.record[i]
+		}
+		outrec = append(outrec, v)
+	}
+	return outrec
+}
+
+
+// join the rows of left to those of right, with rcols columns, writing them
+// to outcsv. returns the number of rows written
+func genJoin(left, right *timedCSV, outcsv *csv.Writer, rcols int, match string, tolerance time.Duration, inner bool) int {
+	within := func(d time.Duration) bool {
+		return tolerance == 0 || d <= tolerance
+	}
+	// the last right row at or before the left row, and the one after it
+	var prev *timedRow
+	next := right.next(nil)
+
+	written := 0
+	var l *timedRow
+	for {
+		if l = left.next(l); l == nil {
+			break
+		}
+		for next != nil && !next.t.After(l.t) {
+			prev, next = next, right.next(next)
+		}
+
+		var r *timedRow
+		switch match {
+		case "exact":
+			if prev != nil && prev.t.Equal(l.t) {
+				r = prev
+			}
+		case "asof":
+			if prev != nil && within(l.t.Sub(prev.t)) {
+				r = prev
+			}
+		case "nearest":
+			switch {
+			case prev != nil && (next == nil || l.t.Sub(prev.t) <= next.t.Sub(l.t)):
+				r = prev
+			case next != nil:
+				r = next
+			}
+			if r != nil && !within(max(l.t.Sub(r.t), r.t.Sub(l.t))) {
+				r = nil
+			}
+		}
+		if r == nil && inner {
+			continue
+		}
+
+		outrec := appendJoined(append([]string(nil), l.record...), r, rcols, right.tcol)
+		if verboseFlag {
+			slog.Debug("write record", "record", outrec)
+		}
+		if err := outcsv.Write(outrec); err != nil {
+			fatal("error writing record to csv", "err", err)
+		}
+		written++
+	}
+	return written
+}
@@ -0,0 +1,342 @@
+// csvsort.go: sort CSV rows, of any size, by columns
+//
+// e.g. to put rows into time order for rollingavg, invoked as the sort
+// subcommand, see commands.go:
+//     mdp sort [-v] -k col[:n|:t][:desc],... [-buffer size] [-tmp-dir dir]
+//         [-f inputfile]... [-o outputfile] [inputfile...]
+// rows are sorted by the -k columns, in order, each compared as strings, or
+// with :n, as numbers, or with :t, as timestamps, in ascending order, or
+// with :desc, descending, e.g. -k Z,Time:t. values that aren't numbers or
+// timestamps, as needed, sort after those that are. the sort is stable, so
+// rows with the same keys stay in input order.
+// rows are read in runs of about -buffer bytes (default 256M), each sorted
+// in memory, and if there's more than one, written to a temporary file in
+// -tmp-dir (default the system temporary directory), and the runs then
+// merged, so files much larger than memory can be sorted, with the
+// temporary files taking about as much space as the input
+
+
+package main
+
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"encoding/csv"
+	"flag"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+// a column rows are sorted by
+type sortKey struct {
+	col  int
+	kind byte // 's' string, 'n' number, 't' timestamp
+	desc bool
+}
+
+
+// a row and its parsed sort key values
+type sortRow struct {
+	record []string
+	nums   []float64
+	times  []time.Time
+	valid  []bool
+}
+
+
+// rows sorted by keys
+type rowSorter struct {
+	keys []sortKey
+}
+
+
+// a sorted run of rows in a temporary file, being merged
+type sortRun struct {
+	fl  *os.File
+	in  *csv.Reader
+	row *sortRow
+	idx int
+}
+
+
+// the runs being merged, a heap of their next rows
+type runHeap struct {
+	s    *rowSorter
+	runs []*sortRun
+}
+
+func (h *runHeap) Len() int { return len(h.runs) }
+func (h *runHeap) Less(i, j int) bool {
+	// rows with the same keys are merged in run order, keeping the sort stable
+	c := h.s.compare(h.runs[i].row, h.runs[j].row)
+	return c < 0 || c == 0 && h.runs[i].idx < h.runs[j].idx
+}
+func (h *runHeap) Swap(i, j int) { h.runs[i], h.runs[j] = h.runs[j], h.runs[i] }
+func (h *runHeap) Push(x any)    { h.runs = append(h.runs, x.(*sortRun)) }
+func (h *runHeap) Pop() any {
+	r := h.runs[len(h.runs)-1]
+	h.runs = h.runs[:len(h.runs)-1]
+	return r
+}
+
+
+// parse a comma separated list of col[:n|:t][:desc] sort keys
+func parseSortKeys(header []string, list string) []sortKey {
+	var keys []sortKey
+	for _, k := range strings.Split(list, ",") {
+		key := sortKey{kind: 's'}
+		// options follow the column, which may have colons itself
+		for {
+			i := strings.LastIndex(k, ":")
+			if i < 0 {
+				break
+			}
+			switch k[i+1:] {
+			case "n", "t":
+				key.kind = k[i+1]
+			case "desc":
+				key.desc = true
+			default:
+				i = -1
+			}
+			if i < 0 {
+				break
+			}
+			k = k[:i]
+		}
+		if key.col = findColumn(header, k); key.col < 0 {
+			fatal("sort column not found in header", "column", k)
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+
+// parse the sort key values of a record
+func (s *rowSorter) row(record []string) *sortRow {
+	r := &sortRow{record: record, nums: make([]float64, len(s.keys)),
+		times: make([]time.Time, len(s.keys)), valid: make([]bool, len(s.keys))}
+	for i, k := range s.keys {
+		v := ""
+		if k.col < len(record) {
+			v = record[k.col]
+		}
+		var err error
+		switch k.kind {
+		case 'n':
+			r.nums[i], err = rollingavg.ParseFloat(v)
+		case 't':
+			r.times[i], err = rollingavg.ParseTime(v)
+		}
+		r.valid[i] = err == nil
+	}
+	return r
+}
+
+
+// compare two rows by the keys, returning -1, 0 or 1
+func (s *rowSorter) compare(a, b *sortRow) int {
+	for i, k := range s.keys {
+		var c int
+		switch {
+		case a.valid[i] != b.valid[i]:
+			// invalid values after valid ones, whatever the order
+			if a.valid[i] {
+				return -1
+			}
+			return 1
+		case k.kind == 'n' && a.valid[i]:
+			c = compareFloats(a.nums[i], b.nums[i])
+		case k.kind == 't' && a.valid[i]:
+			c = a.times[i].Compare(b.times[i])
+		default:
+			c = strings.Compare(field(a.record, k.col), field(b.record, k.col))
+		}
+		if k.desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+
+// the value of a column of a record, or "" if it's missing
+func field(record []string, col int) string {
+	if col < len(record) {
+		return record[col]
+	}
+	return ""
+}
+
+
+func runCSVSort(args []string) {
+	fs := flag.NewFlagSet("sort", flag.ExitOnError)
+	keylist := fs.String("k", "", "comma separated sort columns (name or index), each optionally followed by :n (numeric) or :t (timestamp) and :desc")
+	var buffer sizeFlag = 256 << 20
+	fs.Var(&buffer, "buffer", "approximate memory for each sorted run of rows (K, M, G suffixes allowed)")
+	tmpdir := fs.String("tmp-dir", "", "directory for temporary run files (default the system temporary directory)")
+	commonFlags(fs, "the sorted rows")
+	fs.Parse(args)
+	infilenames = append(infilenames, fs.Args()...)
+	setupLogging()
+
+	if *keylist == "" {
+		fatal("-k sort columns are required")
+	}
+	if buffer <= 0 {
+		fatal("invalid buffer size", "size", int64(buffer))
+	}
+
+	slog.Debug("sort CSV rows",
+		"inputs", infilenames, "output", outfilename, "keys", *keylist, "buffer", formatBytes(int64(buffer)))
+
+	infile := openInputs(context.Background(), infilenames, false, nil)
+	defer infile.Close()
+
+	header, err := infile.Read()
+	if err != nil {
+		fatal("error reading header from csv", "err", err)
+	}
+	header = append([]string(nil), header...)
+	s := &rowSorter{keys: parseSortKeys(header, *keylist)}
+
+	oufl := createOutput(outfilename, "")
+	outfile := csv.NewWriter(bufio.NewWriter(oufl))
+	if err := outfile.Write(header); err != nil {
+		fatal("error writing record to csv", "err", err)
+	}
+
+	// sort runs of rows in memory, spilling them to run files if there's
+	// more than one
+	var runs []*sortRun
+	defer func() {
+		for _, r := range runs {
+			r.fl.Close()
+			os.Remove(r.fl.Name())
+		}
+	}()
+	var rows []*sortRow
+	var size int64
+	n := 0
+	for {
+		record, err := infile.Read()
+		if err != nil && err != io.EOF {
+			fatal("error reading record from csv", "err", err)
+		}
+		if err == nil {
+			n++
+			rows = append(rows, s.row(record))
+			size += 64 + 24*int64(len(s.keys))
+			for _, v := range record {
+				size += 16 + int64(len(v))
+			}
+			if size < int64(buffer) {
+				continue
+			}
+		}
+		if len(rows) > 0 && (err == nil || len(runs) > 0) {
+			runs = append(runs, s.writeRun(rows, *tmpdir, len(runs)))
+			rows, size = nil, 0
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	slog.Debug("sorted records", "records", n, "runs", len(runs))
+
+	if len(runs) == 0 {
+		// a single run, sorted in memory
+		sort.SliceStable(rows, func(i, j int) bool { return s.compare(rows[i], rows[j]) < 0 })
+		for _, r := range rows {
+			if err := outfile.Write(r.record); err != nil {
+				fatal("error writing record to csv", "err", err)
+			}
+		}
+	} else {
+		s.mergeRuns(runs, outfile)
+	}
+
+	outfile.Flush()
+	if err := outfile.Error(); err != nil {
+		fatal("error writing csv", "err", err)
+	}
+	if err := oufl.Close(); err != nil {
+		fatal("error closing destination csv", "err", err)
+	}
+}
+
+
+// sort rows, and write them to a temporary run file
+func (s *rowSorter) writeRun(rows []*sortRow, dir string, idx int) *sortRun {
+	sort.SliceStable(rows, func(i, j int) bool { return s.compare(rows[i], rows[j]) < 0 })
+	fl, err := os.CreateTemp(dir, "mdp-sort-*.csv")
+	if err != nil {
+		fatal("error creating sort run file", "err", err)
+	}
+	w := bufio.NewWriter(fl)
+	out := csv.NewWriter(w)
+	for _, r := range rows {
+		out.Write(r.record)
+	}
+	out.Flush()
+	if err := out.Error(); err != nil {
+		fatal("error writing sort run file", "err", err)
+	}
+	if _, err := fl.Seek(0, io.SeekStart); err != nil {
+		fatal("error writing sort run file", "err", err)
+	}
+	slog.Debug("wrote sort run", "file", fl.Name(), "rows", len(rows))
+	in := csv.NewReader(bufio.NewReader(fl))
+	in.FieldsPerRecord = -1
+	return &sortRun{fl: fl, in: in, idx: idx}
+}
+
+
+// read the next row of a run, returning false at its end
+func (s *rowSorter) next(r *sortRun) bool {
+	record, err := r.in.Read()
+	if err == io.EOF {
+		return false
+	}
+	if err != nil {
+		fatal("error reading sort run file", "err", err)
+	}
+	r.row = s.row(record)
+	return true
+}
+
+
+// merge the sorted runs to outcsv
+func (s *rowSorter) mergeRuns(runs []*sortRun, outcsv *csv.Writer) {
+	h := &runHeap{s: s}
+	for _, r := range runs {
+		if s.next(r) {
+			h.runs = append(h.runs, r)
+		}
+	}
+	heap.Init(h)
+	for h.Len() > 0 {
+		r := h.runs[0]
+		if err := outcsv.Write(r.row.record); err != nil {
+			fatal("error writing record to csv", "err", err)
+		}
+		if s.next(r) {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+}
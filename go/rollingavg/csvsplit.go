@@ -0,0 +1,86 @@
+// csvsplit.go: split a CSV into parts by row count or size
+//
+// for parallel downstream processing, invoked as the csvsplit subcommand,
+// see commands.go:
+//     mdp csvsplit [-v] -rows nrows | -size size [-pattern pattern] [-z gzip|zstd]
+//         [-f inputfile]... [-o outputfile] [inputfile...]
+// the rows are written to parts of -rows rows, or about -size bytes of CSV
+// text (K, M, G suffixes allowed), each starting with the header row.
+// parts are named as for -rotate-size, see rotate.go, from -pattern
+// (default "{dir}/{name}.{n}{ext}") and -o, or without -o, the single input
+// file, so in.csv is split into in.0000.csv, in.0001.csv, ...
+
+
+package main
+
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log/slog"
+)
+
+
+func runCSVSplit(args []string) {
+	fs := flag.NewFlagSet("csvsplit", flag.ExitOnError)
+	rows := fs.Int("rows", 0, "number of rows of each part")
+	var size sizeFlag
+	fs.Var(&size, "size", "approximate size of each part (K, M, G suffixes allowed)")
+	pattern := fs.String("pattern", defaultRotatePattern, "part filename pattern, see rotate.go")
+	compression := fs.String("z", "", "compress parts with gzip or zstd (default from the filename extension)")
+	commonFlags(fs, "the first part, named by -pattern")
+	fs.Parse(args)
+	infilenames = append(infilenames, fs.Args()...)
+	setupLogging()
+
+	switch {
+	case (*rows > 0) == (size > 0):
+		fatal("exactly one of -rows or -size is required")
+	case *rows < 0 || size < 0:
+		fatal("invalid -rows or -size", "rows", *rows, "size", int64(size))
+	}
+	outname := outfilename
+	if outname == "" {
+		if len(infilenames) != 1 || isRemote(infilenames[0]) {
+			fatal("-o is required, unless splitting a single local input file")
+		}
+		outname = infilenames[0]
+	}
+
+	slog.Debug("split CSV rows into parts",
+		"inputs", infilenames, "output", outname, "pattern", *pattern, "rows", *rows, "size", int64(size))
+
+	infile := openInputs(context.Background(), infilenames, false, nil)
+	defer infile.Close()
+
+	out := newRotatingCSV(outname, *pattern, *compression, int64(size), 0, nil)
+	out.maxRows = *rows
+
+	n := 0
+	for {
+		record, err := infile.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fatal("error reading record from csv", "err", err)
+		}
+		if err := out.Write(record); err != nil {
+			fatal("error writing record to csv", "err", err)
+		}
+		n++
+	}
+	if n == 0 {
+		fatal("error reading header from csv", "err", io.ErrUnexpectedEOF)
+	}
+
+	out.Flush()
+	if err := out.Error(); err != nil {
+		fatal("error writing csv", "err", err)
+	}
+	if err := out.Close(); err != nil {
+		fatal("error closing destination csv", "err", err)
+	}
+	slog.Debug("split records", "records", n-1, "parts", out.part+1)
+}
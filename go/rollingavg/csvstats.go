@@ -0,0 +1,233 @@
+// csvstats.go: per-column summary statistics of CSV rows
+//
+// a quick profiling step before configuring windows, invoked as the
+// csvstats, or stats, subcommand, see commands.go:
+//     mdp csvstats [-v] [-quantiles q,...] [-sample nvalues] [-max-distinct n] [-f inputfile]... [-o outputfile] [inputfile...]
+// the rows are streamed, and for each column a row is output of its count
+// of values, empty values and distinct values, and if all its non-empty
+// values are numbers, their mean, standard deviation, min, max and
+// -quantiles (default 0.05,0.25,0.5,0.75,0.95).
+// quantiles are of a uniform random sample of up to -sample values of each
+// column (default 100000), so are exact for inputs with fewer rows.
+// distinct values are counted up to -max-distinct (default 100000), beyond
+// which the count is given as that number with a +, e.g. 100000+
+
+
+package main
+
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"flag"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+// running statistics of a single column
+type columnStats struct {
+	count    int
+	empty    int
+	distinct map[string]struct{}
+	overflow bool // more distinct values than -max-distinct
+
+	numeric bool // all non-empty values so far are numbers
+	mean    rollingavg.Aggregator
+	stddev  rollingavg.Aggregator
+	min     float64
+	max     float64
+
+	// a reservoir sample of the values, for quantiles
+	sample []float64
+	seen   int
+}
+
+
+func runCSVStats(args []string) {
+	fs := flag.NewFlagSet("csvstats", flag.ExitOnError)
+	quantiles := fs.String("quantiles", "0.05,0.25,0.5,0.75,0.95", "comma separated quantiles of numeric columns to output")
+	sampleSize := fs.Int("sample", 100000, "number of values of each column sampled for quantiles")
+	maxDistinct := fs.Int("max-distinct", 100000, "maximum number of distinct values of each column counted")
+	commonFlags(fs, "a row of statistics per input column")
+	fs.Parse(args)
+	infilenames = append(infilenames, fs.Args()...)
+	setupLogging()
+
+	var qs []float64
+	for _, s := range strings.Split(*quantiles, ",") {
+		q, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil || q < 0 || q > 1 {
+			fatal("invalid quantile", "quantile", s)
+		}
+		qs = append(qs, q)
+	}
+	if *sampleSize < 1 || *maxDistinct < 1 {
+		fatal("invalid -sample or -max-distinct", "sample", *sampleSize, "max_distinct", *maxDistinct)
+	}
+
+	slog.Debug("summarise CSV columns",
+		"inputs", infilenames, "output", outfilename, "quantiles", qs, "sample", *sampleSize)
+
+	infile := openInputs(context.Background(), infilenames, false, nil)
+	defer infile.Close()
+
+	oufl := createOutput(outfilename, "")
+	outfile := csv.NewWriter(bufio.NewWriter(oufl))
+
+	header, err := infile.Read()
+	if err != nil {
+		fatal("error reading header from csv", "err", err)
+	}
+	header = append([]string(nil), header...)
+
+	stats := genColumnStats(infile, len(header), *sampleSize, *maxDistinct)
+	writeColumnStats(outfile, header, stats, qs, *maxDistinct)
+
+	outfile.Flush()
+	if err := outfile.Error(); err != nil {
+		fatal("error writing csv", "err", err)
+	}
+	if err := oufl.Close(); err != nil {
+		fatal("error closing destination csv", "err", err)
+	}
+}
+
+
+// accumulate the statistics of each of the ncols columns of incsv's rows
+func genColumnStats(incsv recordReader, ncols int, sampleSize int, maxDistinct int) []*columnStats {
+	newMean, _ := rollingavg.LookupAggregator("mean")
+	newStddev, _ := rollingavg.LookupAggregator("stddev")
+	stats := make([]*columnStats, ncols)
+	for i := range stats {
+		stats[i] = &columnStats{
+			distinct: make(map[string]struct{}),
+			numeric:  true,
+			mean:     newMean(),
+			stddev:   newStddev(),
+			min:      math.Inf(1),
+			max:      math.Inf(-1),
+		}
+	}
+	// a fixed seed, so the sampled quantiles are the same for each run
+	rnd := rand.New(rand.NewSource(1))
+
+	n := 0
+	for {
+		record, err := incsv.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fatal("error reading record from csv", "err", err)
+		}
+		if verboseFlag {
+			slog.Debug("read record", "n", n, "record", record)
+		}
+
+		for i, v := range record {
+			if i >= ncols {
+				break
+			}
+			s := stats[i]
+			s.count++
+			if v == "" {
+				s.empty++
+				continue
+			}
+			if _, found := s.distinct[v]; !found {
+				if len(s.distinct) < maxDistinct {
+					s.distinct[v] = struct{}{}
+				} else {
+					s.overflow = true
+				}
+			}
+			if !s.numeric {
+				continue
+			}
+			f, err := rollingavg.ParseFloat(v)
+			if err != nil {
+				// not a numeric column, so its numbers are no longer kept
+				s.numeric, s.sample = false, nil
+				continue
+			}
+			s.mean.Add(f)
+			s.stddev.Add(f)
+			s.min = math.Min(s.min, f)
+			s.max = math.Max(s.max, f)
+
+			// reservoir sampling: each value seen is kept with equal probability
+			s.seen++
+			if len(s.sample) < sampleSize {
+				s.sample = append(s.sample, f)
+			} else if j := rnd.Intn(s.seen); j < sampleSize {
+				s.sample[j] = f
+			}
+		}
+		n++
+	}
+	slog.Debug("summarised records", "records", n, "columns", ncols)
+	return stats
+}
+
+
+// write a row of the statistics of each column to outcsv
+func writeColumnStats(outcsv *csv.Writer, header []string, stats []*columnStats, qs []float64, maxDistinct int) {
+	outrec := []string{"Column", "Count", "Empty", "Distinct", "Mean", "Stddev", "Min", "Max"}
+	for _, q := range qs {
+		outrec = append(outrec, "Q"+strconv.FormatFloat(q, 'f', -1, 64))
+	}
+	if err := outcsv.Write(outrec); err != nil {
+		fatal("error writing record to csv", "err", err)
+	}
+
+	format := func(f float64) string {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	for i, s := range stats {
+		distinct := strconv.Itoa(len(s.distinct))
+		if s.overflow {
+			distinct = strconv.Itoa(maxDistinct) + "+"
+		}
+		outrec = []string{header[i], strconv.Itoa(s.count), strconv.Itoa(s.empty), distinct}
+		if s.numeric && s.seen > 0 {
+			outrec = append(outrec, format(s.mean.Value()), format(s.stddev.Value()), format(s.min), format(s.max))
+			sort.Float64s(s.sample)
+			for _, q := range qs {
+				outrec = append(outrec, format(quantile(s.sample, q)))
+			}
+		} else {
+			for j := 0; j < 4+len(qs); j++ {
+				outrec = append(outrec, "")
+			}
+		}
+		if verboseFlag {
+			slog.Debug("write record", "record", outrec)
+		}
+		if err := outcsv.Write(outrec); err != nil {
+			fatal("error writing record to csv", "err", err)
+		}
+	}
+}
+
+
+// the q quantile of sorted values, interpolating linearly between them
+func quantile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	pos := q * float64(len(sorted)-1)
+	i := int(pos)
+	if i+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[i] + (pos-float64(i))*(sorted[i+1]-sorted[i])
+}
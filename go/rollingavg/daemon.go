@@ -0,0 +1,131 @@
+// daemon.go: service mode, with systemd notification and a health check
+//
+// with -daemon, rollingavg runs as a long running service, e.g. under
+// systemd or Kubernetes. it must stream its input (-follow, Kafka, MQTT
+// or socket), watch a directory, or serve (-serve, -grpc-addr or
+// -flight-addr), and:
+//   - tells systemd, through $NOTIFY_SOCKET, when it is ready, once its
+//     input and output are open, or its server listening, and when it is
+//     stopping, on SIGINT or SIGTERM
+//   - if the unit sets WatchdogSec, sends watchdog keep-alives at half
+//     the watchdog interval
+// e.g. with the unit
+//     [Service]
+//     Type=notify
+//     ExecStart=/usr/local/bin/rollingavg -daemon -health-addr :8086 -kafka-topic sensors ...
+//     WatchdogSec=30
+//     Restart=on-failure
+// with -health-addr, GET /healthz responds 200 "ok" while running, or
+// 503 before it is ready and once it is stopping, for liveness and
+// readiness probes
+
+
+package main
+
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+
+// the service state reported to systemd and on /healthz
+var serviceState struct {
+	ready    atomic.Bool
+	stopping atomic.Bool
+}
+
+
+// check the mode is long running, and start the watchdog and health check
+func startDaemon(healthAddr string) {
+	if daemonFlag && !streaming() && watchDir == "" && serveAddr == "" && grpcAddr == "" && flightAddr == "" {
+		fatal("-daemon requires streaming input, -watch, -serve, -grpc-addr or -flight-addr")
+	}
+	if daemonFlag {
+		startWatchdog()
+	}
+	if healthAddr != "" {
+		startHealthCheck(healthAddr)
+	}
+}
+
+
+// report that the service is ready, the first time this is called
+func serviceReady() {
+	if serviceState.ready.Swap(true) {
+		return
+	}
+	if daemonFlag {
+		sdNotify("READY=1")
+	}
+	slog.Debug("service ready")
+}
+
+
+// report that the service is stopping
+func serviceStopping() {
+	serviceState.stopping.Store(true)
+	if daemonFlag {
+		sdNotify("STOPPING=1")
+	}
+}
+
+
+// send a state notification to systemd, if run by it with Type=notify
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		slog.Warn("error notifying systemd", "err", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		slog.Warn("error notifying systemd", "err", err)
+	}
+}
+
+
+// send watchdog keep-alives, if systemd expects them from this process
+func startWatchdog() {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	go func() {
+		for range time.Tick(time.Duration(usec) * time.Microsecond / 2) {
+			sdNotify("WATCHDOG=1")
+		}
+	}()
+}
+
+
+// serve /healthz on addr
+func startHealthCheck(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case serviceState.stopping.Load():
+			http.Error(w, "stopping", http.StatusServiceUnavailable)
+		case !serviceState.ready.Load():
+			http.Error(w, "starting", http.StatusServiceUnavailable)
+		default:
+			fmt.Fprintln(w, "ok")
+		}
+	})
+	go func() {
+		fatal("health check listener failed", "err", http.ListenAndServe(addr, mux))
+	}()
+}
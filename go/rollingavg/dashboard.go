@@ -0,0 +1,132 @@
+// dashboard.go: a live web dashboard of the output rows
+//
+// with -dashboard-addr, an HTTP listener serves a page on / charting the
+// statistics of the most recent output rows, and listing the most recent
+// rows whose Result is a trigger, not "0", updated as each row is computed, so the
+// stream can be watched in a browser without any other tooling. the page
+// receives the rows from the server-sent events of /events, as for
+// -sse-addr, see sse.go. most useful when streaming, e.g. with -follow,
+// -kafka-topic or -listen. with -group-by, the series are charted together,
+// in output order.
+// the page is only shown rows computed after it's opened
+
+
+package main
+
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+
+// start serving the dashboard on addr, of the rows of b
+func startDashboard(addr string, b *rowBroadcaster) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		// rows with the quiet Result, e.g. of -labels, aren't triggers
+		page := strings.Replace(dashboardPage, `quiet = "0"`, "quiet = "+strconv.Quote(quietResult()), 1)
+		w.Write([]byte(page))
+	})
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		serveSSE(w, r, b)
+	})
+	go func() {
+		fatal("dashboard listener failed", "err", serveHTTP(addr, mux))
+	}()
+}
+
+
+// the dashboard page. the rows are JSON objects in output header order,
+// so the statistics are the two values before Result, the last
+const dashboardPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>rollingavg</title>
+<style>
+body { font-family: sans-serif; margin: 1em 2em; }
+canvas { width: 100%; height: 220px; border: 1px solid #ccc; }
+table { border-collapse: collapse; font-size: 90%; }
+td, th { padding: 2px 8px; text-align: left; border-bottom: 1px solid #eee; }
+#status { color: #666; }
+</style>
+</head>
+<body>
+<h2>rollingavg <span id="status">connecting</span></h2>
+<p><span id="rows">0</span> rows, <span id="triggers">0</span> triggered</p>
+<h3 id="titleA">A</h3><canvas id="chartA"></canvas>
+<h3 id="titleB">B</h3><canvas id="chartB"></canvas>
+<h3>Recent triggers</h3>
+<table><thead id="thead"></thead><tbody id="tbody"></tbody></table>
+<script>
+const maxPoints = 500, maxTriggers = 20, quiet = "0";
+let points = [], nrows = 0, ntriggers = 0, header = null;
+
+function draw(id, i) {
+  const c = document.getElementById(id), ctx = c.getContext("2d");
+  c.width = c.clientWidth; c.height = c.clientHeight;
+  ctx.clearRect(0, 0, c.width, c.height);
+  const vs = points.map(p => p[i]).filter(v => isFinite(v));
+  if (vs.length < 2) return;
+  let lo = Math.min(...vs), hi = Math.max(...vs);
+  if (lo == hi) { lo -= 1; hi += 1; }
+  const x = k => 40 + k / (maxPoints - 1) * (c.width - 50);
+  const y = v => 10 + (hi - v) / (hi - lo) * (c.height - 20);
+  ctx.fillStyle = "#666";
+  ctx.fillText(hi.toPrecision(4), 2, 14);
+  ctx.fillText(lo.toPrecision(4), 2, c.height - 4);
+  ctx.fillStyle = "rgba(214, 39, 40, 0.15)";
+  points.forEach((p, k) => { if (p[2]) ctx.fillRect(x(k) - 1, 0, 3, c.height); });
+  ctx.strokeStyle = "#1f77b4"; ctx.lineWidth = 2; ctx.beginPath();
+  points.forEach((p, k) => { k ? ctx.lineTo(x(k), y(p[i])) : ctx.moveTo(x(k), y(p[i])); });
+  ctx.stroke();
+}
+
+function trigger(row) {
+  const tbody = document.getElementById("tbody"), tr = document.createElement("tr");
+  header.forEach(k => { const td = document.createElement("td"); td.textContent = row[k]; tr.appendChild(td); });
+  tbody.insertBefore(tr, tbody.firstChild);
+  while (tbody.rows.length > maxTriggers) tbody.deleteRow(-1);
+}
+
+let pending = false;
+const events = new EventSource("events");
+events.onopen = () => { document.getElementById("status").textContent = "live"; };
+events.onerror = () => { document.getElementById("status").textContent = "disconnected"; };
+events.addEventListener("row", e => {
+  const row = JSON.parse(e.data);
+  if (!header) {
+    header = Object.keys(row);
+    document.getElementById("titleA").textContent = header[header.length - 3];
+    document.getElementById("titleB").textContent = header[header.length - 2];
+    const tr = document.getElementById("thead").insertRow();
+    header.forEach(k => { const th = document.createElement("th"); th.textContent = k; tr.appendChild(th); });
+  }
+  const result = String(row[header[header.length - 1]]);
+  const triggered = result != quiet && result != "";
+  points.push([Number(row[header[header.length - 3]]), Number(row[header[header.length - 2]]), triggered]);
+  if (points.length > maxPoints) points.shift();
+  nrows++;
+  if (triggered) { ntriggers++; trigger(row); }
+  // redraw at most once a frame
+  if (!pending) {
+    pending = true;
+    requestAnimationFrame(() => {
+      pending = false;
+      document.getElementById("rows").textContent = nrows;
+      document.getElementById("triggers").textContent = ntriggers;
+      draw("chartA", 0); draw("chartB", 1);
+    });
+  }
+});
+</script>
+</body>
+</html>
+`
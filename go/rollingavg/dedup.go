@@ -0,0 +1,198 @@
+// dedup.go: remove duplicate CSV rows
+//
+// invoked as the dedup subcommand, see commands.go, streaming from stdin to
+// stdout by default:
+//     mdp dedup [-v] [-k col,...] [-hash | -bloom nkeys [-fp rate] | -consecutive] [-f inputfile]... [-o outputfile] [inputfile...]
+// outputs the rows that aren't duplicates of an earlier row, either exactly,
+// or with -k, in the key columns given (names, indexes or index ranges, as
+// for csvcut), keeping the first of each set of duplicates.
+// the keys of the rows seen are kept in memory, so for large files with
+// many distinct keys, -hash keeps only a 64 bit hash of each, 8 bytes, at
+// a tiny risk of a row being dropped as a false duplicate, about 1 in 10^8
+// for a billion distinct keys. for input sorted by the key, -consecutive
+// only compares each row with the one before, in constant memory.
+// for unbounded streams, e.g. from stdin, -bloom keeps the keys seen in a
+// Bloom filter sized for that many distinct keys, in bounded memory, about
+// 1.8 bytes a key at the default -fp false positive rate of 0.001, each
+// row with a new key being dropped with about that chance, e.g.
+//     tail -f events.csv | mdp dedup -k EventID -bloom 10000000 -fp 0.0001
+// beyond nkeys distinct keys, the rate of false duplicates rises
+
+
+package main
+
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"flag"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"math"
+	"strings"
+)
+
+
+func runDedup(args []string) {
+	fs := flag.NewFlagSet("dedup", flag.ExitOnError)
+	keys := fs.String("k", "", "comma separated key columns (names, indexes or index ranges) (default the whole row)")
+	hashed := fs.Bool("hash", false, "keep only a hash of each key seen, to save memory")
+	consecutive := fs.Bool("consecutive", false, "only remove duplicates of the row before, for input sorted by the key")
+	bloomKeys := fs.Int("bloom", 0, "keep the keys seen in a Bloom filter sized for this many distinct keys, in bounded memory")
+	fpRate := fs.Float64("fp", 0.001, "the false positive rate of the -bloom filter")
+	commonFlags(fs, "the rows without duplicates")
+	fs.Parse(args)
+	infilenames = append(infilenames, fs.Args()...)
+	setupLogging()
+
+	if *hashed && *consecutive {
+		fatal("-hash and -consecutive can't be used together")
+	}
+	if *bloomKeys < 0 {
+		fatal("invalid number of Bloom filter keys", "bloom", *bloomKeys)
+	}
+	if *bloomKeys > 0 && (*hashed || *consecutive) {
+		fatal("-bloom can't be used with -hash or -consecutive")
+	}
+	if !(*fpRate > 0 && *fpRate < 1) {
+		fatal("invalid false positive rate, expected between 0 and 1", "fp", *fpRate)
+	}
+
+	slog.Debug("remove duplicate CSV rows",
+		"inputs", infilenames, "output", outfilename, "keys", *keys, "hash", *hashed, "bloom", *bloomKeys, "fp", *fpRate, "consecutive", *consecutive)
+
+	infile := openInputs(context.Background(), infilenames, false, nil)
+	defer infile.Close()
+
+	oufl := createOutput(outfilename, "")
+	outfile := csv.NewWriter(bufio.NewWriter(oufl))
+
+	header, err := infile.Read()
+	if err != nil {
+		fatal("error reading header from csv", "err", err)
+	}
+	header = append([]string(nil), header...)
+	if err := outfile.Write(header); err != nil {
+		fatal("error writing record to csv", "err", err)
+	}
+	var cols []int
+	if *keys != "" {
+		cols = parseColumnList(header, *keys)
+	}
+
+	// the key of a row, its key columns' values separated by NULs
+	var sb strings.Builder
+	rowKey := func(record []string) string {
+		sb.Reset()
+		if cols == nil {
+			for _, v := range record {
+				sb.WriteString(v)
+				sb.WriteByte(0)
+			}
+		} else {
+			for _, i := range cols {
+				if i < len(record) {
+					sb.WriteString(record[i])
+				}
+				sb.WriteByte(0)
+			}
+		}
+		return sb.String()
+	}
+
+	seen := make(map[string]struct{})
+	seenHashes := make(map[uint64]struct{})
+	var filter *bloomFilter
+	if *bloomKeys > 0 {
+		filter = newBloomFilter(*bloomKeys, *fpRate)
+		slog.Debug("bloom filter", "bits", len(filter.bits)*64, "hashes", filter.k)
+	}
+	prev, started := "", false
+	n, written := 0, 0
+	for {
+		record, err := infile.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fatal("error reading record from csv", "err", err)
+		}
+		n++
+		key := rowKey(record)
+
+		dup := false
+		switch {
+		case *consecutive:
+			dup = started && key == prev
+			prev, started = key, true
+		case filter != nil:
+			dup = filter.add(key)
+		case *hashed:
+			h := fnv.New64a()
+			h.Write([]byte(key))
+			_, dup = seenHashes[h.Sum64()]
+			seenHashes[h.Sum64()] = struct{}{}
+		default:
+			_, dup = seen[key]
+			seen[key] = struct{}{}
+		}
+		if dup {
+			if verboseFlag {
+				slog.Debug("drop duplicate record", "n", n, "record", record)
+			}
+			continue
+		}
+		if err := outfile.Write(record); err != nil {
+			fatal("error writing record to csv", "err", err)
+		}
+		written++
+	}
+	slog.Debug("removed duplicate records", "records", n, "written", written, "duplicates", n-written)
+
+	outfile.Flush()
+	if err := outfile.Error(); err != nil {
+		fatal("error writing csv", "err", err)
+	}
+	if err := oufl.Close(); err != nil {
+		fatal("error closing destination csv", "err", err)
+	}
+}
+
+
+// a Bloom filter of keys, of m bits set by k hashes of each
+type bloomFilter struct {
+	bits []uint64
+	m    uint64
+	k    int
+}
+
+
+// a Bloom filter of the optimal size and number of hashes for n keys at
+// the false positive rate p
+func newBloomFilter(n int, p float64) *bloomFilter {
+	m := math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2))
+	k := max(1, int(math.Round(m/float64(n)*math.Ln2)))
+	words := (uint64(m) + 63) / 64
+	return &bloomFilter{bits: make([]uint64, words), m: words * 64, k: k}
+}
+
+
+// add a key, returning whether it was probably added before. the k bit
+// positions are combinations of two hashes of the key, as good as k
+// independent hashes
+func (f *bloomFilter) add(key string) bool {
+	h1 := hllHash(key)
+	h2 := hllHash(key+"\x00") | 1
+	found := true
+	for i := 0; i < f.k; i++ {
+		b := (h1 + uint64(i)*h2) % f.m
+		w, mask := b/64, uint64(1)<<(b%64)
+		if f.bits[w]&mask == 0 {
+			found = false
+			f.bits[w] |= mask
+		}
+	}
+	return found
+}
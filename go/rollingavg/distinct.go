@@ -0,0 +1,44 @@
+// distinct.go: rolling distinct counts of columns
+//
+// -distinct lists columns, by name or index, of any values, e.g. IDs or
+// statuses, whose number of distinct values over each row's window is
+// output, as a column named for it, e.g. "Distinct Status", following the
+// input columns, and any -mode columns, see windowcols.go, e.g.
+//     -distinct Status,Host
+// gives
+//     X,Y,Status,Host,Time,Distinct Status,Distinct Host,Average A,Average B,Result
+// the count is exact, of the values in the window, so its memory is at most
+// that of the window's values. empty values aren't counted
+
+
+package main
+
+
+import (
+	"strconv"
+)
+
+
+var distinctCols string
+
+
+// the -distinct columns of an input header
+func distinctColumns(header []string) []windowColumn {
+	var cols []windowColumn
+	for _, c := range parseColumnList(header, distinctCols) {
+		cols = append(cols, windowColumn{c, "Distinct " + header[c], func() windowStat { return distinctCounter{make(modeCounter)} }})
+	}
+	return cols
+}
+
+
+// the counts of the values of a column in a window, the number of which
+// is its value
+type distinctCounter struct {
+	modeCounter
+}
+
+
+func (d distinctCounter) value() string {
+	return strconv.Itoa(len(d.modeCounter))
+}
@@ -0,0 +1,166 @@
+// dryrun.go: checking a run's configuration without processing
+//
+// to catch misconfiguration before starting a long job, -dry-run resolves
+// the flags, environment and -config file, checks the options, reads the
+// header of the first input and checks the columns the options name, then
+// reports what would be computed and written, e.g.
+//     inputs:   jan.csv, feb.csv
+//     header:   X,Y,Z,Time
+//     averaged: A=X, B=Y
+//     window:   50 rows, per Sensor
+//     stat:     median
+//     rule:     threshold
+//     output:   out.csv.gz (csv, gzip)
+//     columns:  X,Y,Z,Time,Median A,Median B,Result
+// and exits, without creating the output, or reading any input rows.
+// for message inputs, the header is that of -message-header, if given.
+// problems are logged and exit with status 1, as for a run
+
+
+package main
+
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+var dryRunFlag bool
+
+
+// check the run's options and input header, and report what it would do
+func runDryRun(infilenames []string, outfilename string) {
+	checkTailPolicy()
+	checkAnchor()
+	checkQuoting()
+	checkPassthrough()
+	checkEncoding()
+
+	var header []string
+	inputs := strings.Join(infilenames, ", ")
+	switch {
+	case kafkaTopic != "" || len(mqttTopics) > 0 || listenAddr != "":
+		inputs = "messages"
+		if messageHeader != "" {
+			header = strings.Split(messageHeader, ",")
+		}
+	case mergeFlag && len(infilenames) > 1:
+		in := openMergedInputs(infilenames, timeCol)
+		header, _ = in.Read()
+		in.Close()
+	default:
+		if len(infilenames) == 0 {
+			inputs = "stdin"
+		}
+		for _, filename := range infilenames {
+			if _, err := os.Stat(filename); err != nil && !strings.Contains(filename, "://") {
+				fatal("input file not found", "file", filename)
+			}
+		}
+		in := openInputs(context.Background(), infilenames, false, nil)
+		record, err := applyStages(in, pipelineStages).Read()
+		if err != nil {
+			fatal("error reading header from csv", "err", err)
+		}
+		header = append([]string(nil), record...)
+		in.Close()
+	}
+
+	if header == nil {
+		fmt.Println("inputs:  ", inputs)
+		fmt.Println("header:   unknown until the first message")
+		return
+	}
+	if len(header) < 2 {
+		fatal("input has fewer than the 2 columns averaged", "header", header)
+	}
+
+	statA, statB := mainStats()
+	opts := rollingavg.Options{
+		Window:     nrows,
+		WindowB:    nrowsB,
+		WindowUnit: windowUnit,
+		MinWindow:  adaptiveMin,
+		Tumbling:   windowMode == "tumbling",
+		Stat:       statA,
+		StatB:      statB,
+		Rule:       ruleName,
+		GroupBy:    unalias(header, groupBy),
+		TimeColumn: unalias(header, timeCol),
+		Align:      windowAlign,
+		Location:   windowLocation(),
+	}
+	if holidayfile != "" && windowUnit != "rows" {
+		opts.Holidays = loadHolidays(holidayfile)
+	}
+	p, err := rollingavg.NewProcessor(header, opts)
+	if err != nil {
+		fatal("invalid processing options", "err", err)
+	}
+	if p.Rule, err = resultRule(); err != nil {
+		fatal("invalid rule", "err", err)
+	}
+	p.Close()
+
+	// parse the column options, which check the columns they name
+	if schemafile != "" {
+		loadSchema(schemafile)
+	}
+	if convertSpec != "" {
+		parseConversions(convertSpec, header)
+	}
+	if clampSpec != "" {
+		parseClamps(clampSpec, header)
+	}
+	if outlierSpec != "" {
+		parseOutlierFilters(outlierSpec, header)
+	}
+	outhdr := outputHeader(header)
+	if placeSpec != "" {
+		order := placeColumns(header)
+		placed := make([]string, len(order))
+		for i, j := range order {
+			placed[i] = outhdr[j]
+		}
+		outhdr = placed
+	}
+
+	fmt.Println("inputs:  ", inputs)
+	fmt.Println("header:  ", strings.Join(header, ","))
+	fmt.Printf("averaged: A=%s, B=%s\n", header[0], header[1])
+	window := fmt.Sprintf("%d %s", nrows, windowUnit)
+	if windowAlign {
+		window += ", aligned in " + windowTZ
+	}
+	if nrowsB != 0 && nrowsB != nrows {
+		window = fmt.Sprintf("A %d rows, B %d rows", nrows, nrowsB)
+	}
+	if adaptiveMin > 0 {
+		window = fmt.Sprintf("adaptive, %d to %d rows", adaptiveMin, nrows)
+	}
+	if windowMode == "tumbling" {
+		window += ", tumbling"
+	}
+	if groupBy != "" {
+		window += ", per " + groupBy
+	}
+	fmt.Println("window:  ", window)
+	fmt.Println("stat:    ", statsSummary())
+	fmt.Println("rule:    ", ruleName)
+	output := outfilename
+	if output == "" {
+		output = "stdout"
+	}
+	if c := outputCompression(outfilename, compressFlag); c != "" {
+		output += fmt.Sprintf(" (%s, %s)", outputFormat, c)
+	} else {
+		output += fmt.Sprintf(" (%s)", outputFormat)
+	}
+	fmt.Println("output:  ", output)
+	fmt.Println("columns: ", strings.Join(outhdr, ","))
+}
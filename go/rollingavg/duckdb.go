@@ -0,0 +1,352 @@
+// duckdb.go: read input rows from a DuckDB query, and write output rows to
+// a DuckDB table
+//
+// with -duckdb-query, the input rows are the rows of a DuckDB SQL query,
+// rather than of input files, so they can be filtered, joined or
+// aggregated with SQL before windowing, e.g. with DuckDB's CSV and parquet
+// readers
+//     rollingavg -duckdb-query "SELECT s.*, w.Temp FROM 'sensors/*.parquet' s
+//         JOIN read_csv('weather.csv') w USING (ID) WHERE s.X > 0 ORDER BY s.Time" -n 50
+// the header is the names of the query's columns, and values are read as
+// text: NULLs as empty values, and timestamps in the Date Time layout,
+// with milliseconds. the query runs in the DuckDB database file given by
+// -duckdb-db, so it can also query its tables, or without it, in memory.
+// the query should order its rows, as the windows are of rows in order.
+//
+// with -duckdb-table, output rows are instead appended to that table of the
+// -duckdb-db database file, using DuckDB's appender. the table is created
+// if it doesn't exist, with columns named as in the output header, and
+// typed as for the columnar outputs, by -column-types or their values in
+// the first row, see arrow.go, or as the columns of an existing table,
+// which must have the output's columns, any others being left NULL.
+// values that don't parse as their column's type are NULL.
+// DuckDB links with cgo, so requires a C toolchain to build
+
+
+package main
+
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/duckdb/duckdb-go/v2"
+)
+
+
+var duckdbFile string
+var duckdbQuery string
+var duckdbTable string
+
+// the DuckDB database, shared by the input and output, which can't open
+// the file separately, and the number of them using it
+var duckdbShared struct {
+	mu    sync.Mutex
+	db    *sql.DB
+	users int
+}
+
+
+// open the -duckdb-db database, or an in-memory one without it
+func openDuckDB() *sql.DB {
+	duckdbShared.mu.Lock()
+	defer duckdbShared.mu.Unlock()
+	if duckdbShared.db == nil {
+		db, err := sql.Open("duckdb", duckdbFile)
+		if err == nil {
+			err = db.Ping()
+		}
+		if err != nil {
+			fatal("error opening DuckDB database", "db", duckdbFile, "err", err)
+		}
+		duckdbShared.db = db
+	}
+	duckdbShared.users++
+	return duckdbShared.db
+}
+
+
+// close the database once neither the input nor the output uses it
+func closeDuckDB() error {
+	duckdbShared.mu.Lock()
+	defer duckdbShared.mu.Unlock()
+	if duckdbShared.users--; duckdbShared.users > 0 {
+		return nil
+	}
+	db := duckdbShared.db
+	duckdbShared.db = nil
+	return db.Close()
+}
+
+
+// a DuckDB identifier, quoted
+func duckdbIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+
+// reads the rows of a DuckDB query as records, the first being its header
+type duckdbReader struct {
+	rows   *sql.Rows
+	header []string
+	values []any
+	ptrs   []any
+}
+
+
+func newDuckDBReader(ctx context.Context, query string) *duckdbReader {
+	slog.Debug("querying DuckDB", "db", duckdbFile, "query", query)
+	rows, err := openDuckDB().QueryContext(ctx, query)
+	if err != nil {
+		fatal("error running DuckDB query", "err", err)
+	}
+	header, err := rows.Columns()
+	if err != nil {
+		fatal("error running DuckDB query", "err", err)
+	}
+	r := &duckdbReader{rows: rows, header: header, values: make([]any, len(header)), ptrs: make([]any, len(header))}
+	for i := range r.values {
+		r.ptrs[i] = &r.values[i]
+	}
+	return r
+}
+
+
+func (r *duckdbReader) Read() ([]string, error) {
+	if r.header != nil {
+		header := r.header
+		r.header = nil
+		return header, nil
+	}
+	if !r.rows.Next() {
+		if err := r.rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	if err := r.rows.Scan(r.ptrs...); err != nil {
+		return nil, err
+	}
+	record := make([]string, len(r.values))
+	for i, v := range r.values {
+		record[i] = duckdbValueStr(v)
+	}
+	return record, nil
+}
+
+
+// format a DuckDB value as a CSV value, "" for NULL
+func duckdbValueStr(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		return v.UTC().Format(timeLayout + ".000")
+	}
+	return fmt.Sprint(v)
+}
+
+
+func (r *duckdbReader) Close() {
+	r.rows.Close()
+	closeDuckDB()
+}
+
+
+// appends records to a DuckDB table. the first record is taken as the
+// header, and the table created, if need be, once the first data row is
+// seen
+type duckdbOutput struct {
+	conn     *sql.Conn
+	table    string
+	types    map[string]string
+	header   []string
+	coltype  []string
+	appender *duckdb.Appender
+	values   []driver.Value
+	err      error
+}
+
+
+func newDuckDBOutput(table string, types map[string]string) (*duckdbOutput, error) {
+	if duckdbFile == "" {
+		return nil, fmt.Errorf("-duckdb-table requires a -duckdb-db database file")
+	}
+	conn, err := openDuckDB().Conn(context.Background())
+	if err != nil {
+		closeDuckDB()
+		return nil, err
+	}
+	return &duckdbOutput{conn: conn, table: table, types: types}, nil
+}
+
+
+// create the table, if it doesn't exist, typing the columns from the first
+// data row, or nil if there are none, or type them as those of the table
+// if it does, and start appending to it
+func (d *duckdbOutput) start(first []string) error {
+	ctx := context.Background()
+	existing, err := d.tableTypes(ctx)
+	if err != nil {
+		return err
+	}
+	cols := make([]string, len(d.header))
+	d.coltype = make([]string, len(d.header))
+	for i, name := range d.header {
+		d.coltype[i] = columnType(i, name, d.types, first)
+		if typ, found := existing[name]; found {
+			d.coltype[i] = typ
+		}
+		typ, ok := map[string]string{"string": "VARCHAR", "double": "DOUBLE", "int64": "BIGINT",
+			"bool": "BOOLEAN", "timestamp": "TIMESTAMP"}[d.coltype[i]]
+		if !ok {
+			return fmt.Errorf("invalid column type: %s", d.coltype[i])
+		}
+		cols[i] = duckdbIdent(name) + " " + typ
+	}
+	if existing == nil {
+		create := fmt.Sprintf("CREATE TABLE %s (%s)", duckdbIdent(d.table), strings.Join(cols, ", "))
+		if _, err := d.conn.ExecContext(ctx, create); err != nil {
+			return fmt.Errorf("creating DuckDB table: %w", err)
+		}
+	}
+	d.values = make([]driver.Value, len(d.header))
+	return d.conn.Raw(func(dc any) error {
+		a, err := duckdb.NewAppenderWithColumns(dc.(driver.Conn), "", "", d.table, d.header)
+		d.appender = a
+		return err
+	})
+}
+
+
+// the column types of the table, as those of -column-types, or nil if it
+// doesn't exist
+func (d *duckdbOutput) tableTypes(ctx context.Context) (map[string]string, error) {
+	rows, err := d.conn.QueryContext(ctx,
+		"SELECT column_name, data_type FROM information_schema.columns WHERE table_name = ?", d.table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var types map[string]string
+	for rows.Next() {
+		var name, dt string
+		if err := rows.Scan(&name, &dt); err != nil {
+			return nil, err
+		}
+		if types == nil {
+			types = make(map[string]string)
+		}
+		switch {
+		case dt == "BOOLEAN":
+			types[name] = "bool"
+		case strings.HasPrefix(dt, "TIMESTAMP"), dt == "DATE":
+			types[name] = "timestamp"
+		case strings.HasSuffix(dt, "INT"), dt == "INTEGER":
+			types[name] = "int64"
+		case dt == "DOUBLE", dt == "FLOAT", strings.HasPrefix(dt, "DECIMAL"):
+			types[name] = "double"
+		default:
+			types[name] = "string"
+		}
+	}
+	return types, rows.Err()
+}
+
+
+func (d *duckdbOutput) Write(record []string) error {
+	if d.err != nil {
+		return d.err
+	}
+	if d.header == nil {
+		d.header = append([]string(nil), record...)
+		return nil
+	}
+	if d.appender == nil {
+		if d.err = d.start(record); d.err != nil {
+			return d.err
+		}
+	}
+	for i := range d.values {
+		d.values[i] = nil
+		if i < len(record) {
+			d.values[i] = duckdbValue(d.coltype[i], record[i])
+		}
+	}
+	d.err = d.appender.AppendRow(d.values...)
+	return d.err
+}
+
+
+// a CSV value as a value of a column type, nil if it doesn't parse
+func duckdbValue(typ, v string) driver.Value {
+	switch typ {
+	case "string":
+		return v
+	case "double":
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	case "int64":
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return n
+		}
+	case "bool":
+		if t, err := strconv.ParseBool(v); err == nil {
+			return t
+		}
+	case "timestamp":
+		if t, err := time.Parse(timeLayout, v); err == nil {
+			return t
+		}
+	}
+	return nil
+}
+
+
+func (d *duckdbOutput) Flush() {
+	if d.appender != nil && d.err == nil {
+		d.err = d.appender.Flush()
+	}
+}
+
+
+func (d *duckdbOutput) Error() error {
+	return d.err
+}
+
+
+func (d *duckdbOutput) Close() error {
+	// with no rows, the table is of the header alone
+	if d.appender == nil && d.header != nil && d.err == nil {
+		d.err = d.start(nil)
+	}
+	if d.appender != nil {
+		if err := d.appender.Close(); err != nil && d.err == nil {
+			d.err = err
+		}
+	}
+	d.conn.Close()
+	if err := closeDuckDB(); err != nil && d.err == nil {
+		d.err = err
+	}
+	return d.err
+}
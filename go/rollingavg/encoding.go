@@ -0,0 +1,89 @@
+// encoding.go: character encodings of CSV inputs
+//
+// CSV inputs are UTF-8 by default, and a UTF-8 byte order mark, as Excel
+// writes, is skipped rather than read as part of the first header cell.
+// an input starting with a UTF-16 byte order mark, as Excel's "Unicode
+// text" exports do, is read as UTF-16. -encoding gives another encoding
+// of the inputs, decoded to UTF-8 after any decompression:
+//     utf-8         the default
+//     utf-16        UTF-16, by its byte order mark, or little endian without
+//     utf-16le      UTF-16 little endian, unless its byte order mark says not
+//     utf-16be      UTF-16 big endian, unless its byte order mark says not
+//     latin1        ISO 8859-1
+//     windows-1252  Windows Latin 1, a superset of latin1 as commonly used
+// inputs that are decoded, other than skipping a UTF-8 byte order mark,
+// have no byte offsets of their records to checkpoint at, or to split into
+// -parallel chunks by
+
+
+package main
+
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log/slog"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+
+var inputEncoding string
+
+
+var utf8BOM = []byte{0xef, 0xbb, 0xbf}
+
+
+var encodings = map[string]encoding.Encoding{
+	"utf-16":       unicode.UTF16(unicode.LittleEndian, unicode.UseBOM),
+	"utf-16le":     unicode.UTF16(unicode.LittleEndian, unicode.UseBOM),
+	"utf-16be":     unicode.UTF16(unicode.BigEndian, unicode.UseBOM),
+	"latin1":       charmap.ISO8859_1,
+	"windows-1252": charmap.Windows1252,
+}
+
+
+// check that -encoding is known
+func checkEncoding() {
+	if _, ok := encodings[inputEncoding]; !ok && inputEncoding != "utf-8" {
+		fatal("unknown input encoding", "encoding", inputEncoding)
+	}
+}
+
+
+// an input stream decoded to UTF-8
+type decodedInput struct {
+	io.Reader
+
+	// the length of the UTF-8 byte order mark skipped
+	bom int64
+
+	// whether it was decoded from another encoding, so its offsets aren't
+	// those of the input
+	transcoded bool
+}
+
+
+// decode an input stream by -encoding
+func decodeInput(r io.Reader) *decodedInput {
+	checkEncoding()
+	if enc := encodings[inputEncoding]; enc != nil {
+		return &decodedInput{Reader: transform.NewReader(r, enc.NewDecoder()), transcoded: true}
+	}
+	br := bufio.NewReader(r)
+	mark, _ := br.Peek(len(utf8BOM))
+	switch {
+	case bytes.HasPrefix(mark, utf8BOM):
+		br.Discard(len(utf8BOM))
+		return &decodedInput{Reader: br, bom: int64(len(utf8BOM))}
+	case bytes.HasPrefix(mark, []byte{0xff, 0xfe}) || bytes.HasPrefix(mark, []byte{0xfe, 0xff}):
+		slog.Debug("reading UTF-16 input, by its byte order mark")
+		dec := encodings["utf-16"].NewDecoder()
+		return &decodedInput{Reader: transform.NewReader(br, dec), transcoded: true}
+	}
+	return &decodedInput{Reader: br}
+}
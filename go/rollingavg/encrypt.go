@@ -0,0 +1,106 @@
+// encrypt.go: encryption of output files to age or PGP recipients
+//
+// with -encrypt-to, output files are encrypted as they're written, so
+// datasets with access controls, e.g. of location data, are never stored
+// in the clear. each -encrypt-to is an age X25519 recipient, age1..., or a
+// PGP public key file, armored or binary, and may be repeated, for several
+// recipients, who can each decrypt the output, e.g.
+//     rollingavg -encrypt-to age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p -o out.csv.age in.csv
+//     age -d -i key.txt out.csv.age
+// the recipients must all be age, or all PGP. outputs are compressed, see
+// compress.go, before they're encrypted, a .age, .gpg or .pgp extension
+// being ignored in choosing the compression, e.g. out.csv.gz.age. with
+// rotation or splitting, each file is encrypted, as are the -audit and
+// -window-sample files, so that no rows are written in the clear. an
+// encrypted output can't be appended to, so -append and -checkpoint can't
+// be used
+
+
+package main
+
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"golang.org/x/crypto/openpgp"
+)
+
+
+var encryptTo stringList
+
+
+// check that output encryption can be used with the current options
+func checkEncryption() {
+	if len(encryptTo) > 0 && (appendFlag || checkpointfile != "") {
+		fatal("-encrypt-to can't be used with -append or -checkpoint, which append to the output")
+	}
+}
+
+
+// the extension of an encrypted filename, or ""
+func encryptedExt(filename string) string {
+	for _, ext := range []string{".age", ".gpg", ".pgp"} {
+		if strings.HasSuffix(filename, ext) {
+			return ext
+		}
+	}
+	return ""
+}
+
+
+// a writer encrypting to w for the -encrypt-to recipients, or nil without
+// any. the encryption is only complete once it's closed
+func encryptWriter(w io.Writer) io.WriteCloser {
+	if len(encryptTo) == 0 {
+		return nil
+	}
+	var recipients []age.Recipient
+	var keys openpgp.EntityList
+	for _, to := range encryptTo {
+		if strings.HasPrefix(to, "age1") {
+			r, err := age.ParseX25519Recipient(to)
+			if err != nil {
+				fatal("invalid age recipient", "recipient", to, "err", err)
+			}
+			recipients = append(recipients, r)
+			continue
+		}
+		keys = append(keys, readPGPKeys(to)...)
+	}
+	if len(recipients) > 0 && len(keys) > 0 {
+		fatal("output can't be encrypted to both age and PGP recipients")
+	}
+
+	var ew io.WriteCloser
+	var err error
+	if len(recipients) > 0 {
+		ew, err = age.Encrypt(w, recipients...)
+	} else {
+		ew, err = openpgp.Encrypt(w, keys, nil, &openpgp.FileHints{IsBinary: true}, nil)
+	}
+	if err != nil {
+		fatal("error encrypting output", "err", err)
+	}
+	return ew
+}
+
+
+// the public keys of a PGP key file, armored or binary
+func readPGPKeys(filename string) openpgp.EntityList {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		fatal("error reading PGP key file", "file", filename, "err", err)
+	}
+	keys, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	if err != nil {
+		keys, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		fatal("error reading PGP keys", "file", filename, "err", err)
+	}
+	return keys
+}
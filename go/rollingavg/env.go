@@ -0,0 +1,56 @@
+// env.go: flags set from ROLLAVG_* environment variables
+//
+// every flag can be set by an environment variable named ROLLAVG_ and the
+// flag name in upper case with - replaced by _, e.g. ROLLAVG_N=50,
+// ROLLAVG_GROUP_BY=sensor or ROLLAVG_KAFKA_BROKERS=kafka:9092, which
+// suits container deployments where flags are awkward.
+// repeatable flags, e.g. ROLLAVG_F or ROLLAVG_PLUGIN, take a comma
+// separated list. boolean flags take true or false.
+// precedence is: command line flags, then environment variables, then
+// the -config file (which may itself be given by ROLLAVG_CONFIG)
+
+
+package main
+
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+const envPrefix = "ROLLAVG_"
+
+
+// the environment variable for a flag
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+
+// set the flags that weren't given on the command line from their
+// environment variables
+func applyEnv(fs *flag.FlagSet) {
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	// input files given as arguments replace any from the environment
+	given["f"] = given["f"] || len(infilenames) > 0
+
+	fs.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok || given[f.Name] {
+			return
+		}
+		values := []string{value}
+		if _, repeated := f.Value.(*stringList); repeated {
+			values = strings.Split(value, ",")
+		}
+		for _, v := range values {
+			if err := fs.Set(f.Name, v); err != nil {
+				fatal("invalid environment variable value", "variable", envName(f.Name), "err", err)
+			}
+		}
+		slog.Debug("set from environment", "flag", f.Name, "value", value)
+	})
+}
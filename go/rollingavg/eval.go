@@ -0,0 +1,284 @@
+// eval.go: evaluation of the Result column against ground truth labels
+//
+// to tune thresholds, or -labels levels, systematically, -truth compares
+// each output row's Result to its true label, from a column of the input,
+//     rollingavg -truth Label -eval - in.csv
+// or with -truth-file, a column of another CSV file, matched to the output
+// rows by the timestamps of their -time columns, e.g. of analysts' labels
+//     rollingavg -truth Label -truth-file labels.csv -eval eval.txt in.csv
+// the evaluation is written at the end of the run to the -eval file, or
+// with -eval -, to stderr, as the accuracy, the precision, recall and F1
+// of each label, and a confusion matrix of the true labels by Result, e.g.
+//     evaluation of Result against Label, 978 rows, accuracy 0.916
+//     LABEL  PRECISION  RECALL  F1     SUPPORT
+//     0      0.968      0.94    0.954  900
+//     1      0.481      0.641   0.549  78
+//
+//     TRUE\RESULT  0    1
+//     0            846  54
+//     1            28   50
+// and with -report, in its JSON, see report.go. rows without a Result, e.g.
+// the empty -tail rows, or a true label, aren't evaluated. with -batch or
+// -watch, it evaluates all the files processed
+
+
+package main
+
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+var truthCol string
+var truthFile string
+var evalFile string
+
+
+// the evaluation of the run, nil without -truth
+var evaluation *resultEvaluation
+
+
+// the counts of true labels and Results, and their report
+type resultEvaluation struct {
+	Rows      int                       `json:"rows"`
+	Accuracy  float64                   `json:"accuracy"`
+	Labels    map[string]labelScores    `json:"labels"`
+	Confusion map[string]map[string]int `json:"confusion"`
+
+	mu sync.Mutex
+
+	// the records of the -truth-file, header first, and its true labels by
+	// timestamp, once the time column is known
+	truth  [][]string
+	byTime map[string]string
+}
+
+
+// the scores of a label
+type labelScores struct {
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	F1        float64 `json:"f1"`
+	Support   int     `json:"support"`
+}
+
+
+// start evaluating the Results, reading the -truth-file if given
+func startEvaluation() {
+	evaluation = &resultEvaluation{Confusion: make(map[string]map[string]int)}
+	if truthFile != "" {
+		evaluation.truth = readTruthFile(truthFile)
+	}
+}
+
+
+// read the records of the -truth-file
+func readTruthFile(filename string) [][]string {
+	in := openInputs(context.Background(), []string{filename}, false, nil)
+	defer in.Close()
+	var records [][]string
+	for {
+		record, err := in.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fatal("error reading truth file", "file", filename, "err", err)
+		}
+		records = append(records, append([]string(nil), record...))
+	}
+	if len(records) == 0 {
+		fatal("truth file has no header", "file", filename)
+	}
+	return records
+}
+
+
+// the true labels of the -truth-file by timestamp, its time column being
+// named as the output's, or as -time
+func (e *resultEvaluation) labelsByTime(timeName string) map[string]string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.byTime != nil {
+		return e.byTime
+	}
+	header := e.truth[0]
+	tcol, lcol := rollingavg.FindColumn(header, timeName), findColumn(header, truthCol)
+	if tcol < 0 {
+		tcol = findColumn(header, timeCol)
+	}
+	if tcol < 0 || lcol < 0 {
+		fatal("time or truth column not found in truth file header", "file", truthFile, "time", timeName, "truth", truthCol)
+	}
+	e.byTime = make(map[string]string, len(e.truth)-1)
+	for _, record := range e.truth[1:] {
+		e.byTime[field(record, tcol)] = strings.TrimSpace(field(record, lcol))
+	}
+	return e.byTime
+}
+
+
+// an output that evaluates the Result of each record written
+type evalOutput struct {
+	recordWriteCloser
+	header bool
+	tcol   int
+	lcol   int
+	byTime map[string]string
+}
+
+
+func (o *evalOutput) Write(record []string) error {
+	switch {
+	case !o.header:
+		o.header = true
+		o.tcol, o.lcol = findColumn(record, timeCol), findColumn(record, truthCol)
+		switch {
+		case truthFile == "" && o.lcol < 0:
+			fatal("truth column not found in header", "column", truthCol)
+		case truthFile != "" && o.tcol < 0:
+			fatal("time column not found in header", "column", timeCol)
+		case truthFile != "":
+			o.byTime = evaluation.labelsByTime(record[o.tcol])
+		}
+	case len(record) > 0:
+		var label string
+		if o.byTime != nil {
+			label = o.byTime[field(record, o.tcol)]
+		} else {
+			label = strings.TrimSpace(field(record, o.lcol))
+		}
+		evaluation.add(label, record[len(record)-1])
+	}
+	return o.recordWriteCloser.Write(record)
+}
+
+
+func newEvalOutput(out recordWriteCloser) *evalOutput {
+	return &evalOutput{recordWriteCloser: out}
+}
+
+
+// count a row's true label and Result
+func (e *resultEvaluation) add(label, result string) {
+	if label == "" || result == "" {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.Rows++
+	if e.Confusion[label] == nil {
+		e.Confusion[label] = make(map[string]int)
+	}
+	e.Confusion[label][result]++
+}
+
+
+// the labels and Results counted, sorted
+func (e *resultEvaluation) classes() []string {
+	seen := make(map[string]bool)
+	for label, results := range e.Confusion {
+		seen[label] = true
+		for result := range results {
+			seen[result] = true
+		}
+	}
+	classes := make([]string, 0, len(seen))
+	for c := range seen {
+		classes = append(classes, c)
+	}
+	sort.Strings(classes)
+	return classes
+}
+
+
+// compute the accuracy and scores of the labels from the counts
+func (e *resultEvaluation) score() {
+	e.Labels = make(map[string]labelScores)
+	correct := 0
+	for _, c := range e.classes() {
+		var s labelScores
+		predicted := 0
+		for label, results := range e.Confusion {
+			predicted += results[c]
+			if label == c {
+				for _, n := range results {
+					s.Support += n
+				}
+			}
+		}
+		tp := e.Confusion[c][c]
+		correct += tp
+		if predicted > 0 {
+			s.Precision = float64(tp) / float64(predicted)
+		}
+		if s.Support > 0 {
+			s.Recall = float64(tp) / float64(s.Support)
+		}
+		if s.Precision+s.Recall > 0 {
+			s.F1 = 2 * s.Precision * s.Recall / (s.Precision + s.Recall)
+		}
+		e.Labels[c] = s
+	}
+	if e.Rows > 0 {
+		e.Accuracy = float64(correct) / float64(e.Rows)
+	}
+}
+
+
+// write the evaluation to the -eval file, or stderr, and add it to the
+// report
+func (e *resultEvaluation) write() {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.score()
+	if report != nil {
+		report.Evaluation = e
+	}
+	if evalFile == "" {
+		return
+	}
+
+	out := io.Writer(os.Stderr)
+	if evalFile != "-" {
+		fl, err := os.Create(evalFile)
+		if err != nil {
+			fatal("error creating evaluation file", "err", err)
+		}
+		defer fl.Close()
+		out = fl
+	}
+	fmt.Fprintf(out, "evaluation of Result against %s, %d rows, accuracy %.3g\n", truthCol, e.Rows, e.Accuracy)
+	classes := e.classes()
+	tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "LABEL\tPRECISION\tRECALL\tF1\tSUPPORT")
+	for _, c := range classes {
+		s := e.Labels[c]
+		fmt.Fprintf(tw, "%s\t%.3g\t%.3g\t%.3g\t%d\n", c, s.Precision, s.Recall, s.F1, s.Support)
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintf(tw, "TRUE\\RESULT\t%s\n", strings.Join(classes, "\t"))
+	for _, label := range classes {
+		fmt.Fprint(tw, label)
+		for _, result := range classes {
+			fmt.Fprintf(tw, "\t%d", e.Confusion[label][result])
+		}
+		fmt.Fprintln(tw)
+	}
+	if err := tw.Flush(); err != nil {
+		fatal("error writing evaluation", "err", err)
+	}
+}
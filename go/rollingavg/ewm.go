@@ -0,0 +1,185 @@
+// ewm.go: exponentially weighted moving statistics of input columns
+//
+// so anomaly thresholds can adapt to a stream without buffering a window
+// of rows, -ewm adds exponentially weighted moving statistics of input
+// columns, by name or index, to each row, as col:alpha,..., e.g.
+//     -ewm "X:0.1,Y:0.05" -ewm-stats mean,stddev
+// alpha, from 0 to 1, is the weight of the newest value, the older values'
+// weights decaying by 1-alpha a row, e.g. halving every 6.6 rows for 0.1.
+// each of the -ewm-stats, of mean, var (variance) and stddev, default
+// mean,stddev, is output as a column named for it and the column, e.g.
+//     X,Y,Z,Time,EW Mean X,EW Stddev X,EW Mean Y,EW Stddev Y,Average A,...
+// the statistics are of the values before the row's own, so it can be
+// compared to them, and are empty for a series' first value. values that
+// aren't numbers are left out. with -group-by, each series has its own
+// statistics.
+// -ewm is a stage following any config file stages, see stages.go, and
+// can also be given as one, e.g. for a later stage's z-score
+//     stages:
+//       - ewm: X:0.1
+//       - derive:
+//           column: Z X
+//           expr: (row["X"] - row["EW Mean X"]) / row["EW Stddev X"]
+// the statistics aren't saved, so -ewm can't be used with -checkpoint
+
+
+package main
+
+
+import (
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+var ewmSpec string
+var ewmStats string
+
+
+// add the -ewm stage, after any config file stages
+func addEWMStage() {
+	if ewmSpec != "" {
+		pipelineStages = append(pipelineStages, pipelineStage{EWM: ewmSpec})
+	}
+}
+
+
+// an exponentially weighted mean and variance, updated incrementally
+type ewmStat struct {
+	alpha    float64
+	mean     float64
+	variance float64
+	n        int
+}
+
+
+func (s *ewmStat) add(v float64) {
+	if s.n++; s.n == 1 {
+		s.mean = v
+		return
+	}
+	diff := v - s.mean
+	incr := s.alpha * diff
+	s.mean += incr
+	s.variance = (1 - s.alpha) * (s.variance + diff*incr)
+}
+
+
+// the named statistic, or empty if there are no values yet
+func (s *ewmStat) value(stat string) string {
+	if s.n == 0 {
+		return ""
+	}
+	v := s.mean
+	switch stat {
+	case "var":
+		v = s.variance
+	case "stddev":
+		v = math.Sqrt(s.variance)
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+
+// a column of -ewm statistics
+type ewmColumn struct {
+	col   int
+	name  string
+	alpha float64
+}
+
+
+// adds exponentially weighted moving statistics of columns to each record
+type ewmStage struct {
+	in     recordReader
+	spec   string
+	stats  []string
+	cols   []ewmColumn
+	header []string
+	gcol   int
+	series map[string][]ewmStat
+}
+
+
+func newEWMStage(in recordReader, spec string) *ewmStage {
+	stats := strings.Split(ewmStats, ",")
+	for i, stat := range stats {
+		stats[i] = strings.TrimSpace(stat)
+		if stats[i] != "mean" && stats[i] != "var" && stats[i] != "stddev" {
+			fatal("invalid -ewm statistic, expected mean, var or stddev", "ewm-stats", stat)
+		}
+	}
+	return &ewmStage{in: in, spec: spec, stats: stats, series: make(map[string][]ewmStat)}
+}
+
+
+// parse the col:alpha list of the stage's spec for its header
+func (s *ewmStage) parse() {
+	for _, item := range strings.Split(s.spec, ",") {
+		name, a, ok := strings.Cut(item, ":")
+		if !ok {
+			fatal("invalid ewm column, expected col:alpha", "ewm", item)
+		}
+		col := findColumn(s.header, strings.TrimSpace(name))
+		if col < 0 {
+			fatal("ewm column not found in header", "column", name)
+		}
+		alpha, err := strconv.ParseFloat(strings.TrimSpace(a), 64)
+		if err != nil || alpha <= 0 || alpha > 1 {
+			fatal("invalid ewm alpha, expected more than 0 and at most 1", "ewm", item)
+		}
+		s.cols = append(s.cols, ewmColumn{col: col, name: s.header[col], alpha: alpha})
+	}
+}
+
+
+func (s *ewmStage) Read() ([]string, error) {
+	if s.header == nil {
+		s.header = readStageHeader(s.in)
+		s.parse()
+		s.gcol = -1
+		if groupBy != "" {
+			if s.gcol = findColumn(s.header, groupBy); s.gcol < 0 {
+				fatal("group-by column not found in header", "column", groupBy)
+			}
+		}
+		header := s.header[:len(s.header):len(s.header)]
+		for _, c := range s.cols {
+			for _, stat := range s.stats {
+				header = append(header, "EW "+strings.ToUpper(stat[:1])+stat[1:]+" "+c.name)
+			}
+		}
+		slog.Debug("ewm columns", "columns", len(s.cols), "stats", s.stats)
+		return header, nil
+	}
+	record, err := s.in.Read()
+	if err != nil {
+		return record, err
+	}
+	key := ""
+	if s.gcol >= 0 {
+		key = field(record, s.gcol)
+	}
+	stats := s.series[key]
+	if stats == nil {
+		stats = make([]ewmStat, len(s.cols))
+		for i, c := range s.cols {
+			stats[i].alpha = c.alpha
+		}
+		s.series[key] = stats
+	}
+	record = record[:len(record):len(record)]
+	for i, c := range s.cols {
+		for _, stat := range s.stats {
+			record = append(record, stats[i].value(stat))
+		}
+		if v, err := rollingavg.ParseFloat(field(record, c.col)); err == nil {
+			stats[i].add(v)
+		}
+	}
+	return record, nil
+}
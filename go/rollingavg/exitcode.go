@@ -0,0 +1,59 @@
+// exitcode.go: the exit status of a rolling average run
+//
+// so shell pipelines and schedulers can branch on the outcome, rollavg
+// exits with
+//     0  clean, every row processed and none triggered
+//     1  a processing error, e.g. invalid input, or with -batch, a file failed
+//     2  rows skipped, dropped by -script or -outliers, see script.go
+//        and outliers.go, rows that would otherwise have been output.
+//        rows filtered out on purpose, e.g. by -from and -to, or filter
+//        stages, aren't skipped, and invalid rows are errors
+//     3  the Result rule triggered, a Result other than "0", at least once,
+//        or than the last of -labels, see labels.go
+// a run that both skipped rows and triggered exits 3, and a processing
+// error exits 1 whatever else happened. an interrupted run exits 128 plus
+// the signal number, see shutdown.go.
+// as for other commands, invalid flags also exit 2, before any rows are read
+
+
+package main
+
+
+import (
+	"sync/atomic"
+)
+
+
+const (
+	exitClean     = 0
+	exitError     = 1
+	exitSkipped   = 2
+	exitTriggered = 3
+)
+
+
+// the rows skipped and triggered by the run, over all its files
+var rowsSkipped atomic.Int64
+var rowsTriggered atomic.Int64
+
+
+// count an output row's Result, if triggered
+func resultWritten(result string) {
+	if triggered(result) {
+		rowsTriggered.Add(1)
+	}
+}
+
+
+// the exit status of the run, given whether it completed without errors
+func exitStatus(ok bool) int {
+	switch {
+	case !ok:
+		return exitError
+	case rowsTriggered.Load() > 0:
+		return exitTriggered
+	case rowsSkipped.Load() > 0:
+		return exitSkipped
+	}
+	return exitClean
+}
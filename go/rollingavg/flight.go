@@ -0,0 +1,347 @@
+// flight.go: Arrow Flight server mode
+//
+// with -flight-addr, an Arrow Flight server is run rather than writing
+// output, serving the results of processing the inputs given on the command
+// line as columnar record batches, so analytics clients can pull them over
+// the network without parsing CSV, e.g.
+//     rollingavg -flight-addr :8815 -n 50 data/a.csv data/b.csv
+// and in Python
+//     client = pyarrow.flight.connect("grpc://localhost:8815")
+//     table = client.do_get(pyarrow.flight.Ticket(b"data/a.csv?n=10")).read_all()
+// a ticket is an input's name, as given, optionally followed by query
+// parameters overriding the command line options, as for -serve, see
+// serve.go: n, window-unit, group-by, time, stat and rule, time windows
+// being aligned by -window-align and -window-tz. ListFlights lists
+// a flight for each input, with its name as the descriptor's path, and
+// GetFlightInfo and GetSchema describe a flight, of a path descriptor, or a
+// command descriptor of a ticket, for other parameters. the input is
+// processed for each DoGet, and its record batches streamed as they're
+// filled. columns are typed by -column-types, see arrow.go, or their values
+// in the first output row, so describing a flight processes its input until
+// the first row is output. only the inputs given can be read, which must
+// be CSV, possibly compressed or remote, and an unknown input or invalid
+// parameter fails the call with NotFound or InvalidArgument.
+// with -tls-cert, it's served over TLS, and with -auth-token or -auth-basic,
+// calls must be authenticated, see security.go
+
+
+package main
+
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/flight"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+
+var flightAddr string
+
+
+// run the Arrow Flight server on addr, serving the inputs, until it fails
+func runFlight(addr string, infilenames []string) {
+	if len(infilenames) == 0 {
+		fatal("-flight-addr requires inputs to serve")
+	}
+	holidays := make(rollingavg.Holidays)
+	if holidayfile != "" {
+		holidays = loadHolidays(holidayfile)
+	}
+	types := parseColumnTypes(columnTypes)
+	for _, typ := range types {
+		if _, err := arrowType(typ); err != nil {
+			fatal("invalid column type", "err", err)
+		}
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		fatal("error listening for Arrow Flight", "err", err)
+	}
+	s := grpc.NewServer(grpcServerOptions()...)
+	flight.RegisterFlightServiceServer(s, &flightServer{inputs: infilenames, types: types, holidays: holidays, loc: windowLocation()})
+	serviceReady()
+	slog.Debug("serving Arrow Flight", "addr", addr, "inputs", len(infilenames))
+	fatal("Arrow Flight server failed", "err", s.Serve(ln))
+}
+
+
+type flightServer struct {
+	flight.BaseFlightServer
+	inputs   []string
+	types    map[string]string
+	holidays rollingavg.Holidays
+	loc      *time.Location
+}
+
+
+// list a flight for each input, with the command line options
+func (s *flightServer) ListFlights(c *flight.Criteria, stream flight.FlightService_ListFlightsServer) error {
+	for _, name := range s.inputs {
+		desc := &flight.FlightDescriptor{Type: flight.DescriptorPATH, Path: []string{name}}
+		info, err := s.GetFlightInfo(stream.Context(), desc)
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+
+func (s *flightServer) GetFlightInfo(ctx context.Context, desc *flight.FlightDescriptor) (*flight.FlightInfo, error) {
+	ticket, err := descriptorTicket(desc)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := s.schema(ctx, ticket)
+	if err != nil {
+		return nil, err
+	}
+	return &flight.FlightInfo{
+		Schema:           flight.SerializeSchema(schema, memory.DefaultAllocator),
+		FlightDescriptor: desc,
+		Endpoint:         []*flight.FlightEndpoint{{Ticket: &flight.Ticket{Ticket: []byte(ticket)}}},
+		TotalRecords:     -1,
+		TotalBytes:       -1,
+	}, nil
+}
+
+
+func (s *flightServer) GetSchema(ctx context.Context, desc *flight.FlightDescriptor) (*flight.SchemaResult, error) {
+	ticket, err := descriptorTicket(desc)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := s.schema(ctx, ticket)
+	if err != nil {
+		return nil, err
+	}
+	return &flight.SchemaResult{Schema: flight.SerializeSchema(schema, memory.DefaultAllocator)}, nil
+}
+
+
+// stream the record batches of the results of a ticket
+func (s *flightServer) DoGet(ticket *flight.Ticket, stream flight.FlightService_DoGetServer) error {
+	ctx, span := telemetry.start(stream.Context(), "rollingavg.request",
+		attribute.String("rollingavg.protocol", "flight"))
+	rows := &flightRows{stream: stream}
+	rows.batcher = newArrowBatcher(s.types, rows.writeBatch)
+	defer rows.batcher.release()
+
+	err := s.process(ctx, string(ticket.GetTicket()), rows)
+	if err == nil {
+		err = rows.batcher.flush()
+	}
+	if err == nil && rows.w == nil {
+		// no rows, send just the schema
+		err = rows.writeBatch(rows.batcher.finalSchema(), nil)
+	}
+	if rows.w != nil {
+		if cerr := rows.w.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		telemetry.failed(span, err)
+		return err
+	}
+	span.End()
+	return nil
+}
+
+
+// the ticket of a descriptor, the path of an input's name, or a command of
+// a ticket
+func descriptorTicket(desc *flight.FlightDescriptor) (string, error) {
+	switch desc.GetType() {
+	case flight.DescriptorPATH:
+		if len(desc.GetPath()) != 1 {
+			return "", status.Error(codes.InvalidArgument, "the path must be the name of an input")
+		}
+		return desc.GetPath()[0], nil
+	case flight.DescriptorCMD:
+		return string(desc.GetCmd()), nil
+	}
+	return "", status.Error(codes.InvalidArgument, "unknown descriptor type")
+}
+
+
+// the schema of the results of a ticket, of the output header and first row
+func (s *flightServer) schema(ctx context.Context, ticket string) (*arrow.Schema, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	rows := &firstRows{cancel: cancel}
+	if err := s.process(ctx, ticket, rows); err != nil && rows.first == nil {
+		return nil, err
+	}
+	return arrowSchema(rows.header, s.types, rows.first), nil
+}
+
+
+// process the input of a ticket, writing the output header and rows to out
+func (s *flightServer) process(ctx context.Context, ticket string, out rollingavg.RecordWriter) error {
+	name, query, _ := strings.Cut(ticket, "?")
+	if !slices.Contains(s.inputs, name) {
+		return status.Error(codes.NotFound, "no such input: "+name)
+	}
+	if isParquet(name) || isXLSX(name) {
+		return status.Error(codes.InvalidArgument, "only CSV inputs can be served: "+name)
+	}
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	opts, err := s.options(q)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	fl, err := openSource(name)
+	if err != nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	defer fl.Close()
+	dr := decompress(fl)
+	defer dr.Close()
+	in := newCSVReader(decodeInput(dr))
+	header, err := in.Read()
+	if err != nil {
+		return status.Error(codes.InvalidArgument, "error reading header: "+err.Error())
+	}
+	p, err := rollingavg.NewProcessor(header, opts)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if p.Rule, err = ruleByName(opts.Rule); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	p.OnRead = func(n int, record []string) { telemetry.rowsRead(1) }
+	p.OnWrite = func(group string, r rollingavg.Result, outrec []string) { telemetry.rowsWritten(1) }
+
+	if p.WindowLengths {
+		header = append(header[:len(header):len(header)], rollingavg.WindowLengthColumn)
+	}
+	if err := out.Write(rollingavg.OutputHeaderAB(header, opts.Stat, opts.StatB)); err != nil {
+		return err
+	}
+	_, err = p.RunContext(ctx, in, out)
+	if ctx.Err() != nil {
+		return status.FromContextError(ctx.Err()).Err()
+	}
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return out.Error()
+}
+
+
+// the options of a ticket's query parameters, or the command line's
+func (s *flightServer) options(q url.Values) (rollingavg.Options, error) {
+	param := func(name, def string) string {
+		if v := q.Get(name); v != "" {
+			return v
+		}
+		return def
+	}
+	n, err := strconv.Atoi(param("n", strconv.Itoa(nrows)))
+	if err != nil || n <= 0 {
+		return rollingavg.Options{}, fmt.Errorf("invalid window length: %s", q.Get("n"))
+	}
+	return rollingavg.Options{
+		Window:     n,
+		WindowUnit: param("window-unit", windowUnit),
+		Stat:       param("stat", statName),
+		Rule:       param("rule", ruleName),
+		GroupBy:    param("group-by", groupBy),
+		TimeColumn: param("time", timeCol),
+		Holidays:   s.holidays,
+		Align:      windowAlign,
+		Location:   s.loc,
+	}, nil
+}
+
+
+// the output rows of a DoGet, collected into record batches sent on its
+// stream
+type flightRows struct {
+	stream  flight.FlightService_DoGetServer
+	batcher *arrowBatcher
+	w       *flight.Writer
+	err     error
+}
+
+
+// send a record batch, starting the stream with the schema with the first
+func (f *flightRows) writeBatch(schema *arrow.Schema, rec arrow.RecordBatch) error {
+	if f.w == nil {
+		f.w = flight.NewRecordWriter(f.stream, ipc.WithSchema(schema))
+	}
+	if rec == nil {
+		return nil
+	}
+	return f.w.Write(rec)
+}
+
+
+func (f *flightRows) Write(record []string) error {
+	if f.err == nil {
+		f.err = f.batcher.add(record)
+	}
+	return f.err
+}
+
+
+// rows are sent in batches, so there is nothing to flush until the end
+func (f *flightRows) Flush() {}
+
+
+func (f *flightRows) Error() error {
+	return f.err
+}
+
+
+// the output header and first row, cancelling processing once it's seen
+type firstRows struct {
+	header []string
+	first  []string
+	cancel func()
+}
+
+
+func (f *firstRows) Write(record []string) error {
+	switch {
+	case f.header == nil:
+		f.header = slices.Clone(record)
+	case f.first == nil:
+		f.first = slices.Clone(record)
+		f.cancel()
+	}
+	return nil
+}
+
+
+func (f *firstRows) Flush() {}
+
+
+func (f *firstRows) Error() error {
+	return nil
+}
@@ -0,0 +1,89 @@
+// follow.go: keep reading a file as new rows are appended, like tail -f
+//
+// at the end of the file, rather than returning EOF, the reader polls
+// for more data to be appended. if the file is truncated or replaced
+// (e.g. by log rotation) it is reopened from the start, and its first row
+// checked against, and skipped as, the header, see inputs.go.
+// reading stdin in follow mode simply blocks until more data arrives,
+// so only a closed pipe ends the stream.
+// following a file ends when the reader's context is cancelled
+
+
+package main
+
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// how often to check a followed file for new data
+const followPoll = 250 * time.Millisecond
+
+
+// an io.Reader that waits for data to be appended at the end of a file
+type followReader struct {
+	ctx      context.Context
+	fl       *os.File
+	filename string
+	offset   int64
+	reopened bool // whether the file was reopened since its header was read
+}
+
+
+func newFollowReader(ctx context.Context, fl *os.File) *followReader {
+	return &followReader{ctx: ctx, fl: fl, filename: fl.Name()}
+}
+
+
+func (f *followReader) Read(p []byte) (int, error) {
+	for {
+		n, err := f.fl.Read(p)
+		f.offset += int64(n)
+		if n > 0 || (err != nil && err != io.EOF) {
+			return n, err
+		}
+
+		select {
+		case <-f.ctx.Done():
+			return 0, io.EOF
+		case <-time.After(followPoll):
+		}
+		f.checkRotated()
+	}
+}
+
+
+// reopen the file if it has been truncated or replaced
+func (f *followReader) checkRotated() {
+	fi, err := os.Stat(f.filename)
+	if err != nil {
+		// the file may be in the middle of being replaced
+		return
+	}
+	cur, err := f.fl.Stat()
+	if err != nil {
+		return
+	}
+	if os.SameFile(fi, cur) && fi.Size() >= f.offset {
+		return
+	}
+
+	fl, err := os.Open(f.filename)
+	if err != nil {
+		return
+	}
+	slog.Debug("reopen followed file", "file", f.filename)
+	f.fl.Close()
+	f.fl = fl
+	f.offset = 0
+	f.reopened = true
+}
+
+
+func (f *followReader) Close() error {
+	return f.fl.Close()
+}
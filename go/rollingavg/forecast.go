@@ -0,0 +1,416 @@
+// forecast.go: AR and ARIMA forecasts of a CSV column
+//
+// invoked as the forecast subcommand, see commands.go:
+//     mdp forecast [-v] -c col [-order p,d,q] [-horizon steps] [-level 0.95] [-time col]
+//         [-f inputfile]... [-o outputfile] [inputfile...]
+// an ARIMA(p,d,q) model, by default AR(1), -order 1,0,0, is fitted to the
+// numeric values of the -c column, in row order, and the input rows are
+// output followed by -horizon rows of forecasts, e.g. with -c X
+//     X,Y,Z,Time,X forecast,X lower,X upper
+//     ...
+//     28,-142,-2043,2015-11-12 15:44:43.198,,,
+//     ,,,2015-11-12 15:44:43.281,28.45,20.23,36.67
+// where lower and upper are the bounds of the -level prediction interval.
+// the forecast rows' timestamps continue the -time column at the mean
+// interval of its rows, if it has timestamps.
+// the model is fitted by Hannan-Rissanen regressions: the series is
+// differenced d times, the innovations estimated by a long AR fit, and the
+// AR and MA coefficients by least squares on the lagged values and
+// innovations. without differencing, the model has a constant mean.
+// prediction intervals assume normal innovations, and widen with the
+// horizon by the model's psi weights.
+// the values are held in memory for the fit, so very long inputs are best
+// sampled or aggregated first, see sample.go and aggregate.go
+
+
+package main
+
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+// a fitted ARIMA model
+type arimaModel struct {
+	p, d, q int
+	mean    float64   // the mean of the differenced series, when d is 0
+	ar      []float64 // phi, the AR coefficients
+	ma      []float64 // theta, the MA coefficients
+	sigma2  float64   // the innovations' variance
+	resid   []float64 // the innovations of the differenced series
+	diffed  []float64 // the differenced series, less the mean
+}
+
+
+// parse an order, p,d,q
+func parseOrder(s string) (p, d, q int, err error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 {
+		return 0, 0, 0, fmt.Errorf("invalid order %q, expected p,d,q", s)
+	}
+	var order [3]int
+	for i, part := range parts {
+		if order[i], err = strconv.Atoi(strings.TrimSpace(part)); err != nil || order[i] < 0 {
+			return 0, 0, 0, fmt.Errorf("invalid order %q, expected p,d,q of counts", s)
+		}
+	}
+	return order[0], order[1], order[2], nil
+}
+
+
+// difference a series
+func difference(y []float64) []float64 {
+	if len(y) < 2 {
+		return nil
+	}
+	w := make([]float64, len(y)-1)
+	for i := range w {
+		w[i] = y[i+1] - y[i]
+	}
+	return w
+}
+
+
+// solve the least squares problem X b = y by its normal equations, or false
+// if they're singular
+func leastSquares(x [][]float64, y []float64) ([]float64, bool) {
+	k := len(x[0])
+	// the augmented matrix of X'X | X'y
+	a := make([][]float64, k)
+	for i := range a {
+		a[i] = make([]float64, k+1)
+		for r := range x {
+			for j := 0; j < k; j++ {
+				a[i][j] += x[r][i] * x[r][j]
+			}
+			a[i][k] += x[r][i] * y[r]
+		}
+	}
+	// Gaussian elimination with partial pivoting
+	for c := 0; c < k; c++ {
+		pivot := c
+		for r := c + 1; r < k; r++ {
+			if math.Abs(a[r][c]) > math.Abs(a[pivot][c]) {
+				pivot = r
+			}
+		}
+		if math.Abs(a[pivot][c]) < 1e-12 {
+			return nil, false
+		}
+		a[c], a[pivot] = a[pivot], a[c]
+		for r := c + 1; r < k; r++ {
+			f := a[r][c] / a[c][c]
+			for j := c; j <= k; j++ {
+				a[r][j] -= f * a[c][j]
+			}
+		}
+	}
+	b := make([]float64, k)
+	for i := k - 1; i >= 0; i-- {
+		s := a[i][k]
+		for j := i + 1; j < k; j++ {
+			s -= a[i][j] * b[j]
+		}
+		b[i] = s / a[i][i]
+	}
+	return b, true
+}
+
+
+// fit the AR and MA coefficients of w by regressing it on its p lags and
+// the q lags of the innovations e, from row start
+func fitLags(w, e []float64, p, q, start int) ([]float64, bool) {
+	var x [][]float64
+	var y []float64
+	for t := start; t < len(w); t++ {
+		row := make([]float64, 0, p+q)
+		for i := 1; i <= p; i++ {
+			row = append(row, w[t-i])
+		}
+		for j := 1; j <= q; j++ {
+			row = append(row, e[t-j])
+		}
+		x = append(x, row)
+		y = append(y, w[t])
+	}
+	if len(y) <= p+q {
+		return nil, false
+	}
+	return leastSquares(x, y)
+}
+
+
+// fit an ARIMA(p,d,q) model to a series
+func fitARIMA(y []float64, p, d, q int) (*arimaModel, error) {
+	m := &arimaModel{p: p, d: d, q: q}
+	w := y
+	for i := 0; i < d; i++ {
+		w = difference(w)
+	}
+	if len(w) < 2*(p+q)+2 {
+		return nil, fmt.Errorf("too few values, %d, to fit an ARIMA(%d,%d,%d) model", len(y), p, d, q)
+	}
+	if d == 0 {
+		for _, v := range w {
+			m.mean += v
+		}
+		m.mean /= float64(len(w))
+	}
+	m.diffed = make([]float64, len(w))
+	for i, v := range w {
+		m.diffed[i] = v - m.mean
+	}
+	w = m.diffed
+
+	// the innovations, estimated by the residuals of a long AR fit
+	e := make([]float64, len(w))
+	start := p
+	if q > 0 {
+		long := min(max(p, q)+10, len(w)/4)
+		if long < 1 {
+			return nil, fmt.Errorf("too few values, %d, to estimate the innovations of an ARIMA(%d,%d,%d) model", len(y), p, d, q)
+		}
+		coef, ok := fitLags(w, nil, long, 0, long)
+		if !ok {
+			return nil, fmt.Errorf("too few values, %d, to estimate the innovations of an ARIMA(%d,%d,%d) model", len(y), p, d, q)
+		}
+		for t := long; t < len(w); t++ {
+			e[t] = w[t]
+			for i, c := range coef {
+				e[t] -= c * w[t-i-1]
+			}
+		}
+		start = long + q
+	}
+	if p+q > 0 {
+		coef, ok := fitLags(w, e, p, q, start)
+		if !ok {
+			return nil, fmt.Errorf("can't fit an ARIMA(%d,%d,%d) model, the values may be constant", p, d, q)
+		}
+		m.ar, m.ma = coef[:p], coef[p:]
+	}
+
+	// the model's innovations, from its recursion
+	m.resid = make([]float64, len(w))
+	n := 0
+	for t := range w {
+		m.resid[t] = w[t]
+		for i, c := range m.ar {
+			if t-i-1 >= 0 {
+				m.resid[t] -= c * w[t-i-1]
+			}
+		}
+		for j, c := range m.ma {
+			if t-j-1 >= 0 {
+				m.resid[t] -= c * m.resid[t-j-1]
+			}
+		}
+		if t >= p {
+			m.sigma2 += m.resid[t] * m.resid[t]
+			n++
+		}
+	}
+	m.sigma2 /= float64(max(n-p-q, 1))
+	return m, nil
+}
+
+
+// the forecasts of the next h values of the series y the model was fitted
+// to, and their standard errors
+func (m *arimaModel) forecast(y []float64, h int) ([]float64, []float64) {
+	// forecast the differenced series, future innovations being 0
+	w := append([]float64(nil), m.diffed...)
+	e := append([]float64(nil), m.resid...)
+	n := len(w)
+	for k := 0; k < h; k++ {
+		f := 0.0
+		for i, c := range m.ar {
+			f += c * w[n+k-i-1]
+		}
+		for j, c := range m.ma {
+			f += c * e[n+k-j-1]
+		}
+		w = append(w, f)
+		e = append(e, 0)
+	}
+
+	// undifference, the last values of each level of differencing
+	// continuing with the forecasts
+	levels := [][]float64{y}
+	for i := 0; i < m.d; i++ {
+		levels = append(levels, difference(levels[i]))
+	}
+	forecasts := make([]float64, h)
+	for k := 0; k < h; k++ {
+		forecasts[k] = w[n+k] + m.mean
+	}
+	for i := m.d - 1; i >= 0; i-- {
+		last := levels[i][len(levels[i])-1]
+		for k := range forecasts {
+			last += forecasts[k]
+			forecasts[k] = last
+		}
+	}
+
+	// the psi weights, of the AR polynomial, 1 - phi1 B - ..., times the
+	// differencing's, (1 - B)^d
+	poly := []float64{1}
+	for _, c := range m.ar {
+		poly = append(poly, -c)
+	}
+	for i := 0; i < m.d; i++ {
+		next := make([]float64, len(poly)+1)
+		for j, c := range poly {
+			next[j] += c
+			next[j+1] -= c
+		}
+		poly = next
+	}
+	phi := make([]float64, len(poly)-1)
+	for j := range phi {
+		phi[j] = -poly[j+1]
+	}
+	psi := make([]float64, h)
+	stderrs := make([]float64, h)
+	v := 0.0
+	for j := range psi {
+		if j == 0 {
+			psi[j] = 1
+		} else {
+			if j-1 < len(m.ma) {
+				psi[j] = m.ma[j-1]
+			}
+			for i := 1; i <= min(j, len(phi)); i++ {
+				psi[j] += phi[i-1] * psi[j-i]
+			}
+		}
+		v += psi[j] * psi[j]
+		stderrs[j] = math.Sqrt(m.sigma2 * v)
+	}
+	return forecasts, stderrs
+}
+
+
+func runForecast(args []string) {
+	fs := flag.NewFlagSet("forecast", flag.ExitOnError)
+	column := fs.String("c", "", "column (name or index) to forecast")
+	orderSpec := fs.String("order", "1,0,0", "ARIMA model order, p,d,q: the AR order, differences and MA order")
+	horizon := fs.Int("horizon", 10, "number of steps to forecast")
+	level := fs.Float64("level", 0.95, "coverage of the prediction intervals")
+	timecol := fs.String("time", "3", "timestamp column (name or index), continued in the forecast rows")
+	commonFlags(fs, "the rows followed by the forecasts")
+	fs.Parse(args)
+	infilenames = append(infilenames, fs.Args()...)
+	setupLogging()
+
+	p, d, q, err := parseOrder(*orderSpec)
+	switch {
+	case *column == "":
+		fatal("-c column is required")
+	case err != nil:
+		fatal("invalid model order", "err", err)
+	case *horizon < 1:
+		fatal("invalid forecast horizon", "horizon", *horizon)
+	case *level <= 0 || *level >= 1:
+		fatal("invalid prediction interval level, expected between 0 and 1", "level", *level)
+	}
+
+	slog.Debug("forecast CSV column",
+		"inputs", infilenames, "output", outfilename, "column", *column, "order", *orderSpec, "horizon", *horizon)
+
+	infile := openInputs(context.Background(), infilenames, false, nil)
+	defer infile.Close()
+
+	oufl := createOutput(outfilename, "")
+	outfile := csv.NewWriter(bufio.NewWriter(oufl))
+
+	header, err := infile.Read()
+	if err != nil {
+		fatal("error reading header from csv", "err", err)
+	}
+	header = append([]string(nil), header...)
+	col := findColumn(header, *column)
+	if col < 0 {
+		fatal("column not found in header", "column", *column)
+	}
+	tcol := findColumn(header, *timecol)
+	name := header[col]
+	outrec := append(header[:len(header):len(header)], name+" forecast", name+" lower", name+" upper")
+	if err := outfile.Write(outrec); err != nil {
+		fatal("error writing record to csv", "err", err)
+	}
+
+	// the rows are output as read, keeping the values, and the timestamps
+	var y []float64
+	var first, last time.Time
+	ntimes := 0
+	for {
+		record, err := infile.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fatal("error reading record from csv", "err", err)
+		}
+		if v, err := rollingavg.ParseFloat(field(record, col)); err == nil {
+			y = append(y, v)
+		}
+		if t, err := rollingavg.ParseTime(field(record, tcol)); tcol >= 0 && err == nil {
+			if ntimes == 0 {
+				first = t
+			}
+			last = t
+			ntimes++
+		}
+		outrec = append(append(outrec[:0], record...), "", "", "")
+		if err := outfile.Write(outrec); err != nil {
+			fatal("error writing record to csv", "err", err)
+		}
+	}
+
+	m, err := fitARIMA(y, p, d, q)
+	if err != nil {
+		fatal("error fitting model", "column", name, "err", err)
+	}
+	slog.Debug("fitted model", "values", len(y), "ar", m.ar, "ma", m.ma, "mean", m.mean, "sigma2", m.sigma2)
+
+	forecasts, stderrs := m.forecast(y, *horizon)
+	z := math.Sqrt2 * math.Erfinv(*level)
+	var step time.Duration
+	if ntimes > 1 {
+		step = last.Sub(first) / time.Duration(ntimes-1)
+	}
+	format := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	for k, f := range forecasts {
+		outrec = make([]string, len(header), len(header)+3)
+		if step > 0 {
+			outrec[tcol] = last.Add(step * time.Duration(k+1)).Format(rollingavg.TimeLayout + ".999")
+		}
+		outrec = append(outrec, format(f), format(f-z*stderrs[k]), format(f+z*stderrs[k]))
+		if err := outfile.Write(outrec); err != nil {
+			fatal("error writing record to csv", "err", err)
+		}
+	}
+	slog.Debug("forecast records", "records", len(forecasts))
+
+	outfile.Flush()
+	if err := outfile.Error(); err != nil {
+		fatal("error writing csv", "err", err)
+	}
+	if err := oufl.Close(); err != nil {
+		fatal("error closing destination csv", "err", err)
+	}
+}
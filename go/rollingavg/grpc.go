@@ -0,0 +1,177 @@
+// grpc.go: gRPC bidirectional streaming service
+//
+// with -grpc-addr, a gRPC server is run rather than processing files,
+// providing the RollingAvg service defined in rollingavgpb/rollingavg.proto,
+// so clients in any language can stream rows in and rolling averages out.
+// each Process stream starts with a Config, giving the column names and
+// any options overriding those of the command line, followed by rows.
+// output rows are streamed back as soon as their windows are complete.
+// an invalid config or row ends the stream with an InvalidArgument error.
+// time windows are aligned by the command line's -window-align and
+// -window-tz, see calendar.go
+// with -tls-cert, it's served over TLS, and with -auth-token or -auth-basic,
+// streams must be authenticated, see security.go
+
+
+package main
+
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"time"
+
+	pb "github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavgpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+
+// run the gRPC server on addr until it fails
+func runGRPC(addr string) {
+	holidays := make(rollingavg.Holidays)
+	if holidayfile != "" {
+		holidays = loadHolidays(holidayfile)
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		fatal("error listening for gRPC", "err", err)
+	}
+	s := grpc.NewServer(grpcServerOptions()...)
+	pb.RegisterRollingAvgServer(s, &rollingAvgServer{holidays: holidays, loc: windowLocation()})
+	serviceReady()
+	slog.Debug("serving gRPC", "addr", addr)
+	fatal("gRPC server failed", "err", s.Serve(ln))
+}
+
+
+type rollingAvgServer struct {
+	pb.UnimplementedRollingAvgServer
+	holidays rollingavg.Holidays
+	loc      *time.Location
+}
+
+
+// process the rows of a stream, sending back each output row
+func (s *rollingAvgServer) Process(stream pb.RollingAvg_ProcessServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	config := req.GetConfig()
+	if config == nil {
+		return status.Error(codes.InvalidArgument, "the first request must be a Config")
+	}
+	header := config.GetColumns()
+	if len(header) < 2 {
+		return status.Error(codes.InvalidArgument, "at least two columns are needed")
+	}
+
+	n := nrows
+	if config.GetWindow() != 0 {
+		n = int(config.GetWindow())
+	}
+	param := func(v, def string) string {
+		if v != "" {
+			return v
+		}
+		return def
+	}
+	opts := rollingavg.Options{
+		Window:     n,
+		WindowUnit: param(config.GetWindowUnit(), windowUnit),
+		Stat:       param(config.GetStat(), statName),
+		Rule:       ruleName,
+		GroupBy:    param(config.GetGroupBy(), groupBy),
+		TimeColumn: param(config.GetTimeColumn(), timeCol),
+		Holidays:   s.holidays,
+		Align:      windowAlign,
+		Location:   s.loc,
+	}
+	p, err := rollingavg.NewProcessor(header, opts)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if p.Rule, err = ruleByName(opts.Rule); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	ctx, span := telemetry.start(stream.Context(), "rollingavg.request",
+		attribute.String("rollingavg.protocol", "grpc"))
+	p.OnRead = func(n int, record []string) { telemetry.rowsRead(1) }
+	p.OnWrite = func(group string, r rollingavg.Result, outrec []string) { telemetry.rowsWritten(1) }
+	rows := &grpcRows{stream: stream}
+	_, err = p.RunContext(ctx, rows, rows)
+	if rows.err != nil {
+		telemetry.failed(span, rows.err)
+		return rows.err
+	}
+	if err != nil {
+		telemetry.failed(span, err)
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	span.End()
+	return nil
+}
+
+
+// the rows received on, and output rows sent to, a Process stream.
+// on a stream error, reading stops and the error is kept
+type grpcRows struct {
+	stream pb.RollingAvg_ProcessServer
+	err    error
+}
+
+
+func (g *grpcRows) Read() ([]string, error) {
+	if g.err != nil {
+		return nil, io.EOF
+	}
+	req, err := g.stream.Recv()
+	if err == io.EOF {
+		return nil, err
+	}
+	if err != nil {
+		g.err = err
+		return nil, io.EOF
+	}
+	row := req.GetRow()
+	if row == nil {
+		g.err = status.Error(codes.InvalidArgument, "only the first request may be a Config")
+		return nil, io.EOF
+	}
+	return row.GetValues(), nil
+}
+
+
+// send an output row, the input values followed by the averages and result
+func (g *grpcRows) Write(record []string) error {
+	if g.err != nil {
+		return nil
+	}
+	i := len(record) - 3
+	avga, _ := strconv.ParseFloat(record[i], 64)
+	avgb, _ := strconv.ParseFloat(record[i+1], 64)
+	g.err = g.stream.Send(&pb.ProcessResponse{
+		Values:   record[:i],
+		AverageA: avga,
+		AverageB: avgb,
+		Result:   record[i+2],
+	})
+	return nil
+}
+
+
+// rows are sent as they are written
+func (g *grpcRows) Flush() {}
+
+
+func (g *grpcRows) Error() error {
+	return g.err
+}
@@ -0,0 +1,210 @@
+// histogram.go: histogram of a CSV column's values
+//
+// to understand value distributions before setting thresholds, invoked as
+// the histogram subcommand, see commands.go:
+//     mdp histogram [-v] -c col [-bins n] [-scale linear|log] [-min v] [-max v]
+//         [-format csv|ascii] [-width n] [-f inputfile]... [-o outputfile] [inputfile...]
+// the column's values are counted in -bins (default 20) bins of equal width
+// from -min to -max, or with -scale log, of equal ratio, so for positive
+// values only. the range defaults to that of the values, for which they are
+// kept in memory, while with both -min and -max, the rows are streamed.
+// values outside the range, and with -scale log, values not above 0, aren't
+// counted, nor are values that aren't numbers.
+// the output is a CSV of Start,End,Count, each bin being from Start up to
+// End, the last including End, or with -format ascii, bars of up to -width
+// (default 60) characters, e.g.
+//     [     -50,      -40)   1012 ##########################
+
+
+package main
+
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+// the bins of a histogram
+type histogram struct {
+	min, max float64
+	log      bool
+	counts   []int
+}
+
+
+// the bin of a value, or -1 if it's outside the range
+func (h *histogram) bin(v float64) int {
+	lo, hi := h.min, h.max
+	if h.log {
+		if v <= 0 {
+			return -1
+		}
+		v, lo, hi = math.Log(v), math.Log(lo), math.Log(hi)
+	}
+	if v < lo || v > hi || math.IsNaN(v) {
+		return -1
+	}
+	if hi == lo {
+		return 0
+	}
+	return min(int((v-lo)/(hi-lo)*float64(len(h.counts))), len(h.counts)-1)
+}
+
+
+// the lower bound of bin i, or with i the number of bins, the upper bound
+func (h *histogram) edge(i int) float64 {
+	f := float64(i) / float64(len(h.counts))
+	if h.log {
+		return math.Exp(math.Log(h.min) + f*(math.Log(h.max)-math.Log(h.min)))
+	}
+	return h.min + f*(h.max-h.min)
+}
+
+
+func runHistogram(args []string) {
+	fs := flag.NewFlagSet("histogram", flag.ExitOnError)
+	col := fs.String("c", "", "column (name or index) of the values")
+	bins := fs.Int("bins", 20, "number of bins")
+	scale := fs.String("scale", "linear", "bin scale: linear or log")
+	minv := fs.String("min", "", "lower bound of the bins (default the minimum value)")
+	maxv := fs.String("max", "", "upper bound of the bins (default the maximum value)")
+	format := fs.String("format", "csv", "output format: csv or ascii")
+	width := fs.Int("width", 60, "width of the longest ascii bar")
+	commonFlags(fs, "the bins and their counts")
+	fs.Parse(args)
+	infilenames = append(infilenames, fs.Args()...)
+	setupLogging()
+
+	switch {
+	case *col == "":
+		fatal("-c column is required")
+	case *bins < 1:
+		fatal("invalid number of bins", "bins", *bins)
+	case *scale != "linear" && *scale != "log":
+		fatal("invalid bin scale", "scale", *scale)
+	case *format != "csv" && *format != "ascii":
+		fatal("invalid output format", "format", *format)
+	}
+	h := &histogram{min: math.Inf(1), max: math.Inf(-1), log: *scale == "log", counts: make([]int, *bins)}
+	bound := func(s string, v *float64) bool {
+		if s == "" {
+			return false
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			fatal("invalid histogram bound", "bound", s)
+		}
+		*v = f
+		return true
+	}
+	streaming := bound(*minv, &h.min)
+	streaming = bound(*maxv, &h.max) && streaming
+
+	slog.Debug("histogram of CSV column",
+		"inputs", infilenames, "output", outfilename, "column", *col, "bins", *bins, "scale", *scale)
+
+	infile := openInputs(context.Background(), infilenames, false, nil)
+	defer infile.Close()
+
+	header, err := infile.Read()
+	if err != nil {
+		fatal("error reading header from csv", "err", err)
+	}
+	c := findColumn(header, *col)
+	if c < 0 {
+		fatal("column not found in header", "column", *col)
+	}
+
+	// without both bounds, the values are kept to find the range
+	var values []float64
+	lo, hi := math.Inf(1), math.Inf(-1)
+	n, counted := 0, 0
+	for {
+		record, err := infile.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fatal("error reading record from csv", "err", err)
+		}
+		n++
+		v, err := rollingavg.ParseFloat(field(record, c))
+		if err != nil || h.log && v <= 0 {
+			continue
+		}
+		if streaming {
+			if i := h.bin(v); i >= 0 {
+				h.counts[i]++
+				counted++
+			}
+			continue
+		}
+		values = append(values, v)
+		lo, hi = math.Min(lo, v), math.Max(hi, v)
+	}
+	if !streaming {
+		if *minv == "" {
+			h.min = lo
+		}
+		if *maxv == "" {
+			h.max = hi
+		}
+		for _, v := range values {
+			if i := h.bin(v); i >= 0 {
+				h.counts[i]++
+				counted++
+			}
+		}
+	}
+	slog.Debug("counted values", "records", n, "counted", counted, "min", h.min, "max", h.max)
+
+	oufl := createOutput(outfilename, "")
+	w := bufio.NewWriter(oufl)
+	if counted == 0 {
+		slog.Warn("no values in the histogram range", "column", *col)
+	} else if *format == "ascii" {
+		writeHistogramBars(w, h, *width)
+	} else {
+		out := csv.NewWriter(w)
+		out.Write([]string{"Start", "End", "Count"})
+		for i, count := range h.counts {
+			out.Write([]string{strconv.FormatFloat(h.edge(i), 'g', -1, 64),
+				strconv.FormatFloat(h.edge(i+1), 'g', -1, 64), strconv.Itoa(count)})
+		}
+		out.Flush()
+	}
+	if err := w.Flush(); err != nil {
+		fatal("error writing histogram", "err", err)
+	}
+	if err := oufl.Close(); err != nil {
+		fatal("error closing histogram", "err", err)
+	}
+}
+
+
+// write the histogram as ascii bars, the longest of width characters
+func writeHistogramBars(w io.Writer, h *histogram, width int) {
+	most := 0
+	for _, count := range h.counts {
+		most = max(most, count)
+	}
+	for i, count := range h.counts {
+		end := ")"
+		if i == len(h.counts)-1 {
+			end = "]"
+		}
+		bar := strings.Repeat("#", int(math.Round(float64(count)/float64(most)*float64(width))))
+		fmt.Fprintf(w, "[%10.4g, %10.4g%s %8d %s\n", h.edge(i), h.edge(i+1), end, count, bar)
+	}
+}
@@ -0,0 +1,212 @@
+// index.go: sidecar indexes of large input files
+//
+// re-running over a time range of a large file needn't re-scan the whole
+// file. with -index, the first run over a local CSV input file writes a
+// sidecar index, file.csv.idx, of the byte offsets of each block of rows
+// and the earliest and latest -time column timestamps in the block.
+// subsequent runs with -from or -to, see timerange.go, start reading at
+// the first block that may have rows in the range, and stop after the
+// last, e.g.
+//     rollingavg -index -f big.csv -o all.csv
+//     rollingavg -index -from 2015-11-12 -to 2015-11-13 -f big.csv -o day.csv
+// as the blocks' bounds are kept, the file needn't be sorted by time,
+// though the more it's sorted the more can be skipped.
+// an index is rebuilt when its file's size or modification time change,
+// or it's of another time column. offsets are into decompressed content,
+// so compressed files are decompressed up to the range, though not parsed.
+// files that are followed, decoded from another encoding, or remote, and
+// parquet and xlsx inputs, aren't indexed
+
+
+package main
+
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+var indexFlag bool
+
+
+// the number of rows in each indexed block
+const indexBlockRows = 10000
+
+
+// a sidecar index of an input file
+type csvIndex struct {
+	Size    int64        `json:"size"`
+	ModTime time.Time    `json:"mod_time"`
+	Column  int          `json:"column"`
+	Blocks  []indexBlock `json:"blocks"`
+}
+
+
+// a block of rows, from its byte offset, and the range of their timestamps
+type indexBlock struct {
+	Offset int64     `json:"offset"`
+	Rows   int       `json:"rows"`
+	Times  int       `json:"times"`
+	Min    time.Time `json:"min"`
+	Max    time.Time `json:"max"`
+}
+
+
+// the indexing of the files of a multiCSVReader
+type inputIndex struct {
+	tcol     int
+	from, to time.Time
+
+	// the index being built of the current file, if any, and the offset
+	// to stop reading the current file at, or -1
+	build     *csvIndex
+	buildFile string
+	stop      int64
+}
+
+
+// check that -index can be used with the other options
+func checkIndex() {
+	if indexFlag && (mergeFlag || parallelWorkers > 1) {
+		fatal("-index can't be used with -merge or -parallel")
+	}
+}
+
+
+// index the input files, of the given header, seeking to the -from -to
+// range. the header of the first file must have been read
+func (r *multiCSVReader) useIndex(header []string) {
+	tcol := findColumn(header, timeCol)
+	if tcol < 0 {
+		fatal("time column not found in header", "column", timeCol)
+	}
+	r.index = &inputIndex{tcol: tcol, stop: -1}
+	r.index.from, r.index.to = timeRange()
+	if r.cur != nil && r.next > 0 {
+		r.seekIndexed(r.filenames[r.next-1])
+	}
+}
+
+
+func indexFilename(filename string) string {
+	return filename + ".idx"
+}
+
+
+// seek the file just opened, and its header read, to the time range by its
+// index, or if it hasn't an up to date index, build one as it's read
+func (r *multiCSVReader) seekIndexed(filename string) {
+	x := r.index
+	x.build, x.stop = nil, -1
+	if _, ok := r.cur.(offsetReader); !ok || r.transcoded || isRemote(filename) || r.follow && r.next == len(r.filenames) {
+		slog.Debug("input can't be indexed", "file", filename)
+		return
+	}
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return
+	}
+	idx := loadIndex(filename)
+	if idx == nil || idx.Size != fi.Size() || !idx.ModTime.Equal(fi.ModTime()) || idx.Column != x.tcol {
+		slog.Debug("building input index", "file", filename)
+		x.build = &csvIndex{Size: fi.Size(), ModTime: fi.ModTime(), Column: x.tcol}
+		x.buildFile = filename
+		return
+	}
+
+	// blocks before the first that may have rows in the range are skipped,
+	// and those after the last aren't read
+	first, last := len(idx.Blocks), -1
+	for i, b := range idx.Blocks {
+		if b.Times == 0 || !x.from.IsZero() && b.Max.Before(x.from) || !x.to.IsZero() && !b.Min.Before(x.to) {
+			continue
+		}
+		first = min(first, i)
+		last = i
+	}
+	if last < 0 {
+		slog.Debug("no indexed rows in time range", "file", filename)
+		x.stop = 0
+		return
+	}
+	if last+1 < len(idx.Blocks) {
+		x.stop = idx.Blocks[last+1].Offset
+	}
+	if start := idx.Blocks[first].Offset; start > r.offset() {
+		slog.Debug("seek to time range by index", "file", filename, "offset", start)
+		stop := x.stop
+		r.Close()
+		r.next--
+		r.openAt(start)
+		x.stop = stop
+	}
+}
+
+
+// the offset of the next record of the current CSV file
+func (r *multiCSVReader) offset() int64 {
+	if cr, ok := r.cur.(offsetReader); ok {
+		return r.base + cr.InputOffset()
+	}
+	return 0
+}
+
+
+// read an input file's index, or nil if there isn't a valid one
+func loadIndex(filename string) *csvIndex {
+	data, err := os.ReadFile(indexFilename(filename))
+	if err != nil {
+		return nil
+	}
+	idx := &csvIndex{}
+	if err := json.Unmarshal(data, idx); err != nil {
+		slog.Warn("ignoring invalid index", "file", indexFilename(filename), "err", err)
+		return nil
+	}
+	return idx
+}
+
+
+// add a record, read from offset, to the index being built
+func (x *inputIndex) add(record []string, offset int64) {
+	idx := x.build
+	if len(idx.Blocks) == 0 || idx.Blocks[len(idx.Blocks)-1].Rows == indexBlockRows {
+		idx.Blocks = append(idx.Blocks, indexBlock{Offset: offset})
+	}
+	b := &idx.Blocks[len(idx.Blocks)-1]
+	b.Rows++
+	t, err := rollingavg.ParseTime(field(record, x.tcol))
+	if err != nil {
+		return
+	}
+	if b.Times == 0 || t.Before(b.Min) {
+		b.Min = t
+	}
+	if b.Times == 0 || t.After(b.Max) {
+		b.Max = t
+	}
+	b.Times++
+}
+
+
+// write the index built of a file read to its end
+func (x *inputIndex) finish() {
+	if x.build == nil {
+		return
+	}
+	data, err := json.Marshal(x.build)
+	if err == nil {
+		err = os.WriteFile(indexFilename(x.buildFile), data, 0644)
+	}
+	if err != nil {
+		slog.Warn("error writing index", "file", indexFilename(x.buildFile), "err", err)
+	} else {
+		slog.Info("wrote index", "file", indexFilename(x.buildFile), "blocks", len(x.build.Blocks))
+	}
+	x.build = nil
+}
@@ -0,0 +1,143 @@
+// influx.go: write output rows as InfluxDB line protocol
+//
+// with -format influx, each output row becomes one line
+//     measurement,tag=value,... field=value,... timestamp
+// the measurement is given by -influx-measurement, tags are taken from
+// the columns listed in -influx-tags, and the timestamp (in nanoseconds)
+// from the time column (see -time). all other columns, including the
+// averages and Result, become fields: numbers as float fields, anything
+// else as string fields. empty values are left out.
+// field and tag keys are the column names
+
+
+package main
+
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+// escapes for measurement names, and tag keys, tag values and field keys
+var influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+var influxKeyEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+var influxStringEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`)
+
+
+// writes records as InfluxDB line protocol
+type influxOutput struct {
+	out         *outputFile
+	w           *bufio.Writer
+	measurement string
+	tagcols     []string
+	timecol     string
+	header      []string
+	tags        []int
+	istag       []bool
+	tcol        int
+	err         error
+}
+
+
+func newInfluxOutput(outfilename, compression, measurement string, tagcols []string, timecol string) *influxOutput {
+	out := createOutput(outfilename, compression)
+	return &influxOutput{
+		out:         out,
+		w:           bufio.NewWriter(out),
+		measurement: influxMeasurementEscaper.Replace(measurement),
+		tagcols:     tagcols,
+		timecol:     timecol,
+	}
+}
+
+
+// format a field value, as a float if it is a number, otherwise a string
+func influxField(v string) string {
+	if _, err := strconv.ParseFloat(v, 64); err == nil {
+		return v
+	}
+	return `"` + influxStringEscaper.Replace(v) + `"`
+}
+
+
+func (o *influxOutput) Write(record []string) error {
+	if o.err != nil {
+		return o.err
+	}
+	o.out.recordWritten()
+	// the first record is the header, naming the tags and fields
+	if o.header == nil {
+		o.header = make([]string, len(record))
+		o.istag = make([]bool, len(record))
+		for i, name := range record {
+			o.header[i] = influxKeyEscaper.Replace(name)
+		}
+		o.tcol = findColumn(record, o.timecol)
+		if o.tcol < 0 {
+			o.err = fmt.Errorf("time column not found in header: %s", o.timecol)
+			return o.err
+		}
+		for _, name := range o.tagcols {
+			c := findColumn(record, name)
+			if c < 0 {
+				o.err = fmt.Errorf("tag column not found in header: %s", name)
+				return o.err
+			}
+			o.tags = append(o.tags, c)
+			o.istag[c] = true
+		}
+		return nil
+	}
+
+	t, err := rollingavg.ParseTime(record[o.tcol])
+	if err != nil {
+		o.err = err
+		return o.err
+	}
+
+	var line strings.Builder
+	line.WriteString(o.measurement)
+	for _, c := range o.tags {
+		if record[c] != "" {
+			line.WriteString("," + o.header[c] + "=" + influxKeyEscaper.Replace(record[c]))
+		}
+	}
+	sep := " "
+	for i, v := range record {
+		if i == o.tcol || o.istag[i] || v == "" {
+			continue
+		}
+		line.WriteString(sep + o.header[i] + "=" + influxField(v))
+		sep = ","
+	}
+	line.WriteString(" " + strconv.FormatInt(t.UnixNano(), 10) + "\n")
+
+	_, o.err = o.w.WriteString(line.String())
+	return o.err
+}
+
+
+func (o *influxOutput) Flush() {
+	if err := o.w.Flush(); err != nil && o.err == nil {
+		o.err = err
+	}
+}
+
+
+func (o *influxOutput) Error() error {
+	return o.err
+}
+
+
+func (o *influxOutput) Close() error {
+	o.Flush()
+	if o.err != nil {
+		return o.err
+	}
+	return o.out.Close()
+}
@@ -0,0 +1,338 @@
+// inputs.go: read several input CSV files as a single continuous stream
+//
+// files are read in order, and their records returned as if they came from
+// one file. the first file's header row is returned as the stream's header,
+// the header rows of the remaining files must match it and are skipped.
+// with no files, stdin is read
+// if following, the last file is followed for appended rows, see follow.go
+// compressed inputs are decompressed, see compress.go, and decoded to
+// UTF-8, see encoding.go
+// malformed quoting and ragged rows may be tolerated, see ragged.go
+// with -passthrough, the text of the rows is kept, see passthrough.go
+// with -index, files are indexed and seeked to the -from -to range, see
+// index.go
+// filenames may be s3:// or gs:// URLs, see remote.go
+// .parquet files are read as parquet, see parquet.go
+// .xlsx files are read from a worksheet, see xlsx.go
+// with -mmap, local files are memory mapped, see mmap.go
+
+
+package main
+
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+)
+
+
+// a list of strings for flags that may be repeated, e.g. -f a.csv -f b.csv
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+
+// a source of CSV records, satisfied by *csv.Reader and *mmapReader
+type recordReader interface {
+	Read() (record []string, err error)
+}
+
+
+// a record source that holds open files
+type recordReadCloser interface {
+	recordReader
+	Close()
+}
+
+
+// reads the records from a sequence of CSV files as one stream
+type multiCSVReader struct {
+	filenames  []string
+	next       int
+	fl         io.Closer
+	dec        *decompressReader
+	base       int64
+	transcoded bool
+	cur        recordReader
+	columns    []string
+	header     []string
+	follow     bool
+	followed   *followReader
+	reuse      bool
+	index      *inputIndex
+	ctx        context.Context
+}
+
+
+// open a stream over the named files, or stdin if there are none
+// if follow is set, the last file is followed for appended rows, until
+// ctx is cancelled
+// columns selects the columns read from parquet files
+func openInputs(ctx context.Context, filenames []string, follow bool, columns []string) *multiCSVReader {
+	r := &multiCSVReader{filenames: filenames, follow: follow, columns: columns, ctx: ctx}
+	if len(filenames) == 0 {
+		in := decodeInput(decompress(countBytes(os.Stdin)))
+		if passthroughFlag {
+			r.cur = newRawReader(in)
+		} else {
+			r.cur = newCSVReader(in)
+		}
+	}
+	return r
+}
+
+
+// open the next file in the sequence. returns false when there are no more
+func (r *multiCSVReader) openNext() bool {
+	if r.next >= len(r.filenames) {
+		return false
+	}
+	r.openAt(0)
+	return true
+}
+
+
+// open the next file, positioned at the given byte offset into its
+// (decompressed) content. the header is only checked when opened at 0
+func (r *multiCSVReader) openAt(offset int64) {
+	filename := r.filenames[r.next]
+	r.next++
+
+	slog.Debug("open input file", "file", filename)
+
+	fl, err := openSource(filename)
+	if err != nil {
+		fatal("error opening source csv", "err", err)
+	}
+	r.fl = fl
+
+	if isParquet(filename) {
+		if r.follow || offset > 0 {
+			fatal("parquet inputs can't be followed or resumed", "file", filename)
+		}
+		pr := newParquetReader(fl, r.columns)
+		r.fl = multiCloser{pr, fl}
+		r.cur = pr
+		r.base = 0
+		r.checkHeader(filename)
+		return
+	}
+
+	if isXLSX(filename) {
+		if r.follow || offset > 0 {
+			fatal("xlsx inputs can't be followed or resumed", "file", filename)
+		}
+		xr := newXLSXReader(countBytes(fl), xlsxSheet, timeCol)
+		r.fl = multiCloser{xr, fl}
+		r.cur = xr
+		r.base = 0
+		r.checkHeader(filename)
+		return
+	}
+	following := r.follow && r.next == len(r.filenames)
+	var mr *mmapReader
+	if !following {
+		mr = openMapped(filename, fl, offset)
+	}
+	if mr != nil {
+		mr.reuse = r.reuse
+		r.fl = multiCloser{mr, fl}
+		r.cur, r.base, r.transcoded = mr, 0, false
+	} else {
+		var src io.Reader = fl
+		if following {
+			osfl, ok := fl.(*os.File)
+			if !ok {
+				fatal("only local files can be followed", "file", filename)
+			}
+			fr := newFollowReader(r.ctx, osfl)
+			r.fl = fr
+			r.followed = fr
+			src = fr
+		}
+		r.dec = decompress(countBytes(src))
+		if offset > 0 {
+			if _, err := io.CopyN(io.Discard, r.dec, offset); err != nil {
+				fatal("error skipping to offset in source csv", "file", filename, "err", err)
+			}
+		}
+		in := decodeInput(r.dec)
+		r.base = offset + in.bom
+		r.transcoded = in.transcoded
+		if passthroughFlag {
+			r.cur = newRawReader(in)
+		} else {
+			cr := newCSVReader(in)
+			cr.ReuseRecord = r.reuse
+			r.cur = cr
+		}
+	}
+	if offset == 0 {
+		r.checkHeader(filename)
+		if r.index != nil && r.header != nil {
+			r.seekIndexed(filename)
+		}
+	}
+}
+
+
+// reuse the record returned by Read for the next, for CSV inputs, once the
+// header has been read
+func (r *multiCSVReader) reuseRecords() {
+	r.reuse = true
+	switch cur := r.cur.(type) {
+	case *csv.Reader:
+		cur.ReuseRecord = true
+	case *mmapReader:
+		cur.reuse = true
+	}
+}
+
+
+// all but the first file have their header checked and skipped
+func (r *multiCSVReader) checkHeader(filename string) {
+	if r.header == nil {
+		return
+	}
+	header, err := r.cur.Read()
+	if err == io.EOF {
+		return
+	}
+	if err != nil {
+		fatal("error reading header from csv", "file", filename, "err", err)
+	}
+	r.matchHeader(filename, header)
+}
+
+
+// check that a file's header matches that of the first
+func (r *multiCSVReader) matchHeader(filename string, header []string) {
+	if !slices.Equal(header, r.header) {
+		fatal("header does not match first input", "file", filename, "header", header)
+	}
+}
+
+
+// closes several things in order, e.g. a reader and its file
+type multiCloser []io.Closer
+
+func (m multiCloser) Close() error {
+	var err error
+	for _, c := range m {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+
+// the index of the file being read, and the byte offset into its
+// (decompressed) content of the next record
+func (r *multiCSVReader) position() (int, int64) {
+	if r.cur == nil {
+		return r.next, 0
+	}
+	cr, ok := r.cur.(offsetReader)
+	if !ok {
+		fatal("only CSV input positions can be checkpointed")
+	}
+	if r.transcoded {
+		fatal("only UTF-8 input positions can be checkpointed, or split into -parallel chunks")
+	}
+	return r.next - 1, r.base + cr.InputOffset()
+}
+
+
+// continue reading from the given file index and byte offset, as
+// returned by position. the header must already have been read
+func (r *multiCSVReader) resumeAt(file int, offset int64) {
+	if len(r.filenames) == 0 {
+		fatal("can't resume reading from stdin")
+	}
+	if file > len(r.filenames) {
+		fatal("resume position is past the last input file")
+	}
+	r.Close()
+	r.cur = nil
+	r.next = file
+	if offset > 0 {
+		r.openAt(offset)
+	}
+}
+
+
+// read the next record, moving on to the next file at the end of each file
+func (r *multiCSVReader) Read() ([]string, error) {
+	for {
+		if r.cur == nil && !r.openNext() {
+			return nil, io.EOF
+		}
+
+		var offset int64
+		if r.index != nil {
+			offset = r.offset()
+			if r.index.stop >= 0 && offset >= r.index.stop {
+				r.Close()
+				r.cur = nil
+				continue
+			}
+		}
+		record, err := r.cur.Read()
+		if err == io.EOF {
+			if r.index != nil {
+				r.index.finish()
+			}
+			r.Close()
+			r.cur = nil
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if r.followed != nil && r.followed.reopened {
+			// a followed file that was rotated or truncated starts again
+			// with its header
+			r.followed.reopened = false
+			if r.header != nil {
+				r.matchHeader(r.followed.filename, record)
+				continue
+			}
+		}
+		if r.index != nil && r.index.build != nil {
+			r.index.add(record, offset)
+		}
+
+		if r.header == nil {
+			r.header = make([]string, len(record))
+			copy(r.header, record)
+		}
+		return fitRecord(record, len(r.header)), nil
+	}
+}
+
+
+// close the currently open file, if any
+func (r *multiCSVReader) Close() {
+	if r.dec != nil {
+		r.dec.Close()
+		r.dec = nil
+	}
+	if r.fl != nil {
+		r.fl.Close()
+		r.fl = nil
+	}
+	r.followed = nil
+}
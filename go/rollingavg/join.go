@@ -0,0 +1,97 @@
+// join.go: as-of join of a second CSV to the input rows
+//
+// with -join-asof file, each input row is enriched, before its rolling
+// statistics are computed, with the columns of the last row of the second
+// CSV at or before its timestamp, e.g. attaching weather readings to
+// sensor data
+//     rollingavg -join-asof weather.csv -join-time Time -join-tolerance 10m -ewm Temp:0.1 in.csv
+// the input's timestamps are those of its -time column, and the second
+// CSV's of its -join-time column (default 3), which isn't added. with
+// -join-tolerance, a row more than that before the input row isn't
+// joined, the added columns being left empty, as they are for input rows
+// before the second CSV's first. added columns with the same name as an
+// input column are prefixed with "right ", as for the csvjoin subcommand,
+// see csvjoin.go, and can be used as input columns are, e.g. by -ewm,
+// -count-above or config file stages.
+// both the input and the second CSV must be sorted by time, and are
+// streamed, so can be any size. the second CSV may be compressed or remote
+
+
+package main
+
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+var joinAsof string
+var joinTime string
+var joinTolerance time.Duration
+
+
+// joins the rows of the second CSV to those read, as of their timestamps
+type asofJoinReader struct {
+	in     recordReader
+	right  *timedCSV
+	rcols  int
+	tcol   int // the input's time column, once its header is read
+	header []string
+
+	// the last right row at or before the last input row, and the one after
+	prev, next *timedRow
+	last       time.Time
+}
+
+
+func newAsofJoinReader(in recordReader) *asofJoinReader {
+	if passthroughFlag {
+		fatal("-passthrough can't be used with -join-asof, which adds columns to the input rows")
+	}
+	right, rheader := openTimedCSV(joinAsof, joinTime)
+	slog.Debug("join as of", "file", joinAsof, "columns", len(rheader), "tolerance", joinTolerance)
+	return &asofJoinReader{in: in, right: right, rcols: len(rheader), header: rheader, tcol: -1}
+}
+
+
+func (r *asofJoinReader) Read() ([]string, error) {
+	record, err := r.in.Read()
+	if err != nil {
+		return nil, err
+	}
+	if r.tcol < 0 {
+		r.tcol = findColumn(record, timeCol)
+		if r.tcol < 0 {
+			fatal("time column not found in header", "column", timeCol)
+		}
+		r.next = r.right.next(nil)
+		return joinedHeader(record, r.header, r.right.tcol), nil
+	}
+
+	if r.tcol >= len(record) {
+		return nil, fmt.Errorf("missing time column for -join-asof")
+	}
+	t, err := rollingavg.ParseTime(record[r.tcol])
+	if err != nil {
+		return nil, fmt.Errorf("invalid timestamp for -join-asof: %w", err)
+	}
+	if t.Before(r.last) {
+		return nil, fmt.Errorf("input not sorted by time, as -join-asof requires: %s", record[r.tcol])
+	}
+	r.last = t
+	for r.next != nil && !r.next.t.After(t) {
+		r.prev, r.next = r.next, r.right.next(r.next)
+	}
+
+	joined := r.prev
+	if joined != nil && joinTolerance > 0 && t.Sub(joined.t) > joinTolerance {
+		joined = nil
+	}
+	outrec := make([]string, len(record), len(record)+r.rcols)
+	copy(outrec, record)
+	return appendJoined(outrec, joined, r.rcols, r.right.tcol), nil
+}
@@ -0,0 +1,113 @@
+// jsonout.go: write output records as a JSON array of objects
+//
+// the first record written is taken as the header, and each following
+// record is written as an object keyed by the header names, in header
+// order. values that are valid numbers are written as JSON numbers,
+// everything else as strings. e.g.
+//     [
+//     {"X":24,"Y":-129,"Z":-2023,"Time":"2015-11-12 15:44:40.861",...},
+//     ...
+//     ]
+
+
+package main
+
+
+import (
+	"bufio"
+	"encoding/json"
+	"strings"
+)
+
+
+// writes records as a JSON array of header-keyed objects
+type jsonOutput struct {
+	out  *outputFile
+	w    *bufio.Writer
+	keys [][]byte
+	n    int
+	err  error
+}
+
+
+func newJSONOutput(outfilename string, compression string) *jsonOutput {
+	out := createOutput(outfilename, compression)
+	return &jsonOutput{out: out, w: bufio.NewWriter(out)}
+}
+
+
+// encode a value as a JSON number if it is one, otherwise as a string
+func jsonValue(v string) []byte {
+	if v != "" && (v[0] == '-' || (v[0] >= '0' && v[0] <= '9')) && json.Valid([]byte(v)) {
+		return []byte(v)
+	}
+	b, _ := json.Marshal(v)
+	return b
+}
+
+
+func (j *jsonOutput) Write(record []string) error {
+	if j.err != nil {
+		return j.err
+	}
+	j.out.recordWritten()
+	if j.keys == nil {
+		j.keys = make([][]byte, len(record))
+		for i, k := range record {
+			j.keys[i], _ = json.Marshal(strings.TrimSpace(k))
+		}
+		_, j.err = j.w.WriteString("[\n")
+		return j.err
+	}
+
+	if j.n > 0 {
+		j.w.WriteString(",\n")
+	}
+	j.w.WriteByte('{')
+	for i, v := range record {
+		if i > 0 {
+			j.w.WriteByte(',')
+		}
+		if i < len(j.keys) {
+			j.w.Write(j.keys[i])
+		} else {
+			// extra unnamed fields are keyed by their column index
+			k, _ := json.Marshal(i)
+			j.w.WriteString(`"` + string(k) + `"`)
+		}
+		j.w.WriteByte(':')
+		j.w.Write(jsonValue(v))
+	}
+	_, j.err = j.w.WriteString("}")
+	j.n++
+	return j.err
+}
+
+
+func (j *jsonOutput) Flush() {
+	if err := j.w.Flush(); err != nil && j.err == nil {
+		j.err = err
+	}
+}
+
+
+func (j *jsonOutput) Error() error {
+	return j.err
+}
+
+
+// end the array, and close the output file
+func (j *jsonOutput) Close() error {
+	if j.keys == nil {
+		j.w.WriteString("[")
+	}
+	if j.n > 0 {
+		j.w.WriteString("\n")
+	}
+	j.w.WriteString("]\n")
+	j.Flush()
+	if j.err != nil {
+		return j.err
+	}
+	return j.out.Close()
+}
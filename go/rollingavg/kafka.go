@@ -0,0 +1,257 @@
+// kafka.go: read input rows from, and write output rows to, Kafka topics
+//
+// with -kafka-topic, rows are consumed from a Kafka topic rather than read
+// from input files, and processed continuously as they arrive, each output
+// row being flushed as soon as it is computed.
+// messages are CSV or JSON, as given by -message-format, see messages.go.
+// CSV messages have no header, so the column names are given by
+// -message-header, for JSON they default to the keys of the first message.
+// the consumer joins the consumer group -kafka-group, which keeps the
+// committed offsets, so a restarted consumer continues where it left off.
+// a new group starts from the -kafka-start (earliest or latest) offset.
+// a message is committed once all its rows have been read, i.e. when the
+// next message is fetched, rows still buffered in the windows at shutdown
+// are not output and are not read again.
+// reading ends when the context is cancelled.
+// with -retries, fetching and committing messages are retried, the reader
+// continuing from its offset, see retry.go
+// with -broker-tls, brokers are connected to over TLS, and with
+// -broker-user, authenticated by SASL PLAIN, see security.go
+//
+// with -kafka-out-topic, output rows are instead produced, one per message,
+// to a Kafka topic, in the -message-format format. for CSV no header is sent.
+// messages are keyed by the -group-by column value, if any, so that each
+// series stays in order within a partition. they're sent in batches of
+// -kafka-batch messages, or fewer when flushed, e.g. for each row when
+// streaming, at no more than -sink-rate rows a second, see sinkrate.go,
+// and with -retries, a failed batch is sent again, see retry.go
+
+
+package main
+
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+)
+
+
+// reads records from the messages of a Kafka topic
+type kafkaReader struct {
+	*messageReader
+	ctx     context.Context
+	kr      *kafka.Reader
+	last    kafka.Message
+	fetched bool
+}
+
+
+// join the consumer group on the topic, start is earliest or latest
+func newKafkaReader(ctx context.Context, brokers, topic, group, start, format, header string) (*kafkaReader, error) {
+	dec, err := newMessageDecoder(format, header)
+	if err != nil {
+		return nil, err
+	}
+	if brokers == "" {
+		return nil, fmt.Errorf("no Kafka brokers given")
+	}
+	if group == "" {
+		return nil, fmt.Errorf("no Kafka consumer group given")
+	}
+	var offset int64
+	switch start {
+	case "earliest":
+		offset = kafka.FirstOffset
+	case "latest":
+		offset = kafka.LastOffset
+	default:
+		return nil, fmt.Errorf("invalid Kafka start offset: %s", start)
+	}
+
+	k := &kafkaReader{ctx: ctx}
+	k.kr = kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     strings.Split(brokers, ","),
+		GroupID:     group,
+		Topic:       topic,
+		StartOffset: offset,
+		Dialer:      kafkaDialer(),
+	})
+	k.messageReader = &messageReader{dec: dec, next: k.nextMessage}
+	return k, nil
+}
+
+
+// commit the previous message, all of whose rows have been read,
+// then wait for the next
+func (k *kafkaReader) nextMessage() ([]byte, error) {
+	if k.fetched {
+		err := withRetries(k.ctx, "committing Kafka offset", func() error {
+			return k.kr.CommitMessages(context.Background(), k.last)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("committing Kafka offset: %w", err)
+		}
+		k.fetched = false
+	}
+	var m kafka.Message
+	err := withRetries(k.ctx, "fetching Kafka message", func() (err error) {
+		m, err = k.kr.FetchMessage(k.ctx)
+		return err
+	})
+	if k.ctx.Err() != nil {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	if verboseFlag {
+		slog.Debug("kafka message", "partition", m.Partition, "offset", m.Offset)
+	}
+	k.last = m
+	k.fetched = true
+	return m.Value, nil
+}
+
+
+func (k *kafkaReader) Close() {
+	k.kr.Close()
+}
+
+
+// the SASL PLAIN mechanism of -broker-user, or nil without it
+func kafkaSASL() sasl.Mechanism {
+	if brokerUser == "" {
+		return nil
+	}
+	return plain.Mechanism{Username: brokerUser, Password: brokerPassword}
+}
+
+
+// the dialer of the Kafka reader, nil for the default without TLS or SASL
+func kafkaDialer() *kafka.Dialer {
+	tlscfg, mechanism := brokerTLSConfig(), kafkaSASL()
+	if tlscfg == nil && mechanism == nil {
+		return nil
+	}
+	return &kafka.Dialer{Timeout: 10 * time.Second, DualStack: true, TLS: tlscfg, SASLMechanism: mechanism}
+}
+
+
+// the transport of the Kafka writer, nil for the default without TLS or SASL
+func kafkaTransport() kafka.RoundTripper {
+	tlscfg, mechanism := brokerTLSConfig(), kafkaSASL()
+	if tlscfg == nil && mechanism == nil {
+		return nil
+	}
+	return &kafka.Transport{TLS: tlscfg, SASL: mechanism}
+}
+
+
+// writes records as messages to a Kafka topic
+type kafkaOutput struct {
+	kw       *kafka.Writer
+	format   string
+	keycol   string
+	key      int
+	header   []string
+	batch    int
+	pacer    *sinkPacer
+	messages []kafka.Message
+	err      error
+}
+
+
+// keycol, if given, is the column whose value keys each message, and
+// batch the number of messages sent at once
+func newKafkaOutput(brokers, topic, format, keycol string, batch int) (*kafkaOutput, error) {
+	if brokers == "" {
+		return nil, fmt.Errorf("no Kafka brokers given")
+	}
+	if batch <= 0 {
+		return nil, fmt.Errorf("invalid Kafka batch size: %d", batch)
+	}
+	if format != "csv" && format != "json" {
+		return nil, fmt.Errorf("invalid message format: %s", format)
+	}
+	kw := &kafka.Writer{
+		Addr:         kafka.TCP(strings.Split(brokers, ",")...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		BatchSize:    batch,
+		BatchTimeout: 10 * time.Millisecond,
+		Transport:    kafkaTransport(),
+	}
+	return &kafkaOutput{kw: kw, format: format, keycol: keycol, key: -1, batch: batch, pacer: newSinkPacer()}, nil
+}
+
+
+// the first record is the header, which is kept for the message keys
+// and JSON names, the rest are buffered until the next Flush, or a batch
+// of them is
+func (o *kafkaOutput) Write(record []string) error {
+	if o.err != nil {
+		return o.err
+	}
+	if o.header == nil {
+		o.header = append([]string{}, record...)
+		if o.keycol != "" {
+			o.key = findColumn(o.header, o.keycol)
+		}
+		return nil
+	}
+
+	value, err := encodeMessage(o.format, o.header, record)
+	if err != nil {
+		o.err = err
+		return err
+	}
+	m := kafka.Message{Value: value}
+	if o.key >= 0 && o.key < len(record) {
+		m.Key = []byte(record[o.key])
+	}
+	o.messages = append(o.messages, m)
+	if len(o.messages) >= o.batch {
+		o.Flush()
+	}
+	return o.err
+}
+
+
+// send the buffered messages, a batch at a time
+func (o *kafkaOutput) Flush() {
+	for o.err == nil && len(o.messages) > 0 {
+		n := min(len(o.messages), o.batch)
+		o.pacer.wait(n)
+		err := withRetries(context.Background(), "writing to Kafka", func() error {
+			return o.kw.WriteMessages(context.Background(), o.messages[:n]...)
+		})
+		if err != nil {
+			o.err = fmt.Errorf("writing to Kafka: %w", err)
+			return
+		}
+		slog.Debug("sent messages", "messages", n, "topic", o.kw.Topic)
+		o.messages = o.messages[:copy(o.messages, o.messages[n:])]
+	}
+}
+
+
+func (o *kafkaOutput) Error() error {
+	return o.err
+}
+
+
+func (o *kafkaOutput) Close() error {
+	o.Flush()
+	if err := o.kw.Close(); err != nil && o.err == nil {
+		o.err = err
+	}
+	return o.err
+}
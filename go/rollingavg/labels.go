@@ -0,0 +1,88 @@
+// labels.go: multi-class Result labels
+//
+// the threshold rule's Result is "1" or "0". -rule labels instead gives the
+// label of the first of an ordered list of levels whose condition the
+// window statistics meet, as label:condition;..., the last label, without
+// a condition, being that of rows meeting none, e.g.
+//     -rule labels -labels "critical:A < -5 && B < -2000;warning:A < -1 || B < -1500;normal"
+// conditions are csvfilter expressions, see csvfilter.go, of A and B, the
+// statistics of the first two columns. the last label is the quiet one,
+// as "0" is for other rules: rows with other labels are triggers, for the
+// exit status, alerts and notifications, see exitcode.go, webhook.go and
+// notify.go. -labels is reloaded on SIGHUP, as -rule is, see reload.go
+
+
+package main
+
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+var labelSpec string
+
+
+// the rule of the -labels levels
+func labelRule() (rollingavg.Rule, error) {
+	if labelSpec == "" {
+		return nil, fmt.Errorf("-rule labels needs -labels")
+	}
+	specs := strings.Split(labelSpec, ";")
+	otherwise := strings.TrimSpace(specs[len(specs)-1])
+	if otherwise == "" || strings.Contains(otherwise, ":") {
+		return nil, fmt.Errorf("the last of -labels must be a label without a condition: %s", otherwise)
+	}
+	levels := make([]rollingavg.Level, 0, len(specs)-1)
+	for _, spec := range specs[:len(specs)-1] {
+		label, cond, ok := strings.Cut(spec, ":")
+		label = strings.TrimSpace(label)
+		if !ok || label == "" {
+			return nil, fmt.Errorf("invalid label level, expected label:condition: %s", spec)
+		}
+		match, err := compileFilter(cond, []string{"A", "B"}, -1)
+		if err != nil {
+			return nil, fmt.Errorf("invalid condition of label %s: %w", label, err)
+		}
+		levels = append(levels, rollingavg.Level{Label: label, Match: func(avga, avgb float64) bool {
+			return match([]string{strconv.FormatFloat(avga, 'f', -1, 64), strconv.FormatFloat(avgb, 'f', -1, 64)})
+		}})
+	}
+	return rollingavg.LabelRule(levels, otherwise), nil
+}
+
+
+// make -rule labels available to the rule lookups of the processors
+func registerLabelRule() {
+	if ruleName != "labels" {
+		if labelSpec != "" {
+			fatal("-labels requires -rule labels", "rule", ruleName)
+		}
+		return
+	}
+	rule, err := labelRule()
+	if err != nil {
+		fatal("invalid rule", "err", err)
+	}
+	rollingavg.RegisterRule("labels", rule)
+}
+
+
+// the Result of rows that aren't triggers: the last of -labels, or "0"
+func quietResult() string {
+	if ruleName == "labels" && labelSpec != "" {
+		specs := strings.Split(labelSpec, ";")
+		return strings.TrimSpace(specs[len(specs)-1])
+	}
+	return "0"
+}
+
+
+// whether an output row's Result is a trigger
+func triggered(result string) bool {
+	return result != "" && result != quietResult()
+}
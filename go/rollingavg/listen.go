@@ -0,0 +1,146 @@
+// listen.go: receive input rows over a TCP or UDP socket
+//
+// with -listen, rows are received on a socket rather than read from input
+// files, and processed continuously as they arrive, so data loggers can
+// push their readings directly. the address is tcp://host:port or
+// udp://host:port, the host may be omitted to listen on all interfaces.
+// over TCP, any number of clients may connect, each sending rows one
+// per line. over UDP, each datagram carries one or more lines.
+// rows are CSV (without a header) or JSON objects, see messages.go,
+// so the column names are given by -message-header.
+// rows from different clients are interleaved in the order received.
+// reading ends when the context is cancelled
+
+
+package main
+
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+)
+
+
+// largest UDP datagram accepted
+const maxDatagram = 65535
+
+
+// reads records from lines received on a socket
+type socketReader struct {
+	*messageReader
+	ctx      context.Context
+	ln       net.Listener
+	pc       net.PacketConn
+	received chan []byte
+}
+
+
+// listen on a tcp:// or udp:// address
+func newSocketReader(ctx context.Context, addr string, format, header string) (*socketReader, error) {
+	dec, err := newMessageDecoder(format, header)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &socketReader{ctx: ctx, received: make(chan []byte, messageBuffer)}
+	s.messageReader = &messageReader{dec: dec, next: s.nextMessage}
+
+	switch {
+	case strings.HasPrefix(addr, "tcp://"):
+		s.ln, err = net.Listen("tcp", strings.TrimPrefix(addr, "tcp://"))
+		if err != nil {
+			return nil, err
+		}
+		go s.accept()
+	case strings.HasPrefix(addr, "udp://"):
+		s.pc, err = net.ListenPacket("udp", strings.TrimPrefix(addr, "udp://"))
+		if err != nil {
+			return nil, err
+		}
+		go s.receiveDatagrams()
+	default:
+		return nil, fmt.Errorf("listen address must be tcp:// or udp://: %s", addr)
+	}
+	slog.Debug("listening for rows", "addr", addr)
+	return s, nil
+}
+
+
+// accept TCP clients, reading each in its own goroutine
+func (s *socketReader) accept() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				fatal("error accepting connection", "err", err)
+			}
+			return
+		}
+		go s.receiveLines(conn)
+	}
+}
+
+
+// queue each line received from a TCP client
+func (s *socketReader) receiveLines(conn net.Conn) {
+	defer conn.Close()
+	slog.Debug("client connected", "client", conn.RemoteAddr().String())
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		s.received <- []byte(line)
+	}
+	if err := scanner.Err(); err != nil {
+		slog.Warn("client read error", "client", conn.RemoteAddr().String(), "err", err)
+	}
+	slog.Debug("client disconnected", "client", conn.RemoteAddr().String())
+}
+
+
+// queue each UDP datagram received
+func (s *socketReader) receiveDatagrams() {
+	buf := make([]byte, maxDatagram)
+	for {
+		n, from, err := s.pc.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				fatal("error receiving datagram", "err", err)
+			}
+			return
+		}
+		if verboseFlag {
+			slog.Debug("datagram received", "from", from.String(), "bytes", n)
+		}
+		s.received <- append([]byte{}, buf[:n]...)
+	}
+}
+
+
+// wait for the next line or datagram
+func (s *socketReader) nextMessage() ([]byte, error) {
+	select {
+	case payload := <-s.received:
+		return payload, nil
+	case <-s.ctx.Done():
+		return nil, io.EOF
+	}
+}
+
+
+func (s *socketReader) Close() {
+	if s.ln != nil {
+		s.ln.Close()
+	}
+	if s.pc != nil {
+		s.pc.Close()
+	}
+}
@@ -0,0 +1,102 @@
+// logging.go: structured, leveled logging
+//
+// logs are written to stderr with log/slog, so they don't interleave with
+// output CSV written to stdout. -log-level sets the least severe level
+// that is logged:
+//     debug  progress of each file, connection and record, as -v did
+//     info   run summaries and signals (default)
+//     warn   problems that processing continues after, e.g. a bad reload
+//     error  errors, including those that stop the run
+// -log-format json logs each record as a JSON object, for log collectors,
+// rather than as a line of key=value text.
+// -v is the same as -log-level debug
+
+
+package main
+
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime"
+	"time"
+)
+
+var logLevel string
+var logFormat string
+
+
+// register the logging flags
+func logFlags(fs *flag.FlagSet) {
+	fs.StringVar(&logLevel, "log-level", "info", "least severe level logged: debug, info, warn or error")
+	fs.StringVar(&logFormat, "log-format", "text", "log format: text or json")
+}
+
+
+// set up the default logger, from -log-level, -log-format and -v.
+// verboseFlag is set when debug records are logged, to skip building
+// them otherwise
+func setupLogging() {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(logLevel)); err != nil {
+		fmt.Fprintln(os.Stderr, "invalid log level:", logLevel)
+		os.Exit(2)
+	}
+	if verboseFlag {
+		level = slog.LevelDebug
+	}
+	verboseFlag = level <= slog.LevelDebug
+
+	opts := &slog.HandlerOptions{Level: level, AddSource: true}
+	var handler slog.Handler
+	switch logFormat {
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		fmt.Fprintln(os.Stderr, "invalid log format:", logFormat)
+		os.Exit(2)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+
+// when set, fatal panics with a fatalError rather than exiting, for the
+// error to be recovered, e.g. by a batch job
+var fatalPanics bool
+
+
+// an error that would otherwise have been fatal
+type fatalError struct {
+	msg  string
+	args []any
+}
+
+func (e fatalError) Error() string {
+	s := e.msg
+	for i := 0; i+1 < len(e.args); i += 2 {
+		s += fmt.Sprintf(" %v=%v", e.args[i], e.args[i+1])
+	}
+	return s
+}
+
+
+// log msg, with its attributes as key value pairs, as an error, and exit,
+// after exporting any telemetry
+func fatal(msg string, args ...any) {
+	// the source of the record is fatal's caller
+	var pcs [1]uintptr
+	runtime.Callers(2, pcs[:])
+	r := slog.NewRecord(time.Now(), slog.LevelError, msg, pcs[0])
+	r.Add(args...)
+	slog.Default().Handler().Handle(context.Background(), r)
+	if fatalPanics {
+		panic(fatalError{msg, args})
+	}
+	shutdownOtel()
+	os.Exit(1)
+}
@@ -0,0 +1,159 @@
+// manifest.go: a manifest of the output files, with their checksums
+//
+// with -manifest file, a JSON manifest of the files written is written at
+// the end of the run, or with -manifest -, to stderr, so that the consumers
+// of delivered files can check they're complete and unmodified, and how
+// they were made, e.g.
+//     {"version":"1.2.0","created":"2026-01-02T03:04:05Z","inputs":["in.csv"],
+//      "parameters":{"n":"50","o":"out.csv.gz","stat":"median"},
+//      "outputs":[{"file":"out.csv.gz","bytes":20480,"rows":978,
+//      "sha256":"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"}]}
+// where parameters are the flags not at their defaults, passwords and
+// tokens hidden, and outputs every file written, including those of
+// rotation or splitting, -batch and -watch, and sidecar files such as
+// -audit's, but not stdout. bytes and sha256 are of the file as written,
+// after any compression or encryption, so sha256sum of it gives the same
+// checksum, and rows the number of rows after the header, of the files of
+// output rows. appended files are only partly written by the run, so
+// -append and -checkpoint can't be used
+
+
+package main
+
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"hash"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+
+var manifestfile string
+
+// the manifest of the run, nil without -manifest
+var manifest *outputManifest
+
+
+// a file written, in the manifest
+type manifestOutput struct {
+	File   string `json:"file"`
+	Bytes  int64  `json:"bytes"`
+	Rows   *int   `json:"rows,omitempty"`
+	SHA256 string `json:"sha256"`
+
+	hash    hash.Hash
+	records int // records written, including the header
+}
+
+
+type outputManifest struct {
+	Version    string            `json:"version"`
+	Created    time.Time         `json:"created"`
+	Inputs     []string          `json:"inputs"`
+	Parameters map[string]string `json:"parameters"`
+	Outputs    []*manifestOutput `json:"outputs"`
+
+	mu sync.Mutex
+}
+
+
+// start the manifest of the run
+func startManifest(infilenames []string) {
+	manifest = &outputManifest{Version: APP_VERSION, Created: time.Now(), Inputs: infilenames}
+}
+
+
+// check that the manifest can be used with the current options
+func checkManifest() {
+	if manifest != nil && (appendFlag || checkpointfile != "") {
+		fatal("-manifest can't be used with -append or -checkpoint, which append to the output")
+	}
+}
+
+
+// checksums the bytes written to a file
+type hashingWriter struct {
+	io.WriteCloser
+	entry *manifestOutput
+}
+
+func (h *hashingWriter) Write(p []byte) (int, error) {
+	n, err := h.WriteCloser.Write(p)
+	h.entry.hash.Write(p[:n])
+	h.entry.Bytes += int64(n)
+	return n, err
+}
+
+
+// add a file to the manifest, returning it wrapped to checksum what's
+// written to it, and its entry, or as is, and nil, without -manifest
+func (m *outputManifest) track(filename string, fl io.WriteCloser) (io.WriteCloser, *manifestOutput) {
+	if m == nil {
+		return fl, nil
+	}
+	entry := &manifestOutput{File: filename, hash: sha256.New()}
+	m.mu.Lock()
+	m.Outputs = append(m.Outputs, entry)
+	m.mu.Unlock()
+	return &hashingWriter{fl, entry}, entry
+}
+
+
+// count a record written to the file
+func (e *manifestOutput) recordWritten() {
+	if e != nil {
+		e.records++
+	}
+}
+
+
+// the flags not at their defaults, hiding secrets
+func runParameters() map[string]string {
+	params := make(map[string]string)
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		v := f.Value.String()
+		if f.Name == "f" || v == f.DefValue {
+			return
+		}
+		if secretFlags[f.Name] {
+			v = strings.Repeat("*", 8)
+		}
+		params[f.Name] = v
+	})
+	return params
+}
+
+
+// write the manifest, once the outputs are closed
+func (m *outputManifest) write() {
+	if m == nil {
+		return
+	}
+	m.Parameters = runParameters()
+	for _, e := range m.Outputs {
+		e.SHA256 = hex.EncodeToString(e.hash.Sum(nil))
+		if e.records > 0 {
+			rows := e.records - 1
+			e.Rows = &rows
+		}
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		fatal("error encoding manifest", "err", err)
+	}
+	data = append(data, '\n')
+	if manifestfile == "-" {
+		os.Stderr.Write(data)
+		return
+	}
+	if err := os.WriteFile(manifestfile, data, 0644); err != nil {
+		fatal("error writing manifest", "err", err)
+	}
+}
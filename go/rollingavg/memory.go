@@ -0,0 +1,54 @@
+// memory.go: bound the memory held by windows
+//
+// with -max-mem, when the windows of all series hold more than about that
+// many bytes, the windows of the least recently used series are spilled to
+// a temporary file in -spill-dir (default the system temporary directory),
+// and read back when their series has another record, see
+// rollingavg/spill.go. this keeps runs over huge numbers of groups, or
+// huge calendar windows, within memory, at the cost of disk I/O for series
+// that recur after long gaps. the output is the same as without it.
+// the spill file is removed at the end of the run.
+// a single window larger than -max-mem can't be spilled, so is kept in
+// memory regardless, and when the windows of the series being read
+// together are larger than -max-mem, they are spilled and read back over
+// and over, which is very slow.
+// -max-mem can't be used with -parallel, -checkpoint or -append, which
+// need all the windows in memory
+
+
+package main
+
+
+import (
+	"log/slog"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+var maxMem sizeFlag
+var spillDir string
+
+
+// check memory can be bounded, and bound p's windows to -max-mem
+func boundMemory(p *rollingavg.Processor) {
+	if maxMem == 0 {
+		return
+	}
+	switch {
+	case maxMem < 0:
+		fatal("invalid maximum memory", "size", int64(maxMem))
+	case parallelWorkers > 1 || checkpointfile != "" || appendFlag:
+		fatal("-max-mem can't be used with -parallel, -checkpoint or -append")
+	}
+	p.MaxMemory = int64(maxMem)
+	p.SpillDir = spillDir
+}
+
+
+// log the spilling of windows to disk during a run, if any
+func logSpills(p *rollingavg.Processor) {
+	if n := p.Spills(); n > 0 {
+		slog.Debug("spilled windows to disk", "spills", n, "max_mem", formatBytes(int64(maxMem)))
+	}
+}
@@ -0,0 +1,140 @@
+// merge.go: merge several time-sorted CSVs into one chronological stream
+//
+// each input must already be sorted by its timestamp column. a k-way merge
+// using a heap holding the next record from each input produces the
+// records of all inputs in timestamp order. as with multiCSVReader, the
+// first Read returns the header row, which all inputs must share.
+// records with equal timestamps are returned in input file order
+
+
+package main
+
+
+import (
+	"container/heap"
+	"encoding/csv"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+// the next record from one of the merged inputs
+type mergeItem struct {
+	record []string
+	t      time.Time
+	src    int
+}
+
+
+// min-heap of the next record of each input, ordered by timestamp
+type mergeHeap []mergeItem
+
+func (h mergeHeap) Len() int { return len(h) }
+func (h mergeHeap) Less(i, j int) bool {
+	if h[i].t.Equal(h[j].t) {
+		return h[i].src < h[j].src
+	}
+	return h[i].t.Before(h[j].t)
+}
+func (h mergeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x interface{}) { *h = append(*h, x.(mergeItem)) }
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+
+// reads the records of several time-sorted CSV files in timestamp order
+type mergeCSVReader struct {
+	fls     []io.ReadCloser
+	readers []*csv.Reader
+	header  []string
+	tcol    int
+	h       mergeHeap
+	started bool
+}
+
+
+// open all the files, check their headers match, and prime the merge
+// with the first record of each. timecol names the timestamp column
+func openMergedInputs(filenames []string, timecol string) *mergeCSVReader {
+	r := &mergeCSVReader{}
+	for i, filename := range filenames {
+		fl, err := openSource(filename)
+		if err != nil {
+			fatal("error opening source csv", "err", err)
+		}
+		rd := newCSVReader(decodeInput(decompress(countBytes(fl))))
+		r.fls = append(r.fls, fl)
+		r.readers = append(r.readers, rd)
+
+		header, err := rd.Read()
+		if err != nil {
+			fatal("error reading header from csv", "file", filename, "err", err)
+		}
+		if i == 0 {
+			r.header = header
+			r.tcol = findColumn(header, timecol)
+			if r.tcol < 0 {
+				fatal("time column not found in header", "column", timecol)
+			}
+		} else if !slices.Equal(header, r.header) {
+			fatal("header does not match first input", "file", filename, "header", header)
+		}
+	}
+
+	for i := range r.readers {
+		r.pushNext(i)
+	}
+	heap.Init(&r.h)
+	return r
+}
+
+
+// read the next record from input src onto the heap, if there is one
+func (r *mergeCSVReader) pushNext(src int) {
+	record, err := r.readers[src].Read()
+	if err == io.EOF {
+		return
+	}
+	if err != nil {
+		fatal("error reading record from csv", "err", err)
+	}
+	record = fitRecord(record, len(r.header))
+	if r.tcol >= len(record) {
+		fatal("record missing time column", "record", record)
+	}
+	t, err := rollingavg.ParseTime(record[r.tcol])
+	if err != nil {
+		fatal("invalid timestamp in csv", "err", err)
+	}
+	heap.Push(&r.h, mergeItem{record, t, src})
+}
+
+
+// return the header, then the records of all inputs in timestamp order
+func (r *mergeCSVReader) Read() ([]string, error) {
+	if !r.started {
+		r.started = true
+		return r.header, nil
+	}
+	if r.h.Len() == 0 {
+		return nil, io.EOF
+	}
+	item := heap.Pop(&r.h).(mergeItem)
+	r.pushNext(item.src)
+	return item.record, nil
+}
+
+
+func (r *mergeCSVReader) Close() {
+	for _, fl := range r.fls {
+		fl.Close()
+	}
+	r.fls = nil
+}
@@ -0,0 +1,198 @@
+// messages.go: decode and encode records carried in messages
+//
+// used by the message based sources and sinks (Kafka, MQTT, sockets). a message
+// payload is either CSV, one or more lines without a header, or JSON,
+// a single object or an array of objects keyed by column name.
+// CSV messages have no header, so the column names must be given.
+// for JSON messages, if no column names are given, they are taken from
+// the keys of the first object, in order. numbers are kept as written
+
+
+package main
+
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+
+// number of received messages buffered awaiting processing
+const messageBuffer = 1000
+
+
+// decodes message payloads into records with a fixed set of columns
+type messageDecoder struct {
+	format string
+	header []string
+}
+
+
+// format is "csv" or "json", header the comma separated column names
+func newMessageDecoder(format string, header string) (*messageDecoder, error) {
+	d := &messageDecoder{format: format}
+	if header != "" {
+		d.header = strings.Split(header, ",")
+	}
+	switch format {
+	case "csv":
+		if d.header == nil {
+			return nil, fmt.Errorf("CSV messages have no header, so column names must be given")
+		}
+	case "json":
+	default:
+		return nil, fmt.Errorf("invalid message format: %s", format)
+	}
+	return d, nil
+}
+
+
+// return the keys of a JSON object in the order they appear
+func jsonObjectKeys(data []byte) ([]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil, fmt.Errorf("JSON message is not an object")
+	}
+	var keys []string
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, t.(string))
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+
+// decode a payload into records
+func (d *messageDecoder) decode(payload []byte) ([][]string, error) {
+	if d.format == "csv" {
+		r := csv.NewReader(bytes.NewReader(payload))
+		r.FieldsPerRecord = len(d.header)
+		return r.ReadAll()
+	}
+
+	// a JSON object, or array of objects
+	payload = bytes.TrimSpace(payload)
+	var objs []json.RawMessage
+	if len(payload) > 0 && payload[0] == '[' {
+		if err := json.Unmarshal(payload, &objs); err != nil {
+			return nil, err
+		}
+	} else {
+		objs = []json.RawMessage{payload}
+	}
+
+	var records [][]string
+	for _, raw := range objs {
+		if d.header == nil {
+			keys, err := jsonObjectKeys(raw)
+			if err != nil {
+				return nil, err
+			}
+			d.header = keys
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		obj := make(map[string]interface{})
+		if err := dec.Decode(&obj); err != nil {
+			return nil, err
+		}
+		record := make([]string, len(d.header))
+		for i, name := range d.header {
+			switch v := obj[name].(type) {
+			case nil:
+			case string:
+				record[i] = v
+			case json.Number:
+				record[i] = v.String()
+			default:
+				record[i] = fmt.Sprint(v)
+			}
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+
+// encode a record as a message payload, in the decoder's format
+func encodeMessage(format string, header []string, record []string) ([]byte, error) {
+	var buf bytes.Buffer
+	if format == "json" {
+		buf.WriteByte('{')
+		for i, v := range record {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			k, _ := json.Marshal(header[i])
+			buf.Write(k)
+			buf.WriteByte(':')
+			buf.Write(jsonValue(v))
+		}
+		buf.WriteByte('}')
+		return buf.Bytes(), nil
+	}
+
+	w := csv.NewWriter(&buf)
+	w.Write(record)
+	w.Flush()
+	return bytes.TrimRight(buf.Bytes(), "\n"), w.Error()
+}
+
+
+// a record source that returns the header, then the decoded records
+// of each message. next returns the next message payload, or an error
+// (e.g. io.EOF) when there are no more
+type messageReader struct {
+	dec     *messageDecoder
+	next    func() ([]byte, error)
+	records [][]string
+	started bool
+}
+
+
+// fetch and decode the next message, appending its records
+func (m *messageReader) fetch() error {
+	payload, err := m.next()
+	if err != nil {
+		return err
+	}
+	records, err := m.dec.decode(payload)
+	if err != nil {
+		return fmt.Errorf("invalid message: %w", err)
+	}
+	m.records = append(m.records, records...)
+	return nil
+}
+
+
+func (m *messageReader) Read() ([]string, error) {
+	if !m.started {
+		// JSON column names may only be known from the first message
+		for m.dec.header == nil {
+			if err := m.fetch(); err != nil {
+				return nil, err
+			}
+		}
+		m.started = true
+		return m.dec.header, nil
+	}
+	for len(m.records) == 0 {
+		if err := m.fetch(); err != nil {
+			return nil, err
+		}
+	}
+	record := m.records[0]
+	m.records = m.records[1:]
+	return record, nil
+}
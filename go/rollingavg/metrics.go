@@ -0,0 +1,83 @@
+// metrics.go: Prometheus metrics for streaming runs
+//
+// with -metrics-addr, an HTTP listener serves Prometheus metrics on
+// /metrics, so a long running follow mode run can be monitored:
+//     rollingavg_rows_read_total            records read
+//     rollingavg_rows_written_total         records output
+//     rollingavg_results_total{result}      output records by Result value
+//     rollingavg_average{group,column}      latest rolling average of A and B
+// the group label is the -group-by value, or "" without grouping
+
+
+package main
+
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+
+// the metrics updated while processing, nil when metrics are disabled
+var metrics *rollingMetrics
+
+
+type rollingMetrics struct {
+	rowsRead    prometheus.Counter
+	rowsWritten prometheus.Counter
+	results     *prometheus.CounterVec
+	average     *prometheus.GaugeVec
+}
+
+
+// register the metrics and start serving them on addr
+func startMetrics(addr string) {
+	metrics = &rollingMetrics{
+		rowsRead: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "rollingavg_rows_read_total",
+			Help: "Number of input records read.",
+		}),
+		rowsWritten: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "rollingavg_rows_written_total",
+			Help: "Number of output records written.",
+		}),
+		results: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rollingavg_results_total",
+			Help: "Number of output records by Result value.",
+		}, []string{"result"}),
+		average: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "rollingavg_average",
+			Help: "Latest rolling average of each averaged column.",
+		}, []string{"group", "column"}),
+	}
+	prometheus.MustRegister(metrics.rowsRead, metrics.rowsWritten, metrics.results, metrics.average)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		fatal("metrics listener failed", "err", http.ListenAndServe(addr, mux))
+	}()
+}
+
+
+// count a record read
+func (m *rollingMetrics) rowRead() {
+	if m == nil {
+		return
+	}
+	m.rowsRead.Inc()
+}
+
+
+// record an output row's averages and result
+func (m *rollingMetrics) rowWritten(group string, avga, avgb float64, res string) {
+	if m == nil {
+		return
+	}
+	m.rowsWritten.Inc()
+	m.results.WithLabelValues(res).Inc()
+	m.average.WithLabelValues(group, "A").Set(avga)
+	m.average.WithLabelValues(group, "B").Set(avgb)
+}
@@ -0,0 +1,210 @@
+// mmap.go: memory-mapped reading of local CSV inputs
+//
+// with -mmap, local input files are mapped into memory, rather than read
+// through buffers, and their rows scanned directly from the mapping, which
+// saves the syscalls and copying of reading large files, e.g.
+//     rollingavg -mmap -n 50 -o out.csv big.csv
+// each row is copied once, as a string its fields share. rows with quotes
+// are parsed as encoding/csv does, with -lazy-quotes and -ragged, see
+// ragged.go, so the rows read are the same as without -mmap. inputs that
+// can't be mapped are read as usual: stdin, remote, compressed, followed,
+// parquet and xlsx inputs, those of another -encoding than utf-8 or a
+// UTF-16 byte order mark, and on platforms without mmap. a mapped file
+// mustn't be truncated while it's read, as reading past its end faults.
+// record offsets, for -index and -checkpoint, are those of the file, so
+// resuming, or seeking by an index, doesn't read through the rows skipped
+
+
+package main
+
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+
+var mmapFlag bool
+
+
+// a CSV record source with the byte offsets of its records, satisfied by
+// *csv.Reader and *mmapReader
+type offsetReader interface {
+	recordReader
+	InputOffset() int64
+}
+
+
+// reads the records of a memory-mapped CSV file
+type mmapReader struct {
+	data    []byte
+	pos     int  // the offset of the next record
+	line    int  // the line number of the last line read
+	fields  int  // the number of fields of each record, 0 until the first, or -1 if any
+	comment bool // whether lines starting with # are skipped
+	reuse   bool // whether the record returned is reused for the next
+	record  []string
+
+	// reads the records with quotes, from the mapping at pos, through br,
+	// reset for each, counting lines from quotedLine
+	quoted     *csv.Reader
+	src        *bytes.Reader
+	br         *bufio.Reader
+	quotedLine int
+}
+
+
+// a reader of the mapped file, positioned at offset, or nil if it isn't
+// to be mapped, or can't be
+func openMapped(filename string, fl io.ReadCloser, offset int64) *mmapReader {
+	osfl, ok := fl.(*os.File)
+	if !mmapFlag || passthroughFlag || !ok || inputEncoding != "utf-8" {
+		return nil
+	}
+	data, err := mapFile(osfl)
+	if err != nil {
+		slog.Debug("input can't be memory mapped", "file", filename, "err", err)
+		return nil
+	}
+	if bytes.HasPrefix(data, gzipMagic) || bytes.HasPrefix(data, zstdMagic) ||
+		bytes.HasPrefix(data, []byte{0xff, 0xfe}) || bytes.HasPrefix(data, []byte{0xfe, 0xff}) {
+		unmapFile(data)
+		return nil
+	}
+	slog.Debug("memory mapped input file", "file", filename, "size", len(data))
+
+	r := &mmapReader{data: data, pos: int(offset)}
+	if offset == 0 && bytes.HasPrefix(data, utf8BOM) {
+		r.pos = len(utf8BOM)
+	}
+	r.comment = bytes.HasPrefix(data[r.pos:], []byte(commentMark))
+	if raggedRows {
+		r.fields = -1
+	}
+	r.src = bytes.NewReader(nil)
+	r.br = bufio.NewReader(r.src)
+	r.quoted = csv.NewReader(r.br)
+	r.quoted.LazyQuotes = lazyQuotes
+	r.quoted.FieldsPerRecord = -1
+	if r.comment {
+		r.quoted.Comment = '#'
+	}
+	return r
+}
+
+
+func (r *mmapReader) Read() ([]string, error) {
+	for r.pos < len(r.data) {
+		rest := r.data[r.pos:]
+		line, n := rest, len(rest)
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			line, n = rest[:i], i+1
+		}
+		if bytes.IndexByte(line, '"') >= 0 {
+			return r.readQuoted()
+		}
+		r.pos += n
+		r.line++
+		if progress != nil {
+			progress.bytes.Add(int64(n))
+		}
+		// as csv.Reader, \r\n ends a line as \n does, and empty lines are
+		// skipped
+		line = bytes.TrimSuffix(line, []byte{'\r'})
+		if len(line) == 0 || r.comment && line[0] == '#' {
+			continue
+		}
+		return r.checkFields(r.split(line), r.line)
+	}
+	return nil, io.EOF
+}
+
+
+// split an unquoted line into its fields, which share one string
+func (r *mmapReader) split(line []byte) []string {
+	n := bytes.Count(line, []byte{','}) + 1
+	record := r.record[:0]
+	if !r.reuse || cap(record) < n {
+		record = make([]string, 0, n)
+	}
+	s := string(line)
+	for {
+		i := strings.IndexByte(s, ',')
+		if i < 0 {
+			break
+		}
+		record = append(record, s[:i])
+		s = s[i+1:]
+	}
+	record = append(record, s)
+	if r.reuse {
+		r.record = record
+	}
+	return record
+}
+
+
+// read a record with quotes, which may span lines, as encoding/csv does
+func (r *mmapReader) readQuoted() ([]string, error) {
+	r.src.Reset(r.data[r.pos:])
+	r.br.Reset(r.src)
+	r.quoted.ReuseRecord = r.reuse
+	start := r.quoted.InputOffset()
+	record, err := r.quoted.Read()
+	var perr *csv.ParseError
+	if errors.As(err, &perr) {
+		perr.StartLine += r.line - r.quotedLine
+		perr.Line += r.line - r.quotedLine
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	n := int(r.quoted.InputOffset() - start)
+	lines := bytes.Count(r.data[r.pos:r.pos+n], []byte{'\n'})
+	if r.pos+n == len(r.data) && r.data[len(r.data)-1] != '\n' {
+		lines++
+	}
+	startLine := r.line + 1
+	r.quotedLine += lines
+	r.line += lines
+	r.pos += n
+	if progress != nil {
+		progress.bytes.Add(int64(n))
+	}
+	return r.checkFields(record, startLine)
+}
+
+
+// check that a record has as many fields as the first, unless -ragged
+func (r *mmapReader) checkFields(record []string, line int) ([]string, error) {
+	switch {
+	case r.fields == 0:
+		r.fields = len(record)
+	case r.fields > 0 && len(record) != r.fields:
+		return record, &csv.ParseError{StartLine: line, Line: line, Column: 1, Err: csv.ErrFieldCount}
+	}
+	return record, nil
+}
+
+
+// the offset in the file of the next record
+func (r *mmapReader) InputOffset() int64 {
+	return int64(r.pos)
+}
+
+
+func (r *mmapReader) Close() error {
+	if r.data == nil {
+		return nil
+	}
+	err := unmapFile(r.data)
+	r.data = nil
+	return err
+}
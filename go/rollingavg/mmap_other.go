@@ -0,0 +1,22 @@
+//go:build !unix
+
+// mmap_other.go: inputs aren't memory mapped without mmap, see mmap.go
+
+
+package main
+
+
+import (
+	"errors"
+	"os"
+)
+
+
+func mapFile(fl *os.File) ([]byte, error) {
+	return nil, errors.New("memory mapping isn't supported on this platform")
+}
+
+
+func unmapFile(data []byte) error {
+	return nil
+}
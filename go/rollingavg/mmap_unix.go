@@ -0,0 +1,45 @@
+//go:build unix
+
+// mmap_unix.go: memory mapping of input files, see mmap.go
+
+
+package main
+
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+
+// map a regular file's content into memory, read only
+func mapFile(fl *os.File) ([]byte, error) {
+	fi, err := fl.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := fi.Size()
+	switch {
+	case !fi.Mode().IsRegular():
+		return nil, fmt.Errorf("not a regular file")
+	case size == 0:
+		return nil, fmt.Errorf("empty file")
+	case int64(int(size)) != size:
+		return nil, fmt.Errorf("file too large to map")
+	}
+	data, err := unix.Mmap(int(fl.Fd()), 0, int(size), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	// rows are read in order, so the kernel can read ahead, and drop the
+	// pages read
+	unix.Madvise(data, unix.MADV_SEQUENTIAL)
+	return data, nil
+}
+
+
+func unmapFile(data []byte) error {
+	return unix.Munmap(data)
+}
@@ -0,0 +1,60 @@
+// mode.go: rolling mode of categorical columns
+//
+// for columns that can't be averaged, e.g. a status column, -mode lists
+// columns, by name or index, whose most frequent value over each row's
+// window is output, as a column named for it, e.g. "Mode Status",
+// following the input columns, see windowcols.go, e.g.
+//     -mode Status,Z
+// gives
+//     X,Y,Status,Z,Time,Mode Status,Mode Z,Average A,Average B,Result
+// empty values aren't counted, and ties go to the value first in sort
+// order
+
+
+package main
+
+
+var modeCols string
+
+
+// the -mode columns of an input header
+func modeColumns(header []string) []windowColumn {
+	var cols []windowColumn
+	for _, c := range parseColumnList(header, modeCols) {
+		cols = append(cols, windowColumn{c, "Mode " + header[c], func() windowStat { return make(modeCounter) }})
+	}
+	return cols
+}
+
+
+// the counts of the values of a column in a window
+type modeCounter map[string]int
+
+
+// the most frequent value, the first in sort order of any ties
+func (m modeCounter) value() string {
+	mode, most := "", 0
+	for v, n := range m {
+		if n > most || n == most && v < mode {
+			mode, most = v, n
+		}
+	}
+	return mode
+}
+
+
+func (m modeCounter) add(v string) {
+	if v != "" {
+		m[v]++
+	}
+}
+
+
+func (m modeCounter) remove(v string) {
+	if v == "" {
+		return
+	}
+	if m[v]--; m[v] <= 0 {
+		delete(m, v)
+	}
+}
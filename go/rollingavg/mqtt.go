@@ -0,0 +1,105 @@
+// mqtt.go: read input rows from MQTT topics
+//
+// with -mqtt-topic, rows are received from the MQTT broker -mqtt-broker
+// (e.g. tcp://host:1883 or ssl://host:8883) rather than read from input
+// files, and processed continuously as they arrive, for IoT deployments
+// where sensors publish their readings.
+// topic filters may use the + and # wildcards, and -mqtt-topic may be
+// repeated to subscribe to several filters.
+// each message payload carries one or more readings, as CSV or JSON,
+// see messages.go. for JSON the payload fields are mapped to columns by
+// name, using -message-header, or the fields of the first message.
+// the broker session is kept under -mqtt-client-id, so with QoS 1 or 2,
+// messages published while disconnected are delivered on reconnecting.
+// with ssl:// brokers, -broker-ca and client certificates, and -broker-user
+// and -broker-password, can be given, see security.go.
+// reading ends when the context is cancelled
+
+
+package main
+
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+
+// reads records from the messages received on subscribed MQTT topics
+type mqttReader struct {
+	*messageReader
+	ctx      context.Context
+	client   mqtt.Client
+	received chan []byte
+}
+
+
+// connect to the broker and subscribe to the topic filters
+func newMQTTReader(ctx context.Context, broker, clientid string, topics []string, qos int, format, header string) (*mqttReader, error) {
+	dec, err := newMessageDecoder(format, header)
+	if err != nil {
+		return nil, err
+	}
+	if qos < 0 || qos > 2 {
+		return nil, fmt.Errorf("invalid MQTT QoS: %d", qos)
+	}
+
+	m := &mqttReader{ctx: ctx, received: make(chan []byte, messageBuffer)}
+	m.messageReader = &messageReader{dec: dec, next: m.nextMessage}
+
+	opts := mqtt.NewClientOptions().AddBroker(broker).SetClientID(clientid)
+	opts.SetCleanSession(false)
+	if cfg := brokerTLSConfig(); cfg != nil {
+		opts.SetTLSConfig(cfg)
+	}
+	if brokerUser != "" {
+		opts.SetUsername(brokerUser)
+		opts.SetPassword(brokerPassword)
+	}
+	opts.SetOnConnectHandler(func(c mqtt.Client) {
+		slog.Debug("connected to MQTT broker", "broker", broker)
+	})
+	m.client = mqtt.NewClient(opts)
+	if t := m.client.Connect(); t.Wait() && t.Error() != nil {
+		return nil, fmt.Errorf("connecting to MQTT broker: %w", t.Error())
+	}
+
+	filters := make(map[string]byte)
+	for _, topic := range topics {
+		filters[topic] = byte(qos)
+	}
+	if t := m.client.SubscribeMultiple(filters, m.receive); t.Wait() && t.Error() != nil {
+		m.client.Disconnect(0)
+		return nil, fmt.Errorf("subscribing to MQTT topics: %w", t.Error())
+	}
+	return m, nil
+}
+
+
+// queue a received message's payload for reading
+func (m *mqttReader) receive(c mqtt.Client, msg mqtt.Message) {
+	if verboseFlag {
+		slog.Debug("mqtt message", "topic", msg.Topic(), "id", msg.MessageID())
+	}
+	m.received <- msg.Payload()
+}
+
+
+// wait for the next message
+func (m *mqttReader) nextMessage() ([]byte, error) {
+	select {
+	case payload := <-m.received:
+		return payload, nil
+	case <-m.ctx.Done():
+		return nil, io.EOF
+	}
+}
+
+
+func (m *mqttReader) Close() {
+	m.client.Disconnect(250)
+}
@@ -0,0 +1,218 @@
+// normalize.go: min-max or z-score normalisation of CSV columns
+//
+// for preparing features for ML pipelines, invoked as the normalize
+// subcommand, see commands.go:
+//     mdp normalize [-v] -c col,... [-method minmax|zscore] [-stats file] [-replace]
+//         [-f inputfile]... [-o outputfile] [inputfile...]
+// each of the -c columns (names, indexes or index ranges, as for csvcut) is
+// normalised, by -method minmax (the default) to (v - min) / (max - min),
+// or by zscore to (v - mean) / stddev, output as a column named for it and
+// the method, e.g. "X minmax", following the input columns, or with
+// -replace, in its place.
+// the min, max, mean and stddev are those of a prior csvstats run over the
+// data, see csvstats.go, given by -stats, or by default streaming
+// estimates, from the column's values up to and including each row, so the
+// first rows are normalised by few values.
+// values that aren't numbers, and those of a column without any spread,
+// are output empty
+
+
+package main
+
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"flag"
+	"io"
+	"log/slog"
+	"math"
+	"strconv"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+// the statistics normalising a column, fixed or streaming
+type normalizer struct {
+	streaming bool
+	n         int
+	mean, m2  float64 // Welford's running mean and sum of squared differences
+	min, max  float64
+	stddev    float64
+}
+
+
+// add a value to the streaming statistics
+func (s *normalizer) add(v float64) {
+	if !s.streaming {
+		return
+	}
+	s.n++
+	if s.n == 1 || v < s.min {
+		s.min = v
+	}
+	if s.n == 1 || v > s.max {
+		s.max = v
+	}
+	d := v - s.mean
+	s.mean += d / float64(s.n)
+	s.m2 += d * (v - s.mean)
+	s.stddev = math.Sqrt(s.m2 / float64(s.n))
+}
+
+
+// the normalised value, or false if the column has no spread
+func (s *normalizer) normalize(v float64, method string) (float64, bool) {
+	if method == "zscore" {
+		if s.stddev == 0 || math.IsNaN(s.stddev) {
+			return 0, false
+		}
+		return (v - s.mean) / s.stddev, true
+	}
+	if s.max == s.min {
+		return 0, false
+	}
+	return (v - s.min) / (s.max - s.min), true
+}
+
+
+// read the statistics of each column from csvstats output
+func readColumnStats(filename string) map[string]*normalizer {
+	in := openInputs(context.Background(), []string{filename}, false, nil)
+	defer in.Close()
+	header, err := in.Read()
+	if err != nil {
+		fatal("error reading header from stats", "file", filename, "err", err)
+	}
+	cols := make(map[string]int)
+	for _, name := range []string{"Column", "Mean", "Stddev", "Min", "Max"} {
+		if cols[name] = findColumn(header, name); cols[name] < 0 {
+			fatal("column not found in stats, expected csvstats output", "file", filename, "column", name)
+		}
+	}
+	stats := make(map[string]*normalizer)
+	for {
+		record, err := in.Read()
+		if err == io.EOF {
+			return stats
+		}
+		if err != nil {
+			fatal("error reading record from stats", "file", filename, "err", err)
+		}
+		s := &normalizer{}
+		values := []*float64{&s.mean, &s.stddev, &s.min, &s.max}
+		for i, name := range []string{"Mean", "Stddev", "Min", "Max"} {
+			// non-numeric columns have no statistics
+			if *values[i], err = rollingavg.ParseFloat(field(record, cols[name])); err != nil {
+				s = nil
+				break
+			}
+		}
+		if s != nil {
+			stats[field(record, cols["Column"])] = s
+		}
+	}
+}
+
+
+func runNormalize(args []string) {
+	fs := flag.NewFlagSet("normalize", flag.ExitOnError)
+	columns := fs.String("c", "", "comma separated columns (names, indexes or index ranges) to normalise")
+	method := fs.String("method", "minmax", "normalisation: minmax or zscore")
+	statsfile := fs.String("stats", "", "csvstats output giving each column's statistics (default streaming estimates)")
+	replace := fs.Bool("replace", false, "replace the columns by their normalised values, rather than adding columns")
+	commonFlags(fs, "the rows with normalised columns")
+	fs.Parse(args)
+	infilenames = append(infilenames, fs.Args()...)
+	setupLogging()
+
+	switch {
+	case *columns == "":
+		fatal("-c columns are required")
+	case *method != "minmax" && *method != "zscore":
+		fatal("invalid normalisation method", "method", *method)
+	}
+
+	slog.Debug("normalize CSV columns",
+		"inputs", infilenames, "output", outfilename, "columns", *columns, "method", *method, "stats", *statsfile)
+
+	infile := openInputs(context.Background(), infilenames, false, nil)
+	defer infile.Close()
+
+	oufl := createOutput(outfilename, "")
+	outfile := csv.NewWriter(bufio.NewWriter(oufl))
+
+	header, err := infile.Read()
+	if err != nil {
+		fatal("error reading header from csv", "err", err)
+	}
+	cols := parseColumnList(header, *columns)
+	norms := make([]*normalizer, len(cols))
+	var stats map[string]*normalizer
+	if *statsfile != "" {
+		stats = readColumnStats(*statsfile)
+	}
+	for i, c := range cols {
+		if stats == nil {
+			norms[i] = &normalizer{streaming: true}
+		} else if norms[i] = stats[header[c]]; norms[i] == nil {
+			fatal("column has no numeric statistics", "column", header[c], "stats", *statsfile)
+		}
+	}
+
+	outrec := append([]string(nil), header...)
+	if !*replace {
+		for _, c := range cols {
+			outrec = append(outrec, header[c]+" "+*method)
+		}
+	}
+	if err := outfile.Write(outrec); err != nil {
+		fatal("error writing record to csv", "err", err)
+	}
+
+	n := 0
+	for {
+		record, err := infile.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fatal("error reading record from csv", "err", err)
+		}
+		outrec = append(outrec[:0], record...)
+		for i, c := range cols {
+			value := ""
+			if v, err := rollingavg.ParseFloat(field(record, c)); err == nil {
+				norms[i].add(v)
+				if nv, ok := norms[i].normalize(v, *method); ok {
+					value = strconv.FormatFloat(nv, 'f', -1, 64)
+				}
+			}
+			if *replace {
+				if c < len(outrec) {
+					outrec[c] = value
+				}
+			} else {
+				outrec = append(outrec, value)
+			}
+		}
+		if verboseFlag {
+			slog.Debug("write record", "n", n, "record", outrec)
+		}
+		if err := outfile.Write(outrec); err != nil {
+			fatal("error writing record to csv", "err", err)
+		}
+		n++
+	}
+	slog.Debug("normalized records", "records", n)
+
+	outfile.Flush()
+	if err := outfile.Error(); err != nil {
+		fatal("error writing csv", "err", err)
+	}
+	if err := oufl.Close(); err != nil {
+		fatal("error closing destination csv", "err", err)
+	}
+}
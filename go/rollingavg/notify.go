@@ -0,0 +1,234 @@
+// notify.go: email and Slack notifications of Result triggers
+//
+// for long streaming runs, output rows whose Result is a trigger, see
+// labels.go, can be
+// notified to people, batched so a burst of triggers doesn't flood them.
+// with -notify-slack, notifications are posted to a Slack incoming webhook
+// URL, and with -notify-email, mailed to a comma separated list of
+// addresses, through the SMTP server -smtp-addr (default localhost:25),
+// from -smtp-from, authenticating as -smtp-user with -smtp-password if
+// given, best set by the ROLLAVG_SMTP_PASSWORD environment variable, see
+// env.go.
+// the triggers of each -notify-every (default 1m) are batched into one
+// notification per sink, which lists up to -notify-max (default 20) of
+// them, and counts the rest, so each sink is sent at most one notification
+// per interval. a notification that fails is logged and not retried.
+// at the end of the run, the triggers not yet notified are sent before
+// exiting. for alerting other tooling of each trigger, see webhook.go
+
+
+package main
+
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+
+var notifySlack string
+var notifyEmail string
+var smtpAddr string
+var smtpFrom string
+var smtpUser string
+var smtpPassword string
+var notifyEvery time.Duration
+var notifyMax int
+
+// the notifier of Result triggers, nil when no sink is configured
+var notifier *triggerNotifier
+
+
+// a destination for notifications
+type notifySink interface {
+	name() string
+	send(subject, body string) error
+}
+
+
+// batches triggers, notifying them to the sinks periodically
+type triggerNotifier struct {
+	sinks []notifySink
+	every time.Duration
+	max   int
+
+	mu      sync.Mutex
+	batch   []webhookAlert
+	count   int // the triggers batched, including those not listed
+	stopped chan struct{}
+	done    chan struct{}
+}
+
+
+// start notifying the sinks given by the -notify flags, if any
+func startNotify() {
+	var sinks []notifySink
+	if notifySlack != "" {
+		sinks = append(sinks, &slackSink{url: notifySlack, client: &http.Client{Timeout: 10 * time.Second}})
+	}
+	if notifyEmail != "" {
+		if smtpFrom == "" {
+			fatal("-notify-email requires a sender address, -smtp-from")
+		}
+		sinks = append(sinks, &emailSink{
+			addr: smtpAddr, from: smtpFrom, to: strings.Split(notifyEmail, ","),
+			user: smtpUser, password: smtpPassword,
+		})
+	}
+	if len(sinks) == 0 {
+		return
+	}
+	if notifyEvery <= 0 || notifyMax < 1 {
+		fatal("invalid -notify-every or -notify-max", "every", notifyEvery, "max", notifyMax)
+	}
+	notifier = &triggerNotifier{
+		sinks:   sinks,
+		every:   notifyEvery,
+		max:     notifyMax,
+		stopped: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go notifier.run()
+}
+
+
+// add a trigger to the next notification
+func (n *triggerNotifier) trigger(alert webhookAlert) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.count++
+	if len(n.batch) < n.max {
+		n.batch = append(n.batch, alert)
+	}
+}
+
+
+// notify the batched triggers every interval, until stopped
+func (n *triggerNotifier) run() {
+	defer close(n.done)
+	ticker := time.NewTicker(n.every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			n.flush()
+		case <-n.stopped:
+			n.flush()
+			return
+		}
+	}
+}
+
+
+// notify the batched triggers, if any
+func (n *triggerNotifier) flush() {
+	n.mu.Lock()
+	batch, count := n.batch, n.count
+	n.batch, n.count = nil, 0
+	n.mu.Unlock()
+	if count == 0 {
+		return
+	}
+
+	subject := fmt.Sprintf("rollingavg: %d Result triggers", count)
+	var body strings.Builder
+	for _, alert := range batch {
+		if alert.Group != "" {
+			fmt.Fprintf(&body, "%s: ", alert.Group)
+		}
+		fmt.Fprintf(&body, "Result %s (rule %s), statistics %g, %g: %s\n",
+			alert.Result, alert.Rule, alert.StatA, alert.StatB, alert.Row)
+	}
+	if count > len(batch) {
+		fmt.Fprintf(&body, "and %d more\n", count-len(batch))
+	}
+	for _, sink := range n.sinks {
+		if err := sink.send(subject, body.String()); err != nil {
+			slog.Warn("error sending notification", "sink", sink.name(), "err", err)
+		} else {
+			slog.Debug("sent notification", "sink", sink.name(), "triggers", count)
+		}
+	}
+}
+
+
+// notify the triggers not yet notified, and stop
+func (n *triggerNotifier) close() {
+	if n == nil {
+		return
+	}
+	close(n.stopped)
+	<-n.done
+}
+
+
+// posts notifications to a Slack incoming webhook
+type slackSink struct {
+	url    string
+	client *http.Client
+}
+
+
+func (s *slackSink) name() string {
+	return "slack"
+}
+
+
+func (s *slackSink) send(subject, body string) error {
+	msg, err := json.Marshal(map[string]string{"text": "*" + subject + "*\n```\n" + body + "```"})
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(msg))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP status %s", resp.Status)
+	}
+	return nil
+}
+
+
+// mails notifications by SMTP
+type emailSink struct {
+	addr     string
+	from     string
+	to       []string
+	user     string
+	password string
+}
+
+
+func (s *emailSink) name() string {
+	return "email"
+}
+
+
+func (s *emailSink) send(subject, body string) error {
+	var auth smtp.Auth
+	if s.user != "" {
+		host, _, _ := net.SplitHostPort(s.addr)
+		auth = smtp.PlainAuth("", s.user, s.password, host)
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return smtp.SendMail(s.addr, auth, s.from, s.to, msg.Bytes())
+}
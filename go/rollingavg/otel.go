@@ -0,0 +1,226 @@
+// otel.go: OpenTelemetry tracing and metrics
+//
+// with -otel, runs are traced, and metrics recorded, with OpenTelemetry,
+// exported by OTLP over gRPC as configured by the standard environment
+// variables, e.g.
+//     OTEL_EXPORTER_OTLP_ENDPOINT=http://collector:4317
+//     OTEL_EXPORTER_OTLP_INSECURE=true
+//     OTEL_SERVICE_NAME=rollingavg (the default)
+//     OTEL_RESOURCE_ATTRIBUTES=deployment.environment=prod
+// spans:
+//     rollingavg.run       a run over its inputs, of the following spans
+//     rollingavg.open      opening the inputs and output, and the header row
+//     rollingavg.process   reading the records and writing the output rows
+//     rollingavg.finish    writing the tail rows and closing the output
+//     rollingavg.request   an HTTP -serve request, or gRPC Process stream
+// metrics:
+//     rollingavg.rows.in          records read
+//     rollingavg.rows.out         output rows written
+//     rollingavg.errors           processing errors
+//     rollingavg.stage.duration   seconds spent reading, computing (parsing
+//                                 and windowing) and writing, by stage
+// telemetry is flushed when the run ends, or on a processing error
+
+
+package main
+
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+
+// the tracer and instruments, nil when telemetry is disabled
+var telemetry *otelTelemetry
+
+
+type otelTelemetry struct {
+	tracerProvider *sdktrace.TracerProvider
+	meterProvider  *sdkmetric.MeterProvider
+	tracer         trace.Tracer
+	rowsIn         metric.Int64Counter
+	rowsOut        metric.Int64Counter
+	errors         metric.Int64Counter
+	stageTime      metric.Float64Counter
+}
+
+
+// set up the OTLP exporters and instruments
+func startOtel() {
+	ctx := context.Background()
+	res, err := resource.New(ctx,
+		resource.WithAttributes(
+			attribute.String("service.name", "rollingavg"),
+			attribute.String("service.version", APP_VERSION),
+		),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		fatal("error creating OpenTelemetry resource", "err", err)
+	}
+	traceExporter, err := otlptracegrpc.New(ctx)
+	if err != nil {
+		fatal("error creating OTLP trace exporter", "err", err)
+	}
+	metricExporter, err := otlpmetricgrpc.New(ctx)
+	if err != nil {
+		fatal("error creating OTLP metric exporter", "err", err)
+	}
+
+	t := &otelTelemetry{
+		tracerProvider: sdktrace.NewTracerProvider(
+			sdktrace.WithBatcher(traceExporter),
+			sdktrace.WithResource(res),
+		),
+		meterProvider: sdkmetric.NewMeterProvider(
+			sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)),
+			sdkmetric.WithResource(res),
+		),
+	}
+	otel.SetTracerProvider(t.tracerProvider)
+	otel.SetMeterProvider(t.meterProvider)
+	t.tracer = t.tracerProvider.Tracer("rollingavg")
+
+	meter := t.meterProvider.Meter("rollingavg")
+	if t.rowsIn, err = meter.Int64Counter("rollingavg.rows.in",
+		metric.WithDescription("Number of input records read.")); err != nil {
+		fatal("error creating OpenTelemetry instrument", "err", err)
+	}
+	if t.rowsOut, err = meter.Int64Counter("rollingavg.rows.out",
+		metric.WithDescription("Number of output rows written.")); err != nil {
+		fatal("error creating OpenTelemetry instrument", "err", err)
+	}
+	if t.errors, err = meter.Int64Counter("rollingavg.errors",
+		metric.WithDescription("Number of processing errors.")); err != nil {
+		fatal("error creating OpenTelemetry instrument", "err", err)
+	}
+	if t.stageTime, err = meter.Float64Counter("rollingavg.stage.duration",
+		metric.WithDescription("Time spent in each processing stage."), metric.WithUnit("s")); err != nil {
+		fatal("error creating OpenTelemetry instrument", "err", err)
+	}
+	telemetry = t
+}
+
+
+// flush any telemetry not yet exported, and stop exporting
+func shutdownOtel() {
+	if telemetry == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := telemetry.tracerProvider.Shutdown(ctx); err != nil {
+		slog.Warn("error exporting traces", "err", err)
+	}
+	if err := telemetry.meterProvider.Shutdown(ctx); err != nil {
+		slog.Warn("error exporting metrics", "err", err)
+	}
+}
+
+
+// start a span, which does nothing when telemetry is disabled
+func (t *otelTelemetry) start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if t == nil {
+		return ctx, noop.Span{}
+	}
+	return t.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+
+// count records read
+func (t *otelTelemetry) rowsRead(n int) {
+	if t == nil {
+		return
+	}
+	t.rowsIn.Add(context.Background(), int64(n))
+}
+
+
+// count output rows written
+func (t *otelTelemetry) rowsWritten(n int) {
+	if t == nil {
+		return
+	}
+	t.rowsOut.Add(context.Background(), int64(n))
+}
+
+
+// count a processing error, recording it on span, which is ended
+func (t *otelTelemetry) failed(span trace.Span, err error) {
+	if t == nil {
+		return
+	}
+	t.errors.Add(context.Background(), 1)
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+	span.End()
+}
+
+
+// add time spent in a processing stage
+func (t *otelTelemetry) stage(name string, d time.Duration) {
+	t.stageTime.Add(context.Background(), d.Seconds(), metric.WithAttributes(attribute.String("stage", name)))
+}
+
+
+// times the read, compute and write stages of processing, as the reader
+// and writer of the records. compute is the time between reads, other
+// than writing
+type stageTimer struct {
+	in         recordReader
+	out        recordWriter
+	lastRead   time.Time
+	writeSince time.Duration
+}
+
+
+func (s *stageTimer) Read() ([]string, error) {
+	start := time.Now()
+	if !s.lastRead.IsZero() {
+		telemetry.stage("compute", start.Sub(s.lastRead)-s.writeSince)
+	}
+	record, err := s.in.Read()
+	s.lastRead = time.Now()
+	s.writeSince = 0
+	telemetry.stage("read", s.lastRead.Sub(start))
+	return record, err
+}
+
+
+func (s *stageTimer) Write(record []string) error {
+	start := time.Now()
+	err := s.out.Write(record)
+	d := time.Since(start)
+	s.writeSince += d
+	telemetry.stage("write", d)
+	return err
+}
+
+
+func (s *stageTimer) Flush() {
+	start := time.Now()
+	s.out.Flush()
+	d := time.Since(start)
+	s.writeSince += d
+	telemetry.stage("write", d)
+}
+
+
+func (s *stageTimer) Error() error {
+	return s.out.Error()
+}
@@ -0,0 +1,279 @@
+// outliers.go: IQR or MAD outlier removal from input columns
+//
+// so spikes and glitches are kept out of the averages, -outliers lists
+// input columns, by name or index, whose outlying values are removed before
+// processing, as col:k[:rolling|global][:drop|null][:iqr|mad], e.g.
+//     -outliers "X:1.5,Y:3:global:null,Z:3:mad"
+// a value is an outlier when it's more than k times the interquartile range
+// (IQR) below the lower quartile or above the upper quartile of the
+// column's values, or with mad, a Hampel filter, more than k robust
+// standard deviations, 1.4826 times the median absolute deviation (MAD),
+// from their median, a robust z-score beyond k. it's then either dropped,
+// with its row, or nulled, replaced by an empty value. the MAD of a
+// window is also a statistic, see -stat in rollingavg.go.
+// the quartiles, or median and MAD, are of a rolling distribution,
+// the default, of the previous -outlier-window values (default -n nrows),
+// no values being removed until there are 4, or of the global distribution,
+// estimated from the first -outlier-sample rows (default 1000), which are
+// held back until it's known.
+// outliers are removed after any -schema coercions, -convert conversions and
+// -clamp bounds. the first two columns, which are averaged, need a value in
+// every row, so their outliers can only be dropped.
+// values that aren't numbers are left as they are. the number of values
+// removed of each column is logged at the end, and rows dropped count as
+// skipped, see exitcode.go. with -audit, the rows dropped or modified are
+// logged, see audit.go
+
+
+package main
+
+
+import (
+	"io"
+	"log/slog"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+var outlierSpec string
+var outlierWindow int
+var outlierSample int
+
+
+// an outlier filter of a column's values
+type outlierFilter struct {
+	col    int
+	name   string
+	k      float64
+	global bool
+	null   bool
+	mad    bool
+	lo, hi float64   // the bounds of global filters
+	window []float64 // the previous values of rolling filters, as a ring
+	next   int
+	n      int // values removed
+}
+
+
+// the bounds from the quartiles of the given values, which are sorted
+func iqrBounds(sorted []float64, k float64) (float64, float64) {
+	q1, q3 := quantile(sorted, 0.25), quantile(sorted, 0.75)
+	return q1 - k*(q3-q1), q3 + k*(q3-q1)
+}
+
+
+// the bounds of k robust standard deviations, from the median absolute
+// deviation, of the given values, which are sorted
+func madBounds(sorted []float64, k float64) (float64, float64) {
+	m := quantile(sorted, 0.5)
+	sigma := 1.4826 * rollingavg.MedianAbsDeviation(sorted)
+	return m - k*sigma, m + k*sigma
+}
+
+
+// the bounds of the filter's method for the given values, which are sorted
+func (f *outlierFilter) bounds(sorted []float64) (float64, float64) {
+	if f.mad {
+		return madBounds(sorted, f.k)
+	}
+	return iqrBounds(sorted, f.k)
+}
+
+
+// whether a value is an outlier, then adding it to a rolling window
+func (f *outlierFilter) outlier(v float64, size int) bool {
+	if f.global {
+		return v < f.lo || v > f.hi
+	}
+	out := false
+	if len(f.window) >= 4 {
+		sorted := append([]float64(nil), f.window...)
+		sort.Float64s(sorted)
+		lo, hi := f.bounds(sorted)
+		out = v < lo || v > hi
+	}
+	if len(f.window) < size {
+		f.window = append(f.window, v)
+	} else {
+		f.window[f.next] = v
+		f.next = (f.next + 1) % size
+	}
+	return out
+}
+
+
+// parse an -outliers list of col:k[:rolling|global][:drop|null][:iqr|mad] for input
+// with the given header
+func parseOutlierFilters(spec string, header []string) []outlierFilter {
+	var filters []outlierFilter
+	for _, item := range strings.Split(spec, ",") {
+		parts := strings.Split(item, ":")
+		if len(parts) < 2 || len(parts) > 5 {
+			fatal("invalid outlier filter, expected col:k[:rolling|global][:drop|null][:iqr|mad]", "outliers", item)
+		}
+		col := findColumn(header, strings.TrimSpace(parts[0]))
+		if col < 0 {
+			fatal("outlier column not found in header", "column", parts[0])
+		}
+		f := outlierFilter{col: col, name: header[col]}
+		var err error
+		if f.k, err = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64); err != nil || f.k < 0 {
+			fatal("invalid outlier IQR or MAD multiple", "outliers", item)
+		}
+		for _, opt := range parts[2:] {
+			switch strings.TrimSpace(opt) {
+			case "rolling", "drop", "iqr":
+			case "global":
+				f.global = true
+			case "null":
+				f.null = true
+			case "mad":
+				f.mad = true
+			default:
+				fatal("invalid outlier option, expected rolling, global, drop, null, iqr or mad", "outliers", item)
+			}
+		}
+		if f.null && col < 2 {
+			fatal("outliers of the averaged columns can't be nulled, only dropped", "outliers", item)
+		}
+		filters = append(filters, f)
+	}
+	return filters
+}
+
+
+// a record source with outlying values removed
+type outlierReader struct {
+	in      recordReader
+	filters []outlierFilter
+	window  int
+	sample  [][]string // the rows held back for estimating global distributions
+	sampled bool
+	dropped int
+	done    bool
+	n       int // rows read
+}
+
+
+func newOutlierReader(in recordReader, filters []outlierFilter, window, sample int) *outlierReader {
+	r := &outlierReader{in: in, filters: filters, window: window, sampled: true}
+	for _, f := range filters {
+		if f.global {
+			r.sampled = sample <= 0
+		}
+	}
+	if !r.sampled {
+		r.sample = make([][]string, 0, sample)
+	}
+	return r
+}
+
+
+// read the sample rows, and set the bounds of global filters from them
+func (r *outlierReader) readSample() error {
+	for len(r.sample) < cap(r.sample) {
+		record, err := r.in.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		r.sample = append(r.sample, append([]string(nil), record...))
+	}
+	for i := range r.filters {
+		f := &r.filters[i]
+		if !f.global {
+			continue
+		}
+		var values []float64
+		for _, record := range r.sample {
+			if v, err := rollingavg.ParseFloat(field(record, f.col)); err == nil {
+				values = append(values, v)
+			}
+		}
+		sort.Float64s(values)
+		if f.lo, f.hi = f.bounds(values); len(values) == 0 {
+			f.lo, f.hi = math.Inf(-1), math.Inf(1)
+		}
+		slog.Debug("outlier bounds", "column", f.name, "sampled", len(values), "lo", f.lo, "hi", f.hi)
+	}
+	r.sampled = true
+	return nil
+}
+
+
+func (r *outlierReader) Read() ([]string, error) {
+	if !r.sampled {
+		if err := r.readSample(); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		var record []string
+		if len(r.sample) > 0 {
+			record, r.sample = r.sample[0], r.sample[1:]
+		} else {
+			var err error
+			if record, err = r.in.Read(); err != nil {
+				if err == io.EOF {
+					r.finish()
+				}
+				return record, err
+			}
+		}
+		r.n++
+		var original []string
+		if audit != nil {
+			original = slices.Clone(record)
+		}
+		drop := false
+		var outliers []string
+		for i := range r.filters {
+			f := &r.filters[i]
+			if f.col >= len(record) {
+				continue
+			}
+			v, err := rollingavg.ParseFloat(record[f.col])
+			if err != nil || !f.outlier(v, r.window) {
+				continue
+			}
+			f.n++
+			outliers = append(outliers, f.name)
+			if f.null {
+				record[f.col] = ""
+			} else {
+				drop = true
+			}
+		}
+		if !drop {
+			if len(outliers) > 0 {
+				audit.row(r.n, "modified", "input outliers nulled "+strings.Join(outliers, ", "), original)
+			}
+			return record, nil
+		}
+		r.dropped++
+		rowsSkipped.Add(1)
+		audit.row(r.n, "dropped", "input outliers "+strings.Join(outliers, ", "), original)
+	}
+}
+
+
+// log the number of values removed
+func (r *outlierReader) finish() {
+	if r.done {
+		return
+	}
+	r.done = true
+	args := []any{"rows_dropped", r.dropped}
+	for _, f := range r.filters {
+		args = append(args, f.name, f.n)
+	}
+	slog.Info("removed outliers", args...)
+}
@@ -0,0 +1,192 @@
+// outputs.go: destinations for output CSV records
+//
+// records are written to a single output file, or with rotation enabled,
+// to a sequence of files, see rotate.go, or with splitting enabled,
+// to a file per day or month, see split.go
+// in append mode, the output is appended to, see append.go
+// with -format json, records are written as a JSON array, see jsonout.go
+// with -format parquet, as a parquet file, see parquet.go
+// with -format arrow, as an Arrow IPC file, see arrowout.go
+// with -format influx, as InfluxDB line protocol, see influx.go
+// with -pg-conn, rows are copied into a PostgreSQL table, see postgres.go
+// with -kafka-out-topic, rows are produced to a Kafka topic, see kafka.go
+// with -script, rows are first passed through a Starlark script, see script.go
+// with -provenance, CSV outputs record how they were made, see provenance.go
+// with -column-stats, CSV outputs have statistics of their columns, see colstats.go
+
+
+package main
+
+
+import (
+	"bufio"
+	"strings"
+)
+
+
+// a destination for CSV records, satisfied by *csv.Writer
+type recordWriter interface {
+	Write(record []string) error
+	Flush()
+	Error() error
+}
+
+
+// a record destination that holds open files
+type recordWriteCloser interface {
+	recordWriter
+	Close() error
+}
+
+
+// a CSV writer to a single, possibly compressed, output file
+type csvOutput struct {
+	recordWriter
+	out   *outputFile
+	name  string
+	prov  *provenance
+	stats *outputColumnStats
+}
+
+func (c *csvOutput) Write(record []string) error {
+	c.out.recordWritten()
+	c.stats.add(record)
+	return c.recordWriter.Write(record)
+}
+
+// flush any buffered records and close the output file
+func (c *csvOutput) Close() error {
+	c.Flush()
+	if err := c.Error(); err != nil {
+		return err
+	}
+	if err := c.stats.writeFooter(c.out); err != nil {
+		return err
+	}
+	if err := c.out.Close(); err != nil {
+		return err
+	}
+	if err := c.stats.writeSidecar(c.name); err != nil {
+		return err
+	}
+	return c.prov.writeSidecar(c.name)
+}
+
+
+// create the CSV output file, or stdout if outfilename is empty, with the
+// provenance prov, if not nil, and any -column-stats
+func newCSVOutput(outfilename string, compression string, prov *provenance) *csvOutput {
+	out := createOutput(outfilename, compression)
+	prov.writeComment(out, outfilename)
+	stats := newColumnStats()
+	stats.writeMark(out)
+	c := newCSVOutputFile(out)
+	c.name, c.prov, c.stats = outfilename, prov, stats
+	return c
+}
+
+
+// write CSV to an already opened output file
+func newCSVOutputFile(out *outputFile) *csvOutput {
+	bw := bufio.NewWriter(out)
+	w := newCSVWriter(bw)
+	if passthroughFlag {
+		w = &passthroughWriter{w: bw, fields: w}
+	}
+	return &csvOutput{recordWriter: w, out: out}
+}
+
+
+// open the output for the rolling average records, with rotation or
+// splitting if enabled, and CSV files with the provenance prov, if not nil
+func openOutput(outfilename string, prov *provenance) recordWriteCloser {
+	rotating := rotateSize > 0 || rotateEvery > 0
+	if pgConn != "" {
+		if outfilename != "" || rotating || splitBy != "" || appendFlag {
+			fatal("PostgreSQL output can't be combined with output file options")
+		}
+		out, err := newPGOutput(pgConn, pgTable, pgBatch)
+		if err != nil {
+			fatal("error opening PostgreSQL output", "err", err)
+		}
+		return out
+	}
+	if duckdbTable != "" {
+		if outfilename != "" || rotating || splitBy != "" || appendFlag {
+			fatal("DuckDB output can't be combined with output file options")
+		}
+		out, err := newDuckDBOutput(duckdbTable, parseColumnTypes(columnTypes))
+		if err != nil {
+			fatal("error opening DuckDB output", "err", err)
+		}
+		return out
+	}
+	if kafkaOutTopic != "" {
+		if outfilename != "" || rotating || splitBy != "" || appendFlag {
+			fatal("Kafka output can't be combined with output file options")
+		}
+		out, err := newKafkaOutput(kafkaBrokers, kafkaOutTopic, messageFormat, groupBy, kafkaBatch)
+		if err != nil {
+			fatal("error opening Kafka output", "err", err)
+		}
+		return out
+	}
+	switch outputFormat {
+	case "csv":
+	case "json":
+		if rotating || splitBy != "" || appendFlag {
+			fatal("JSON output can't be rotated, split or appended to")
+		}
+		return newJSONOutput(outfilename, compressFlag)
+	case "influx":
+		if rotating || splitBy != "" || appendFlag {
+			fatal("influx output can't be rotated, split or appended to")
+		}
+		var tags []string
+		if influxTags != "" {
+			tags = strings.Split(influxTags, ",")
+		}
+		return newInfluxOutput(outfilename, compressFlag, influxMeasurement, tags, timeCol)
+	case "parquet":
+		if rotating || splitBy != "" || appendFlag {
+			fatal("parquet output can't be rotated, split or appended to")
+		}
+		return newParquetOutput(outfilename, parseColumnTypes(columnTypes))
+	case "arrow":
+		if rotating || splitBy != "" || appendFlag {
+			fatal("arrow output can't be rotated, split or appended to")
+		}
+		return newArrowOutput(outfilename, parseColumnTypes(columnTypes), compressFlag)
+	case "protobuf", "msgpack":
+		if rotating || splitBy != "" || appendFlag {
+			fatal(outputFormat+" output can't be rotated, split or appended to")
+		}
+		return newRecordOutput(outfilename, compressFlag, outputFormat, parseColumnTypes(columnTypes))
+	default:
+		fatal("invalid output format", "format", outputFormat)
+	}
+	if splitBy != "" {
+		if rotating {
+			fatal("output can't be both rotated and split by date")
+		}
+		return newSplitCSV(outfilename, splitPattern, compressFlag, splitBy, timeCol, prov)
+	}
+	if appendFlag {
+		if rotating {
+			fatal("output can't be both rotated and appended to")
+		}
+		return openAppendOutput(outfilename, compressFlag)
+	}
+	if rotating {
+		return newRotatingCSV(outfilename, rotatePattern, compressFlag, int64(rotateSize), rotateEvery, prov)
+	}
+	return newCSVOutput(outfilename, compressFlag, prov)
+}
+
+
+// whether output rows are written out before the next is, rather than kept,
+// e.g. for a batch of rows or by another goroutine, so that their buffers
+// can be reused for the next, see Processor.ReuseRecords
+func outputDoesntKeepRows() bool {
+	return outputFormat == "csv" && pgConn == "" && duckdbTable == "" && kafkaOutTopic == "" && liveRows == nil && scriptfile == ""
+}
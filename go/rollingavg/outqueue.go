@@ -0,0 +1,202 @@
+// outqueue.go: queued output, decoupling reading from a slow output
+//
+// in follow and message streaming runs, each row is written and flushed to
+// the output as it's computed, so a slow output, e.g. a Kafka topic or a
+// PostgreSQL table, or a file on a busy disk, stalls reading. with
+// -output-queue n, up to n output rows are queued for a goroutine that
+// writes them, flushing them once the queue is empty, or with
+// -flush-interval, at most that often, e.g.
+//     rollingavg -follow -kafka-out-topic avgs -output-queue 10000 -flush-interval 1s in.csv
+// when the queue is full, reading waits for the output to catch up, so
+// no rows are dropped, the first wait being logged as a warning, and the
+// number and total time of the waits at the end of the run. an error
+// writing the output stops the run at the next row written.
+// the output's size is only known once its rows are written, so
+// -output-queue can't be used with -checkpoint
+
+
+package main
+
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+
+var outputQueue int
+var flushInterval time.Duration
+
+
+// check that -output-queue and -flush-interval are valid with the current
+// options
+func checkOutputQueue() {
+	switch {
+	case outputQueue < 0:
+		fatal("invalid output queue length", "output-queue", outputQueue)
+	case flushInterval < 0:
+		fatal("invalid flush interval", "flush-interval", flushInterval)
+	case flushInterval > 0 && outputQueue == 0:
+		fatal("-flush-interval requires -output-queue")
+	case outputQueue > 0 && checkpointfile != "":
+		fatal("-output-queue can't be used with -checkpoint")
+	}
+}
+
+
+// queue the rows written to out with -output-queue, or return it as is
+func queueOutput(out recordWriteCloser) recordWriteCloser {
+	if outputQueue == 0 {
+		return out
+	}
+	return newQueuedOutput(out, outputQueue, flushInterval)
+}
+
+
+// an output whose rows are queued for a goroutine writing them to out
+type queuedOutput struct {
+	out      recordWriteCloser
+	rows     chan []string
+	flush    chan struct{}
+	interval time.Duration
+	done     chan struct{}
+
+	mu  sync.Mutex
+	err error
+
+	waits  int
+	waited time.Duration
+}
+
+
+func newQueuedOutput(out recordWriteCloser, n int, interval time.Duration) *queuedOutput {
+	q := &queuedOutput{
+		out:      out,
+		rows:     make(chan []string, n),
+		flush:    make(chan struct{}, 1),
+		interval: interval,
+		done:     make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+
+// queue a copy of the record, the caller's being reusable, waiting while
+// the queue is full
+func (q *queuedOutput) Write(record []string) error {
+	if err := q.Error(); err != nil {
+		return err
+	}
+	record = append([]string(nil), record...)
+	select {
+	case q.rows <- record:
+		return nil
+	default:
+	}
+	if q.waits == 0 {
+		slog.Warn("output queue full, waiting for the output", "output-queue", cap(q.rows))
+	}
+	q.waits++
+	start := time.Now()
+	q.rows <- record
+	q.waited += time.Since(start)
+	return nil
+}
+
+
+// ask for the rows queued to be flushed once written, unless they're
+// flushed every -flush-interval
+func (q *queuedOutput) Flush() {
+	if q.interval > 0 {
+		return
+	}
+	select {
+	case q.flush <- struct{}{}:
+	default:
+	}
+}
+
+
+// the first error writing the output
+func (q *queuedOutput) Error() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.err
+}
+
+
+func (q *queuedOutput) setError(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err == nil {
+		q.err = err
+	}
+}
+
+
+// write the rows queued, flushing them when asked and the queue is empty,
+// or every interval. after an error, the rows are discarded, so that
+// writes don't wait, until the next returns it
+func (q *queuedOutput) run() {
+	defer close(q.done)
+	var tick <-chan time.Time
+	if q.interval > 0 {
+		ticker := time.NewTicker(q.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	flushWanted, unflushed := false, false
+	flushOut := func() {
+		q.out.Flush()
+		if err := q.out.Error(); err != nil {
+			q.setError(err)
+		}
+		flushWanted, unflushed = false, false
+	}
+	for {
+		select {
+		case record, ok := <-q.rows:
+			if !ok {
+				return
+			}
+			if q.Error() != nil {
+				continue
+			}
+			if err := q.out.Write(record); err != nil {
+				q.setError(err)
+				continue
+			}
+			unflushed = true
+		case <-q.flush:
+			flushWanted = true
+		case <-tick:
+			if unflushed && q.Error() == nil {
+				flushOut()
+			}
+		}
+		if flushWanted && unflushed && len(q.rows) == 0 && q.Error() == nil {
+			flushOut()
+		}
+	}
+}
+
+
+// write the rows queued, then flush and close the output
+func (q *queuedOutput) Close() error {
+	close(q.rows)
+	<-q.done
+	if q.waits > 0 {
+		slog.Info("waited for the output", "waits", q.waits, "waited", q.waited)
+	}
+	if q.Error() == nil {
+		q.out.Flush()
+		q.setError(q.out.Error())
+	}
+	if err := q.Error(); err != nil {
+		q.out.Close()
+		return err
+	}
+	return q.out.Close()
+}
@@ -0,0 +1,69 @@
+// parallel.go: process a large input file in parallel chunks
+//
+// with -parallel N, a single local, uncompressed CSV input is split into
+// chunks of about -chunk-size bytes (default 16M), starting at lines,
+// processed by up to N worker goroutines at once, and their output rows
+// stitched together in input order, see rollingavg/parallel.go. the output
+// is as for a sequential run, except that the mean and stddev may differ
+// in the last digits from rounding.
+// the speedup is near linear for row windows without grouping, whose
+// workers only read a window's length past their chunk. with -group-by,
+// workers read on until each of their series has a complete window, so
+// rare series make for longer overlaps.
+// quoted fields must not contain newlines, which would be split.
+// -parallel can't be used with -follow, -merge, -checkpoint, -append, -schema,
+// -convert, -clamp, -outliers, -mode, -distinct, -count-above,
+// -window-bounds, extra -stats, -anchor, -window-sample, -from, -to,
+// config file stages or -ewm, and with -progress, bytes read aren't counted
+
+
+package main
+
+
+import (
+	"bytes"
+	"os"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+var parallelWorkers int
+var chunkSize sizeFlag = 16 << 20
+
+
+// check the input can be processed in parallel, and split it into chunks,
+// positioned after the header already read by infile
+func parallelChunks(infile recordReadCloser, infilenames []string, header []string) (*rollingavg.Chunks, *os.File) {
+	switch {
+	case len(infilenames) != 1:
+		fatal("-parallel requires a single input file")
+	case isRemote(infilenames[0]) || isParquet(infilenames[0]) || isXLSX(infilenames[0]):
+		fatal("-parallel requires a local CSV input file")
+	case streaming() || mergeFlag || checkpointfile != "" || appendFlag || schemafile != "" || convertSpec != "" || clampSpec != "" || outlierSpec != "" || windowsWrapped() || fromTime != "" || toTime != "" || len(pipelineStages) > 0:
+		fatal("-parallel can't be used with streaming input, -merge, -checkpoint, -append, -schema, -convert, -clamp, -outliers, -mode, -distinct, -count-above, -window-bounds, extra -stats, -anchor, -window-sample, -from, -to, config file stages or -ewm")
+	case chunkSize <= 0:
+		fatal("invalid chunk size", "size", int64(chunkSize))
+	}
+
+	fl, err := os.Open(infilenames[0])
+	if err != nil {
+		fatal("error opening source csv", "err", err)
+	}
+	magic := make([]byte, len(zstdMagic))
+	n, _ := fl.ReadAt(magic, 0)
+	if bytes.HasPrefix(magic[:n], gzipMagic) || bytes.HasPrefix(magic[:n], zstdMagic) {
+		fatal("-parallel requires an uncompressed input file", "file", infilenames[0])
+	}
+	fi, err := fl.Stat()
+	if err != nil {
+		fatal("error opening source csv", "err", err)
+	}
+
+	_, start := infile.(*multiCSVReader).position()
+	chunks, err := rollingavg.SplitChunks(fl, start, fi.Size(), int64(chunkSize), len(header))
+	if err != nil {
+		fatal("error splitting input into chunks", "err", err)
+	}
+	return chunks, fl
+}
@@ -0,0 +1,209 @@
+// parquet.go: Apache Parquet input and output
+//
+// input files with a .parquet extension are read as parquet, with their
+// columns in file order, or as selected and ordered by -parquet-columns.
+// with -format parquet, output is written as a parquet file, with column
+// types as given by -column-types, see arrow.go.
+// parquet files are read whole from remote paths, as they need random access
+
+
+package main
+
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet"
+	"github.com/apache/arrow-go/v18/parquet/compress"
+	"github.com/apache/arrow-go/v18/parquet/file"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+)
+
+
+func isParquet(filename string) bool {
+	return strings.EqualFold(filepath.Ext(filename), ".parquet")
+}
+
+
+// reads a parquet file as CSV records, header first
+type parquetReader struct {
+	pf      *file.Reader
+	rr      pqarrow.RecordReader
+	header  []string
+	records [][]string
+	started bool
+}
+
+
+// open the parquet file fl, selecting the named columns, or all if none
+func newParquetReader(fl io.ReadCloser, columns []string) *parquetReader {
+	var src parquet.ReaderAtSeeker
+	if osfl, ok := fl.(*os.File); ok {
+		src = osfl
+	} else {
+		data, err := io.ReadAll(fl)
+		if err != nil {
+			fatal("error reading parquet input", "err", err)
+		}
+		src = bytes.NewReader(data)
+	}
+
+	pf, err := file.NewParquetReader(src)
+	if err != nil {
+		fatal("error opening parquet input", "err", err)
+	}
+	fr, err := pqarrow.NewFileReader(pf, pqarrow.ArrowReadProperties{BatchSize: arrowBatchSize}, memory.DefaultAllocator)
+	if err != nil {
+		fatal("error opening parquet input", "err", err)
+	}
+
+	var indices []int
+	if len(columns) > 0 {
+		schema := pf.MetaData().Schema
+		for _, name := range columns {
+			idx := schema.ColumnIndexByName(name)
+			if idx < 0 {
+				fatal("column not found in parquet input", "column", name)
+			}
+			indices = append(indices, idx)
+		}
+	}
+	rr, err := fr.GetRecordReader(context.Background(), indices, nil)
+	if err != nil {
+		fatal("error reading parquet input", "err", err)
+	}
+
+	r := &parquetReader{pf: pf, rr: rr}
+	for _, f := range rr.Schema().Fields() {
+		r.header = append(r.header, f.Name)
+	}
+	// GetRecordReader returns columns in file order, reorder them as requested
+	if len(columns) > 0 {
+		r.header = columns
+	}
+	return r
+}
+
+
+func (r *parquetReader) Read() ([]string, error) {
+	if !r.started {
+		r.started = true
+		return r.header, nil
+	}
+	for len(r.records) == 0 {
+		if !r.rr.Next() {
+			if err := r.rr.Err(); err != nil && err != io.EOF {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		r.records = r.reorder(arrowRecords(r.rr.RecordBatch()))
+	}
+	record := r.records[0]
+	r.records = r.records[1:]
+	return record, nil
+}
+
+
+// put the columns of records in header order
+func (r *parquetReader) reorder(records [][]string) [][]string {
+	schema := r.rr.Schema()
+	order := make([]int, len(r.header))
+	for i, name := range r.header {
+		idx := schema.FieldIndices(name)
+		if len(idx) == 0 {
+			return records
+		}
+		order[i] = idx[0]
+	}
+	for n, record := range records {
+		reordered := make([]string, len(order))
+		for i, c := range order {
+			reordered[i] = record[c]
+		}
+		records[n] = reordered
+	}
+	return records
+}
+
+
+func (r *parquetReader) Close() error {
+	r.rr.Release()
+	return r.pf.Close()
+}
+
+
+// writes records to a parquet file
+type parquetOutput struct {
+	out     *outputFile
+	batcher *arrowBatcher
+	fw      *pqarrow.FileWriter
+	err     error
+}
+
+
+func newParquetOutput(outfilename string, types map[string]string) *parquetOutput {
+	p := &parquetOutput{out: createOutput(outfilename, "")}
+	p.batcher = newArrowBatcher(types, p.writeBatch)
+	return p
+}
+
+
+// write a record batch, creating the parquet writer with the first
+func (p *parquetOutput) writeBatch(schema *arrow.Schema, rec arrow.RecordBatch) error {
+	if p.fw == nil {
+		props := parquet.NewWriterProperties(parquet.WithCompression(compress.Codecs.Snappy))
+		fw, err := pqarrow.NewFileWriter(schema, p.out, props, pqarrow.DefaultWriterProps())
+		if err != nil {
+			return fmt.Errorf("creating parquet output: %w", err)
+		}
+		p.fw = fw
+	}
+	if rec == nil {
+		return nil
+	}
+	return p.fw.Write(rec)
+}
+
+
+func (p *parquetOutput) Write(record []string) error {
+	if p.err == nil {
+		p.out.recordWritten()
+		p.err = p.batcher.add(record)
+	}
+	return p.err
+}
+
+
+// rows are written in batches, so there is nothing to flush until Close
+func (p *parquetOutput) Flush() {}
+
+
+func (p *parquetOutput) Error() error {
+	return p.err
+}
+
+
+func (p *parquetOutput) Close() error {
+	defer p.batcher.release()
+	if p.err == nil {
+		p.err = p.batcher.flush()
+	}
+	if p.err == nil && p.fw == nil {
+		// no rows, write a file with just the schema
+		p.err = p.writeBatch(p.batcher.finalSchema(), nil)
+	}
+	if p.err != nil {
+		return p.err
+	}
+	// closing the parquet writer also closes the output file
+	return p.fw.Close()
+}
@@ -0,0 +1,170 @@
+// passthrough.go: output of the input rows as they were written
+//
+// for consumers that diff the output against the source file, -passthrough
+// copies each input row's text verbatim, with its original quoting, number
+// formatting and spacing, and only appends the new columns, e.g.
+//     X, Y,"Z",Time
+//     24, -129,"-2023",2015-11-12 15:44:40.861
+// is output as
+//     X, Y,"Z",Time,Average A,Average B,Result
+//     24, -129,"-2023",2015-11-12 15:44:40.861,29.13,-138.96,0
+// the text of the CSV input rows is kept until they're output, and output
+// rows are matched to it by their input fields, in order, so rows dropped,
+// e.g. by -outliers or -tail, are skipped over. line endings are those of
+// the output, by -crlf, and the new columns are quoted by -quote.
+// as the input rows must be output as they were read, it's only for CSV
+// inputs and output, and can't be combined with options that change the
+// input rows, or their order
+
+
+package main
+
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+)
+
+
+var passthroughFlag bool
+
+
+// the text of the input rows yet to be output, oldest first
+var rawRows []rawRow
+
+
+// the text of the header row, output at the start of each output file
+var rawHeader *rawRow
+
+
+// an input row's text, without its line ending, and its fields
+type rawRow struct {
+	text   string
+	fields []string
+}
+
+
+// check that -passthrough isn't combined with options it can't be used with
+func checkPassthrough() {
+	if !passthroughFlag {
+		return
+	}
+	switch {
+	case outputFormat != "csv":
+		fatal("-passthrough requires CSV output", "format", outputFormat)
+	case schemafile != "" || convertSpec != "" || clampSpec != "" || raggedRows || len(pipelineStages) > 0:
+		fatal("-passthrough can't be used with -schema, -convert, -clamp, -ragged, config file stages or -ewm, which change the input rows")
+	case placeSpec != "" || anchor != "start":
+		fatal("-passthrough can't be used with -place or -anchor, which move the input columns or rows")
+	case groupBy != "" || mergeFlag || parallelWorkers > 1:
+		fatal("-passthrough can't be used with -group-by, -merge or -parallel, which reorder the input rows")
+	case appendFlag || checkpointfile != "":
+		fatal("-passthrough can't be used with -append or -checkpoint")
+	}
+}
+
+
+// reads CSV records, keeping the text of each row for its output
+type rawReader struct {
+	br *bufio.Reader
+}
+
+
+func newRawReader(r io.Reader) *rawReader {
+	return &rawReader{br: bufio.NewReader(r)}
+}
+
+
+// read the next row's lines, joining those of quoted fields spanning lines
+func (r *rawReader) readRow() (string, error) {
+	var text strings.Builder
+	for {
+		line, err := r.br.ReadString('\n')
+		text.WriteString(line)
+		if err != nil {
+			if err == io.EOF && text.Len() > 0 {
+				break
+			}
+			return "", err
+		}
+		if strings.Count(text.String(), `"`)%2 == 0 {
+			break
+		}
+	}
+	return strings.TrimRight(text.String(), "\r\n"), nil
+}
+
+
+func (r *rawReader) Read() ([]string, error) {
+	for {
+		text, err := r.readRow()
+		if err != nil {
+			return nil, err
+		}
+		// blank lines are skipped, as by encoding/csv
+		if text == "" {
+			continue
+		}
+		fields, err := newCSVReader(strings.NewReader(text)).Read()
+		if err != nil {
+			return nil, err
+		}
+		row := rawRow{text, fields}
+		if rawHeader == nil {
+			rawHeader = &row
+		} else {
+			rawRows = append(rawRows, row)
+		}
+		return slices.Clone(fields), nil
+	}
+}
+
+
+// writes output records as the text of the input rows they start with,
+// followed by the remaining fields
+type passthroughWriter struct {
+	w      *bufio.Writer
+	fields recordWriter
+}
+
+
+// whether a record starts with a row's fields
+func (row *rawRow) matches(record []string) bool {
+	return len(record) >= len(row.fields) && slices.Equal(record[:len(row.fields)], row.fields)
+}
+
+
+func (p *passthroughWriter) Write(record []string) error {
+	row := rawHeader
+	if row == nil || !row.matches(record) {
+		// skip the rows that weren't output
+		for len(rawRows) > 0 && !rawRows[0].matches(record) {
+			rawRows = rawRows[1:]
+		}
+		if len(rawRows) == 0 {
+			return errors.New("-passthrough output row doesn't match an input row")
+		}
+		row = &rawRows[0]
+		rawRows = rawRows[1:]
+	}
+	p.w.WriteString(row.text)
+	rest := record[len(row.fields):]
+	if len(rest) == 0 {
+		return p.fields.Write([]string{})
+	}
+	p.w.WriteByte(',')
+	return p.fields.Write(rest)
+}
+
+
+func (p *passthroughWriter) Flush() {
+	p.fields.Flush()
+}
+
+
+func (p *passthroughWriter) Error() error {
+	return p.fields.Error()
+}
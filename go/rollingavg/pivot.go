@@ -0,0 +1,267 @@
+// pivot.go: pivot long format CSV rows to wide, and back
+//
+// rolling averages need wide format rows, of a column per series, while
+// exports are often long, of a row per timestamp, series and value.
+// invoked as the pivot and unpivot subcommands, see commands.go:
+//     mdp pivot [-v] [-time col] [-key col] [-value col] [-series name,...]
+//         [-f inputfile]... [-o outputfile] [inputfile...]
+//     mdp unpivot [-v] [-time col] [-columns col,...] [-key-name name] [-value-name name]
+//         [-f inputfile]... [-o outputfile] [inputfile...]
+// pivot outputs a row per timestamp, of the timestamp and the value of each
+// series, in a column named for it, empty if the series has no value then.
+// its time, key and value columns default to the first three columns, and
+// any other columns are dropped. when a series has several values at a
+// timestamp, the last is used.
+// the series aren't known until all the rows are read, so all the rows are
+// kept in memory, and output in the order of their timestamps' first rows,
+// with the series in name order. with -series, the series are known, and
+// rows are streamed instead, with each run of rows with the same timestamp
+// output as a row, in -series order, and values of other series dropped.
+// unpivot outputs a row per timestamp and column of -columns, default all
+// but the time column (default 3), with columns time, -key-name (default
+// Series) and -value-name (default Value). empty values are left out
+
+
+package main
+
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"flag"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+
+func runPivot(args []string) {
+	fs := flag.NewFlagSet("pivot", flag.ExitOnError)
+	timecol := fs.String("time", "0", "timestamp column (name or index)")
+	keycol := fs.String("key", "1", "series name column (name or index)")
+	valuecol := fs.String("value", "2", "value column (name or index)")
+	series := fs.String("series", "", "comma separated series to output, streaming rather than reading all rows first")
+	commonFlags(fs, "a column per series")
+	fs.Parse(args)
+	infilenames = append(infilenames, fs.Args()...)
+	setupLogging()
+
+	slog.Debug("pivot long CSV rows to wide",
+		"inputs", infilenames, "output", outfilename, "time_column", *timecol, "key_column", *keycol,
+		"value_column", *valuecol, "series", *series)
+
+	infile := openInputs(context.Background(), infilenames, false, nil)
+	defer infile.Close()
+
+	oufl := createOutput(outfilename, "")
+	outfile := csv.NewWriter(bufio.NewWriter(oufl))
+
+	header, err := infile.Read()
+	if err != nil {
+		fatal("error reading header from csv", "err", err)
+	}
+	cols := make([]int, 3)
+	for i, col := range []string{*timecol, *keycol, *valuecol} {
+		if cols[i] = findColumn(header, col); cols[i] < 0 {
+			fatal("column not found in header", "column", col)
+		}
+	}
+	timename := header[cols[0]]
+
+	if *series != "" {
+		streamPivot(infile, outfile, cols, timename, strings.Split(*series, ","))
+	} else {
+		genPivot(infile, outfile, cols, timename)
+	}
+
+	outfile.Flush()
+	if err := outfile.Error(); err != nil {
+		fatal("error writing csv", "err", err)
+	}
+	if err := oufl.Close(); err != nil {
+		fatal("error closing destination csv", "err", err)
+	}
+}
+
+
+// read the next long format row's timestamp, series and value, from cols
+func readLongRow(incsv recordReader, cols []int, n int) (t, key, value string, ok bool) {
+	record, err := incsv.Read()
+	if err == io.EOF {
+		return "", "", "", false
+	}
+	if err != nil {
+		fatal("error reading record from csv", "err", err)
+	}
+	if verboseFlag {
+		slog.Debug("read record", "n", n, "record", record)
+	}
+	for _, c := range cols {
+		if c >= len(record) {
+			fatal("missing column in csv", "row", n+1)
+		}
+	}
+	return record[cols[0]], record[cols[1]], record[cols[2]], true
+}
+
+
+// write a wide row to outcsv
+func writeWideRow(outcsv *csv.Writer, outrec []string) {
+	if verboseFlag {
+		slog.Debug("write record", "record", outrec)
+	}
+	if err := outcsv.Write(outrec); err != nil {
+		fatal("error writing record to csv", "err", err)
+	}
+}
+
+
+// pivot all of incsv's rows, kept in memory, to outcsv
+func genPivot(incsv recordReader, outcsv *csv.Writer, cols []int, timename string) {
+	var times []string
+	values := make(map[string]map[string]string)
+	names := make(map[string]bool)
+	n := 0
+	for {
+		t, key, value, ok := readLongRow(incsv, cols, n)
+		if !ok {
+			break
+		}
+		row, found := values[t]
+		if !found {
+			row = make(map[string]string)
+			values[t] = row
+			times = append(times, t)
+		}
+		row[key] = value
+		names[key] = true
+		n++
+	}
+
+	series := make([]string, 0, len(names))
+	for name := range names {
+		series = append(series, name)
+	}
+	sort.Strings(series)
+	writeWideRow(outcsv, append([]string{timename}, series...))
+	for _, t := range times {
+		outrec := []string{t}
+		for _, name := range series {
+			outrec = append(outrec, values[t][name])
+		}
+		writeWideRow(outcsv, outrec)
+	}
+	slog.Debug("pivoted records", "records", n, "rows", len(times), "series", len(series))
+}
+
+
+// pivot incsv's rows of the given series to outcsv as they're read, a row
+// per run of rows with the same timestamp
+func streamPivot(incsv recordReader, outcsv *csv.Writer, cols []int, timename string, series []string) {
+	index := make(map[string]int)
+	for i, name := range series {
+		index[name] = i + 1
+	}
+	writeWideRow(outcsv, append([]string{timename}, series...))
+
+	outrec := make([]string, len(series)+1)
+	n, rows, started := 0, 0, false
+	for {
+		t, key, value, ok := readLongRow(incsv, cols, n)
+		if started && (!ok || t != outrec[0]) {
+			writeWideRow(outcsv, outrec)
+			rows++
+			clear(outrec)
+		}
+		if !ok {
+			break
+		}
+		outrec[0], started = t, true
+		if i, found := index[key]; found {
+			outrec[i] = value
+		}
+		n++
+	}
+	slog.Debug("pivoted records", "records", n, "rows", rows, "series", len(series))
+}
+
+
+func runUnpivot(args []string) {
+	fs := flag.NewFlagSet("unpivot", flag.ExitOnError)
+	timecol := fs.String("time", "3", "timestamp column (name or index)")
+	columns := fs.String("columns", "", "comma separated columns (names or indexes) to unpivot (default all but the time column)")
+	keyname := fs.String("key-name", "Series", "name of the output series name column")
+	valuename := fs.String("value-name", "Value", "name of the output value column")
+	commonFlags(fs, "a row per timestamp and series")
+	fs.Parse(args)
+	infilenames = append(infilenames, fs.Args()...)
+	setupLogging()
+
+	slog.Debug("unpivot wide CSV rows to long",
+		"inputs", infilenames, "output", outfilename, "time_column", *timecol, "columns", *columns)
+
+	infile := openInputs(context.Background(), infilenames, false, nil)
+	defer infile.Close()
+
+	oufl := createOutput(outfilename, "")
+	outfile := csv.NewWriter(bufio.NewWriter(oufl))
+
+	header, err := infile.Read()
+	if err != nil {
+		fatal("error reading header from csv", "err", err)
+	}
+	header = append([]string(nil), header...)
+	tcol := findColumn(header, *timecol)
+	if tcol < 0 {
+		fatal("time column not found in header", "column", *timecol)
+	}
+	var cols []int
+	if *columns == "" {
+		for i := range header {
+			if i != tcol {
+				cols = append(cols, i)
+			}
+		}
+	} else {
+		for _, col := range strings.Split(*columns, ",") {
+			i := findColumn(header, col)
+			if i < 0 {
+				fatal("column not found in header", "column", col)
+			}
+			cols = append(cols, i)
+		}
+	}
+
+	writeWideRow(outfile, []string{header[tcol], *keyname, *valuename})
+	n, rows := 0, 0
+	for {
+		record, err := infile.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fatal("error reading record from csv", "err", err)
+		}
+		if verboseFlag {
+			slog.Debug("read record", "n", n, "record", record)
+		}
+		for _, c := range cols {
+			if c < len(record) && record[c] != "" {
+				writeWideRow(outfile, []string{record[tcol], header[c], record[c]})
+				rows++
+			}
+		}
+		n++
+	}
+	slog.Debug("unpivoted records", "records", n, "rows", rows)
+
+	outfile.Flush()
+	if err := outfile.Error(); err != nil {
+		fatal("error writing csv", "err", err)
+	}
+	if err := oufl.Close(); err != nil {
+		fatal("error closing destination csv", "err", err)
+	}
+}
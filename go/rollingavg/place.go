@@ -0,0 +1,119 @@
+// place.go: placement of the appended output columns
+//
+// the columns rollavg appends to the input columns, the window statistics,
+// Result, and any -mode, -distinct or -count-above columns, see
+// windowcols.go, are output after the input columns unless -place puts
+// them elsewhere, as
+// out:after:col, out:before:col or out:replace:col, where out is the name
+// of an appended column and col an input column, by name or index, e.g.
+//     -place "Average A:after:X,Average B:after:Y,Result:replace:Z"
+// gives
+//     X,Average A,Y,Average B,Result,Time
+// columns placed after or before the same column are in the order given.
+// a replaced column isn't output, the column replacing it takes its place.
+// columns not placed keep their places at the end.
+// only the output is rearranged, -script, -alert rules and the like see
+// the columns in their usual places
+
+
+package main
+
+
+import (
+	"strings"
+)
+
+
+var placeSpec string
+
+
+// the order of the output columns, given as indexes of the usual output
+// columns, for an input header
+func placeColumns(header []string) []int {
+	outhdr := outputHeader(header)
+	n := len(header)
+	before := make([][]int, n)
+	after := make([][]int, n)
+	replaced := make([]int, n)
+	for i := range replaced {
+		replaced[i] = -1
+	}
+	placed := make(map[int]bool)
+	for _, item := range strings.Split(placeSpec, ",") {
+		parts := strings.Split(item, ":")
+		if len(parts) != 3 {
+			fatal("invalid column placement, expected out:after|before|replace:col", "place", item)
+		}
+		out := -1
+		for i := n; i < len(outhdr); i++ {
+			if outhdr[i] == strings.TrimSpace(parts[0]) {
+				out = i
+			}
+		}
+		col := findColumn(header, strings.TrimSpace(parts[2]))
+		switch {
+		case out < 0:
+			fatal("placed column isn't an appended output column", "column", parts[0], "appended", outhdr[n:])
+		case col < 0:
+			fatal("column to place by not found in header", "column", parts[2])
+		case placed[out]:
+			fatal("column placed more than once", "column", parts[0])
+		}
+		placed[out] = true
+		switch strings.TrimSpace(parts[1]) {
+		case "after":
+			after[col] = append(after[col], out)
+		case "before":
+			before[col] = append(before[col], out)
+		case "replace":
+			if replaced[col] >= 0 {
+				fatal("column replaced more than once", "column", parts[2])
+			}
+			replaced[col] = out
+		default:
+			fatal("invalid column placement, expected after, before or replace", "place", item)
+		}
+	}
+
+	order := make([]int, 0, len(outhdr))
+	for i := 0; i < n; i++ {
+		order = append(order, before[i]...)
+		if replaced[i] >= 0 {
+			order = append(order, replaced[i])
+		} else {
+			order = append(order, i)
+		}
+		order = append(order, after[i]...)
+	}
+	for i := n; i < len(outhdr); i++ {
+		if !placed[i] {
+			order = append(order, i)
+		}
+	}
+	return order
+}
+
+
+// an output whose columns are rearranged by -place
+type placedOutput struct {
+	recordWriteCloser
+	order []int
+}
+
+
+// set the order of the columns, from the input header
+func (o *placedOutput) setHeader(header []string) {
+	if o != nil {
+		o.order = placeColumns(header)
+	}
+}
+
+
+func (o *placedOutput) Write(record []string) error {
+	// outputs may keep the rows they're given, e.g. to batch them
+	outrec := make([]string, len(o.order))
+	for j, i := range o.order {
+		outrec[j] = field(record, i)
+	}
+	return o.recordWriteCloser.Write(outrec)
+}
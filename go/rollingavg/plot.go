@@ -0,0 +1,237 @@
+// plot.go: a chart of the raw and averaged series
+//
+// with -plot file.svg, a chart of the output rows is written at the end of
+// the run, alongside the CSV, with a panel for each of columns A and B,
+// plotting its raw values and their averages (or other -stat) against the
+// output row number. to keep the chart small for huge runs, at most 2000
+// rows are plotted, evenly spaced. with -group-by, the series are plotted
+// together in output order.
+// with -plot file.gp (or .gnuplot or .plt), a gnuplot script is written
+// instead, plotting the output CSV, which must then be a local file given
+// by -o, to a PNG of the same name, run as
+//     gnuplot file.gp
+// -plot can't be used with -batch or -watch
+
+
+package main
+
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+var plotfile string
+
+// the rows plotted in the current run, nil when not plotting an SVG
+var plot *plotRecorder
+
+// the most rows plotted
+const plotPoints = 2000
+
+
+// a row plotted, its raw A and B values and their statistics
+type plotPoint struct {
+	row          int
+	a, b         float64
+	avgA, avgB   float64
+}
+
+
+// records every stride'th output row
+type plotRecorder struct {
+	points []plotPoint
+	stride int
+	n      int
+}
+
+
+// whether -plot writes a gnuplot script
+func gnuplotScript() bool {
+	switch strings.ToLower(filepath.Ext(plotfile)) {
+	case ".gp", ".gnuplot", ".plt":
+		return true
+	}
+	return false
+}
+
+
+// check the -plot options, and start recording rows to plot
+func startPlot(outfilename string) {
+	if plotfile == "" {
+		return
+	}
+	switch {
+	case batchGlob != "" || watchDir != "":
+		fatal("-plot can't be used with -batch or -watch")
+	case gnuplotScript():
+		if outfilename == "" || isRemote(outfilename) || outputFormat != "csv" {
+			fatal("-plot with a gnuplot script requires a local CSV output file, -o")
+		}
+	case strings.ToLower(filepath.Ext(plotfile)) != ".svg":
+		fatal("-plot file must be .svg, or a .gp gnuplot script", "file", plotfile)
+	default:
+		plot = &plotRecorder{stride: 1}
+	}
+}
+
+
+// record an output row
+func (p *plotRecorder) rowWritten(r rollingavg.Result) {
+	if p == nil {
+		return
+	}
+	if p.n%p.stride == 0 {
+		a, _ := rollingavg.ParseFloat(r.Record[0])
+		b, _ := rollingavg.ParseFloat(r.Record[1])
+		p.points = append(p.points, plotPoint{p.n, a, b, r.AvgA, r.AvgB})
+		// halve the rows kept, and keep half as many from now on
+		if len(p.points) >= 2*plotPoints {
+			for i := range p.points[:plotPoints] {
+				p.points[i] = p.points[2*i]
+			}
+			p.points = p.points[:plotPoints]
+			p.stride *= 2
+		}
+	}
+	p.n++
+}
+
+
+// write the chart or gnuplot script of a run with the given output header,
+// and order of the output columns, nil for as usual, see place.go, to -plot
+func writePlot(outheader []string, order []int, outfilename string) {
+	if plotfile == "" {
+		return
+	}
+	fl, err := os.Create(plotfile)
+	if err != nil {
+		fatal("error creating plot", "err", err)
+	}
+	w := bufio.NewWriter(fl)
+	if gnuplotScript() {
+		writeGnuplot(w, outheader, order, outfilename)
+	} else {
+		plot.writeSVG(w, outheader)
+	}
+	if err := w.Flush(); err != nil {
+		fatal("error writing plot", "err", err)
+	}
+	if err := fl.Close(); err != nil {
+		fatal("error closing plot", "err", err)
+	}
+	plot = nil
+}
+
+
+// write a gnuplot script plotting the output CSV to a PNG
+func writeGnuplot(w io.Writer, outheader []string, order []int, outfilename string) {
+	png := strings.TrimSuffix(plotfile, filepath.Ext(plotfile)) + ".png"
+	// the gnuplot column number of an output column, or 0 if replaced
+	column := func(i int) int {
+		if order == nil {
+			return i + 1
+		}
+		return slices.Index(order, i) + 1
+	}
+	// the statistic columns are before Result, at the end unless placed
+	avgA, avgB := len(outheader)-3, len(outheader)-2
+	fmt.Fprintf(w, "# plot of %s, written by rollingavg -plot\n", outfilename)
+	fmt.Fprintf(w, "set terminal pngcairo size 1200,800\n")
+	fmt.Fprintf(w, "set output %q\n", png)
+	fmt.Fprintf(w, "set datafile separator \",\"\n")
+	fmt.Fprintf(w, "set key outside top center horizontal\n")
+	fmt.Fprintf(w, "set xlabel \"row\"\n")
+	fmt.Fprintf(w, "set multiplot layout 2,1\n")
+	for i, col := range []int{avgA, avgB} {
+		if raw := column(i); raw > 0 {
+			fmt.Fprintf(w, "plot %q every ::1 using 0:%d with lines lc rgb \"#bbbbbb\" title %q, \\\n",
+				outfilename, raw, outheader[i])
+			fmt.Fprintf(w, "     '' every ::1 using 0:%d with lines lw 2 title %q\n", column(col), outheader[col])
+		} else {
+			fmt.Fprintf(w, "plot %q every ::1 using 0:%d with lines lw 2 title %q\n", outfilename, column(col), outheader[col])
+		}
+	}
+	fmt.Fprintf(w, "unset multiplot\n")
+}
+
+
+// write the recorded rows as an SVG chart
+func (p *plotRecorder) writeSVG(w io.Writer, outheader []string) {
+	const width, height, margin = 1000, 300, 60
+	avgA, avgB := outheader[len(outheader)-3], outheader[len(outheader)-2]
+	fmt.Fprintf(w, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" font-family=\"sans-serif\" font-size=\"12\">\n",
+		width, 2*height)
+	fmt.Fprintf(w, "<rect width=\"100%%\" height=\"100%%\" fill=\"white\"/>\n")
+
+	panels := []struct {
+		raw, avg string
+		value    func(pt plotPoint) (float64, float64)
+	}{
+		{outheader[0], avgA, func(pt plotPoint) (float64, float64) { return pt.a, pt.avgA }},
+		{outheader[1], avgB, func(pt plotPoint) (float64, float64) { return pt.b, pt.avgB }},
+	}
+	last := max(p.n-1, 1)
+	for i, panel := range panels {
+		top := i * height
+		lo, hi := math.Inf(1), math.Inf(-1)
+		for _, pt := range p.points {
+			raw, avg := panel.value(pt)
+			lo, hi = math.Min(lo, math.Min(raw, avg)), math.Max(hi, math.Max(raw, avg))
+		}
+		if len(p.points) == 0 {
+			lo, hi = 0, 1
+		} else if hi == lo {
+			lo, hi = lo-1, hi+1
+		}
+		x := func(row int) float64 {
+			return margin + float64(row)/float64(last)*(width-2*margin)
+		}
+		y := func(v float64) float64 {
+			return float64(top) + margin/2 + (hi-v)/(hi-lo)*(height-margin)
+		}
+
+		// axes, with the value range and row count
+		fmt.Fprintf(w, "<line x1=\"%d\" y1=\"%g\" x2=\"%d\" y2=\"%g\" stroke=\"black\"/>\n",
+			margin, y(lo), width-margin, y(lo))
+		fmt.Fprintf(w, "<line x1=\"%d\" y1=\"%g\" x2=\"%d\" y2=\"%g\" stroke=\"black\"/>\n",
+			margin, y(hi), margin, y(lo))
+		fmt.Fprintf(w, "<text x=\"%d\" y=\"%g\" text-anchor=\"end\">%.4g</text>\n", margin-4, y(hi)+4, hi)
+		fmt.Fprintf(w, "<text x=\"%d\" y=\"%g\" text-anchor=\"end\">%.4g</text>\n", margin-4, y(lo)+4, lo)
+		fmt.Fprintf(w, "<text x=\"%d\" y=\"%g\" text-anchor=\"end\">row %d</text>\n", width-margin, y(lo)+16, p.n)
+
+		for j, series := range []struct {
+			name, color string
+			avg         bool
+		}{{panel.raw, "#bbbbbb", false}, {panel.avg, "#1f77b4", true}} {
+			fmt.Fprintf(w, "<polyline fill=\"none\" stroke=\"%s\" stroke-width=\"%d\" points=\"", series.color, j+1)
+			for _, pt := range p.points {
+				raw, avg := panel.value(pt)
+				v := raw
+				if series.avg {
+					v = avg
+				}
+				fmt.Fprintf(w, "%.1f,%.1f ", x(pt.row), y(v))
+			}
+			fmt.Fprintf(w, "\"/>\n")
+			fmt.Fprintf(w, "<text x=\"%d\" y=\"%d\" fill=\"%s\">%s</text>\n",
+				margin+200*j, top+16, series.color, svgEscape(series.name))
+		}
+	}
+	fmt.Fprintf(w, "</svg>\n")
+}
+
+
+// escape text for SVG
+func svgEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
@@ -0,0 +1,34 @@
+// plugins.go: load user-defined aggregators and rules from Go plugins
+//
+// with -plugin, a Go plugin (.so) is loaded before processing, so that
+// site-specific window statistics and Result rules can be used without
+// being upstreamed. a plugin is a main package built with
+//     go build -buildmode=plugin -o myrules.so ./myrules
+// against the same rollingavg package version, which registers its
+// aggregators and rules from its init function, e.g.
+//     func init() {
+//         rollingavg.RegisterRule("spike", func(a, b float64) string { ... })
+//         rollingavg.RegisterAggregator("p90", newP90)
+//     }
+// these are then selected by name with -stat and -rule.
+// Go plugins are only supported on Linux, FreeBSD and macOS, with cgo
+
+
+package main
+
+
+import (
+	"log/slog"
+	"plugin"
+)
+
+
+// load each plugin, registering its aggregators and rules
+func loadPlugins(filenames []string) {
+	for _, filename := range filenames {
+		slog.Debug("load plugin", "file", filename)
+		if _, err := plugin.Open(filename); err != nil {
+			fatal("error loading plugin", "err", err)
+		}
+	}
+}
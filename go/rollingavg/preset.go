@@ -0,0 +1,63 @@
+// preset.go: named pipeline presets
+//
+// pipeline configurations that are run often can be kept as named presets,
+// YAML -config files, see config.go, in the user's config directory,
+//     $XDG_CONFIG_HOME/rollingavg/presets/name.yaml
+// (~/.config on Linux, ~/Library/Application Support on macOS, %AppData%
+// on Windows), or -preset-dir, and run by name, e.g.
+//     rollingavg -preset daily-qa -o today.csv today.csv
+// flags given on the command line, or by environment variables, override
+// the preset's settings for the run, as for -config, which can't be given
+// as well. an unknown preset lists those there are
+
+
+package main
+
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+
+var presetName string
+var presetDir string
+
+
+// the directory of the presets, -preset-dir or in the user config directory
+func presetsDir() string {
+	if presetDir != "" {
+		return presetDir
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		fatal("no user config directory for presets, give -preset-dir", "err", err)
+	}
+	return filepath.Join(dir, "rollingavg", "presets")
+}
+
+
+// the config file of the named preset
+func presetFile(name string) string {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		fatal("invalid preset name", "preset", name)
+	}
+	dir := presetsDir()
+	filename := filepath.Join(dir, name+".yaml")
+	if _, err := os.Stat(filename); err != nil {
+		fatal("preset not found", "preset", name, "dir", dir, "presets", presetNames(dir))
+	}
+	return filename
+}
+
+
+// the names of the presets in dir
+func presetNames(dir string) []string {
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	names := make([]string, len(matches))
+	for i, m := range matches {
+		names[i] = strings.TrimSuffix(filepath.Base(m), ".yaml")
+	}
+	return names
+}
@@ -0,0 +1,99 @@
+// printconfig.go: dump of the effective configuration
+//
+// settings come from command line flags, ROLLAVG_* environment variables,
+// see env.go, the -config file, see config.go, and the flag defaults, in
+// that order of precedence. for reproducibility, -print-config writes the
+// fully resolved settings, commented with where each came from, as a YAML
+// -config file, and exits, e.g.
+//     inputs: [jan.csv] # command line
+//     flags:
+//       n: "50" # environment ROLLAVG_N
+//       stat: median # config file
+//       time: "3" # default
+// repeated flags other than -f and -plugin, e.g. -mqtt-topic, are written
+// comma separated, and passwords and tokens are hidden
+
+
+package main
+
+
+import (
+	"flag"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+
+var printConfigFlag bool
+
+
+// flags whose values aren't printed
+var secretFlags = map[string]bool{"smtp-password": true, "auth-token": true, "auth-basic": true, "broker-password": true}
+
+
+// write the effective configuration to stdout, given the flags set by
+// the environment, or on the command line
+func printConfig(fs *flag.FlagSet, envFlags map[string]bool) {
+	given := givenFlags(fs)
+	source := func(name string) string {
+		switch {
+		case cmdlineFlags[name]:
+			return "command line"
+		case envFlags[name]:
+			return "environment " + envName(name)
+		case given[name]:
+			return "config file"
+		}
+		return "default"
+	}
+
+	// config file inputs are added without setting -f
+	inputsFrom := source("f")
+	switch {
+	case len(fs.Args()) > 0:
+		inputsFrom = "command line"
+	case len(infilenames) == 0:
+		inputsFrom = "stdin"
+	case inputsFrom == "default":
+		inputsFrom = "config file"
+	}
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+	add := func(m *yaml.Node, key string, value *yaml.Node, comment string) {
+		value.LineComment = comment
+		m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+	}
+	list := func(values []string) *yaml.Node {
+		n := &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+		for _, v := range values {
+			n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: v})
+		}
+		return n
+	}
+	add(doc, "inputs", list(infilenames), inputsFrom)
+	add(doc, "plugins", list(pluginFiles), source("plugin"))
+
+	flags := &yaml.Node{Kind: yaml.MappingNode}
+	fs.VisitAll(func(f *flag.Flag) {
+		switch f.Name {
+		case "f", "plugin", "config", "print-config":
+			return
+		}
+		value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: f.Value.String()}
+		comment := source(f.Name)
+		if secretFlags[f.Name] && value.Value != "" {
+			value.Value = strings.Repeat("*", 8)
+			comment += ", hidden"
+		}
+		add(flags, f.Name, value, comment)
+	})
+	add(doc, "flags", flags, "")
+
+	enc := yaml.NewEncoder(os.Stdout)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		fatal("error writing config", "err", err)
+	}
+	enc.Close()
+}
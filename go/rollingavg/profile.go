@@ -0,0 +1,80 @@
+// profile.go: CPU and memory profiling
+//
+// to diagnose performance on large inputs without rebuilding:
+//   - -cpuprofile writes a CPU profile of the run to a file
+//   - -memprofile writes a heap profile to a file at the end of the run
+//   - -pprof-addr serves the net/http/pprof endpoints on /debug/pprof/,
+//     for long running follow, daemon and server modes, which
+//     -cpuprofile and -memprofile, written when the run ends, don't suit
+// profiles are read with go tool pprof, e.g.
+//     rollingavg -cpuprofile cpu.prof -o out.csv big.csv
+//     go tool pprof -top rollingavg cpu.prof
+//     go tool pprof http://localhost:6060/debug/pprof/heap
+
+
+package main
+
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	rpprof "runtime/pprof"
+)
+
+
+var cpuProfile *os.File
+
+
+// start CPU profiling to cpufile, and serving pprof on addr, if given
+func startProfiling(cpufile string, addr string) {
+	if cpufile != "" {
+		fl, err := os.Create(cpufile)
+		if err != nil {
+			fatal("error creating CPU profile", "err", err)
+		}
+		if err := rpprof.StartCPUProfile(fl); err != nil {
+			fatal("error starting CPU profile", "err", err)
+		}
+		cpuProfile = fl
+	}
+
+	if addr != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		go func() {
+			fatal("pprof listener failed", "err", http.ListenAndServe(addr, mux))
+		}()
+	}
+}
+
+
+// stop CPU profiling, and write the heap profile to memfile, if given
+func stopProfiling(memfile string) {
+	if cpuProfile != nil {
+		rpprof.StopCPUProfile()
+		if err := cpuProfile.Close(); err != nil {
+			slog.Error("error writing CPU profile", "err", err)
+		}
+		cpuProfile = nil
+	}
+
+	if memfile != "" {
+		fl, err := os.Create(memfile)
+		if err != nil {
+			slog.Error("error creating memory profile", "err", err)
+			return
+		}
+		defer fl.Close()
+		runtime.GC() // for up to date heap statistics
+		if err := rpprof.WriteHeapProfile(fl); err != nil {
+			slog.Error("error writing memory profile", "err", err)
+		}
+	}
+}
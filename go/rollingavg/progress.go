@@ -0,0 +1,167 @@
+// progress.go: periodic progress reports of long runs
+//
+// with -progress, every -progress-every (default 5s) a run logs, at info
+// level to stderr, the records read so far, the rate of the last period
+// in rows/sec, the input bytes read, and when the total size of the inputs
+// is known, the percentage read and an estimate of the time remaining, e.g.
+//     msg=progress rows=1843000 rows_per_sec=368600 bytes=97.3MiB total=1.2GiB done=7.9% eta=58s
+// a final report with the average rate is logged at the end of the run.
+// bytes are counted as read from the input files, before decompression,
+// so the ETA holds for compressed inputs. parquet inputs, read by column,
+// aren't counted. the total is only known when every input is a local
+// CSV or xlsx file, and not followed; ETA is then the time to read the
+// remaining bytes at the average rate so far
+
+
+package main
+
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+
+var progressFlag bool
+var progressEvery time.Duration
+
+// the progress of the current run, nil when not reporting
+var progress *progressReporter
+
+
+type progressReporter struct {
+	rows  atomic.Int64
+	bytes atomic.Int64
+	total int64 // bytes, or 0 if unknown
+	start time.Time
+	done  chan struct{}
+}
+
+
+// start reporting the progress of a run over the named inputs
+func startProgress(filenames []string) {
+	if progressEvery <= 0 {
+		fatal("invalid progress interval", "every", progressEvery)
+	}
+	p := &progressReporter{total: inputSize(filenames), start: time.Now(), done: make(chan struct{})}
+	progress = p
+	go p.report()
+}
+
+
+// the total size of the inputs, or 0 if any isn't a local CSV or xlsx
+// file, parquet files being read by column rather than in full
+func inputSize(filenames []string) int64 {
+	if len(filenames) == 0 || followFlag {
+		return 0
+	}
+	var total int64
+	for _, filename := range filenames {
+		if scheme, _, _ := splitRemote(filename); scheme != "" || isParquet(filename) {
+			return 0
+		}
+		fi, err := os.Stat(filename)
+		if err != nil {
+			return 0
+		}
+		total += fi.Size()
+	}
+	return total
+}
+
+
+// log a report every interval until stopped
+func (p *progressReporter) report() {
+	ticker := time.NewTicker(progressEvery)
+	defer ticker.Stop()
+	lastRows, last := int64(0), p.start
+	for {
+		select {
+		case <-p.done:
+			return
+		case now := <-ticker.C:
+			rows := p.rows.Load()
+			rate := float64(rows-lastRows) / now.Sub(last).Seconds()
+			p.log("progress", rows, rate, now)
+			lastRows, last = rows, now
+		}
+	}
+}
+
+
+// log the rows, rate and bytes read, and the ETA if the total is known
+func (p *progressReporter) log(msg string, rows int64, rate float64, now time.Time) {
+	bytes := p.bytes.Load()
+	args := []any{"rows", rows, "rows_per_sec", int64(rate), "bytes", formatBytes(bytes)}
+	if p.total > 0 {
+		args = append(args, "total", formatBytes(p.total), "done", fmt.Sprintf("%.1f%%", 100*float64(bytes)/float64(p.total)))
+		if elapsed := now.Sub(p.start); bytes > 0 && bytes < p.total {
+			eta := time.Duration(float64(elapsed) * float64(p.total-bytes) / float64(bytes))
+			args = append(args, "eta", eta.Round(time.Second))
+		}
+	}
+	slog.Info(msg, args...)
+}
+
+
+// stop reporting, and log the final report of the run
+func (p *progressReporter) stop() {
+	if p == nil {
+		return
+	}
+	close(p.done)
+	progress = nil
+	now := time.Now()
+	rows := p.rows.Load()
+	p.log("progress finished", rows, float64(rows)/now.Sub(p.start).Seconds(), now)
+}
+
+
+// count a record read
+func (p *progressReporter) rowRead() {
+	if p == nil {
+		return
+	}
+	p.rows.Add(1)
+}
+
+
+// count the bytes read from r, if reporting progress
+func countBytes(r io.Reader) io.Reader {
+	if progress == nil {
+		return r
+	}
+	return &countingReader{r, &progress.bytes}
+}
+
+
+// counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+
+// a byte count in binary units, e.g. 1.5GiB
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,154 @@
+// provenance.go: provenance metadata of output CSVs
+//
+// with -provenance comment, each output CSV starts with a block of comment
+// lines, before its header, recording how it was made, e.g.
+//     # rollingavg provenance
+//     # version: 0.1
+//     # command: rollingavg -n 50 -o out.csv in.csv
+//     # inputs: in.csv
+//     # output: out.csv
+//     # started: 2026-01-02T03:04:05Z
+//     X,Y,Z,Time,Average A,Average B,Result
+// which CSV readers skip with '#' as their comment character, e.g. pandas'
+// read_csv(comment="#"), and rollingavg's own readers skip when an input
+// starts with the block, see ragged.go. the block can't be added to an
+// appended file, so -append can't be used. with -provenance sidecar, it's
+// instead written as JSON to a file named for the output, with
+// .provenance.json appended, once the output is complete, also recording
+// when it finished, e.g.
+//     {"tool":"rollingavg","version":"0.1","command":["rollingavg","-n","50",
+//      "-o","out.csv","in.csv"],"inputs":["in.csv"],"output":"out.csv",
+//      "started":"2026-01-02T03:04:05Z","finished":"2026-01-02T03:04:07Z"}
+// stdout has no sidecar. with rotation or splitting, each file has its own
+// provenance, and with -batch or -watch, each output that of its input.
+// the values of passwords and tokens in the command are hidden
+
+
+package main
+
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+
+var provenanceMode string
+
+// the first line of a provenance comment block
+const provenanceMark = commentMark + "provenance"
+
+
+// the provenance of the outputs of a run
+type provenance struct {
+	Tool     string    `json:"tool"`
+	Version  string    `json:"version"`
+	Command  []string  `json:"command"`
+	Inputs   []string  `json:"inputs"`
+	Output   string    `json:"output"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+}
+
+
+// check that the -provenance mode is valid with the current options
+func checkProvenance() {
+	switch provenanceMode {
+	case "", "sidecar":
+	case "comment":
+		if appendFlag {
+			fatal("-provenance comment can't be used with -append")
+		}
+	default:
+		fatal("invalid provenance, expected comment or sidecar", "provenance", provenanceMode)
+	}
+}
+
+
+// the provenance of the outputs of a run of the inputs, nil without
+// -provenance
+func newProvenance(infilenames []string) *provenance {
+	if provenanceMode == "" {
+		return nil
+	}
+	return &provenance{
+		Tool:    "rollingavg",
+		Version: APP_VERSION,
+		Command: provenanceCommand(),
+		Inputs:  infilenames,
+		Started: time.Now(),
+	}
+}
+
+
+// the command line, hiding the values of secret flags
+func provenanceCommand() []string {
+	args := append([]string(nil), os.Args...)
+	hide := false
+	for i, arg := range args {
+		if hide {
+			args[i], hide = strings.Repeat("*", 8), false
+			continue
+		}
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		name, _, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !secretFlags[name] {
+			continue
+		}
+		if hasValue {
+			args[i] = arg[:strings.Index(arg, "=")+1] + strings.Repeat("*", 8)
+		} else {
+			hide = true
+		}
+	}
+	return args
+}
+
+
+// write the comment block, with -provenance comment, to the output file
+// before its header
+func (p *provenance) writeComment(w io.Writer, outfilename string) {
+	if p == nil || provenanceMode != "comment" {
+		return
+	}
+	if outfilename == "" {
+		outfilename = "-"
+	}
+	_, err := fmt.Fprintf(w, "%s\n# version: %s\n# command: %s\n# inputs: %s\n# output: %s\n# started: %s\n",
+		provenanceMark, p.Version, strings.Join(p.Command, " "), strings.Join(p.Inputs, " "),
+		outfilename, p.Started.UTC().Format(time.RFC3339))
+	if err != nil {
+		fatal("error writing provenance", "err", err)
+	}
+}
+
+
+// write the sidecar, with -provenance sidecar, once the output file is
+// complete
+func (p *provenance) writeSidecar(outfilename string) error {
+	if p == nil || provenanceMode != "sidecar" || outfilename == "" {
+		return nil
+	}
+	side := *p
+	side.Output = outfilename
+	side.Finished = time.Now()
+	data, err := json.Marshal(side)
+	if err != nil {
+		return err
+	}
+	fl, err := createDest(outfilename + ".provenance.json")
+	if err != nil {
+		return err
+	}
+	if _, err := fl.Write(append(data, '\n')); err != nil {
+		fl.Close()
+		return err
+	}
+	return fl.Close()
+}
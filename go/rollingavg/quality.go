@@ -0,0 +1,299 @@
+// quality.go: a data-quality report of CSV rows
+//
+// invoked as the quality subcommand, see commands.go:
+//     mdp quality [-v] [-format csv|json] [-time col] [-max-gap duration]
+//         [-range col:min:max,...] [-f inputfile]... [-o outputfile] [inputfile...]
+// the rows are read, without being modified, and the problems found in
+// each column are counted:
+//     missing         empty values
+//     parse failures  values that aren't numbers, in columns numeric in the
+//                     first row, or timestamps, in the -time column
+//     out of order    timestamps before the one in the row before
+//     gaps            timestamps more than -max-gap (default 1h) after the
+//                     one in the row before
+//     duplicates      timestamps the same as the one in the row before, and
+//                     for the row as a whole, column *, rows the same as an
+//                     earlier row
+//     out of range    values outside the -range given for the column
+// with the row number of the first problem in each column.
+// the report is a CSV of a row per column, or with -format json, a JSON
+// object, which also lists the first 100 gaps
+
+
+package main
+
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+// the maximum number of gaps listed in a JSON report
+const maxReportedGaps = 100
+
+
+// the problems found in a column
+type columnQuality struct {
+	Column        string `json:"column"`
+	Missing       int    `json:"missing"`
+	ParseFailures int    `json:"parse_failures"`
+	OutOfOrder    int    `json:"out_of_order"`
+	Gaps          int    `json:"gaps"`
+	Duplicates    int    `json:"duplicates"`
+	OutOfRange    int    `json:"out_of_range"`
+	FirstRow      int    `json:"first_row,omitempty"` // of the first problem, from 1
+
+	numeric bool
+	rng     *valueRange
+}
+
+
+// a gap between timestamps
+type qualityGap struct {
+	Row  int    `json:"row"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+
+// the report of all the columns
+type qualityReport struct {
+	Rows    int              `json:"rows"`
+	Columns []*columnQuality `json:"columns"`
+	Gaps    []qualityGap     `json:"gaps"`
+}
+
+
+// the range a column's values should be in
+type valueRange struct {
+	min, max float64
+}
+
+
+// count a problem in row n
+func (c *columnQuality) problem(count *int, n int) {
+	*count++
+	if c.FirstRow == 0 {
+		c.FirstRow = n
+	}
+}
+
+
+func runQuality(args []string) {
+	fs := flag.NewFlagSet("quality", flag.ExitOnError)
+	format := fs.String("format", "csv", "report format: csv or json")
+	timecol := fs.String("time", "3", "timestamp column (name or index), or \"\" for none")
+	maxGap := fs.Duration("max-gap", time.Hour, "the longest time between consecutive rows not reported as a gap")
+	ranges := fs.String("range", "", "comma separated col:min:max ranges of valid values")
+	commonFlags(fs, "the report")
+	fs.Parse(args)
+	infilenames = append(infilenames, fs.Args()...)
+	setupLogging()
+
+	if *format != "csv" && *format != "json" {
+		fatal("invalid report format", "format", *format)
+	}
+
+	slog.Debug("report on the quality of CSV rows",
+		"inputs", infilenames, "output", outfilename, "time_column", *timecol, "max_gap", *maxGap, "ranges", *ranges)
+
+	infile := openInputs(context.Background(), infilenames, false, nil)
+	defer infile.Close()
+
+	header, err := infile.Read()
+	if err != nil {
+		fatal("error reading header from csv", "err", err)
+	}
+	header = append([]string(nil), header...)
+
+	report := &qualityReport{Gaps: []qualityGap{}}
+	for _, name := range header {
+		report.Columns = append(report.Columns, &columnQuality{Column: name})
+	}
+	tcol := -1
+	if *timecol != "" {
+		if tcol = findColumn(header, *timecol); tcol < 0 {
+			fatal("time column not found in header", "column", *timecol)
+		}
+	}
+	if *ranges != "" {
+		for _, r := range strings.Split(*ranges, ",") {
+			col, rng := parseRange(header, r)
+			report.Columns[col].rng = rng
+		}
+	}
+
+	checkQuality(infile, report, tcol, *maxGap)
+
+	oufl := createOutput(outfilename, "")
+	w := bufio.NewWriter(oufl)
+	if *format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	} else {
+		err = writeQualityCSV(w, report)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		fatal("error writing report", "err", err)
+	}
+	if err := oufl.Close(); err != nil {
+		fatal("error closing report", "err", err)
+	}
+}
+
+
+// parse a col:min:max range, of a column of header
+func parseRange(header []string, s string) (int, *valueRange) {
+	i := strings.LastIndex(s, ":")
+	j := strings.LastIndex(s[:max(i, 0)], ":")
+	if j < 0 {
+		fatal("invalid range, expected col:min:max", "range", s)
+	}
+	col := findColumn(header, s[:j])
+	if col < 0 {
+		fatal("range column not found in header", "column", s[:j])
+	}
+	rng := &valueRange{math.Inf(-1), math.Inf(1)}
+	var err error
+	// either bound may be left empty
+	if s[j+1:i] != "" {
+		if rng.min, err = strconv.ParseFloat(s[j+1:i], 64); err != nil {
+			fatal("invalid range minimum", "range", s)
+		}
+	}
+	if s[i+1:] != "" {
+		if rng.max, err = strconv.ParseFloat(s[i+1:], 64); err != nil {
+			fatal("invalid range maximum", "range", s)
+		}
+	}
+	return col, rng
+}
+
+
+// count the problems of incsv's rows in the report. tcol is the timestamp
+// column, or -1 if none
+func checkQuality(incsv recordReader, report *qualityReport, tcol int, maxGap time.Duration) {
+	cols := report.Columns
+	rows := &columnQuality{Column: "*"}
+	seen := make(map[uint64]struct{})
+	var prev time.Time
+	prevRaw := ""
+
+	n := 0
+	for {
+		record, err := incsv.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fatal("error reading record from csv", "err", err)
+		}
+		n++
+		if verboseFlag {
+			slog.Debug("read record", "n", n, "record", record)
+		}
+
+		// numeric columns are those that are numbers in the first row
+		if n == 1 {
+			for i, v := range record {
+				if _, err := rollingavg.ParseFloat(v); i < len(cols) && i != tcol && err == nil {
+					cols[i].numeric = true
+				}
+			}
+		}
+
+		// rows are compared by hash, to save keeping them all
+		h := fnv.New64a()
+		for _, v := range record {
+			h.Write([]byte(v))
+			h.Write([]byte{0})
+		}
+		if _, found := seen[h.Sum64()]; found {
+			rows.problem(&rows.Duplicates, n)
+		} else {
+			seen[h.Sum64()] = struct{}{}
+		}
+
+		for i, v := range record {
+			if i >= len(cols) {
+				break
+			}
+			c := cols[i]
+			if v == "" {
+				c.problem(&c.Missing, n)
+				continue
+			}
+			if i == tcol {
+				t, err := rollingavg.ParseTime(v)
+				if err != nil {
+					c.problem(&c.ParseFailures, n)
+					continue
+				}
+				if !prev.IsZero() {
+					switch {
+					case t.Before(prev):
+						c.problem(&c.OutOfOrder, n)
+					case t.Equal(prev):
+						c.problem(&c.Duplicates, n)
+					case t.Sub(prev) > maxGap:
+						c.problem(&c.Gaps, n)
+						if len(report.Gaps) < maxReportedGaps {
+							report.Gaps = append(report.Gaps, qualityGap{n, prevRaw, v})
+						}
+					}
+				}
+				prev, prevRaw = t, v
+				continue
+			}
+			if !c.numeric && c.rng == nil {
+				continue
+			}
+			f, err := rollingavg.ParseFloat(v)
+			if err != nil {
+				c.problem(&c.ParseFailures, n)
+				continue
+			}
+			if c.rng != nil && (f < c.rng.min || f > c.rng.max) {
+				c.problem(&c.OutOfRange, n)
+			}
+		}
+	}
+	report.Rows = n
+	report.Columns = append(report.Columns, rows)
+	slog.Debug("checked records", "records", n, "duplicate_rows", rows.Duplicates)
+}
+
+
+// write the report as a CSV of a row per column
+func writeQualityCSV(w io.Writer, report *qualityReport) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"Column", "Missing", "ParseFailures", "OutOfOrder", "Gaps", "Duplicates", "OutOfRange", "FirstRow"})
+	for _, c := range report.Columns {
+		first := ""
+		if c.FirstRow > 0 {
+			first = strconv.Itoa(c.FirstRow)
+		}
+		out.Write([]string{c.Column, strconv.Itoa(c.Missing), strconv.Itoa(c.ParseFailures),
+			strconv.Itoa(c.OutOfOrder), strconv.Itoa(c.Gaps), strconv.Itoa(c.Duplicates),
+			strconv.Itoa(c.OutOfRange), first})
+	}
+	out.Flush()
+	return out.Error()
+}
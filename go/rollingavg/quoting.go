@@ -0,0 +1,122 @@
+// quoting.go: quoting and line endings of CSV output
+//
+// CSV output fields are quoted only as needed, as encoding/csv does, and
+// lines end with LF. for tools that expect otherwise, e.g. on Windows,
+// -quote selects the fields quoted:
+//     minimal     only those containing commas, quotes or line breaks, or
+//                 starting with a space (the default)
+//     all         every field
+//     nonnumeric  fields that aren't numbers, as well as those needing it,
+//                 so empty fields aren't quoted
+//     none        no fields, writing a field that needs quoting fails
+// and -crlf ends lines with CRLF.
+// these apply to the CSV output files, including rotated, split and
+// appended outputs, not to subcommand outputs
+
+
+package main
+
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+var quoteMode string
+var crlfFlag bool
+
+
+// check that -quote is valid
+func checkQuoting() {
+	switch quoteMode {
+	case "minimal", "all", "nonnumeric", "none":
+	default:
+		fatal("invalid quoting", "quote", quoteMode)
+	}
+}
+
+
+// a CSV writer to w, quoting fields by -quote and ending lines by -crlf
+func newCSVWriter(w io.Writer) recordWriter {
+	if quoteMode == "" || quoteMode == "minimal" {
+		cw := csv.NewWriter(w)
+		cw.UseCRLF = crlfFlag
+		return cw
+	}
+	bw, ok := w.(*bufio.Writer)
+	if !ok {
+		bw = bufio.NewWriter(w)
+	}
+	return &quotingWriter{w: bw, quote: quoteMode, crlf: crlfFlag}
+}
+
+
+// a CSV writer quoting all fields, non-numeric fields, or none
+type quotingWriter struct {
+	w     *bufio.Writer
+	quote string
+	crlf  bool
+	err   error
+}
+
+
+// whether a field must be quoted to be read back as it is
+func fieldNeedsQuotes(field string) bool {
+	return strings.ContainsAny(field, ",\"\r\n") || field != "" && (field[0] == ' ' || field[0] == '\t')
+}
+
+
+func (q *quotingWriter) Write(record []string) error {
+	if q.err != nil {
+		return q.err
+	}
+	for i, field := range record {
+		if i > 0 {
+			q.w.WriteByte(',')
+		}
+		quoted := fieldNeedsQuotes(field)
+		switch q.quote {
+		case "all":
+			quoted = true
+		case "nonnumeric":
+			if _, err := rollingavg.ParseFloat(field); err != nil && field != "" {
+				quoted = true
+			}
+		case "none":
+			if quoted {
+				q.err = errors.New("field needs quoting, with -quote none: " + field)
+				return q.err
+			}
+		}
+		if quoted {
+			q.w.WriteByte('"')
+			q.w.WriteString(strings.ReplaceAll(field, `"`, `""`))
+			q.w.WriteByte('"')
+		} else {
+			q.w.WriteString(field)
+		}
+	}
+	if q.crlf {
+		q.w.WriteByte('\r')
+	}
+	_, err := q.w.WriteString("\n")
+	return err
+}
+
+
+func (q *quotingWriter) Flush() {
+	if err := q.w.Flush(); err != nil && q.err == nil {
+		q.err = err
+	}
+}
+
+
+func (q *quotingWriter) Error() error {
+	return q.err
+}
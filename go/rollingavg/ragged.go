@@ -0,0 +1,66 @@
+// ragged.go: tolerance of malformed CSV inputs
+//
+// CSV inputs must be well formed by default, and reading stops at the
+// first row with a stray quote, or a different number of fields to the
+// header. for slightly ragged real-world files, -lazy-quotes allows quotes
+// in unquoted fields, and non-doubled quotes in quoted fields, and -ragged
+// allows rows of any number of fields, padding short rows with empty
+// fields and truncating long rows to the header's width, e.g. with the
+// header X,Y,Z,Time
+//     1,2,3           is read as 1,2,3,
+//     1,2,3,t1,extra  is read as 1,2,3,t1
+// these apply to CSV inputs, not parquet or xlsx.
+// inputs starting with the comment lines of -provenance, see provenance.go,
+// or -column-stats, see colstats.go, are read skipping the lines starting
+// with #
+
+
+package main
+
+
+import (
+	"bufio"
+	"encoding/csv"
+	"io"
+	"log/slog"
+)
+
+
+var lazyQuotes bool
+var raggedRows bool
+
+// the start of the comment lines of output CSVs
+const commentMark = "# rollingavg "
+
+
+// a CSV reader of r, as lenient as -lazy-quotes and -ragged allow
+func newCSVReader(r io.Reader) *csv.Reader {
+	// the csv.Reader reads through br, so its offsets include what's skipped
+	br := bufio.NewReader(r)
+	cr := csv.NewReader(br)
+	if mark, _ := br.Peek(len(commentMark)); string(mark) == commentMark {
+		cr.Comment = '#'
+	}
+	cr.LazyQuotes = lazyQuotes
+	if raggedRows {
+		cr.FieldsPerRecord = -1
+	}
+	return cr
+}
+
+
+// pad or truncate a record to width fields, for -ragged, reusing the
+// record's storage where it can
+func fitRecord(record []string, width int) []string {
+	if !raggedRows || len(record) == width || width == 0 {
+		return record
+	}
+	slog.Debug("fitting ragged row to header", "fields", len(record), "header", width)
+	if len(record) > width {
+		return record[:width]
+	}
+	for len(record) < width {
+		record = append(record, "")
+	}
+	return record
+}
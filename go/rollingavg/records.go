@@ -0,0 +1,323 @@
+// records.go: write output rows as protobuf or MessagePack records
+//
+// compact binary encodings for high-throughput consumers, which decode them
+// faster than they parse CSV. with -format protobuf, each output row is
+// written as a Row message, prefixed with its length as a varint, the
+// delimited format read by e.g. Java's parseDelimitedFrom, or Go's
+// protodelim. the Row message has a field for each column, numbered in
+// order from 1, and named for the column in lower case, e.g. Average A is
+//     optional double average_a = 5;
+// with -proto-schema file, its definition is written as a .proto file, to
+// generate the consumers' code from. with -format msgpack, each output row
+// is written as a MessagePack map of the column names to their values,
+// which are self-delimiting, so simply concatenated, as read by e.g.
+// Python's msgpack.Unpacker. columns are typed as for the columnar
+// formats, by -column-types or their values in the first row, see arrow.go.
+// values that don't parse as their column's type are left out of protobuf
+// messages, and nil in MessagePack maps. timestamps are
+// google.protobuf.Timestamp messages, and MessagePack timestamps.
+// outputs are compressed as given by -z, see compress.go
+
+
+package main
+
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+
+var protoSchemafile string
+
+
+// writes records as length-prefixed protobuf messages or MessagePack maps
+type recordOutput struct {
+	out     *outputFile
+	w       *bufio.Writer
+	format  string // protobuf or msgpack
+	types   map[string]string
+	header  []string
+	coltype []string // the type of each column, once the first row is seen
+	fields  []string // the protobuf field names of the columns
+	buf     []byte
+	err     error
+}
+
+
+// check that -proto-schema can be used with the output format
+func checkProtoSchema() {
+	if protoSchemafile != "" && outputFormat != "protobuf" {
+		fatal("-proto-schema requires -format protobuf", "format", outputFormat)
+	}
+}
+
+
+func newRecordOutput(outfilename, compression, format string, types map[string]string) *recordOutput {
+	out := createOutput(outfilename, compression)
+	return &recordOutput{out: out, w: bufio.NewWriter(out), format: format, types: types}
+}
+
+
+func (o *recordOutput) Write(record []string) error {
+	if o.err != nil {
+		return o.err
+	}
+	o.out.recordWritten()
+	// the first record is the header, naming the fields
+	if o.header == nil {
+		o.header = append([]string(nil), record...)
+		o.fields = protoFieldNames(o.header)
+		return nil
+	}
+	if o.coltype == nil {
+		o.setTypes(record)
+		if o.err != nil {
+			return o.err
+		}
+	}
+
+	if o.format == "protobuf" {
+		o.buf = o.appendProto(o.buf[:0], record)
+		var size [binary.MaxVarintLen64]byte
+		n := binary.PutUvarint(size[:], uint64(len(o.buf)))
+		if _, o.err = o.w.Write(size[:n]); o.err != nil {
+			return o.err
+		}
+	} else {
+		o.buf = o.appendMsgpack(o.buf[:0], record)
+	}
+	_, o.err = o.w.Write(o.buf)
+	return o.err
+}
+
+
+// type the columns from the first data row, or nil if there are none, and
+// write the -proto-schema
+func (o *recordOutput) setTypes(first []string) {
+	o.coltype = make([]string, len(o.header))
+	for i, name := range o.header {
+		o.coltype[i] = columnType(i, name, o.types, first)
+		switch o.coltype[i] {
+		case "string", "double", "int64", "bool", "timestamp":
+		default:
+			fatal("invalid column type", "column", name, "type", o.coltype[i])
+		}
+	}
+	if protoSchemafile != "" {
+		o.err = o.writeProtoSchema(protoSchemafile)
+	}
+}
+
+
+// protobuf field names for the columns: lower case, with anything other
+// than letters and digits as underscores, and unique
+func protoFieldNames(header []string) []string {
+	names := make([]string, len(header))
+	used := make(map[string]bool)
+	for i, col := range header {
+		name := strings.Trim(strings.Map(func(r rune) rune {
+			if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+				return unicode.ToLower(r)
+			}
+			return '_'
+		}, col), "_")
+		if name == "" || unicode.IsDigit(rune(name[0])) {
+			name = "column_" + name
+		}
+		for base, n := name, 2; used[name]; n++ {
+			name = base + "_" + strconv.Itoa(n)
+		}
+		used[name] = true
+		names[i] = name
+	}
+	return names
+}
+
+
+// write the .proto definition of the Row message
+func (o *recordOutput) writeProtoSchema(filename string) error {
+	var sb strings.Builder
+	sb.WriteString("// rollingavg output rows, written as length-delimited Row messages\n")
+	sb.WriteString("syntax = \"proto3\";\n\npackage rollingavg;\n\n")
+	for _, typ := range o.coltype {
+		if typ == "timestamp" {
+			sb.WriteString("import \"google/protobuf/timestamp.proto\";\n\n")
+			break
+		}
+	}
+	sb.WriteString("message Row {\n")
+	for i, typ := range o.coltype {
+		if typ == "timestamp" {
+			typ = "google.protobuf.Timestamp"
+		}
+		fmt.Fprintf(&sb, "  optional %s %s = %d; // %s\n", typ, o.fields[i], i+1, o.header[i])
+	}
+	sb.WriteString("}\n")
+
+	fl, err := createDest(filename)
+	if err != nil {
+		return err
+	}
+	if _, err := fl.Write([]byte(sb.String())); err != nil {
+		fl.Close()
+		return err
+	}
+	return fl.Close()
+}
+
+
+// append a record as a protobuf Row message, leaving out values that don't
+// parse as their column's type
+func (o *recordOutput) appendProto(b []byte, record []string) []byte {
+	for i, v := range record {
+		if i >= len(o.coltype) {
+			break
+		}
+		num := protowire.Number(i + 1)
+		switch o.coltype[i] {
+		case "string":
+			b = protowire.AppendTag(b, num, protowire.BytesType)
+			b = protowire.AppendString(b, v)
+		case "double":
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+				b = protowire.AppendFixed64(b, math.Float64bits(f))
+			}
+		case "int64":
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				b = protowire.AppendTag(b, num, protowire.VarintType)
+				b = protowire.AppendVarint(b, uint64(n))
+			}
+		case "bool":
+			if t, err := strconv.ParseBool(v); err == nil {
+				b = protowire.AppendTag(b, num, protowire.VarintType)
+				b = protowire.AppendVarint(b, protowire.EncodeBool(t))
+			}
+		case "timestamp":
+			if t, err := time.Parse(timeLayout, v); err == nil {
+				var ts []byte
+				ts = protowire.AppendTag(ts, 1, protowire.VarintType)
+				ts = protowire.AppendVarint(ts, uint64(t.Unix()))
+				ts = protowire.AppendTag(ts, 2, protowire.VarintType)
+				ts = protowire.AppendVarint(ts, uint64(t.Nanosecond()))
+				b = protowire.AppendTag(b, num, protowire.BytesType)
+				b = protowire.AppendBytes(b, ts)
+			}
+		}
+	}
+	return b
+}
+
+
+// append a record as a MessagePack map of the column names to their
+// values, nil for those that don't parse as their column's type
+func (o *recordOutput) appendMsgpack(b []byte, record []string) []byte {
+	b = msgpackMap(b, len(o.header))
+	for i, name := range o.header {
+		b = msgpackString(b, name)
+		v := ""
+		if i < len(record) {
+			v = record[i]
+		}
+		switch o.coltype[i] {
+		case "string":
+			b = msgpackString(b, v)
+			continue
+		case "double":
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				b = binary.BigEndian.AppendUint64(append(b, 0xcb), math.Float64bits(f))
+				continue
+			}
+		case "int64":
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				if n >= -32 && n < 128 {
+					b = append(b, byte(n))
+				} else {
+					b = binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(n))
+				}
+				continue
+			}
+		case "bool":
+			if t, err := strconv.ParseBool(v); err == nil {
+				if t {
+					b = append(b, 0xc3)
+				} else {
+					b = append(b, 0xc2)
+				}
+				continue
+			}
+		case "timestamp":
+			if t, err := time.Parse(timeLayout, v); err == nil {
+				// the timestamp 96 extension, of nanoseconds and seconds
+				b = append(b, 0xc7, 12, 0xff)
+				b = binary.BigEndian.AppendUint32(b, uint32(t.Nanosecond()))
+				b = binary.BigEndian.AppendUint64(b, uint64(t.Unix()))
+				continue
+			}
+		}
+		b = append(b, 0xc0)
+	}
+	return b
+}
+
+
+// append a MessagePack string
+func msgpackString(b []byte, s string) []byte {
+	switch {
+	case len(s) < 32:
+		b = append(b, 0xa0|byte(len(s)))
+	case len(s) < 1<<8:
+		b = append(b, 0xd9, byte(len(s)))
+	case len(s) < 1<<16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xda), uint16(len(s)))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xdb), uint32(len(s)))
+	}
+	return append(b, s...)
+}
+
+
+// append the header of a MessagePack map of n entries
+func msgpackMap(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x80|byte(n))
+	case n < 1<<16:
+		return binary.BigEndian.AppendUint16(append(b, 0xde), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, 0xdf), uint32(n))
+}
+
+
+func (o *recordOutput) Flush() {
+	if err := o.w.Flush(); err != nil && o.err == nil {
+		o.err = err
+	}
+}
+
+
+func (o *recordOutput) Error() error {
+	return o.err
+}
+
+
+func (o *recordOutput) Close() error {
+	// with no rows, the schema is of the header alone
+	if o.coltype == nil && o.header != nil && o.err == nil {
+		o.setTypes(nil)
+	}
+	o.Flush()
+	if o.err != nil {
+		return o.err
+	}
+	return o.out.Close()
+}
@@ -0,0 +1,168 @@
+// reload.go: reload settings on SIGHUP in streaming runs
+//
+// in streaming runs, with -follow, Kafka, MQTT or socket input, a SIGHUP
+// rereads the -config file, and changes the rule, the thresholds of the
+// threshold rule, the levels of the labels rule, and the output destination to its settings, keeping
+// the in-memory windows, so no rows are lost. flags given on the command
+// line, or by ROLLAVG_* environment variables, keep precedence, and
+// settings removed from the config file return to their defaults.
+// the output is reopened, starting with a header row, even when unchanged,
+// so that e.g. logrotate can move the output file aside. the output can't
+// be changed with -append or -checkpoint, which keep track of it.
+// the new settings apply from the next row received.
+// the HTTP and gRPC server modes keep no windows between requests, which
+// select their rule themselves
+
+
+package main
+
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+// the flags reloaded on SIGHUP
+var reloadFlags = []string{"rule", "labels", "threshold-a", "threshold-b", "o"}
+
+// the flags given on the command line, which reloading doesn't change
+var cmdlineFlags map[string]bool
+
+
+// an output whose destination can be replaced while processing
+type reopenableOutput struct {
+	recordWriteCloser
+	prov *provenance
+}
+
+
+// close the output and open outfilename in its place, writing the header
+// row of the output for the input header
+func (o *reopenableOutput) reopen(outfilename string, header []string) {
+	if err := o.Close(); err != nil {
+		slog.Warn("error closing output", "err", err)
+	}
+	o.recordWriteCloser = queueOutput(openOutput(outfilename, o.prov))
+	if placeSpec != "" {
+		placed := &placedOutput{recordWriteCloser: o.recordWriteCloser}
+		placed.setHeader(header)
+		o.recordWriteCloser = placed
+	}
+	if err := o.Write(outputHeader(header)); err != nil {
+		slog.Warn("error writing header to reopened output", "err", err)
+	}
+}
+
+
+// a reader that, between records, applies any reload requested by SIGHUP
+// to the processor and output
+type reloadingReader struct {
+	recordReader
+	hup    chan os.Signal
+	p      *rollingavg.Processor
+	out    *reopenableOutput
+	header []string
+}
+
+
+// reload the settings of p and out on SIGHUP while reading from in
+func newReloadingReader(in recordReader, p *rollingavg.Processor, out *reopenableOutput, header []string) *reloadingReader {
+	r := &reloadingReader{recordReader: in, hup: make(chan os.Signal, 1), p: p, out: out, header: header}
+	signal.Notify(r.hup, syscall.SIGHUP)
+	return r
+}
+
+
+// stop handling SIGHUP
+func (r *reloadingReader) stop() {
+	signal.Stop(r.hup)
+}
+
+
+// read the next record, then reload if requested, so that the new
+// settings apply to it
+func (r *reloadingReader) Read() ([]string, error) {
+	record, err := r.recordReader.Read()
+	select {
+	case <-r.hup:
+		r.reload()
+	default:
+	}
+	return record, err
+}
+
+
+// reread the settings and apply them. if they're invalid, the current
+// ones are kept
+func (r *reloadingReader) reload() {
+	slog.Info("reloading settings")
+	oldrule, oldlabels, oldthresholda, oldthresholdb, oldout := ruleName, labelSpec, thresholdA, thresholdB, outfilename
+	restore := func() {
+		ruleName, labelSpec, thresholdA, thresholdB, outfilename = oldrule, oldlabels, oldthresholda, oldthresholdb, oldout
+	}
+
+	if err := reloadSettings(flag.CommandLine); err != nil {
+		slog.Warn("error reloading settings, keeping the current ones", "err", err)
+		restore()
+		return
+	}
+	rule, err := resultRule()
+	if err != nil {
+		slog.Warn("error reloading settings, keeping the current ones", "err", err)
+		restore()
+		return
+	}
+	if outfilename != oldout && (appendFlag || checkpointfile != "") {
+		slog.Warn("output can't be changed with -append or -checkpoint, keeping it", "output", oldout)
+		outfilename = oldout
+	}
+
+	r.p.Rule = rule
+	if !appendFlag && checkpointfile == "" {
+		r.out.reopen(outfilename, r.header)
+	}
+	slog.Info("reloaded settings", "rule", ruleName, "threshold_a", thresholdA, "threshold_b", thresholdB, "output", outfilename)
+}
+
+
+// set the reloaded flags that weren't given on the command line from
+// the environment, or failing that the config file, or their defaults
+func reloadSettings(fs *flag.FlagSet) error {
+	values := make(map[string]string)
+	for _, name := range reloadFlags {
+		values[name] = fs.Lookup(name).DefValue
+	}
+	if configfile != "" {
+		config, err := readConfig(configfile)
+		if err != nil {
+			return err
+		}
+		for _, s := range config.settings() {
+			if _, ok := values[s.name]; ok {
+				values[s.name] = s.value
+			}
+		}
+	}
+	for _, name := range reloadFlags {
+		if value, ok := os.LookupEnv(envName(name)); ok {
+			values[name] = value
+		}
+	}
+
+	for _, name := range reloadFlags {
+		if cmdlineFlags[name] {
+			continue
+		}
+		if err := fs.Set(name, values[name]); err != nil {
+			return fmt.Errorf("invalid value for -%s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+// remote.go: read and write s3:// and gs:// objects as input and output files
+//
+// objects are streamed via the AWS and Google Cloud SDKs, so nothing is
+// staged locally. credentials and region come from the SDKs' usual
+// environment variables and config files.
+// paths are of the form s3://bucket/key or gs://bucket/object,
+// anything else is treated as a local filename.
+// with -retries, opening an object is retried, and an error reading one
+// resumes reading from the offset read to, with a ranged read, see retry.go.
+// uploads are retried by the SDKs, with -retries as their retries
+
+
+package main
+
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+
+// split a remote URL into its scheme, bucket and key
+// returns an empty scheme for local filenames
+func splitRemote(name string) (scheme, bucket, key string) {
+	for _, s := range []string{"s3", "gs"} {
+		if rest, ok := strings.CutPrefix(name, s+"://"); ok {
+			bucket, key, _ = strings.Cut(rest, "/")
+			return s, bucket, key
+		}
+	}
+	return "", "", ""
+}
+
+
+func isRemote(name string) bool {
+	scheme, _, _ := splitRemote(name)
+	return scheme != ""
+}
+
+
+func newS3Client(ctx context.Context) (*s3.Client, error) {
+	var opts []func(*config.LoadOptions) error
+	if retries > 0 {
+		opts = append(opts, config.WithRetryMaxAttempts(retries+1))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return s3.NewFromConfig(cfg), nil
+}
+
+
+// open a local file or remote object for reading
+func openSource(name string) (io.ReadCloser, error) {
+	ctx := context.Background()
+	scheme, bucket, key := splitRemote(name)
+	switch scheme {
+	case "s3":
+		client, err := newS3Client(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return openResuming(ctx, name, func(offset int64) (io.ReadCloser, error) {
+			in := &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)}
+			if offset > 0 {
+				in.Range = aws.String(fmt.Sprintf("bytes=%d-", offset))
+			}
+			obj, err := client.GetObject(ctx, in)
+			if err != nil {
+				return nil, err
+			}
+			return obj.Body, nil
+		})
+
+	case "gs":
+		client, err := storage.NewClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return openResuming(ctx, name, func(offset int64) (io.ReadCloser, error) {
+			return client.Bucket(bucket).Object(key).NewRangeReader(ctx, offset, -1)
+		})
+	}
+	return os.Open(name)
+}
+
+
+// a remote object's reader that, with -retries, after an error reading,
+// reopens the object from the offset read to
+type resumingReader struct {
+	ctx     context.Context
+	name    string
+	open    func(offset int64) (io.ReadCloser, error)
+	rc      io.ReadCloser
+	offset  int64
+	stalled int // errors since the last bytes read
+}
+
+
+// open the object from its start, with retries
+func openResuming(ctx context.Context, name string, open func(offset int64) (io.ReadCloser, error)) (io.ReadCloser, error) {
+	r := &resumingReader{ctx: ctx, name: name, open: open}
+	if err := withRetries(ctx, "opening "+name, r.reopen); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+
+func (r *resumingReader) reopen() error {
+	rc, err := r.open(r.offset)
+	if err != nil {
+		return err
+	}
+	r.rc = rc
+	return nil
+}
+
+
+func (r *resumingReader) Read(p []byte) (int, error) {
+	n, err := r.rc.Read(p)
+	r.offset += int64(n)
+	if n > 0 {
+		r.stalled = 0
+	}
+	if err == nil || err == io.EOF || r.stalled >= retries {
+		return n, err
+	}
+	r.stalled++
+	slog.Warn("error reading, resuming", "name", r.name, "offset", r.offset, "err", err)
+	r.rc.Close()
+	if err := withRetries(r.ctx, "opening "+r.name, r.reopen); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+
+func (r *resumingReader) Close() error {
+	return r.rc.Close()
+}
+
+
+// an upload to S3 fed through a pipe. Close waits for the upload to finish
+type s3Writer struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+func (w *s3Writer) Close() error {
+	w.pw.Close()
+	return <-w.done
+}
+
+
+// create a local file or remote object for writing
+// remote objects are only complete once closed
+func createDest(name string) (io.WriteCloser, error) {
+	ctx := context.Background()
+	scheme, bucket, key := splitRemote(name)
+	switch scheme {
+	case "s3":
+		client, err := newS3Client(ctx)
+		if err != nil {
+			return nil, err
+		}
+		pr, pw := io.Pipe()
+		w := &s3Writer{pw, make(chan error, 1)}
+		go func() {
+			_, err := manager.NewUploader(client).Upload(ctx, &s3.PutObjectInput{
+				Bucket: aws.String(bucket),
+				Key:    aws.String(key),
+				Body:   pr,
+			})
+			if err != nil {
+				err = fmt.Errorf("uploading %s: %w", name, err)
+			}
+			// unblock any writer if the upload failed part way
+			pr.CloseWithError(err)
+			w.done <- err
+		}()
+		return w, nil
+
+	case "gs":
+		client, err := storage.NewClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		obj := client.Bucket(bucket).Object(key)
+		if retries > 0 {
+			obj = obj.Retryer(storage.WithMaxAttempts(retries + 1))
+		}
+		return obj.NewWriter(ctx), nil
+	}
+	return os.Create(name)
+}
@@ -0,0 +1,175 @@
+// report.go: a JSON summary report of a run
+//
+// with -report file, a machine readable summary of the run is written to
+// the file at the end, or with -report -, to stderr, e.g.
+//     {"inputs":["in.csv"],"output":"out.csv","started":"2026-01-02T03:04:05Z",
+//      "duration_seconds":1.5,"rows_read":1000,"rows_written":978,
+//      "rows_skipped":0,"tail_rows":0,"pending_rows":22,"groups":1,
+//      "triggers":3,"columns":{"Average A":{"count":978,"min":-2.5,
+//      "max":30.1,"mean":12.4},"Average B":{...}},"exit_status":3}
+// where columns summarises the statistic of each averaged column over the
+// output rows with complete windows, and triggers counts the rows whose
+// Result isn't "0". with -clamp, clamped counts the values clamped of each
+// column, see clamp.go, and with -truth, evaluation has the evaluation of
+// the Results against the true labels, see eval.go, and with -cardinality,
+// cardinality has the estimated numbers of distinct values of columns, see
+// cardinality.go. with -batch or -watch, it summarises all the files
+// processed. the report is written even when the run is interrupted, with
+// "interrupted":true, but not when it fails with an error
+
+
+package main
+
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+var reportfile string
+
+// the report of the run, nil without -report
+var report *runReport
+
+
+// a summary of the values of an averaged column
+type columnSummary struct {
+	Count int     `json:"count"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Mean  float64 `json:"mean"`
+	sum   float64
+}
+
+
+// the JSON summary report
+type runReport struct {
+	Inputs      []string                  `json:"inputs"`
+	Output      string                    `json:"output"`
+	Started     time.Time                 `json:"started"`
+	Duration    float64                   `json:"duration_seconds"`
+	Read        int                       `json:"rows_read"`
+	Written     int                       `json:"rows_written"`
+	Skipped     int64                     `json:"rows_skipped"`
+	Tail        int                       `json:"tail_rows"`
+	Pending     int                       `json:"pending_rows"`
+	Groups      int                       `json:"groups"`
+	Triggers    int64                     `json:"triggers"`
+	Columns     map[string]*columnSummary `json:"columns"`
+	Clamped     map[string]int            `json:"clamped,omitempty"`
+	Interrupted bool                      `json:"interrupted,omitempty"`
+	ExitStatus  int                       `json:"exit_status"`
+	Evaluation  *resultEvaluation         `json:"evaluation,omitempty"`
+	Cardinality map[string]uint64         `json:"cardinality,omitempty"`
+
+	mu   sync.Mutex
+	a, b *columnSummary
+}
+
+
+// start the report of the run
+func startReport(infilenames []string, outfilename string) {
+	a, b := &columnSummary{}, &columnSummary{}
+	statA, statB := mainStats()
+	report = &runReport{
+		Inputs:  infilenames,
+		Output:  outfilename,
+		Started: time.Now(),
+		Columns: map[string]*columnSummary{rollingavg.StatColumn(statA) + " A": a, rollingavg.StatColumn(statB) + " B": b},
+		a:       a,
+		b:       b,
+	}
+}
+
+
+// add a value to the summary
+func (s *columnSummary) add(v float64) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return
+	}
+	if s.Count == 0 || v < s.Min {
+		s.Min = v
+	}
+	if s.Count == 0 || v > s.Max {
+		s.Max = v
+	}
+	s.Count++
+	s.sum += v
+	s.Mean = s.sum / float64(s.Count)
+}
+
+
+// record the statistics of an output row
+func (r *runReport) rowWritten(avga, avgb float64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.a.add(avga)
+	r.b.add(avgb)
+	r.mu.Unlock()
+}
+
+
+// add the counts of a file's run
+func (r *runReport) runDone(counts rollingavg.Counts, tail int, groups int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.Read += counts.Read
+	r.Written += counts.Written
+	r.Pending += counts.Pending
+	r.Tail += tail
+	r.Groups += groups
+	r.mu.Unlock()
+}
+
+
+// add the number of values of a column clamped
+func (r *runReport) clamped(column string, n int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	if r.Clamped == nil {
+		r.Clamped = make(map[string]int)
+	}
+	r.Clamped[column] += n
+	r.mu.Unlock()
+}
+
+
+// write the report, given whether the run was interrupted, and its exit status
+func (r *runReport) write(interrupted bool, status int) {
+	if r == nil {
+		return
+	}
+	r.Duration = time.Since(r.Started).Seconds()
+	r.Skipped = rowsSkipped.Load()
+	r.Triggers = rowsTriggered.Load()
+	r.Interrupted = interrupted
+	r.ExitStatus = status
+	if sig, ok := stopSignal.(syscall.Signal); ok && interrupted {
+		r.ExitStatus = 128 + int(sig)
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		fatal("error encoding report", "err", err)
+	}
+	data = append(data, '\n')
+	if reportfile == "-" {
+		os.Stderr.Write(data)
+		return
+	}
+	if err := os.WriteFile(reportfile, data, 0644); err != nil {
+		fatal("error writing report", "err", err)
+	}
+}
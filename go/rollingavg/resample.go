@@ -0,0 +1,184 @@
+// resample.go: resample CSV rows to a fixed interval, filling the gaps
+//
+// a subcommand, see commands.go:
+//     mdp resample [-v] [-interval d] [-time col] [-group-by col] [-fill none|empty|previous|linear] [-f inputfile]... [-o outputfile] [inputfile...]
+// the rows are rolled up to one per -interval of the time column, e.g. 30s,
+// 5m or 1h (default 1m), per -group-by series, as by the resample stage of
+// a -config pipeline, see stages.go: the mean of the numeric columns and
+// the last value of the others, the time being the interval's start.
+// with -fill, the intervals without rows, between the first and last rows
+// of a series, are output too, with
+//     empty     only the time, and series
+//     previous  the values of the interval before
+//     linear    the numeric values interpolated between the intervals either
+//               side, and the others those of the interval before
+// rows must be in time order per series, a row out of order starting a new
+// interval, and leaving no gap to fill
+
+
+package main
+
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"flag"
+	"io"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+func runResample(args []string) {
+	fs := flag.NewFlagSet("resample", flag.ExitOnError)
+	interval := fs.Duration("interval", time.Minute, "interval to resample rows to, e.g. 30s, 5m or 1h")
+	fill := fs.String("fill", "none", "intervals without rows to fill: none, empty, previous or linear")
+	fs.StringVar(&timeCol, "time", "3", "timestamp column (name or index)")
+	fs.StringVar(&groupBy, "group-by", "", "column (name or index) to resample as independent series")
+	commonFlags(fs, "the resampled rows")
+	fs.Parse(args)
+	infilenames = append(infilenames, fs.Args()...)
+	setupLogging()
+
+	if *interval <= 0 {
+		fatal("invalid resample interval", "interval", *interval)
+	}
+	switch *fill {
+	case "none", "empty", "previous", "linear":
+	default:
+		fatal("invalid fill, expected none, empty, previous or linear", "fill", *fill)
+	}
+
+	slog.Debug("resample CSV rows",
+		"inputs", infilenames, "output", outfilename, "interval", *interval, "fill", *fill)
+
+	infile := openInputs(context.Background(), infilenames, false, nil)
+	defer infile.Close()
+
+	var in recordReader = &resampleStage{in: infile, interval: *interval, buckets: make(map[string]*resampleBucket)}
+	if *fill != "none" {
+		in = &fillStage{in: in, interval: *interval, fill: *fill, last: make(map[string][]string)}
+	}
+
+	oufl := createOutput(outfilename, "")
+	outfile := csv.NewWriter(bufio.NewWriter(oufl))
+	n := 0
+	for {
+		record, err := in.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fatal("error reading record from csv", "err", err)
+		}
+		if err := outfile.Write(record); err != nil {
+			fatal("error writing record to csv", "err", err)
+		}
+		n++
+	}
+	slog.Debug("resampled records", "records", n-1)
+
+	outfile.Flush()
+	if err := outfile.Error(); err != nil {
+		fatal("error writing csv", "err", err)
+	}
+	if err := oufl.Close(); err != nil {
+		fatal("error closing destination csv", "err", err)
+	}
+}
+
+
+// fills the intervals missing between the resampled records of each series
+type fillStage struct {
+	in       recordReader
+	interval time.Duration
+	fill     string
+	header   []string
+	tcol     int
+	gcol     int
+	last     map[string][]string // the last record of each series
+	ready    [][]string
+	filled   int
+}
+
+
+func (f *fillStage) Read() ([]string, error) {
+	if f.header == nil {
+		f.header = readStageHeader(f.in)
+		f.tcol = findColumn(f.header, timeCol)
+		f.gcol = -1
+		if groupBy != "" {
+			f.gcol = findColumn(f.header, groupBy)
+		}
+		return f.header, nil
+	}
+	for len(f.ready) == 0 {
+		record, err := f.in.Read()
+		if err == io.EOF {
+			slog.Debug("filled intervals", "fill", f.fill, "intervals", f.filled)
+		}
+		if err != nil {
+			return nil, err
+		}
+		f.add(record)
+	}
+	record := f.ready[0]
+	f.ready = f.ready[1:]
+	return record, nil
+}
+
+
+// ready a record, after the records filling the intervals between it and
+// the last of its series
+func (f *fillStage) add(record []string) {
+	key := ""
+	if f.gcol >= 0 {
+		key = field(record, f.gcol)
+	}
+	prev := f.last[key]
+	f.last[key] = record
+	if prev != nil {
+		t0, err0 := rollingavg.ParseTime(field(prev, f.tcol))
+		t1, err1 := rollingavg.ParseTime(field(record, f.tcol))
+		if err0 == nil && err1 == nil {
+			steps := int(t1.Sub(t0) / f.interval)
+			for i := 1; i < steps; i++ {
+				t := t0.Add(time.Duration(i) * f.interval)
+				f.ready = append(f.ready, f.fillRecord(prev, record, t, float64(i)/float64(steps)))
+				f.filled++
+			}
+		}
+	}
+	f.ready = append(f.ready, record)
+}
+
+
+// the record of an interval at t, the fraction frac of the way from the
+// interval of prev to that of next
+func (f *fillStage) fillRecord(prev []string, next []string, t time.Time, frac float64) []string {
+	record := make([]string, len(f.header))
+	for i := range record {
+		switch {
+		case i == f.tcol:
+			record[i] = t.Format(rollingavg.TimeLayout)
+		case i == f.gcol:
+			record[i] = field(prev, i)
+		case f.fill == "empty":
+		case f.fill == "linear":
+			a, aerr := rollingavg.ParseFloat(field(prev, i))
+			b, berr := rollingavg.ParseFloat(field(next, i))
+			if aerr == nil && berr == nil {
+				record[i] = strconv.FormatFloat(a+(b-a)*frac, 'f', -1, 64)
+			} else {
+				record[i] = field(prev, i)
+			}
+		default:
+			record[i] = field(prev, i)
+		}
+	}
+	return record
+}
@@ -0,0 +1,67 @@
+// retry.go: retries with backoff for network inputs and outputs
+//
+// with -retries n, a failed call to a network input or output is retried up
+// to n times, waiting -retry-wait before the first retry, doubling the wait
+// for each after, up to -retry-max-wait, the waits being jittered so that
+// many runs don't retry at once, e.g.
+//     rollingavg -retries 5 -retry-wait 2s -o s3://bucket/avgs.csv s3://bucket/in.csv
+// so that a transient failure doesn't abort a long run. retried are:
+// opening and reading s3:// and gs:// inputs, reading resuming from the
+// offset read to, see remote.go, and uploading outputs, by the SDKs' own
+// retries; fetching and committing Kafka messages, the reader continuing
+// from its offset, and producing them, see kafka.go; the PostgreSQL COPY of
+// a batch, reconnecting if the connection was lost, the failed COPY having
+// copied none of it, see postgres.go; and sending webhook alerts, see
+// webhook.go. each retry is logged as a warning. without -retries, the
+// first failure is an error
+
+
+package main
+
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"time"
+)
+
+
+var retries int
+var retryWait time.Duration
+var retryMaxWait time.Duration
+
+
+// check that the retry settings are valid
+func checkRetries() {
+	switch {
+	case retries < 0:
+		fatal("invalid number of retries", "retries", retries)
+	case retryWait <= 0:
+		fatal("invalid retry wait", "retry-wait", retryWait)
+	case retryMaxWait < retryWait:
+		fatal("invalid retry max wait, expected at least -retry-wait", "retry-max-wait", retryMaxWait, "retry-wait", retryWait)
+	}
+}
+
+
+// call f until it succeeds, or has been retried -retries times, or ctx is
+// done, returning its last error. what is logged with each retry
+func withRetries(ctx context.Context, what string, f func() error) error {
+	wait := retryWait
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt > retries || ctx.Err() != nil {
+			return err
+		}
+		// a random wait from half to all of the backoff
+		d := wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+		slog.Warn("retrying after error "+what, "retry", attempt, "retries", retries, "wait", d, "err", err)
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return err
+		}
+		wait = min(2*wait, retryMaxWait)
+	}
+}
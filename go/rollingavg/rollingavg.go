@@ -7,22 +7,237 @@
 // output a CSV containing header row followed by  rows of
 //     X, Y, Z, Date Time, rolling-Avg-A, rolling-Avg-A
 //
-// Synopsis: rollingavg [-version] [-v] [-n nrows] [-f inputfile] [-o outputfile] 
-// files default to stdin and stdout, nrows to 23
+// if a group-by column is given, each distinct value in that column (e.g. a
+// sensor ID) gets its own independent rolling window, so interleaved rows
+// from many series can be processed in one pass
+//
+// windows can also be measured in business days or calendar months of the
+// Date Time column rather than rows, see rollingavg/calendar.go
+//
+// with -stat, another window statistic than the mean is output, in
+// columns named for it, e.g. "Median A", see rollingavg/aggregator.go.
+// the Result thresholds apply to whichever statistic is output.
+// -rule selects another rule for the Result column, see rollingavg/rule.go,
+// and -threshold-a and -threshold-b change the thresholds of the default one.
+// -rule labels gives labels of ordered levels, not 1 or 0, see labels.go
+// with -plugin, aggregators and rules are loaded from Go plugins, see plugins.go
+// with -script, a Starlark script can modify or drop each output row,
+// see script.go
+//
+// the windowing, parsing and output row logic is in the importable
+// rollingavg package, in rollingavg/, which this command wraps
+//
+// Synopsis: rollingavg [-version] [-print-config] [-dry-run] [-v] [-log-level debug|info|warn|error] [-log-format text|json]
+//     [-config file.yaml | -preset name [-preset-dir dir]] [-encoding enc] [-lazy-quotes] [-ragged] [-n nrows [-nb nrows | -adaptive nrows]] [-group-by col]
+//     [-alias name=column]... [-window-unit rows|bdays|months|minutes|hours|days] [-window-align] [-window-tz zone] [-window-mode sliding|tumbling] [-stat mean|median|min|max|stddev|mad|geomean|harmmean] [-stats A:stat,...;B:stat,...]
+//     [-rule threshold [-threshold-a a] [-threshold-b b] | -rule labels -labels label:cond;...;label] [-plugin file.so]... [-script file.star]
+//     [-tail drop|partial|empty]
+//     [-time col] [-holidays file] [-from time] [-to time] [-index] [-mmap]
+//     [-join-asof file [-join-time col] [-join-tolerance duration]]
+//     [-merge] [-follow] [-daemon] [-health-addr addr] [-metrics-addr addr] [-ws-addr addr] [-sse-addr addr] [-dashboard-addr addr]
+//     [-webhook-url url [-webhook-debounce duration]]
+//     [-notify-slack url] [-notify-email addr,... -smtp-from addr [-smtp-addr host:port]
+//         [-smtp-user user -smtp-password password]] [-notify-every duration] [-notify-max n]
+//     [-kafka-brokers host:port,... [-kafka-topic topic [-kafka-group group] [-kafka-start earliest|latest]]
+//         [-kafka-out-topic topic [-kafka-batch nrows]]] [-sink-rate rows/s]
+//     [-mqtt-broker url -mqtt-topic filter... [-mqtt-qos 0|1|2] [-mqtt-client-id id]]
+//     [-listen tcp://host:port|udp://host:port]
+//     [-message-format csv|json] [-message-header name,...]
+//     [-z gzip|zstd] [-encrypt-to recipient|keyfile]... [-f inputfile]... [-o outputfile] [inputfile...]
+//     [-format csv|json|parquet|arrow|influx|protobuf|msgpack] [-column-types name:type,...] [-proto-schema file] [-quote minimal|all|nonnumeric|none] [-crlf] [-passthrough]
+//     [-influx-measurement name] [-influx-tags col,...]
+//     [-parquet-columns name,...] [-sheet name|index] [-rotate-size size] [-rotate-every duration] [-rotate-pattern pattern]
+//     [-split-by day|month [-split-pattern pattern]] [-append]
+//     [-pg-conn connstring -pg-table table [-pg-batch nrows]]
+//     [-duckdb-db file] [-duckdb-query sql] [-duckdb-table table]
+//     [-output-queue nrows [-flush-interval duration]] [-retries n [-retry-wait duration] [-retry-max-wait duration]]
+//     [-checkpoint file [-checkpoint-every nrows] [-resume]]
+//     [-batch glob|dir [-out-pattern pattern] [-jobs njobs] [-state file]]
+//     [-watch dir [-done-dir dir] [-out-pattern pattern] [-state file]]
+//     [-serve addr] [-grpc-addr addr] [-flight-addr addr] [-tls-cert file -tls-key file [-tls-client-ca file]] [-auth-token token] [-auth-basic user:password]
+//     [-broker-tls] [-broker-ca file] [-broker-cert file -broker-key file] [-broker-user user -broker-password password]
+//     [-cpuprofile file] [-memprofile file] [-pprof-addr addr] [-otel] [-progress [-progress-every duration]] [-plot file.svg|file.gp]
+//     [-bench] [-parallel nworkers [-chunk-size size]] [-max-mem size [-spill-dir dir]] [-report file|-] [-manifest file|-] [-provenance comment|sidecar] [-column-stats comment|sidecar] [-audit file]
+//     [-window-sample k -window-sample-file file] [-cardinality col,...]
+//     [-truth col [-truth-file file] [-eval file|-]]
+//     [-schema file.yaml] [-convert col:from>to,...] [-clamp col:lo:hi,... [-clamp-sample nrows]]
+//     [-outliers col:k[:rolling|global][:drop|null][:iqr|mad],... [-outlier-window nrows] [-outlier-sample nrows]]
+//     [-ewm col:alpha,... [-ewm-stats mean,var,stddev]]
+//     [-mode col,...] [-distinct col,...] [-count-above col:threshold,...] [-place out:after|before|replace:col,...]
+//     [-anchor start|end|center] [-window-bounds]
+// files default to stdin and stdout, nrows to 23, time col to 3
+// -nb gives B a row window of its own length, A's being -n, e.g. for
+// signals of different noise, the rows being output once the longer
+// window is complete
+// -adaptive adapts row windows' lengths to the values, from that many rows
+// to -n, outputting each row's window length, see adaptive.go
+// -window-mode tumbling outputs a row per window, of windows that don't
+// overlap, rather than per row, see tumbling.go
+// -window-align ends time windows on the boundaries of their unit, e.g. the
+// top of the hour, in the -window-tz timezone, see calendar.go
+// -stats gives A and B statistics of their own, and any extra statistics
+// as more columns, see stats.go
+// -alias gives columns short names, for expressions and flags, see aliases.go
+// multiple input files, given by repeated -f or as arguments, are processed
+// in order as one continuous stream, see inputs.go
+// with -merge, the inputs are instead merged into timestamp order,
+// each input must already be sorted by time, see merge.go
+// with -from and -to, only rows in a time range are processed, see
+// timerange.go, and with -index, large inputs are seeked to it, see index.go
+// -mmap memory maps local input files, scanning their rows in place, see
+// mmap.go
+// with -join-asof, input rows are joined with the last row of a second CSV
+// as of their timestamps, see join.go
+// with -follow, the last input (or stdin) is followed as rows are appended,
+// and each output row is flushed as soon as it is computed, see follow.go
+// with -kafka-topic, rows are consumed from a Kafka topic, and with
+// -kafka-out-topic, output rows produced to one, see kafka.go
+// with -mqtt-topic, rows are received from MQTT topics, see mqtt.go
+// with -listen, rows are received over a TCP or UDP socket, see listen.go
+// Kafka, MQTT and socket messages are CSV or JSON, see messages.go
+// with -metrics-addr, Prometheus metrics are served on /metrics, see metrics.go
+// with -ws-addr, output rows are pushed to WebSocket clients, see websocket.go
+// with -sse-addr, output rows are sent as server-sent events, see sse.go
+// with -dashboard-addr, a web page charts the output rows live, see dashboard.go
+// with -webhook-url, rows whose Result is triggered are POSTed as JSON
+// alerts, see webhook.go, and with -notify-slack or -notify-email, batched
+// notifications of them are sent, see notify.go
+// -daemon runs as a service, notifying systemd when ready, and
+// -health-addr serves a /healthz health check, see daemon.go
+// -cpuprofile and -memprofile write profiles of the run, and -pprof-addr
+// serves them while running, see profile.go
+// with -otel, OpenTelemetry traces and metrics are exported, see otel.go
+// with -progress, rows, rows/sec, bytes read and ETA are logged periodically,
+// see progress.go
+// with -parallel, a large input file is processed in chunks by parallel
+// workers, see parallel.go
+// with -max-mem, windows beyond that memory are spilled to disk, see memory.go
+// with -plot, a chart of the raw and averaged series, or a gnuplot script
+// plotting the output, is written at the end of the run, see plot.go
+// with -bench, the run's time, rows/sec, allocations and peak memory are
+// reported, see bench.go
+// logs are written to stderr, at -log-level, as text or JSON, see logging.go
+// in follow and message streaming runs, SIGHUP reloads the rule,
+// thresholds and output from -config, see reload.go
+// gzip and zstd compressed inputs are read transparently, and outputs
+// compressed by .gz/.zst extension or -z, see compress.go
+// inputs are UTF-8, skipping any byte order mark, or UTF-16 or Latin-1 by
+// -encoding, see encoding.go
+// stray quotes and rows of the wrong width may be allowed with -lazy-quotes
+// and -ragged, see ragged.go
+// input and output files may be s3:// or gs:// URLs, see remote.go
+// output is CSV, or with -format json a JSON array of objects, see jsonout.go
+// CSV output quoting and line endings are set by -quote and -crlf, see quoting.go
+// with -passthrough, input rows are output as they were written, see
+// passthrough.go
+// .parquet input files, and -format parquet output, are parquet, see parquet.go
+// -format arrow output is an Arrow IPC (Feather v2) file, see arrowout.go
+// -format influx output is InfluxDB line protocol, see influx.go
+// -format protobuf and msgpack outputs are length-prefixed protobuf messages
+// and MessagePack maps, see records.go
+// .xlsx input files are read from the worksheet given by -sheet, see xlsx.go
+// with -pg-conn, output rows are instead copied into the PostgreSQL table
+// -pg-table, see postgres.go
+// with -duckdb-query, input rows are read from a DuckDB query, and with
+// -duckdb-table, output rows are appended to a DuckDB table, see duckdb.go
+// with -encrypt-to, output files are encrypted to age or PGP recipients,
+// see encrypt.go
+// -sink-rate limits the rows a second sent to Kafka or PostgreSQL, e.g.
+// for backfills, see sinkrate.go
+// with -retries, failed calls to S3, GCS, Kafka, PostgreSQL and webhooks are
+// retried with backoff, remote inputs resuming where they were, see retry.go
+// the output file can be rotated by size or time, see rotate.go
+// or split into a file per day or month of the time column, see split.go
+// with -append, the output file is appended to, and the windows continue
+// from where the previous append run left off, see append.go
+// with -output-queue, output rows are queued for writing, so that a slow
+// output doesn't stall reading until the queue is full, see outqueue.go
+// with -checkpoint, processing state is saved every -checkpoint-every rows,
+// and -resume continues an interrupted run from it, see checkpoint.go
+// an interrupt (^C) or SIGTERM stops processing cleanly: the rows read so
+// far are output, with -checkpoint the checkpoint saved, and a summary
+// logged, exiting with status 130 or 143, see shutdown.go.
+// otherwise the exit status is 0 when clean, 1 on an error, 2 when rows
+// were skipped and 3 when the Result rule triggered, see exitcode.go
+// with -report, a JSON summary of the run is written at the end, see report.go
+// with -provenance, output CSVs record the version, command line, inputs
+// and times of the run, in a comment block or a sidecar file, see provenance.go
+// with -column-stats, the count, mean, min and max of the output columns
+// are written in a comment footer or a sidecar file, see colstats.go
+// with -manifest, a JSON manifest of the output files, with their row counts
+// and SHA-256 checksums, and the parameters used, is written, see manifest.go
+// with -truth, the Results are evaluated against true labels, see eval.go
+// with -audit, the output rows dropped or modified are logged, see audit.go
+// with -cardinality, the numbers of distinct values of columns are
+// estimated, and logged and reported at the end, see cardinality.go
+// with -window-sample, random samples of each window's rows are written to
+// a file, for spot-checking, see windowsample.go
+// with -schema, input values are coerced, e.g. stripping units, by the
+// coercions of their columns in a csvcheck schema file, see coerce.go
+// with -convert, input columns are converted between units, see convert.go
+// with -clamp, input values are clamped to bounds or percentiles, see clamp.go
+// with -outliers, input values outside k IQRs, or k robust stddevs by MAD, are removed, see outliers.go
+// with -ewm, exponentially weighted moving means and stddevs of input
+// columns are added to the rows, see ewm.go
+// with -mode, the most frequent values of categorical columns over each
+// window are output, with -distinct, the number of distinct values of
+// columns, and with -count-above, the number of values above
+// thresholds, and with -window-bounds, the first and last timestamps of
+// each window, see windowcols.go
+// with -anchor, each row is output with the statistics of the window
+// ending at, or centered on, it instead, see anchor.go
+// with -place, the appended columns are output among the input columns,
+// or in place of them, see place.go
+// -tail outputs the last rows, which have no complete window, with partial
+// or empty statistics, rather than dropping them
+// col may be a column name from the header row or a 0-based column index
+// with -config, settings are read from a YAML pipeline file, see config.go,
+// or with -preset, a named one in the user's config directory, see preset.go
+// flags can also be set by ROLLAVG_* environment variables, see env.go.
+// command line flags take precedence over these, and these over -config.
+// -print-config prints the resulting settings, see printconfig.go
+// -dry-run checks the settings and input header, and reports what would be
+// done, without processing, see dryrun.go
+//
+// with -batch, each CSV matching a glob, or in a directory, is processed
+// into its own output file, named by -out-pattern, up to -jobs at once,
+// with a status report of each file, see batch.go
+// with -watch, new CSVs appearing in a directory are processed likewise,
+// and then moved to a done directory, or if they fail, a failed one, see
+// watch.go
+// with -state, inputs already processed with the same content and
+// parameters are skipped, so re-runs are safe, see state.go
+// with -serve, an HTTP server processes CSVs POSTed to it, see serve.go
+// with -grpc-addr, a gRPC server streams rows in and out, see grpc.go
+// with -flight-addr, an Arrow Flight server serves the results of the
+// inputs as record batches, see flight.go
+// the servers can be served over TLS, and require authentication, and the
+// Kafka and MQTT clients connect over TLS and authenticate, see security.go
+//
+// subcommands:
+// this is the rollavg subcommand of the mdp umbrella command, whose other
+// subcommands, e.g. aggregate, csvstats, dedup, view and forecast, are
+// listed in commands.go, and by mdp help. built as rollingavg, a first
+// argument naming a subcommand runs it, e.g.
+//     rollingavg aggregate ...
 
 
 package main
 
 
 import (
+	"context"
+	"errors"
 	"flag"
-	"log"
-	"os"
-	"io"
-	"bufio"
-	"encoding/csv"
 	"fmt"
-	"strconv"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const APP_VERSION = "0.1"
@@ -30,177 +245,789 @@ const APP_VERSION = "0.1"
 // The flag package provides a default help printer via -h switch
 var versionFlag bool
 var verboseFlag bool
-var infilename string
+var configfile string
+var infilenames stringList
 var outfilename string
 var nrows int
+var nrowsB int
+var groupBy string
+var windowUnit string
+var statName string
+var ruleName string
+var thresholdA float64
+var thresholdB float64
+var pluginFiles stringList
+var scriptfile string
+var tailPolicy string
+var timeCol string
+var holidayfile string
+var mergeFlag bool
+var followFlag bool
+var metricsAddr string
+var daemonFlag bool
+var healthAddr string
+var cpuprofile string
+var memprofile string
+var pprofAddr string
+var otelFlag bool
+var wsAddr string
+var sseAddr string
+var dashboardAddr string
+var compressFlag string
+var outputFormat string
+var columnTypes string
+var parquetColumns string
+var xlsxSheet string
+var influxMeasurement string
+var influxTags string
+var pgConn string
+var pgTable string
+var pgBatch int
+var rotateSize sizeFlag
+var rotateEvery time.Duration
+var rotatePattern string
+var splitBy string
+var splitPattern string
+var appendFlag bool
+var checkpointfile string
+var checkpointEvery int
+var resumeFlag bool
+var batchGlob string
+var outPattern string
+var serveAddr string
+var grpcAddr string
+var watchDir string
+var doneDir string
+var kafkaBrokers string
+var kafkaTopic string
+var kafkaGroup string
+var kafkaStart string
+var kafkaOutTopic string
+var kafkaBatch int
+var mqttBroker string
+var mqttTopics stringList
+var mqttQoS int
+var mqttClientID string
+var listenAddr string
+var messageFormat string
+var messageHeader string
 
 
 func init() {
+	flag.BoolVar(&printConfigFlag, "print-config", false, "print the effective configuration, and the source of each setting, as a -config file and exit")
+	flag.BoolVar(&dryRunFlag, "dry-run", false, "check the options and input header, report what would be computed and written, and exit without processing")
 	flag.BoolVar(&versionFlag, "version", false, "Print the version number.")
-	flag.BoolVar(&verboseFlag, "v", false, "verbose output for debugging")
+	commonFlags(flag.CommandLine, "processed")
+	flag.StringVar(&configfile, "config", "", "YAML pipeline configuration file, overridden by command line flags")
+	flag.StringVar(&presetName, "preset", "", "name of a preset YAML pipeline configuration in the user config directory, overridden by command line flags")
+	flag.StringVar(&presetDir, "preset-dir", "", "directory of -preset configurations (default rollingavg/presets in the user config directory)")
 	flag.IntVar(&nrows, "n", 23, "number of rows (interval) for moving average")
-	flag.StringVar(&infilename, "f", "", "CSV containing data to process")
-	flag.StringVar(&outfilename, "o", "", "output CSV containing processed")
-	log.SetFlags(log.LstdFlags | log.Llongfile)
+	flag.IntVar(&nrowsB, "nb", 0, "number of rows of the B column's window, if it differs from A's (default -n nrows)")
+	flag.IntVar(&adaptiveMin, "adaptive", 0, "adapt the row windows' lengths to the values, from this many rows to -n nrows")
+	flag.StringVar(&groupBy, "group-by", "", "column (name or index) to group rows into independent series")
+	flag.Var(&aliasSpecs, "alias", "short name for a column, as name=column, usable in expressions and flags (may be repeated)")
+	flag.StringVar(&windowUnit, "window-unit", "rows", "unit of the -n window length: rows, bdays (business days), months, minutes, hours or days")
+	flag.BoolVar(&windowAlign, "window-align", false, "align time windows to the boundaries of their unit, e.g. the top of the hour, rather than each row's time")
+	flag.StringVar(&windowTZ, "window-tz", "UTC", "timezone of the boundaries of -window-align windows, e.g. Australia/Brisbane or Local")
+	flag.StringVar(&windowMode, "window-mode", "sliding", "sliding windows, a row output per row, or tumbling, a row output per window, the windows not overlapping")
+	flag.StringVar(&statName, "stat", "mean", "window statistic: "+strings.Join(rollingavg.AggregatorNames(), ", "))
+	flag.StringVar(&statsSpec, "stats", "", "window statistics of each column, e.g. \"A:mean,stddev;B:median\", the first in place of -stat's, the rest as extra columns")
+	flag.StringVar(&ruleName, "rule", "threshold", "rule for the Result column: "+strings.Join(rollingavg.RuleNames(), ", ")+", or one from a plugin")
+	flag.StringVar(&labelSpec, "labels", "", "levels of the labels rule, as label:condition;...;label, the last being for rows meeting no condition")
+	flag.Float64Var(&thresholdA, "threshold-a", -1, "the threshold rule's Result is 1 when the A statistic is below this")
+	flag.Float64Var(&thresholdB, "threshold-b", -1500, "the threshold rule's Result is 1 when the B statistic is below this")
+	flag.Var(&pluginFiles, "plugin", "Go plugin (.so) registering aggregators and rules to load (may be repeated)")
+	flag.StringVar(&scriptfile, "script", "", "Starlark script whose transform(row) function modifies or drops each output row")
+	flag.StringVar(&tailPolicy, "tail", "drop", "output of the last rows, without complete windows: drop, partial or empty")
+	flag.StringVar(&timeCol, "time", "3", "timestamp column (name or index) for calendar windows, merging and splitting")
+	flag.StringVar(&fromTime, "from", "", "only process rows with -time column timestamps from this time")
+	flag.StringVar(&toTime, "to", "", "only process rows with -time column timestamps before this time")
+	flag.BoolVar(&indexFlag, "index", false, "build sidecar .idx indexes of the input files, and seek to the -from -to range by them")
+	flag.BoolVar(&mmapFlag, "mmap", false, "memory map local input files, rather than reading them through buffers, for large files")
+	flag.StringVar(&holidayfile, "holidays", "", "file of YYYY-MM-DD holiday dates excluded from business days")
+	flag.BoolVar(&mergeFlag, "merge", false, "merge time-sorted inputs by timestamp rather than concatenating them")
+	flag.StringVar(&joinAsof, "join-asof", "", "time-sorted CSV whose last row at or before each input row's timestamp is joined to it")
+	flag.StringVar(&joinTime, "join-time", "3", "timestamp column (name or index) of the -join-asof CSV")
+	flag.DurationVar(&joinTolerance, "join-tolerance", 0, "the furthest before an input row a -join-asof row may be (default no limit)")
+	flag.BoolVar(&followFlag, "follow", false, "keep reading the input as rows are appended, flushing each output row")
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "address (e.g. :9100) to serve Prometheus metrics on /metrics")
+	flag.BoolVar(&daemonFlag, "daemon", false, "run as a service, notifying systemd when ready and stopping")
+	flag.StringVar(&healthAddr, "health-addr", "", "address (e.g. :8086) to serve a /healthz health check on")
+	flag.StringVar(&cpuprofile, "cpuprofile", "", "write a CPU profile of the run to this file")
+	flag.StringVar(&memprofile, "memprofile", "", "write a heap profile to this file at the end of the run")
+	flag.StringVar(&pprofAddr, "pprof-addr", "", "address (e.g. localhost:6060) to serve pprof profiles on /debug/pprof/")
+	flag.BoolVar(&otelFlag, "otel", false, "export OpenTelemetry traces and metrics by OTLP, configured by OTEL_* environment variables")
+	flag.IntVar(&parallelWorkers, "parallel", 1, "number of workers processing chunks of a single large input file in parallel")
+	flag.Var(&chunkSize, "chunk-size", "size of the chunks of -parallel processing (K, M, G suffixes allowed)")
+	flag.Var(&maxMem, "max-mem", "approximate memory for windows, beyond which the least recently used are spilled to disk (K, M, G suffixes allowed)")
+	flag.StringVar(&spillDir, "spill-dir", "", "directory for -max-mem spill files (default the system temporary directory)")
+	flag.BoolVar(&benchFlag, "bench", false, "report wall time, rows/sec, allocations and peak memory of the run, discarding the output unless -o is given")
+	flag.StringVar(&plotfile, "plot", "", "write a chart of the raw and averaged A and B to this .svg file, or a gnuplot script of the output to this .gp file")
+	flag.StringVar(&truthCol, "truth", "", "column of true labels to evaluate the Result column against, of the input or -truth-file")
+	flag.StringVar(&truthFile, "truth-file", "", "CSV file of the -truth labels, matched to output rows by -time timestamp")
+	flag.StringVar(&evalFile, "eval", "", "write the evaluation of the Results against -truth to this file, or - for stderr, at the end")
+	flag.StringVar(&reportfile, "report", "", "write a JSON summary of the run to this file, or - for stderr, at the end")
+	flag.StringVar(&provenanceMode, "provenance", "", "record the version, command line, inputs and times of the run in a comment block before the header of output CSVs, or a sidecar JSON file: comment or sidecar")
+	flag.StringVar(&columnStatsMode, "column-stats", "", "write the count, mean, min and max of each numeric column of output CSVs in a comment footer, or a sidecar CSV file: comment or sidecar")
+	flag.StringVar(&manifestfile, "manifest", "", "write a JSON manifest of the output files, with their SHA-256 checksums, to this file, or - for stderr, at the end")
+	flag.StringVar(&schemafile, "schema", "", "schema file (see csvcheck) of coercions, e.g. stripping units, applied to input values before processing")
+	flag.StringVar(&convertSpec, "convert", "", "unit conversions of input columns as col:from>to,..., e.g. TempF:F>C,Dist:ft>m")
+	flag.StringVar(&clampSpec, "clamp", "", "bounds of input columns as col:lo:hi,..., a bound a number, pN percentile or empty, e.g. X:-500:500,Y:p1:p99")
+	flag.IntVar(&clampSample, "clamp-sample", 1000, "number of rows -clamp percentiles are estimated from")
+	flag.StringVar(&outlierSpec, "outliers", "", "IQR or MAD outlier removal from input columns as col:k[:rolling|global][:drop|null][:iqr|mad],..., e.g. X:1.5,Y:3:global,Z:3:mad")
+	flag.IntVar(&outlierWindow, "outlier-window", 0, "number of previous values of rolling -outliers quartiles (default -n nrows)")
+	flag.IntVar(&outlierSample, "outlier-sample", 1000, "number of rows global -outliers quartiles are estimated from")
+	flag.StringVar(&ewmSpec, "ewm", "", "exponentially weighted moving statistics of input columns as col:alpha,..., e.g. X:0.1,Y:0.05")
+	flag.StringVar(&ewmStats, "ewm-stats", "mean,stddev", "statistics of -ewm columns: mean, var and stddev")
+	flag.StringVar(&modeCols, "mode", "", "comma separated categorical columns whose most frequent value over the window is output")
+	flag.StringVar(&distinctCols, "distinct", "", "comma separated columns whose number of distinct values over the window is output")
+	flag.StringVar(&countAbove, "count-above", "", "columns whose number of values above a threshold over the window is output, as col:threshold,...")
+	flag.StringVar(&anchor, "anchor", "start", "row each window's statistics are output with: start (forward looking), end (trailing) or center")
+	flag.BoolVar(&windowBounds, "window-bounds", false, "output the first and last timestamps (of -time) of each window, as Window Start and Window End")
+	flag.StringVar(&placeSpec, "place", "", "placement of appended output columns as out:after|before|replace:col,..., e.g. Average A:after:X")
+	flag.StringVar(&cardinalityCols, "cardinality", "", "comma separated columns whose number of distinct values is estimated, and logged and reported at the end")
+	flag.IntVar(&windowSample, "window-sample", 0, "write a random sample of this many of the rows of each output row's window to the -window-sample-file")
+	flag.StringVar(&windowSampleFile, "window-sample-file", "", "CSV file of -window-sample rows, numbered by output row")
+	flag.StringVar(&auditfile, "audit", "", "log the output rows dropped or modified, e.g. by -script, to this CSV file")
+	flag.BoolVar(&progressFlag, "progress", false, "periodically log rows processed, rows/sec, bytes read and ETA to stderr")
+	flag.DurationVar(&progressEvery, "progress-every", 5*time.Second, "interval between -progress reports")
+	flag.StringVar(&wsAddr, "ws-addr", "", "address (e.g. :8081) to stream output rows to WebSocket clients on /rows")
+	flag.StringVar(&dashboardAddr, "dashboard-addr", "", "address (e.g. :8083) to serve a live dashboard of the output rows on /")
+	flag.StringVar(&webhookURL, "webhook-url", "", "URL to POST a JSON alert to for each output row whose Result is triggered")
+	flag.DurationVar(&webhookDebounce, "webhook-debounce", 0, "least time between webhook alerts for a series, counting the triggers between")
+	flag.StringVar(&notifySlack, "notify-slack", "", "Slack incoming webhook URL to post batched notifications of Result triggers to")
+	flag.StringVar(&notifyEmail, "notify-email", "", "comma separated addresses to mail batched notifications of Result triggers to")
+	flag.StringVar(&smtpAddr, "smtp-addr", "localhost:25", "SMTP server (host:port) for -notify-email")
+	flag.StringVar(&smtpFrom, "smtp-from", "", "sender address of -notify-email")
+	flag.StringVar(&smtpUser, "smtp-user", "", "SMTP user name, if the server requires authentication")
+	flag.StringVar(&smtpPassword, "smtp-password", "", "SMTP password, best set by ROLLAVG_SMTP_PASSWORD")
+	flag.DurationVar(&notifyEvery, "notify-every", time.Minute, "interval between notifications, each batching the triggers since the last")
+	flag.IntVar(&notifyMax, "notify-max", 20, "most triggers listed in a notification, the rest being counted")
+	flag.StringVar(&sseAddr, "sse-addr", "", "address (e.g. :8082) to stream output rows as server-sent events on /events")
+	flag.Var(&encryptTo, "encrypt-to", "age recipient (age1...) or PGP public key file to encrypt output files to (may be repeated)")
+	flag.StringVar(&compressFlag, "z", "", "compress output with gzip or zstd (default from output filename extension), or lz4 or zstd for arrow")
+	flag.StringVar(&outputFormat, "format", "csv", "output format: csv, json, parquet, arrow, influx, protobuf or msgpack")
+	flag.StringVar(&protoSchemafile, "proto-schema", "", "write the .proto definition of -format protobuf's Row messages to the file")
+	flag.StringVar(&quoteMode, "quote", "minimal", "CSV output quoting: minimal, all, nonnumeric (quote strings only) or none")
+	flag.BoolVar(&crlfFlag, "crlf", false, "end CSV output lines with CRLF rather than LF")
+	flag.BoolVar(&passthroughFlag, "passthrough", false, "output the text of the input rows verbatim, appending the new columns")
+	flag.StringVar(&influxMeasurement, "influx-measurement", "rollingavg", "InfluxDB measurement name for influx output")
+	flag.StringVar(&influxTags, "influx-tags", "", "comma separated columns to output as InfluxDB tags")
+	flag.StringVar(&columnTypes, "column-types", "", "columnar output types as name:type,... (string, double, int64, bool, timestamp)")
+	flag.StringVar(&parquetColumns, "parquet-columns", "", "comma separated parquet input columns to read, in order (default all)")
+	flag.StringVar(&xlsxSheet, "sheet", "", "worksheet (name or 0-based index) to read from .xlsx inputs (default first)")
+	flag.StringVar(&pgConn, "pg-conn", "", "PostgreSQL connection string, to copy output rows into -pg-table")
+	flag.StringVar(&pgTable, "pg-table", "", "PostgreSQL table to copy output rows into")
+	flag.IntVar(&pgBatch, "pg-batch", 10000, "number of rows per PostgreSQL COPY")
+	flag.StringVar(&duckdbFile, "duckdb-db", "", "DuckDB database file for -duckdb-query and -duckdb-table (default in memory)")
+	flag.StringVar(&duckdbQuery, "duckdb-query", "", "DuckDB SQL query to read input rows from, rather than input files")
+	flag.StringVar(&duckdbTable, "duckdb-table", "", "DuckDB table of -duckdb-db to append output rows to")
+	flag.Float64Var(&sinkRate, "sink-rate", 0, "most rows a second sent to Kafka or PostgreSQL outputs, on average (default unlimited)")
+	flag.Var(&rotateSize, "rotate-size", "start a new output file after this many bytes (K, M, G suffixes allowed)")
+	flag.DurationVar(&rotateEvery, "rotate-every", 0, "start a new output file after this duration, e.g. 1h")
+	flag.StringVar(&rotatePattern, "rotate-pattern", defaultRotatePattern, "rotated output filename pattern using {dir}, {name}, {ext}, {n} and {time}")
+	flag.StringVar(&splitBy, "split-by", "", "split output into a file per day or month of the time column")
+	flag.StringVar(&splitPattern, "split-pattern", defaultSplitPattern, "split output filename pattern using {dir}, {name}, {ext} and {date}")
+	flag.BoolVar(&appendFlag, "append", false, "append to the output file, continuing the windows of the previous append run")
+	flag.StringVar(&checkpointfile, "checkpoint", "", "file to periodically save processing state to")
+	flag.IntVar(&checkpointEvery, "checkpoint-every", 100000, "number of rows between checkpoints")
+	flag.IntVar(&outputQueue, "output-queue", 0, "number of output rows queued for a goroutine writing them, reading waiting while it's full (default unqueued)")
+	flag.IntVar(&retries, "retries", 0, "number of times failed calls to network inputs and outputs are retried")
+	flag.DurationVar(&retryWait, "retry-wait", time.Second, "wait before the first retry, doubling for each after")
+	flag.DurationVar(&retryMaxWait, "retry-max-wait", 30*time.Second, "longest wait between retries")
+	flag.DurationVar(&flushInterval, "flush-interval", 0, "with -output-queue, flush the rows written at most this often (default once the queue is empty)")
+	flag.BoolVar(&resumeFlag, "resume", false, "resume processing from the checkpoint file, if it exists")
+	flag.StringVar(&batchGlob, "batch", "", "glob or directory of CSVs to process into separate output files")
+	flag.IntVar(&batchJobs, "jobs", 1, "number of -batch files processed at once")
+	flag.StringVar(&outPattern, "out-pattern", defaultOutPattern, "batch/watch output filename pattern using {dir}, {name} and {ext}")
+	flag.StringVar(&serveAddr, "serve", "", "address (e.g. :8080) to serve on-demand processing of POSTed CSVs on")
+	flag.StringVar(&grpcAddr, "grpc-addr", "", "address (e.g. :9090) to serve the RollingAvg gRPC service on")
+	flag.StringVar(&flightAddr, "flight-addr", "", "address (e.g. :8815) to serve the results of the inputs by Arrow Flight on")
+	flag.StringVar(&watchDir, "watch", "", "directory to watch for new CSVs to process")
+	flag.StringVar(&doneDir, "done-dir", "", "directory processed watched CSVs are moved to (default watch dir/done)")
+	flag.StringVar(&statefile, "state", "", "JSON file of the inputs processed, by content, for -batch and -watch to skip those processed with the same parameters")
+	flag.StringVar(&kafkaBrokers, "kafka-brokers", "localhost:9092", "comma separated Kafka broker addresses")
+	flag.StringVar(&kafkaTopic, "kafka-topic", "", "Kafka topic to consume input rows from, instead of input files")
+	flag.StringVar(&kafkaGroup, "kafka-group", "rollingavg", "Kafka consumer group, which keeps the committed offsets")
+	flag.StringVar(&kafkaStart, "kafka-start", "earliest", "offset a new Kafka consumer group starts from: earliest or latest")
+	flag.StringVar(&kafkaOutTopic, "kafka-out-topic", "", "Kafka topic to produce output rows to, instead of an output file")
+	flag.IntVar(&kafkaBatch, "kafka-batch", 1000, "number of messages per Kafka produce")
+	flag.StringVar(&mqttBroker, "mqtt-broker", "tcp://localhost:1883", "MQTT broker URL")
+	flag.Var(&mqttTopics, "mqtt-topic", "MQTT topic filter to subscribe to for input rows, instead of input files (may be repeated)")
+	flag.IntVar(&mqttQoS, "mqtt-qos", 1, "MQTT subscription QoS: 0, 1 or 2")
+	flag.StringVar(&mqttClientID, "mqtt-client-id", "rollingavg", "MQTT client ID, which keeps the broker session")
+	flag.StringVar(&listenAddr, "listen", "", "tcp://host:port or udp://host:port to receive input rows on, instead of input files")
+	flag.StringVar(&messageFormat, "message-format", "csv", "Kafka/MQTT/socket message format: csv or json")
+	flag.StringVar(&messageHeader, "message-header", "", "comma separated column names of Kafka/MQTT/socket messages (required for csv)")
+	flag.StringVar(&tlsCert, "tls-cert", "", "PEM certificate file to serve -serve, -grpc-addr, -flight-addr, -ws-addr, -sse-addr and -dashboard-addr over TLS with")
+	flag.StringVar(&tlsKey, "tls-key", "", "PEM private key file of -tls-cert")
+	flag.StringVar(&tlsClientCA, "tls-client-ca", "", "PEM file of CAs whose client certificates the servers require, for mutual TLS")
+	flag.StringVar(&authToken, "auth-token", "", "bearer token the servers require, best set by ROLLAVG_AUTH_TOKEN")
+	flag.StringVar(&authBasic, "auth-basic", "", "user:password of HTTP basic authentication the servers require, best set by ROLLAVG_AUTH_BASIC")
+	flag.BoolVar(&brokerTLS, "broker-tls", false, "connect to Kafka and MQTT brokers over TLS")
+	flag.StringVar(&brokerCA, "broker-ca", "", "PEM file of CAs to verify Kafka and MQTT brokers by, implying -broker-tls (default the system's)")
+	flag.StringVar(&brokerCert, "broker-cert", "", "PEM client certificate file to present to Kafka and MQTT brokers, implying -broker-tls")
+	flag.StringVar(&brokerKey, "broker-key", "", "PEM private key file of -broker-cert")
+	flag.StringVar(&brokerUser, "broker-user", "", "user to authenticate to Kafka, by SASL PLAIN, and MQTT brokers as")
+	flag.StringVar(&brokerPassword, "broker-password", "", "password of -broker-user, best set by ROLLAVG_BROKER_PASSWORD")
 }
 
 
 func main() {
-	flag.Parse() // Scan the arguments list
+	runCommand(os.Args[0], os.Args[1:])
+}
+
+
+// the rollavg subcommand, run with the arguments following it
+func runRollAvg(args []string) {
+	flag.CommandLine.Parse(args) // Scan the arguments list
+	infilenames = append(infilenames, flag.Args()...)
+	cmdlineFlags = givenFlags(flag.CommandLine)
+	// set up logging from the command line, to log settings from the
+	// environment and config file, then again with them
+	setupLogging()
+	applyEnv(flag.CommandLine)
+	envFlags := givenFlags(flag.CommandLine)
+	if presetName != "" {
+		if configfile != "" {
+			fatal("-preset and -config can't both be given")
+		}
+		configfile = presetFile(presetName)
+	}
+	if configfile != "" {
+		loadConfig(configfile).apply(flag.CommandLine)
+	}
+	setupLogging()
 	if versionFlag {
 		fmt.Println("Version:", APP_VERSION)
 	}
-
-	if verboseFlag {
-		fmt.Println("rolling average over CSV rows.")
-		fmt.Println("input filename: ", infilename)
-		fmt.Println("output filename: ", outfilename)
-		fmt.Println("interval: ", nrows)
+	if printConfigFlag {
+		printConfig(flag.CommandLine, envFlags)
+		return
 	}
 
-	infl := os.Stdin
-	oufl := os.Stdout
-	var err error
+	slog.Debug("rolling average over CSV rows",
+		"inputs", infilenames, "output", outfilename, "interval", nrows, "group_by", groupBy, "window_unit", windowUnit)
 
-	if infilename != "" {
-		infl, err = os.Open(infilename)
-		if err != nil {
-			log.Fatalln("error opening source csv:", err)
-		}
-		defer infl.Close()
-	}
-	infile := csv.NewReader(bufio.NewReader(infl))
+	loadPlugins(pluginFiles)
+	registerLabelRule()
+	addEWMStage()
+	checkRetries()
 
-	if outfilename != "" {
-		oufl, err = os.Create(outfilename)
-		if err != nil {
-			log.Fatalln("error creating destination csv:", err)
-		}
-		defer oufl.Close()
+	if dryRunFlag {
+		runDryRun(infilenames, outfilename)
+		return
 	}
-	outfile := csv.NewWriter(bufio.NewWriter(oufl))
 
-	cols := processHeader(infile, outfile)
-	if verboseFlag {
-		fmt.Printf("read header record containing %d columns\n", cols)
+	if metricsAddr != "" {
+		startMetrics(metricsAddr)
+	}
+	startDaemon(healthAddr)
+	startProfiling(cpuprofile, pprofAddr)
+	if otelFlag {
+		startOtel()
 	}
 
-	genRollingAvg(infile, outfile, nrows)
+	if webhookURL != "" {
+		startWebhook(webhookURL, webhookDebounce)
+	}
+	startNotify()
+	if reportfile != "" {
+		startReport(infilenames, outfilename)
+	}
+	if manifestfile != "" {
+		startManifest(infilenames)
+	}
+	if statefile != "" {
+		startProcessed(statefile)
+	}
+	if auditfile != "" {
+		startAudit(auditfile)
+	}
+	if cardinalityCols != "" {
+		startCardinality()
+	}
+	if windowSample != 0 || windowSampleFile != "" {
+		startWindowSample(windowSampleFile, windowSample)
+	}
+	if truthCol != "" {
+		startEvaluation()
+	} else if truthFile != "" || evalFile != "" {
+		fatal("-truth-file and -eval require -truth")
+	}
 
-	outfile.Flush()
-	if err := outfile.Error(); err != nil {
-		log.Fatalln("error writing csv:", err)
+	if wsAddr != "" || sseAddr != "" || dashboardAddr != "" {
+		liveRows = newRowBroadcaster()
+	}
+	if wsAddr != "" {
+		startWebSocket(wsAddr, liveRows)
+	}
+	if sseAddr != "" {
+		startSSE(sseAddr, liveRows)
+	}
+	if dashboardAddr != "" {
+		startDashboard(dashboardAddr, liveRows)
 	}
-}
 
+	if serveAddr != "" {
+		runServe(serveAddr)
+		return
+	}
 
-// append 2 floating average cols to the original header and write to CSV file
-func processHeader(incsv *csv.Reader, outcsv *csv.Writer) (cols int) {
-	record, err := incsv.Read()
-	if err != nil {
-		log.Fatal(err)
+	if grpcAddr != "" {
+		runGRPC(grpcAddr)
+		return
 	}
 
-	if verboseFlag {
-		fmt.Println("read header record: ", record)
+	if flightAddr != "" {
+		runFlight(flightAddr, infilenames)
+		return
 	}
 
-	cols = len(record)
-	outrec := append(record, "Average A", "Average B", "Result")
+	// SIGINT or SIGTERM cancels processing, after which a second one exits
+	ctx, stop := signalContext()
+	defer stop()
 
-	if verboseFlag {
-		fmt.Println("write header record: ", outrec)
+	ok := true
+	switch {
+	case benchFlag:
+		runBench(ctx, infilenames, outfilename)
+	case watchDir != "":
+		runWatch(ctx, watchDir, outPattern, doneDir)
+	case batchGlob != "":
+		ok = runBatch(ctx, batchGlob, outPattern)
+	default:
+		runRollingAvg(ctx, infilenames, outfilename)
 	}
-
-	if err = outcsv.Write(outrec); err != nil {
-		log.Fatalln("error writing record to csv:", err)
+	stopProfiling(memprofile)
+	shutdownOtel()
+	alerts.close()
+	notifier.close()
+	audit.close()
+	sampler.close()
+	evaluation.write()
+	cardinality.write()
+	manifest.write()
+	report.write(ctx.Err() != nil, exitStatus(ok))
+	exitIfStopped(ctx)
+	if code := exitStatus(ok); code != exitClean {
+		os.Exit(code)
 	}
-	return
 }
 
 
-// generate a forward looking rolling average from incsv rows, write to outcsv
-func genRollingAvg(incsv *csv.Reader, outcsv *csv.Writer, interval int) {
-	// use circular buffer to keep track of previous values
-	// for running average
-	cbufA := make([]float64, interval)
-	cbufB := make([]float64, interval)
-	rows := make([][]string, interval)
-	suma := 0.0
-	sumb := 0.0
-	n := 0
-	for {
-		record, err := incsv.Read()
-		if err == io.EOF {
-			break
+// whether rows arrive continuously, rather than from files
+func streaming() bool {
+	return followFlag || kafkaTopic != "" || len(mqttTopics) > 0 || listenAddr != ""
+}
+
+
+// process the input files as one stream into the output file
+// empty filenames default to stdin and stdout
+// if ctx is cancelled, the rows read so far are output, and any checkpoint
+// saved, so that the run can be resumed
+// returns the counts of records read and rows written
+func runRollingAvg(ctx context.Context, infilenames []string, outfilename string) rollingavg.Counts {
+	start := time.Now()
+	checkTailPolicy()
+	checkAnchor()
+	checkAdaptive()
+	checkWindowMode()
+	checkWindowAlign()
+	checkQuoting()
+	checkPassthrough()
+	checkIndex()
+	checkOutputQueue()
+	checkEncryption()
+	checkManifest()
+	checkProvenance()
+	checkColumnStats()
+	checkProtoSchema()
+	timeRange()
+	ctx, runSpan := telemetry.start(ctx, "rollingavg.run",
+		attribute.StringSlice("rollingavg.inputs", infilenames),
+		attribute.String("rollingavg.output", outfilename),
+		attribute.Int("rollingavg.window", nrows),
+		attribute.String("rollingavg.window_unit", windowUnit),
+		attribute.String("rollingavg.stat", statName),
+		attribute.String("rollingavg.rule", ruleName))
+	defer runSpan.End()
+	_, openSpan := telemetry.start(ctx, "rollingavg.open")
+	var pqcols []string
+	if parquetColumns != "" {
+		pqcols = strings.Split(parquetColumns, ",")
+	}
+	if progressFlag {
+		startProgress(infilenames)
+	}
+	startPlot(outfilename)
+	var infile recordReadCloser = openInputs(ctx, infilenames, followFlag, pqcols)
+	if mergeFlag && len(infilenames) > 1 {
+		infile = openMergedInputs(infilenames, timeCol)
+	}
+	if kafkaTopic != "" {
+		if len(infilenames) > 0 || checkpointfile != "" {
+			fatal("Kafka input can't be combined with input files or checkpoints")
 		}
+		kr, err := newKafkaReader(ctx, kafkaBrokers, kafkaTopic, kafkaGroup, kafkaStart, messageFormat, messageHeader)
 		if err != nil {
-			log.Fatalln("error reading record from csv:", err)
+			fatal("error opening Kafka input", "err", err)
 		}
-
-		if verboseFlag {
-			fmt.Printf("read record [%d]: %s\n", n, record)
+		infile = kr
+	}
+	if len(mqttTopics) > 0 {
+		if len(infilenames) > 0 || checkpointfile != "" || kafkaTopic != "" {
+			fatal("MQTT input can't be combined with input files, Kafka or checkpoints")
 		}
-
-		a, err := strconv.ParseFloat(record[0], 64)
+		mr, err := newMQTTReader(ctx, mqttBroker, mqttClientID, mqttTopics, mqttQoS, messageFormat, messageHeader)
 		if err != nil {
-			log.Fatalln("invalid column value in csv:", err)
+			fatal("error opening MQTT input", "err", err)
+		}
+		infile = mr
+	}
+	if listenAddr != "" {
+		if len(infilenames) > 0 || checkpointfile != "" || kafkaTopic != "" || len(mqttTopics) > 0 {
+			fatal("socket input can't be combined with input files, Kafka, MQTT or checkpoints")
 		}
-		b, err := strconv.ParseFloat(record[1], 64)
+		sr, err := newSocketReader(ctx, listenAddr, messageFormat, messageHeader)
 		if err != nil {
-			log.Fatalln("invalid column value in csv:", err)
+			fatal("error listening for input", "err", err)
+		}
+		infile = sr
+	}
+	if duckdbQuery != "" {
+		if len(infilenames) > 0 || checkpointfile != "" || streaming() {
+			fatal("DuckDB input can't be combined with input files, Kafka, MQTT, socket input or checkpoints")
 		}
+		infile = newDuckDBReader(ctx, duckdbQuery)
+	}
+	defer infile.Close()
 
-		// cbuf will be zero initialised so this works when n<interval
-		i := n % interval
-		suma -= cbufA[i]
-		sumb -= cbufB[i]
-		suma += a
-		sumb += b
-		cbufA[i] = a
-		cbufB[i] = b
-		rows[i] = record
+	var state *checkpointState
+	if checkpointfile != "" {
+		checkCheckpointable(infilenames, outfilename)
+		if checkpointEvery <= 0 {
+			fatal("invalid checkpoint interval", "rows", checkpointEvery)
+		}
+		if resumeFlag {
+			state = loadCheckpoint(checkpointfile, infilenames)
+		}
+	}
 
-		if verboseFlag {
-			fmt.Printf("record [%d]: i=%d suma=%f sumb=%f\n", n, i, suma, sumb)
+	prov := newProvenance(infilenames)
+	var outfile recordWriteCloser
+	if state != nil {
+		// append to the output as it was at the checkpoint
+		truncateOutput(outfilename, state.OutSize)
+		outfile = openAppendOutput(outfilename, compressFlag)
+	} else {
+		outfile = queueOutput(openOutput(outfilename, prov))
+	}
+	var placed *placedOutput
+	if placeSpec != "" {
+		placed = &placedOutput{recordWriteCloser: outfile}
+		outfile = placed
+	}
+	reopenable := &reopenableOutput{recordWriteCloser: outfile, prov: prov}
+	outfile = reopenable
+	if liveRows != nil {
+		outfile = &broadcastOutput{recordWriteCloser: outfile, b: liveRows}
+	}
+	if alerts != nil || notifier != nil {
+		outfile = newAlertOutput(outfile)
+	}
+	if evaluation != nil {
+		outfile = newEvalOutput(outfile)
+	}
+	if scriptfile != "" {
+		outfile = newScriptOutput(outfile, scriptfile)
+	}
+
+	var source recordReader = infile
+	if joinAsof != "" {
+		source = newAsofJoinReader(source)
+	}
+	if len(pipelineStages) > 0 {
+		if checkpointfile != "" {
+			fatal("config file stages and -ewm can't be used with -checkpoint")
 		}
+		source = applyStages(source, pipelineStages)
+	}
+	header := processHeader(source, outfile, placed)
+	slog.Debug("read header record", "columns", len(header))
+	if len(aliasSpecs) > 0 {
+		checkAliases(header)
+	}
+	card := cardinality.columns(header)
 
-		n++
-		if n >= interval {
-			if verboseFlag {
-				fmt.Printf("write record [%d]: ", n-interval)
-			}
-			ravga := suma/float64(interval)
-			ravgb := sumb/float64(interval)
-			res := "0"
-			if ravga < -1 && ravgb < -1500 {
-				res = "1"
-			}
-			outputCSVrow(outcsv, rows[n%interval], strconv.FormatFloat(ravga, 'f', -1, 64), strconv.FormatFloat(ravgb, 'f', -1, 64), res)
+	// continue the windows from the rows left buffered by the last run
+	var incsv recordReader = source
+	if mr, ok := infile.(*multiCSVReader); ok && indexFlag {
+		mr.useIndex(header)
+	}
+	if fromTime != "" || toTime != "" {
+		incsv = newTimeRangeReader(incsv, header)
+	}
+	if appendFlag {
+		incsv = &seededReader{in: incsv, seed: readTail(outfilename, header), started: true}
+	}
+	if schemafile != "" {
+		incsv = newCoercingReader(incsv, loadSchema(schemafile), header)
+	}
+	if convertSpec != "" {
+		incsv = &convertingReader{in: incsv, convs: parseConversions(convertSpec, header)}
+	}
+	if clampSpec != "" {
+		incsv = newClampingReader(incsv, parseClamps(clampSpec, header), clampSample)
+	}
+	if outlierSpec != "" {
+		window := outlierWindow
+		if window <= 0 {
+			window = nrows
 		}
+		incsv = newOutlierReader(incsv, parseOutlierFilters(outlierSpec, header), window, outlierSample)
 	}
 
-	if verboseFlag {
-		fmt.Printf("processed %d records\n", n)
+	statA, statB := mainStats()
+	opts := rollingavg.Options{
+		Window:     nrows,
+		WindowB:    nrowsB,
+		WindowUnit: windowUnit,
+		MinWindow:  adaptiveMin,
+		Tumbling:   windowMode == "tumbling",
+		Stat:       statA,
+		StatB:      statB,
+		Rule:       ruleName,
+		GroupBy:    unalias(header, groupBy),
+		TimeColumn: unalias(header, timeCol),
+		Align:      windowAlign,
+		Location:   windowLocation(),
+	}
+	if holidayfile != "" && windowUnit != "rows" {
+		opts.Holidays = loadHolidays(holidayfile)
+	}
+	p, err := rollingavg.NewProcessor(header, opts)
+	if err != nil {
+		fatal("invalid processing options", "err", err)
+	}
+	if p.Rule, err = resultRule(); err != nil {
+		fatal("invalid rule", "err", err)
+	}
+	if windowsWrapped() {
+		if maxMem != 0 {
+			fatal("-mode, -distinct, -count-above, -window-bounds, extra -stats, -anchor and -window-sample can't be used with -max-mem")
+		}
+		wrapWindows(p, infilenames, header, opts.Holidays)
+	}
+	boundMemory(p)
+	defer p.Close()
+	// records are copied into the windows, and output rows written
+	// immediately, so their buffers can be reused rather than allocated
+	// for each record
+	if outputDoesntKeepRows() {
+		p.ReuseRecords = true
+		if mr, ok := infile.(*multiCSVReader); ok {
+			mr.reuseRecords()
+		}
+	}
+	if streaming() {
+		rr := newReloadingReader(incsv, p, reopenable, header)
+		defer rr.stop()
+		incsv = rr
 	}
 
-	// NOTE:
-	// if need to output the remaining records, do it here
-	// how to deal with their rolling averages???
+	var cp *checkpointer
+	if checkpointfile != "" {
+		cp = &checkpointer{
+			filename:    checkpointfile,
+			every:       checkpointEvery,
+			inputs:      infilenames,
+			in:          infile.(*multiCSVReader),
+			out:         outfile,
+			outfilename: outfilename,
+		}
+		if cp.in.transcoded {
+			fatal("-checkpoint requires UTF-8 input, whose positions can be saved", "encoding", inputEncoding)
+		}
+	}
+	if state != nil {
+		p.Windows = state.restoreWindows(p.NewWindow)
+		cp.rows = state.Rows
+		cp.in.resumeAt(state.File, state.Offset)
+	}
+	var chunks *rollingavg.Chunks
+	if parallelWorkers > 1 {
+		var fl *os.File
+		chunks, fl = parallelChunks(infile, infilenames, header)
+		defer fl.Close()
+	}
+
+	openSpan.End()
+
+	// time the processing stages for telemetry
+	var outcsv recordWriter = outfile
+	if telemetry != nil {
+		timer := &stageTimer{in: incsv, out: outfile}
+		incsv, outcsv = timer, timer
+	}
+
+	serviceReady()
+	processCtx, processSpan := telemetry.start(ctx, "rollingavg.process")
+	counts := genRollingAvg(processCtx, incsv, outcsv, p, cp, chunks, card)
+	progress.stop()
+	processSpan.SetAttributes(
+		attribute.Int("rollingavg.rows.read", counts.Read),
+		attribute.Int("rollingavg.rows.written", counts.Written),
+		attribute.Int("rollingavg.rows.pending", counts.Pending))
+	processSpan.End()
+
+	_, finishSpan := telemetry.start(ctx, "rollingavg.finish")
+	defer finishSpan.End()
+	tail := writeTailRows(p, outfile)
+	telemetry.rowsWritten(tail)
+
+	outfile.Flush()
+	if err := outfile.Error(); err != nil {
+		fatal("error writing csv", "err", err)
+	}
+	if err := outfile.Close(); err != nil {
+		fatal("error closing destination csv", "err", err)
+	}
+
+	if appendFlag {
+		writeTail(outfilename, header, p.Windows)
+	}
+	if ctx.Err() == nil {
+		cp.finish()
+	}
+	var order []int
+	if placed != nil {
+		order = placed.order
+	}
+	writePlot(outputHeader(header), order, outfilename)
+	logSpills(p)
+	report.runDone(counts, tail, p.NumSeries())
+	logSummary(ctx, counts, tail, p.NumSeries(), time.Since(start))
+	return counts
 }
 
 
-// append the floating averages to the original record and write to CSV file
-func outputCSVrow(outcsv *csv.Writer, record []string, avga string, avgb string, res string) {
-	outrec := append(record, avga, avgb, res)
+// append 2 floating average cols to the original header and write to CSV file,
+// setting the order of the columns of placed, if not nil
+// returns the original header record
+func processHeader(incsv recordReader, outcsv recordWriter, placed *placedOutput) (header []string) {
+	record, err := incsv.Read()
+	if err != nil {
+		fatal("error reading header from csv", "err", err)
+	}
+
+	slog.Debug("read header record", "header", record)
+
+	header = make([]string, len(record))
+	copy(header, record)
+	outrec := outputHeader(record)
+	placed.setHeader(header)
+
+	slog.Debug("write header record", "header", outrec)
+
+	if err = outcsv.Write(outrec); err != nil {
+		fatal("error writing record to csv", "err", err)
+	}
+	return
+}
+
+
+// the rule for the Result column given by -rule, and for the threshold
+// rule, -threshold-a and -threshold-b, and the labels rule, -labels
+func resultRule() (rollingavg.Rule, error) {
+	return ruleByName(ruleName)
+}
+
 
-	if verboseFlag {
-		fmt.Println("write record: ", outrec)
+// the rule for the Result column of a rule name, as for -rule, e.g. of a
+// server request's parameter
+func ruleByName(name string) (rollingavg.Rule, error) {
+	switch name {
+	case "threshold":
+		return rollingavg.ThresholdRule(thresholdA, thresholdB), nil
+	case "labels":
+		return labelRule()
 	}
+	return rollingavg.LookupRule(name)
+}
+
 
-	if err := outcsv.Write(outrec); err != nil {
-		log.Fatalln("error writing record to csv:", err)
+// find a column by header name, or failing that by 0-based index, or
+// -alias, see aliases.go
+// returns -1 if not found
+func findColumn(header []string, col string) int {
+	i := rollingavg.FindColumn(header, col)
+	if i < 0 {
+		if aliased := aliasColumn(col); aliased != "" {
+			i = rollingavg.FindColumn(header, aliased)
+		}
 	}
+	return i
 }
 
 
+// generate a forward looking rolling average from incsv rows, write to outcsv
+// p holds the windows, and any existing window state, e.g. from a checkpoint
+// cp, if not nil, is told after each record is processed, and if ctx is
+// cancelled, saves a checkpoint of the records processed so far
+// if chunks is not nil, they are processed in parallel instead of incsv
+// card, if not nil, is the input's -cardinality columns, see cardinality.go
+// returns the counts of records read, rows written, and rows still buffered
+// in the windows of each group
+func genRollingAvg(ctx context.Context, incsv recordReader, outcsv recordWriter, p *rollingavg.Processor, cp *checkpointer, chunks *rollingavg.Chunks, card *cardinalityColumns) rollingavg.Counts {
+	var counts rollingavg.Counts
+	// in follow mode, or streaming from messages, rows are wanted as
+	// soon as they are available
+	p.FlushEach = streaming()
+	p.OnRead = func(n int, record []string) {
+		if verboseFlag {
+			slog.Debug("read record", "n", n, "record", record)
+		}
+		metrics.rowRead()
+		card.add(record)
+		telemetry.rowsRead(1)
+		progress.rowRead()
+	}
+	p.OnWrite = func(key string, r rollingavg.Result, outrec []string) {
+		if verboseFlag {
+			slog.Debug("write record", "group", key, "record", outrec)
+		}
+		metrics.rowWritten(key, r.AvgA, r.AvgB, outrec[len(outrec)-1])
+		telemetry.rowsWritten(1)
+		plot.rowWritten(r)
+		resultWritten(outrec[len(outrec)-1])
+		report.rowWritten(r.AvgA, r.AvgB)
+		counts.Written++
+	}
+	p.OnRowDone = func(n int) {
+		cp.rowDone(n, p.Windows)
+	}
+
+	var n int
+	var err error
+	if chunks != nil {
+		n, err = p.RunParallel(ctx, chunks, parallelWorkers, outcsv)
+	} else {
+		n, err = p.RunContext(ctx, incsv, outcsv)
+	}
+	if errors.Is(err, context.Canceled) {
+		cp.interrupted(n, p.Windows)
+	} else if err != nil {
+		telemetry.failed(trace.SpanFromContext(ctx), err)
+		fatal("error processing csv", "err", err)
+	}
+
+	counts.Read = n
+	p.EachWindow(func(key string, w rollingavg.Window) error {
+		counts.Pending += len(w.Pending())
+		return nil
+	})
+	// the remaining records, without complete windows, are output as
+	// given by -tail, see writeTailRows
+	return counts
+}
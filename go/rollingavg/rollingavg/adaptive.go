@@ -0,0 +1,164 @@
+// adaptive.go: row windows whose length adapts to the values
+//
+// an AdaptiveWindow is a row window whose length, for each record, is
+// from a least to a most number of rows, by Kaufman's efficiency ratio
+// (as of KAMA, the Kaufman adaptive moving average) of A's values over the
+// most rows starting at the record: the net change over them, divided by
+// the sum of the absolute changes from row to row. the window shrinks
+// towards the least rows as the values move in one direction, e.g. the
+// swings of volatile periods, so the statistic lags less, and grows
+// towards the most as they're flat or noisy, e.g. quiet periods, so it's
+// smoother. the ratio, from 0 to 1, maps the smoothing constant of an
+// exponential moving average between those of the most and least rows,
+// 2/(rows+1), as for KAMA, though unsquared so the length stays within
+// them, the length being 2/constant-1 rows, rounded.
+// the windows of A and B are of the same length, and each record is
+// output once the most rows starting at it have been seen, with its
+// window's length appended, as the WindowLengthColumn
+
+
+package rollingavg
+
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+
+// WindowLengthColumn is the output column of the lengths of adaptive
+// windows, following the input columns
+const WindowLengthColumn = "Window Length"
+
+
+// AdaptiveWindow is a rolling window of an adaptive number of rows
+type AdaptiveWindow struct {
+	minLen int
+	maxLen int
+	rows   [][]string
+	as     []float64
+	bs     []float64
+	aggA   Aggregator
+	aggB   Aggregator
+
+	// the number of the buffered values, oldest first, the aggregators hold
+	end int
+
+	// the approximate memory of the buffered records
+	bytes int64
+}
+
+
+// NewAdaptiveWindow returns a window of from minLen to maxLen rows, whose
+// statistic is kept by aggregators from newAggregator, or the mean if nil
+func NewAdaptiveWindow(minLen, maxLen int, newAggregator func() Aggregator) *AdaptiveWindow {
+	if newAggregator == nil {
+		newAggregator = aggregators["mean"]
+	}
+	return &AdaptiveWindow{minLen: minLen, maxLen: maxLen, aggA: newAggregator(), aggB: newAggregator()}
+}
+
+
+// EfficiencyRatio returns Kaufman's efficiency ratio of values, their net
+// change divided by the sum of their absolute changes, or 0 if they don't
+// change
+func EfficiencyRatio(values []float64) float64 {
+	var path float64
+	for i := 1; i < len(values); i++ {
+		path += math.Abs(values[i] - values[i-1])
+	}
+	if path == 0 {
+		return 0
+	}
+	return math.Abs(values[len(values)-1]-values[0]) / path
+}
+
+
+// the window length, from the efficiency ratio er
+func (w *AdaptiveWindow) length(er float64) int {
+	fast, slow := 2/float64(w.minLen+1), 2/float64(w.maxLen+1)
+	sc := er*(fast-slow) + slow
+	return min(max(int(math.Round(2/sc-1)), w.minLen), w.maxLen)
+}
+
+
+// Add adds a record to the window. once the most rows are buffered,
+// returns the oldest buffered record, with its window's length appended,
+// and the statistics over its window
+func (w *AdaptiveWindow) Add(record []string, a, b float64) ([]Result, error) {
+	w.rows = append(w.rows, record)
+	w.as = append(w.as, a)
+	w.bs = append(w.bs, b)
+	w.bytes += recordSize(record)
+	if len(w.rows) < w.maxLen {
+		return nil, nil
+	}
+
+	n := w.length(EfficiencyRatio(w.as))
+	for ; w.end < n; w.end++ {
+		w.aggA.Add(w.as[w.end])
+		w.aggB.Add(w.bs[w.end])
+	}
+	for w.end > n {
+		w.end--
+		w.aggA.Remove(w.as[w.end])
+		w.aggB.Remove(w.bs[w.end])
+	}
+	oldest := w.rows[0]
+	result := Result{append(oldest[:len(oldest):len(oldest)], strconv.Itoa(n)), w.aggA.Value(), w.aggB.Value()}
+
+	w.aggA.Remove(w.as[0])
+	w.aggB.Remove(w.bs[0])
+	w.end--
+	w.rows, w.as, w.bs = w.rows[1:], w.as[1:], w.bs[1:]
+	w.bytes -= recordSize(oldest)
+	return []Result{result}, nil
+}
+
+
+// exported form of an AdaptiveWindow's state. the aggregators are rebuilt
+// from the values they hold
+type adaptiveWindowState struct {
+	Rows [][]string `json:"rows"`
+	As   []float64  `json:"a"`
+	Bs   []float64  `json:"b"`
+	End  int        `json:"end"`
+}
+
+func (w *AdaptiveWindow) MarshalJSON() ([]byte, error) {
+	return json.Marshal(adaptiveWindowState{w.rows, w.as, w.bs, w.end})
+}
+
+func (w *AdaptiveWindow) UnmarshalJSON(data []byte) error {
+	var s adaptiveWindowState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if len(s.As) != len(s.Rows) || len(s.Bs) != len(s.Rows) || s.End < 0 || s.End > len(s.Rows) || len(s.Rows) >= w.maxLen {
+		return fmt.Errorf("invalid adaptive window state of %d rows", len(s.Rows))
+	}
+	w.rows, w.as, w.bs, w.end = s.Rows, s.As, s.Bs, s.End
+	w.bytes = 0
+	for _, record := range w.rows {
+		w.bytes += recordSize(record)
+	}
+	if err := restoreAggregator(w.aggA, nil, w.as[:w.end]); err != nil {
+		return err
+	}
+	return restoreAggregator(w.aggB, nil, w.bs[:w.end])
+}
+
+
+// the approximate memory held by the window: its buffered values and
+// records, allowing for aggregators keeping the values
+func (w *AdaptiveWindow) memSize() int64 {
+	return int64(len(w.rows))*(8+8+24+16) + w.bytes
+}
+
+
+// Pending returns the buffered records not yet output
+func (w *AdaptiveWindow) Pending() [][]string {
+	return append([][]string(nil), w.rows...)
+}
@@ -0,0 +1,305 @@
+// aggregator.go: window statistics
+//
+// an Aggregator keeps a statistic of the values in a window, as values
+// are added to, and removed from, the window. windows keep one for each
+// of the A and B columns, and output its Value for each complete window.
+// aggregators are registered by name, and the built-ins are:
+//     mean      the mean (the default, as for rolling averages)
+//     median    the median, the mean of the middle two for an even count
+//     min       the minimum
+//     max       the maximum
+//     stddev    the sample standard deviation, 0 for fewer than two values
+//     mad       the median absolute deviation from the median, a robust
+//               stddev, about 1.4826 times it for normal values
+//     geomean   the geometric mean, e.g. of growth rates, NaN unless all
+//               the values are positive
+//     harmmean  the harmonic mean, e.g. of speeds or ratios, NaN unless all
+//               the values are positive
+// aggregators that implement json.Marshaler and json.Unmarshaler have
+// their state saved with the window's, others are rebuilt from the
+// window's values
+
+
+package rollingavg
+
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+
+// Aggregator keeps a statistic of a changing set of values.
+// Remove is only called with values that have been added
+type Aggregator interface {
+	Add(v float64)
+	Remove(v float64)
+	Value() float64
+}
+
+
+var aggregators = map[string]func() Aggregator{
+	"mean":     func() Aggregator { return &meanAggregator{} },
+	"median":   func() Aggregator { return &sortedAggregator{stat: median} },
+	"min":      func() Aggregator { return &sortedAggregator{stat: minimum} },
+	"max":      func() Aggregator { return &sortedAggregator{stat: maximum} },
+	"stddev":   func() Aggregator { return &stddevAggregator{} },
+	"mad":      func() Aggregator { return &sortedAggregator{stat: MedianAbsDeviation} },
+	"geomean":  func() Aggregator { return &transformedMean{f: math.Log, inv: math.Exp} },
+	"harmmean": func() Aggregator { return &transformedMean{f: reciprocal, inv: reciprocal} },
+}
+
+
+// RegisterAggregator makes an aggregator available by name, replacing
+// any registered with the same name. it is not safe to call concurrently
+// with LookupAggregator
+func RegisterAggregator(name string, newAggregator func() Aggregator) {
+	aggregators[name] = newAggregator
+}
+
+
+// LookupAggregator returns the constructor of the named aggregator
+func LookupAggregator(name string) (func() Aggregator, error) {
+	newAggregator, ok := aggregators[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown aggregator: %s (have %s)", name, strings.Join(AggregatorNames(), ", "))
+	}
+	return newAggregator, nil
+}
+
+
+// AggregatorNames returns the names of the registered aggregators, sorted
+func AggregatorNames() []string {
+	names := make([]string, 0, len(aggregators))
+	for name := range aggregators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+
+// StatColumn returns the output column name prefix for an aggregator,
+// "Average" for the mean, otherwise its name capitalised, e.g. "Median"
+func StatColumn(name string) string {
+	if name == "" || name == "mean" {
+		return "Average"
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+
+// the mean, from a running sum
+type meanAggregator struct {
+	Sum float64 `json:"sum"`
+	N   int     `json:"n"`
+}
+
+func (m *meanAggregator) Add(v float64) {
+	m.Sum += v
+	m.N++
+}
+
+func (m *meanAggregator) Remove(v float64) {
+	m.Sum -= v
+	m.N--
+}
+
+func (m *meanAggregator) Value() float64 {
+	return m.Sum / float64(m.N)
+}
+
+func (m *meanAggregator) MarshalJSON() ([]byte, error) {
+	type state meanAggregator
+	return json.Marshal((*state)(m))
+}
+
+func (m *meanAggregator) UnmarshalJSON(data []byte) error {
+	type state meanAggregator
+	return json.Unmarshal(data, (*state)(m))
+}
+
+
+// order statistics, from the values kept in sorted order. NaNs, which
+// don't sort, are counted instead, and make the statistic NaN while in
+// the window
+type sortedAggregator struct {
+	values []float64
+	nans   int
+	stat   func(sorted []float64) float64
+}
+
+func (s *sortedAggregator) Add(v float64) {
+	if math.IsNaN(v) {
+		s.nans++
+		return
+	}
+	i := sort.SearchFloat64s(s.values, v)
+	s.values = append(s.values, 0)
+	copy(s.values[i+1:], s.values[i:])
+	s.values[i] = v
+}
+
+func (s *sortedAggregator) Remove(v float64) {
+	if math.IsNaN(v) {
+		s.nans--
+		return
+	}
+	i := sort.SearchFloat64s(s.values, v)
+	if i < len(s.values) && s.values[i] == v {
+		s.values = append(s.values[:i], s.values[i+1:]...)
+	}
+}
+
+func (s *sortedAggregator) Value() float64 {
+	if len(s.values) == 0 || s.nans > 0 {
+		return math.NaN()
+	}
+	return s.stat(s.values)
+}
+
+func median(sorted []float64) float64 {
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+func minimum(sorted []float64) float64 {
+	return sorted[0]
+}
+
+func maximum(sorted []float64) float64 {
+	return sorted[len(sorted)-1]
+}
+
+
+// MedianAbsDeviation returns the median of the absolute deviations of
+// sorted values from their median, unscaled, or NaN if there are none
+func MedianAbsDeviation(sorted []float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	m := median(sorted)
+	devs := make([]float64, len(sorted))
+	for i, v := range sorted {
+		devs[i] = math.Abs(v - m)
+	}
+	sort.Float64s(devs)
+	return median(devs)
+}
+
+
+// the sample standard deviation, using Welford's method, reversed for
+// removals
+type stddevAggregator struct {
+	n    int
+	mean float64
+	m2   float64
+}
+
+func (s *stddevAggregator) Add(v float64) {
+	s.n++
+	d := v - s.mean
+	s.mean += d / float64(s.n)
+	s.m2 += d * (v - s.mean)
+}
+
+func (s *stddevAggregator) Remove(v float64) {
+	if s.n <= 1 {
+		*s = stddevAggregator{}
+		return
+	}
+	n := float64(s.n)
+	prev := (n*s.mean - v) / (n - 1)
+	s.m2 -= (v - prev) * (v - s.mean)
+	s.mean = prev
+	s.n--
+	if s.m2 < 0 {
+		s.m2 = 0
+	}
+}
+
+func (s *stddevAggregator) Value() float64 {
+	if s.n < 2 {
+		return 0
+	}
+	return math.Sqrt(s.m2 / float64(s.n-1))
+}
+
+
+// a mean of transformed values, transformed back, e.g. the geometric mean
+// from the mean of the logs of the values. only defined for positive
+// values, so those that aren't are counted rather than transformed
+type transformedMean struct {
+	state  transformedMeanState
+	f, inv func(float64) float64
+}
+
+type transformedMeanState struct {
+	Sum    float64 `json:"sum"`
+	N      int     `json:"n"`
+	NonPos int     `json:"nonpos"`
+}
+
+func (m *transformedMean) Add(v float64) {
+	m.state.N++
+	if v <= 0 {
+		m.state.NonPos++
+		return
+	}
+	m.state.Sum += m.f(v)
+}
+
+func (m *transformedMean) Remove(v float64) {
+	m.state.N--
+	if v <= 0 {
+		m.state.NonPos--
+		return
+	}
+	m.state.Sum -= m.f(v)
+}
+
+func (m *transformedMean) Value() float64 {
+	if m.state.N == 0 || m.state.NonPos > 0 {
+		return math.NaN()
+	}
+	return m.inv(m.state.Sum / float64(m.state.N))
+}
+
+func (m *transformedMean) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.state)
+}
+
+func (m *transformedMean) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &m.state)
+}
+
+func reciprocal(v float64) float64 {
+	return 1 / v
+}
+
+
+// save an aggregator's state, if it has state to save
+func marshalAggregator(agg Aggregator) (json.RawMessage, error) {
+	if m, ok := agg.(json.Marshaler); ok {
+		return m.MarshalJSON()
+	}
+	return nil, nil
+}
+
+
+// restore an aggregator from saved state, or failing that, from the values
+func restoreAggregator(agg Aggregator, state json.RawMessage, values []float64) error {
+	if u, ok := agg.(json.Unmarshaler); ok && state != nil {
+		return u.UnmarshalJSON(state)
+	}
+	for _, v := range values {
+		agg.Add(v)
+	}
+	return nil
+}
@@ -0,0 +1,296 @@
+// calendar.go: calendar-aware rolling windows
+//
+// instead of a fixed number of rows, a window can span a number of
+// business days (skipping weekends and holidays) or calendar months,
+// as needed for financial time series, or of minutes, hours or days.
+// as with row windows, the window is forward looking: each row is output
+// with the averages over all rows from its own timestamp up to, but not
+// including, the point n business days, months, etc. later.
+// with business day windows, rows dated on weekends or holidays are skipped.
+//
+// windows can instead be aligned to the boundaries of their unit in a
+// timezone, each row's window ending at the end of the n unit period it's
+// in, so that tumbling windows are of reporting periods, e.g. hours from
+// the top of the hour. periods of minutes and hours are counted from
+// midnight, so n must divide a day, and of months from the start of the
+// year, so n must divide 12, e.g. 3 for quarters. periods of days start at
+// the midnight of each row's date. business day windows already end at
+// midnight, in the timestamps' own timezone, so are unaffected
+//
+// a holiday list contains one date (YYYY-MM-DD) per line,
+// blank lines and lines starting with # are ignored
+
+
+package rollingavg
+
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+
+// Holidays is a set of holiday dates, keyed by YYYY-MM-DD
+type Holidays map[string]bool
+
+
+// LoadHolidays reads a holiday list containing one YYYY-MM-DD date per line
+func LoadHolidays(r io.Reader) (Holidays, error) {
+	holidays := make(Holidays)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		d, err := time.Parse("2006-01-02", line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid holiday date: %w", err)
+		}
+		holidays[d.Format("2006-01-02")] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return holidays, nil
+}
+
+
+// IsBusinessDay reports whether t's date is neither a weekend nor a holiday
+func (h Holidays) IsBusinessDay(t time.Time) bool {
+	wd := t.Weekday()
+	if wd == time.Saturday || wd == time.Sunday {
+		return false
+	}
+	return !h[t.Format("2006-01-02")]
+}
+
+
+// return midnight at the start of the date n business days after t's date
+// t's date counts as the first business day of the n
+func (h Holidays) addBusinessDays(t time.Time, n int) time.Time {
+	d := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	for n > 0 {
+		if h.IsBusinessDay(d) {
+			n--
+		}
+		d = d.AddDate(0, 0, 1)
+	}
+	return d
+}
+
+
+// a buffered row awaiting the end of its window
+type calendarRow struct {
+	record []string
+	a, b   float64
+	end    time.Time
+}
+
+
+// CalendarWindow is the rolling window state for a single series, where
+// the window spans a number of business days or calendar months rather
+// than rows
+type CalendarWindow struct {
+	unit     string
+	length   int
+	tcol     int
+	holidays Holidays
+	rows     []calendarRow
+	aggA     Aggregator
+	aggB     Aggregator
+	n        int
+
+	// the date of the last business day window end worked out, and its end,
+	// as consecutive rows are often on the same date
+	lastDate time.Time
+	lastEnd  time.Time
+
+	// whether windows end on the boundaries of periods of their unit, in loc
+	align bool
+	loc   *time.Location
+
+	// the approximate memory of the buffered rows
+	bytes int64
+}
+
+
+// NewCalendarWindow returns a window of length units, "bdays", "months",
+// "minutes", "hours" or "days",
+// of the timestamps in column tcol, whose statistic is kept by aggregators
+// from newAggregator, or the mean if nil
+func NewCalendarWindow(unit string, length int, tcol int, holidays Holidays, newAggregator func() Aggregator) *CalendarWindow {
+	if newAggregator == nil {
+		newAggregator = aggregators["mean"]
+	}
+	return &CalendarWindow{unit: unit, length: length, tcol: tcol, holidays: holidays,
+		aggA: newAggregator(), aggB: newAggregator()}
+}
+
+
+// return the (exclusive) end of the window starting at t
+func (w *CalendarWindow) windowEnd(t time.Time) time.Time {
+	if w.align && w.unit != "bdays" {
+		return w.periodEnd(t)
+	}
+	switch w.unit {
+	case "minutes":
+		return t.Add(time.Duration(w.length) * time.Minute)
+	case "hours":
+		return t.Add(time.Duration(w.length) * time.Hour)
+	case "days":
+		return t.AddDate(0, 0, w.length)
+	case "months":
+		return t.AddDate(0, w.length, 0)
+	}
+	date := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	if !date.Equal(w.lastDate) {
+		w.lastDate, w.lastEnd = date, w.holidays.addBusinessDays(t, w.length)
+	}
+	return w.lastEnd
+}
+
+
+// return the end of the aligned period of length units that t is in, of
+// the wall clock time in w.loc
+func (w *CalendarWindow) periodEnd(t time.Time) time.Time {
+	lt := t.In(w.loc)
+	y, mo, d := lt.Date()
+	switch w.unit {
+	case "minutes":
+		m := lt.Hour()*60 + lt.Minute()
+		return time.Date(y, mo, d, 0, m-m%w.length+w.length, 0, 0, w.loc)
+	case "hours":
+		h := lt.Hour()
+		return time.Date(y, mo, d, h-h%w.length+w.length, 0, 0, 0, w.loc)
+	case "days":
+		return time.Date(y, mo, d+w.length, 0, 0, 0, 0, w.loc)
+	}
+	m := int(mo) - 1
+	return time.Date(y, time.Month(m-m%w.length+w.length+1), 1, 0, 0, 0, 0, w.loc)
+}
+
+
+// SetAlign sets whether windows end on the boundaries of periods of their
+// unit, in loc, or UTC if nil, rather than n units after each row
+func (w *CalendarWindow) SetAlign(align bool, loc *time.Location) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	w.align, w.loc = align, loc
+}
+
+
+// Add adds a record to the window, returning any buffered records whose
+// windows are now complete, along with their rolling averages.
+// rows are expected to be in chronological order
+func (w *CalendarWindow) Add(record []string, a, b float64) (results []Result, err error) {
+	if w.tcol >= len(record) {
+		return nil, fmt.Errorf("record missing time column: %v", record)
+	}
+	t, err := ParseTime(record[w.tcol])
+	if err != nil {
+		return nil, fmt.Errorf("invalid timestamp: %w", err)
+	}
+
+	// rows on non-business days are skipped
+	if w.unit == "bdays" && !w.holidays.IsBusinessDay(t) {
+		return nil, nil
+	}
+
+	// the new row falls outside the windows of the oldest buffered rows,
+	// so all rows in their windows have been seen
+	for len(w.rows) > 0 && !t.Before(w.rows[0].end) {
+		oldest := w.rows[0]
+		results = append(results, Result{oldest.record, w.aggA.Value(), w.aggB.Value()})
+		w.aggA.Remove(oldest.a)
+		w.aggB.Remove(oldest.b)
+		w.rows = w.rows[1:]
+		w.bytes -= calendarRowSize + recordSize(oldest.record)
+	}
+
+	w.rows = append(w.rows, calendarRow{record, a, b, w.windowEnd(t)})
+	w.bytes += calendarRowSize + recordSize(record)
+	w.aggA.Add(a)
+	w.aggB.Add(b)
+	w.n++
+	return results, nil
+}
+
+
+// exported form of a buffered calendarRow
+type calendarRowState struct {
+	Record []string  `json:"record"`
+	A      float64   `json:"a"`
+	B      float64   `json:"b"`
+	End    time.Time `json:"end"`
+}
+
+// exported form of a CalendarWindow's state
+type calendarWindowState struct {
+	Rows []calendarRowState `json:"rows"`
+	AggA json.RawMessage    `json:"agg_a,omitempty"`
+	AggB json.RawMessage    `json:"agg_b,omitempty"`
+	N    int                `json:"n"`
+}
+
+func (w *CalendarWindow) MarshalJSON() ([]byte, error) {
+	s := calendarWindowState{N: w.n}
+	for _, r := range w.rows {
+		s.Rows = append(s.Rows, calendarRowState{r.record, r.a, r.b, r.end})
+	}
+	var err error
+	if s.AggA, err = marshalAggregator(w.aggA); err != nil {
+		return nil, err
+	}
+	if s.AggB, err = marshalAggregator(w.aggB); err != nil {
+		return nil, err
+	}
+	return json.Marshal(s)
+}
+
+func (w *CalendarWindow) UnmarshalJSON(data []byte) error {
+	var s calendarWindowState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	w.rows = nil
+	w.bytes = 0
+	var as, bs []float64
+	for _, r := range s.Rows {
+		w.rows = append(w.rows, calendarRow{r.Record, r.A, r.B, r.End})
+		w.bytes += calendarRowSize + recordSize(r.Record)
+		as = append(as, r.A)
+		bs = append(bs, r.B)
+	}
+	w.n = s.N
+	if err := restoreAggregator(w.aggA, s.AggA, as); err != nil {
+		return err
+	}
+	return restoreAggregator(w.aggB, s.AggB, bs)
+}
+
+
+// the approximate memory of a buffered calendarRow, other than its record,
+// allowing for aggregators keeping its values
+const calendarRowSize = 24 + 8 + 8 + 24 + 16
+
+
+// the approximate memory held by the window
+func (w *CalendarWindow) memSize() int64 {
+	return w.bytes
+}
+
+
+// Pending returns the buffered records not yet output
+func (w *CalendarWindow) Pending() [][]string {
+	records := make([][]string, len(w.rows))
+	for i, r := range w.rows {
+		records[i] = r.record
+	}
+	return records
+}
@@ -0,0 +1,294 @@
+// parallel.go: parallel processing of a CSV file in chunks
+//
+// a large CSV file can be split into chunks of lines by SplitChunks, and
+// processed by RunParallel with a worker goroutine per chunk, up to a
+// number at once. each worker runs the records of its chunk through its
+// own windows, then carries on reading the records after its chunk, the
+// window overlap, until all of its records' windows are complete, or the
+// end of the file. the output rows of all the workers are then stitched
+// together in the order a sequential run outputs them, which is the order
+// of the records completing their windows.
+// with row windows and no grouping, the overlap is just the window length
+// less one. with grouping, a worker reads on until the last of its series
+// has enough records, so series that are rare, or end, within the file
+// make for long overlaps.
+// chunks split at newlines, so quoted fields must not contain newlines.
+// the mean and standard deviation are kept as running sums, from which a
+// sequential run's rounding errors differ, so they may differ from it in
+// the last digits. the other statistics are exact
+
+
+package rollingavg
+
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+
+// Chunks is a CSV file, or part of one, split into chunks for RunParallel
+type Chunks struct {
+	// the file
+	R io.ReaderAt
+
+	// the byte offsets of the start of each chunk, and of the end of the last
+	Bounds []int64
+
+	// the number of fields per record, as for csv.Reader.FieldsPerRecord
+	Fields int
+}
+
+
+// SplitChunks splits the CSV records of r, from byte offset start up to
+// end, into chunks of about chunkSize bytes, each starting at a line
+func SplitChunks(r io.ReaderAt, start, end, chunkSize int64, fields int) (*Chunks, error) {
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("invalid chunk size: %d", chunkSize)
+	}
+	c := &Chunks{R: r, Bounds: []int64{start}, Fields: fields}
+	for offset := start + chunkSize; offset < end; offset += chunkSize {
+		// the next chunk starts after the end of the line at offset
+		br := bufio.NewReader(io.NewSectionReader(r, offset, end-offset))
+		line, err := br.ReadSlice('\n')
+		for err == bufio.ErrBufferFull {
+			var more []byte
+			more, err = br.ReadSlice('\n')
+			line = append(line, more...)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		offset += int64(len(line))
+		if offset >= end {
+			break
+		}
+		if offset > c.Bounds[len(c.Bounds)-1] {
+			c.Bounds = append(c.Bounds, offset)
+		}
+	}
+	c.Bounds = append(c.Bounds, end)
+	return c, nil
+}
+
+
+// Len returns the number of chunks
+func (c *Chunks) Len() int {
+	return len(c.Bounds) - 1
+}
+
+
+// a result of a worker, positioned by the record completing its window
+type chunkResult struct {
+	key   string
+	r     Result
+	chunk int // the chunk of the completing record
+	index int // the index of the completing record in its chunk
+}
+
+
+// the output of a worker
+type chunkOutput struct {
+	results []chunkResult
+	pending map[string][][]string // records of the chunk left in windows at the end
+	n       int
+	err     error
+	done    chan struct{}
+}
+
+
+// RunParallel is RunContext over the records of the chunks, run by up to
+// workers goroutines at once, each with its own windows. the output rows
+// are written to out in the order RunContext writes them, with OnWrite
+// called for each as it is written. OnRead is called concurrently by the
+// workers, with the index of the record in its chunk. OnRowDone and
+// FlushEach aren't used.
+// afterwards p.Windows holds the records of all series still buffered,
+// as after RunContext, unless ctx was cancelled. tumbling and adaptive
+// windows can't be processed in parallel
+func (p *Processor) RunParallel(ctx context.Context, chunks *Chunks, workers int, out RecordWriter) (int, error) {
+	if workers < 1 {
+		return 0, fmt.Errorf("invalid number of workers: %d", workers)
+	}
+	if p.Tumbling || p.WindowLengths {
+		return 0, fmt.Errorf("tumbling and adaptive windows can't be processed in parallel")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// a token is taken to start each chunk, and given back once its
+	// results are written, bounding the chunks held in memory
+	outputs := make([]*chunkOutput, chunks.Len())
+	for k := range outputs {
+		outputs[k] = &chunkOutput{done: make(chan struct{})}
+	}
+	tokens := make(chan struct{}, workers)
+	go func() {
+		for k := range outputs {
+			select {
+			case tokens <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			go p.runChunk(ctx, chunks, k, outputs[k])
+		}
+	}()
+
+	n := 0
+	// the next result to write of each chunk's output
+	next := make([]int, len(outputs))
+	for c, o := range outputs {
+		select {
+		case <-o.done:
+		case <-ctx.Done():
+			return n, ctx.Err()
+		}
+		if o.err != nil {
+			return n, o.err
+		}
+		n += o.n
+
+		// the results of the records completed in chunk c, from it and
+		// the chunks before it, in the order they were completed
+		var completed []chunkResult
+		for k := 0; k <= c; k++ {
+			results := outputs[k].results
+			i := next[k]
+			for i < len(results) && results[i].chunk == c {
+				i++
+			}
+			completed = append(completed, results[next[k]:i]...)
+			next[k] = i
+		}
+		sort.SliceStable(completed, func(i, j int) bool {
+			return completed[i].index < completed[j].index
+		})
+		for _, cr := range completed {
+			outrec := OutputRow(cr.r, p.Rule)
+			if err := out.Write(outrec); err != nil {
+				return n, fmt.Errorf("error writing record: %w", err)
+			}
+			if p.OnWrite != nil {
+				p.OnWrite(cr.key, cr.r, outrec)
+			}
+		}
+		<-tokens
+	}
+
+	// rebuild the windows from the records left buffered by each chunk,
+	// which are too few to complete any window
+	for _, o := range outputs {
+		for _, records := range o.pending {
+			for _, record := range records {
+				if _, _, err := p.add(record); err != nil {
+					return n, err
+				}
+			}
+		}
+	}
+	return n, nil
+}
+
+
+// errChunkDone ends reading after a chunk's overlap
+var errChunkDone = errors.New("chunk done")
+
+
+// run the records of chunk k through fresh windows, then the records after
+// it until all of its records' windows are complete
+func (p *Processor) runChunk(ctx context.Context, chunks *Chunks, k int, o *chunkOutput) {
+	defer close(o.done)
+	err := p.processChunk(ctx, chunks, k, o)
+	if err != nil && err != errChunkDone {
+		o.err = fmt.Errorf("chunk at byte %d: %w", chunks.Bounds[k], err)
+	}
+}
+
+
+func (p *Processor) processChunk(ctx context.Context, chunks *Chunks, k int, o *chunkOutput) error {
+	w := &Processor{NewWindow: p.NewWindow, GroupCol: p.GroupCol, Windows: make(map[string]Window)}
+	start, end := chunks.Bounds[k], chunks.Bounds[len(chunks.Bounds)-1]
+	in := csv.NewReader(io.NewSectionReader(chunks.R, start, end-start))
+	in.FieldsPerRecord = chunks.Fields
+
+	// the chunk of the next record, and its index in the chunk
+	c, index := k, 0
+	// in the overlap, the number of each series' records from chunk k
+	// still without complete windows
+	var remaining map[string]int
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for c+1 < len(chunks.Bounds)-1 && start+in.InputOffset() >= chunks.Bounds[c+1] {
+			c, index = c+1, 0
+		}
+		if c > k && remaining == nil {
+			remaining = make(map[string]int)
+			for key, win := range w.Windows {
+				if pending := len(win.Pending()); pending > 0 {
+					remaining[key] = pending
+				}
+			}
+		}
+		if remaining != nil && len(remaining) == 0 {
+			return errChunkDone
+		}
+
+		record, err := in.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading record: %w", err)
+		}
+		if c == k {
+			o.n++
+			if p.OnRead != nil {
+				p.OnRead(index, record)
+			}
+		} else if p.GroupCol >= 0 && p.GroupCol < len(record) && remaining[record[p.GroupCol]] == 0 {
+			// the overlap only matters to the series with records of chunk k
+			index++
+			continue
+		}
+
+		key, results, err := w.add(record)
+		if err != nil {
+			return err
+		}
+		if c > k && len(results) > remaining[key] {
+			results = results[:remaining[key]]
+		}
+		for _, r := range results {
+			o.results = append(o.results, chunkResult{key, r, c, index})
+		}
+		if c > k {
+			if remaining[key] -= len(results); remaining[key] == 0 {
+				delete(remaining, key)
+			}
+		}
+		index++
+	}
+
+	// at the end of the file, the records of chunk k still buffered
+	o.pending = make(map[string][][]string)
+	for key, win := range w.Windows {
+		pending := win.Pending()
+		if remaining != nil {
+			pending = pending[:remaining[key]]
+		}
+		if len(pending) > 0 {
+			o.pending[key] = pending
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+// parse.go: fast parsing of values and timestamps
+//
+// every record has its A and B values parsed, and with calendar windows,
+// its timestamp, so these are on the hot path of processing.
+// ParseFloat handles plain decimals, e.g. -129 or 2023.75, whose digits,
+// without the point, make an integer below 2^53, so those of up to 15
+// digits and some of 16, with up to 22 decimal places, directly: their
+// digits make an exact integer, which divided by an exact power of ten
+// gives the correctly rounded result, as strconv.ParseFloat does. anything
+// else, e.g. exponents, is left to strconv.ParseFloat.
+// ParseTime handles timestamps in TimeLayout, with any fractional seconds,
+// directly, rather than interpreting the layout for each, and leaves
+// anything else to time.Parse.
+// both give the same results and errors as the strconv and time functions
+
+
+package rollingavg
+
+
+import (
+	"strconv"
+	"time"
+)
+
+
+// exact powers of ten, up to the largest a float64 holds exactly
+var pow10 = [...]float64{1e0, 1e1, 1e2, 1e3, 1e4, 1e5, 1e6, 1e7, 1e8, 1e9, 1e10,
+	1e11, 1e12, 1e13, 1e14, 1e15, 1e16, 1e17, 1e18, 1e19, 1e20, 1e21, 1e22}
+
+
+// ParseFloat is strconv.ParseFloat(s, 64), faster for plain decimals
+func ParseFloat(s string) (float64, error) {
+	if f, ok := parseDecimal(s); ok {
+		return f, nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+
+// parse a plain decimal, returning false if s isn't one, or has too many
+// digits to parse exactly
+func parseDecimal(s string) (float64, bool) {
+	i, neg := 0, false
+	if len(s) > 0 && (s[0] == '-' || s[0] == '+') {
+		neg = s[0] == '-'
+		i++
+	}
+	var mant uint64
+	digits, frac, dot := 0, 0, false
+	for ; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= '0' && c <= '9':
+			if mant >= 1<<53/10 {
+				return 0, false
+			}
+			mant = mant*10 + uint64(c-'0')
+			digits++
+			if dot {
+				frac++
+			}
+		case c == '.' && !dot:
+			dot = true
+		default:
+			return 0, false
+		}
+	}
+	if digits == 0 || frac >= len(pow10) {
+		return 0, false
+	}
+	f := float64(mant) / pow10[frac]
+	if neg {
+		f = -f
+	}
+	return f, true
+}
+
+
+// ParseTime is time.Parse(TimeLayout, s), faster for timestamps in it
+func ParseTime(s string) (time.Time, error) {
+	if t, ok := parseTimestamp(s); ok {
+		return t, nil
+	}
+	return time.Parse(TimeLayout, s)
+}
+
+
+// parse a valid "2006-01-02 15:04:05" timestamp, with optional fractional
+// seconds, returning false if s isn't one
+func parseTimestamp(s string) (time.Time, bool) {
+	if len(s) < 19 || s[4] != '-' || s[7] != '-' || s[10] != ' ' || s[13] != ':' || s[16] != ':' {
+		return time.Time{}, false
+	}
+	year, ok1 := atoi(s[0:4])
+	month, ok2 := atoi(s[5:7])
+	day, ok3 := atoi(s[8:10])
+	hour, ok4 := atoi(s[11:13])
+	min, ok5 := atoi(s[14:16])
+	sec, ok6 := atoi(s[17:19])
+	if !(ok1 && ok2 && ok3 && ok4 && ok5 && ok6) {
+		return time.Time{}, false
+	}
+	nsec := 0
+	if len(s) > 19 {
+		// 1 to 9 digits of fractional seconds
+		if s[19] != '.' || len(s) == 20 || len(s) > 29 {
+			return time.Time{}, false
+		}
+		f, ok := atoi(s[20:])
+		if !ok {
+			return time.Time{}, false
+		}
+		nsec = f * int(pow10[29-len(s)])
+	}
+	if month < 1 || month > 12 || day < 1 || day > daysIn(time.Month(month), year) ||
+		hour > 23 || min > 59 || sec > 59 {
+		return time.Time{}, false
+	}
+	return time.Date(year, time.Month(month), day, hour, min, sec, nsec, time.UTC), true
+}
+
+
+// parse a string of digits, returning false if it has anything else
+func atoi(s string) (int, bool) {
+	n := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n, true
+}
+
+
+// the number of days in a month
+func daysIn(m time.Month, year int) int {
+	return time.Date(year, m+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
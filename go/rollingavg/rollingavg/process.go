@@ -0,0 +1,555 @@
+// process.go: run a stream of records through rolling windows
+//
+// a Processor reads records, parses their A and B values, adds each to
+// the window of its series, and writes the records whose windows are
+// complete, with their averages, as output rows.
+// hooks let the caller observe progress, e.g. for logging, metrics or
+// checkpointing, without changing the processing.
+// a Processor is configured directly, or from Options by NewProcessor.
+// Process is a one-shot entry point from a CSV reader to a CSV writer.
+// RunContext stops early, returning the context's error, when the context
+// is cancelled.
+// Tail gives the records left without complete windows at the end, with
+// statistics over their partial windows
+
+
+package rollingavg
+
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+
+// Options configures processing, as for the rollingavg command line.
+// zero values select the defaults
+type Options struct {
+	// window length, default 23
+	Window int
+
+	// window length of B, for row windows, default Window
+	WindowB int
+
+	// unit of the window length: rows (default), bdays, months, minutes,
+	// hours or days
+	WindowUnit string
+
+	// whether calendar windows are aligned to the boundaries of periods of
+	// their unit, in Location, or UTC if nil, see calendar.go
+	Align    bool
+	Location *time.Location
+
+	// if > 0, row windows are adaptive, from this many rows to Window,
+	// see adaptive.go
+	MinWindow int
+
+	// whether windows tumble, not overlapping, rather than slide, see
+	// tumbling.go
+	Tumbling bool
+
+	// window statistic, a registered aggregator name, default mean
+	Stat string
+
+	// window statistic of B, default Stat
+	StatB string
+
+	// rule for the Result column, a registered rule name, default threshold
+	Rule string
+
+	// column (name or index) grouping rows into independent series
+	GroupBy string
+
+	// timestamp column (name or index) for calendar windows, default 3
+	TimeColumn string
+
+	// holidays excluded from business days
+	Holidays Holidays
+
+	// the input column names, for Stream, where there is no header row
+	Columns []string
+}
+
+
+// Processor computes rolling averages over the records of a stream.
+// the header is expected to have already been read from the input
+type Processor struct {
+	// creates the window for each series
+	NewWindow func() Window
+
+	// creates the aggregators of the partial windows of Tail, the mean if nil,
+	// and those of B, NewAggregator if nil
+	NewAggregator  func() Aggregator
+	NewAggregatorB func() Aggregator
+
+	// if > 0, the most rows of the partial windows of A and B of Tail, for
+	// row windows whose lengths differ
+	TailRowsA int
+	TailRowsB int
+
+	// whether the records of results have their window's length appended,
+	// as those of adaptive windows do, Tail appending the lengths of the
+	// partial windows
+	WindowLengths bool
+
+	// whether windows tumble, Tail then giving one result, of the first
+	// record, for the partial window of each series
+	Tumbling bool
+
+	// if >= 0, rows are windowed independently per value of this column
+	GroupCol int
+
+	// the window of each series, keyed by group column value, or "" without
+	// grouping. may hold existing window state, e.g. from a checkpoint.
+	// after Run, holds the windows with any records still buffered
+	Windows map[string]Window
+
+	// gives the Result column value, ResultValue if nil
+	Rule Rule
+
+	// flush the output after each row, for streaming
+	FlushEach bool
+
+	// optional hooks, called after each record is read, after each output
+	// row is written, and after each record is processed
+	OnRead    func(n int, record []string)
+	OnWrite   func(group string, r Result, outrec []string)
+	OnRowDone func(n int)
+
+	// reuse the buffers of records and output rows, rather than allocating
+	// them for each record. records read are copied into buffers freed as
+	// their windows complete, so the reader may reuse its records, e.g. with
+	// csv.Reader.ReuseRecord, and the output rows written to out, and passed
+	// to OnWrite with their Result, are only valid until the next record.
+	// for RunContext only
+	ReuseRecords bool
+
+	// if > 0, the approximate bytes of memory held by windows above which
+	// the windows of the least recently used series are spilled to a
+	// temporary file in SpillDir, or the default temporary directory, see
+	// spill.go. Close removes the file
+	MaxMemory int64
+	SpillDir  string
+
+	// number of records added
+	n int
+
+	// with ReuseRecords, the free record buffers, and the output row and
+	// statistics buffers
+	free   [][]string
+	outrec []string
+	buf    []byte
+
+	// with MaxMemory, the spilled windows
+	spill *spillStore
+}
+
+
+// FindColumn finds a column by header name, or failing that by 0-based
+// index. returns -1 if not found
+func FindColumn(header []string, col string) int {
+	for i, name := range header {
+		if name == col {
+			return i
+		}
+	}
+	if i, err := strconv.Atoi(col); err == nil && i >= 0 && i < len(header) {
+		return i
+	}
+	return -1
+}
+
+
+// NewProcessor returns a Processor for input with the given header,
+// configured by opts
+func NewProcessor(header []string, opts Options) (*Processor, error) {
+	n := opts.Window
+	if n == 0 {
+		n = 23
+	}
+	if n < 0 {
+		return nil, fmt.Errorf("invalid window length: %d", n)
+	}
+	stat := opts.Stat
+	if stat == "" {
+		stat = "mean"
+	}
+	newAggregator, err := LookupAggregator(stat)
+	if err != nil {
+		return nil, err
+	}
+	newAggregatorB := newAggregator
+	if opts.StatB != "" {
+		if newAggregatorB, err = LookupAggregator(opts.StatB); err != nil {
+			return nil, err
+		}
+	}
+
+	p := &Processor{GroupCol: -1, NewAggregator: newAggregator, NewAggregatorB: newAggregatorB}
+	if opts.Rule != "" {
+		if p.Rule, err = LookupRule(opts.Rule); err != nil {
+			return nil, err
+		}
+	}
+	if opts.GroupBy != "" {
+		p.GroupCol = FindColumn(header, opts.GroupBy)
+		if p.GroupCol < 0 {
+			return nil, fmt.Errorf("group-by column not found in header: %s", opts.GroupBy)
+		}
+	}
+
+	switch opts.WindowUnit {
+	case "", "rows":
+		nb := opts.WindowB
+		if nb == 0 {
+			nb = n
+		}
+		if nb < 0 {
+			return nil, fmt.Errorf("invalid window length of B: %d", nb)
+		}
+		if opts.MinWindow > 0 {
+			if nb != n || opts.MinWindow > n {
+				return nil, fmt.Errorf("adaptive windows need a least length of at most %d rows, and the same length of B", n)
+			}
+			p.NewWindow = func() Window {
+				w := NewAdaptiveWindow(opts.MinWindow, n, newAggregator)
+				w.aggB = newAggregatorB()
+				return w
+			}
+			p.WindowLengths = true
+			break
+		}
+		p.NewWindow = func() Window {
+			w := NewRowWindowAB(n, nb, newAggregator)
+			w.aggB = newAggregatorB()
+			return w
+		}
+		if nb != n {
+			p.TailRowsA, p.TailRowsB = n, nb
+		}
+	case "bdays", "months", "minutes", "hours", "days":
+		if opts.WindowB != 0 && opts.WindowB != n {
+			return nil, fmt.Errorf("window lengths of A and B can only differ for row windows")
+		}
+		if opts.MinWindow > 0 {
+			return nil, fmt.Errorf("only row windows can be adaptive")
+		}
+		timecol := opts.TimeColumn
+		if timecol == "" {
+			timecol = "3"
+		}
+		tcol := FindColumn(header, timecol)
+		if tcol < 0 {
+			return nil, fmt.Errorf("time column not found in header: %s", timecol)
+		}
+		holidays := opts.Holidays
+		if holidays == nil {
+			holidays = make(Holidays)
+		}
+		unit := opts.WindowUnit
+		if opts.Align {
+			switch {
+			case unit == "minutes" && 24*60%n != 0, unit == "hours" && 24%n != 0:
+				return nil, fmt.Errorf("aligned windows of %d %s don't divide a day", n, unit)
+			case unit == "months" && 12%n != 0:
+				return nil, fmt.Errorf("aligned windows of %d months don't divide a year", n)
+			}
+		}
+		p.NewWindow = func() Window {
+			w := NewCalendarWindow(unit, n, tcol, holidays, newAggregator)
+			w.SetAlign(opts.Align, opts.Location)
+			w.aggB = newAggregatorB()
+			return w
+		}
+	default:
+		return nil, fmt.Errorf("invalid window unit: %s", opts.WindowUnit)
+	}
+	if opts.Tumbling {
+		if opts.MinWindow > 0 {
+			return nil, fmt.Errorf("adaptive windows can't tumble")
+		}
+		newWindow := p.NewWindow
+		if _, err := NewTumblingWindow(newWindow()); err != nil {
+			return nil, err
+		}
+		p.NewWindow = func() Window {
+			w, _ := NewTumblingWindow(newWindow())
+			return w
+		}
+		p.Tumbling = true
+	}
+	return p, nil
+}
+
+
+// Add processes a single record, returning the records whose windows
+// are now complete, with their statistics, in a slice that may be reused
+// by the next Add
+func (p *Processor) Add(record []string) ([]Result, error) {
+	_, results, err := p.add(record)
+	return results, err
+}
+
+
+// add a record to the window of its series, returning the series key
+func (p *Processor) add(record []string) (string, []Result, error) {
+	// one window per group, rows without grouping all share the "" key
+	if p.Windows == nil {
+		p.Windows = make(map[string]Window)
+	}
+
+	if len(record) < 2 {
+		return "", nil, fmt.Errorf("record %d: missing columns", p.n+1)
+	}
+	a, err := ParseFloat(record[0])
+	if err != nil {
+		return "", nil, fmt.Errorf("record %d: invalid column value: %w", p.n+1, err)
+	}
+	b, err := ParseFloat(record[1])
+	if err != nil {
+		return "", nil, fmt.Errorf("record %d: invalid column value: %w", p.n+1, err)
+	}
+
+	key := ""
+	if p.GroupCol >= 0 {
+		if p.GroupCol >= len(record) {
+			return "", nil, fmt.Errorf("record %d: missing group-by column", p.n+1)
+		}
+		key = record[p.GroupCol]
+	}
+	w, found := p.Windows[key]
+	if !found && p.spill != nil {
+		if w, err = p.unspill(key); err != nil {
+			return key, nil, err
+		}
+		found = w != nil
+	}
+	if !found {
+		w = p.NewWindow()
+		p.Windows[key] = w
+	}
+
+	results, err := w.Add(record, a, b)
+	if err != nil {
+		return key, nil, fmt.Errorf("record %d: %w", p.n+1, err)
+	}
+	p.n++
+	if p.MaxMemory > 0 {
+		if err := p.track(key, w); err != nil {
+			return key, nil, err
+		}
+	}
+	return key, results, nil
+}
+
+
+// Run processes the records of in until io.EOF, writing output rows to out.
+// returns the number of records processed
+func (p *Processor) Run(in RecordReader, out RecordWriter) (int, error) {
+	return p.RunContext(context.Background(), in, out)
+}
+
+
+// RunContext is Run, stopping when ctx is cancelled and returning its error.
+// the records read up to then have been processed, and their complete
+// output rows written. readers that block, e.g. waiting for more input,
+// should return io.EOF when ctx is cancelled
+func (p *Processor) RunContext(ctx context.Context, in RecordReader, out RecordWriter) (int, error) {
+	n := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		record, err := in.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, fmt.Errorf("error reading record: %w", err)
+		}
+		if p.OnRead != nil {
+			p.OnRead(n, record)
+		}
+		if p.ReuseRecords {
+			record = p.copyRecord(record)
+		}
+
+		key, results, err := p.add(record)
+		if err != nil {
+			return n, err
+		}
+		n++
+		for _, r := range results {
+			outrec := p.outputRow(r)
+			if err := out.Write(outrec); err != nil {
+				return n, fmt.Errorf("error writing record: %w", err)
+			}
+			if p.FlushEach {
+				out.Flush()
+				if err := out.Error(); err != nil {
+					return n, fmt.Errorf("error writing record: %w", err)
+				}
+			}
+			if p.OnWrite != nil {
+				p.OnWrite(key, r, outrec)
+			}
+			if p.ReuseRecords {
+				p.free = append(p.free, r.Record)
+			}
+		}
+		if p.OnRowDone != nil {
+			p.OnRowDone(n)
+		}
+	}
+	return n, ctx.Err()
+}
+
+
+// copy a record read into a free buffer, as its reader may reuse it
+func (p *Processor) copyRecord(record []string) []string {
+	var buf []string
+	if n := len(p.free); n > 0 {
+		buf, p.free = p.free[n-1][:0], p.free[:n-1]
+	}
+	return append(buf, record...)
+}
+
+
+// the output row for a result, as OutputRow, reusing the output row
+// buffer with ReuseRecords
+func (p *Processor) outputRow(r Result) []string {
+	if !p.ReuseRecords {
+		return OutputRow(r, p.Rule)
+	}
+	rule := p.Rule
+	if rule == nil {
+		rule = ResultValue
+	}
+	// both statistics are formatted into one string, allocated once
+	p.buf = strconv.AppendFloat(p.buf[:0], r.AvgA, 'f', -1, 64)
+	i := len(p.buf)
+	p.buf = strconv.AppendFloat(p.buf, r.AvgB, 'f', -1, 64)
+	stats := string(p.buf)
+	p.outrec = append(append(p.outrec[:0], r.Record...), stats[:i], stats[i:], rule(r.AvgA, r.AvgB))
+	return p.outrec
+}
+
+
+// Tail returns results for the records still buffered in the windows,
+// which have no complete window, with their statistics over the partial
+// windows of the records remaining after them. series are in key order
+func (p *Processor) Tail() ([]Result, error) {
+	newAggregator := p.NewAggregator
+	if newAggregator == nil {
+		newAggregator = aggregators["mean"]
+	}
+	newAggregatorB := p.NewAggregatorB
+	if newAggregatorB == nil {
+		newAggregatorB = newAggregator
+	}
+	var results []Result
+	err := p.EachWindow(func(key string, w Window) error {
+		pending := w.Pending()
+		tail := make([]Result, len(pending))
+		aggA, aggB := newAggregator(), newAggregatorB()
+		as, bs := make([]float64, len(pending)), make([]float64, len(pending))
+		// each record's partial window is itself and the records after it,
+		// up to the window's length
+		for i := len(pending) - 1; i >= 0; i-- {
+			a, err := ParseFloat(pending[i][0])
+			if err != nil {
+				return fmt.Errorf("tail record: invalid column value: %w", err)
+			}
+			b, err := ParseFloat(pending[i][1])
+			if err != nil {
+				return fmt.Errorf("tail record: invalid column value: %w", err)
+			}
+			as[i], bs[i] = a, b
+			aggA.Add(a)
+			aggB.Add(b)
+			if p.TailRowsA > 0 && i+p.TailRowsA < len(pending) {
+				aggA.Remove(as[i+p.TailRowsA])
+			}
+			if p.TailRowsB > 0 && i+p.TailRowsB < len(pending) {
+				aggB.Remove(bs[i+p.TailRowsB])
+			}
+			record := pending[i]
+			if p.WindowLengths {
+				record = append(record[:len(record):len(record)], strconv.Itoa(len(pending)-i))
+			}
+			tail[i] = Result{Record: record, AvgA: aggA.Value(), AvgB: aggB.Value()}
+		}
+		if p.Tumbling {
+			tail = tail[:min(len(tail), 1)]
+		}
+		results = append(results, tail...)
+		return nil
+	})
+	return results, err
+}
+
+
+// Counts reports the records processed by Process
+type Counts struct {
+	// input records read, not counting the header
+	Read int
+
+	// output rows written, not counting the header
+	Written int
+
+	// records still buffered in windows, without complete windows to output
+	Pending int
+}
+
+
+// Process reads a CSV, with a header row, from r, and writes the output
+// CSV, with its header row, to w, configured by opts
+func Process(r io.Reader, w io.Writer, opts Options) (Counts, error) {
+	return ProcessContext(context.Background(), r, w, opts)
+}
+
+
+// ProcessContext is Process, stopping when ctx is cancelled and returning
+// its error, with the output so far written
+func ProcessContext(ctx context.Context, r io.Reader, w io.Writer, opts Options) (Counts, error) {
+	var counts Counts
+	in := csv.NewReader(r)
+	header, err := in.Read()
+	if err != nil {
+		return counts, fmt.Errorf("error reading header: %w", err)
+	}
+	p, err := NewProcessor(header, opts)
+	if err != nil {
+		return counts, err
+	}
+	p.OnWrite = func(group string, r Result, outrec []string) {
+		counts.Written++
+	}
+
+	out := csv.NewWriter(w)
+	if p.WindowLengths {
+		header = append(header[:len(header):len(header)], WindowLengthColumn)
+	}
+	if err := out.Write(OutputHeaderAB(header, opts.Stat, opts.StatB)); err != nil {
+		return counts, fmt.Errorf("error writing header: %w", err)
+	}
+	in.ReuseRecord = true
+	p.ReuseRecords = true
+	counts.Read, err = p.RunContext(ctx, in, out)
+	p.EachWindow(func(key string, win Window) error {
+		counts.Pending += len(win.Pending())
+		return nil
+	})
+	out.Flush()
+	if err != nil {
+		return counts, err
+	}
+	if err := out.Error(); err != nil {
+		return counts, fmt.Errorf("error writing record: %w", err)
+	}
+	return counts, nil
+}
@@ -0,0 +1,294 @@
+// rollingavg.go: forward looking rolling averages over series of records
+//
+// the windowing, parsing and output row logic of the rollingavg command,
+// as a package other Go programs can embed.
+// records are CSV style rows, whose first two columns (A and B) are
+// averaged. each record is output with the averages of A and B over the
+// window starting at it, and a Result flag, once its window is complete.
+// windows are a number of rows (see NewRowWindow), or business days or
+// calendar months of a timestamp column (see calendar.go), or an adaptive
+// number of rows (see adaptive.go).
+// other window statistics than the mean can be used, see aggregator.go,
+// and other rules for the Result flag, see rule.go.
+// a Processor runs records through a window per series, see process.go,
+// or rows can be streamed through channels, see stream.go, or a large
+// file processed in parallel chunks, see parallel.go.
+// a Processor's memory can be bounded by spilling windows to disk, see
+// spill.go
+
+
+// Package rollingavg computes forward looking rolling averages of the
+// first two columns of CSV style records, as the rollingavg command does.
+package rollingavg
+
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+
+// TimeLayout is the layout of timestamp columns, fractional seconds are
+// accepted when parsing
+const TimeLayout = "2006-01-02 15:04:05"
+
+
+// RecordReader is a source of records, satisfied by *csv.Reader.
+// it returns io.EOF when there are no more records
+type RecordReader interface {
+	Read() (record []string, err error)
+}
+
+
+// RecordWriter is a destination for records, satisfied by *csv.Writer
+type RecordWriter interface {
+	Write(record []string) error
+	Flush()
+	Error() error
+}
+
+
+// Result is a record whose window is complete, with its rolling averages,
+// or other window statistic
+type Result struct {
+	Record []string
+	AvgA   float64
+	AvgB   float64
+}
+
+
+// Window is the rolling window state for a single series. Add takes the
+// next record and its A and B values, and returns the records whose
+// windows are now complete, in a slice that may be reused by the next
+// Add. Pending returns the records still awaiting
+// a complete window, oldest first. windows marshal their full state to
+// JSON, e.g. for checkpointing
+type Window interface {
+	Add(record []string, a, b float64) ([]Result, error)
+	Pending() [][]string
+	json.Marshaler
+	json.Unmarshaler
+}
+
+
+// ResultValue is the Result column value for a pair of averages,
+// "1" if both are below their thresholds, otherwise "0".
+// it is the default, "threshold", Rule
+func ResultValue(avga, avgb float64) string {
+	if avga < -1 && avgb < -1500 {
+		return "1"
+	}
+	return "0"
+}
+
+
+// OutputHeader returns the output header for an input header, with the
+// columns named for the window statistic, e.g. "Average A" for the mean
+func OutputHeader(header []string, stat string) []string {
+	return OutputHeaderAB(header, stat, stat)
+}
+
+
+// OutputHeaderAB returns the output header for an input header, with the
+// columns named for the window statistics of A and B, that of B being
+// that of A if empty
+func OutputHeaderAB(header []string, statA, statB string) []string {
+	if statB == "" {
+		statB = statA
+	}
+	return append(header[:len(header):len(header)], StatColumn(statA)+" A", StatColumn(statB)+" B", "Result")
+}
+
+
+// OutputRow returns the output record for a result: the input record
+// followed by the averages and the Result value given by rule, or
+// ResultValue if nil
+func OutputRow(r Result, rule Rule) []string {
+	if rule == nil {
+		rule = ResultValue
+	}
+	outrec := make([]string, len(r.Record), len(r.Record)+3)
+	copy(outrec, r.Record)
+	return append(outrec,
+		strconv.FormatFloat(r.AvgA, 'f', -1, 64),
+		strconv.FormatFloat(r.AvgB, 'f', -1, 64),
+		rule(r.AvgA, r.AvgB))
+}
+
+
+// RowWindow is a rolling window of a fixed number of rows, which may
+// differ for A and B.
+// uses circular buffers to keep track of previous values for the window
+// statistic
+type RowWindow struct {
+	cbufA []float64
+	cbufB []float64
+	rows  [][]string
+	aggA  Aggregator
+	aggB  Aggregator
+	n     int
+
+	// the window lengths of A and B, the longer being that of the buffers
+	lenA int
+	lenB int
+
+	// the result returned by Add, reused to save allocating it
+	result [1]Result
+
+	// the approximate memory of the buffered records
+	bytes int64
+}
+
+
+// NewRowWindow returns a window of interval rows, whose statistic is kept
+// by aggregators from newAggregator, or the mean if nil
+func NewRowWindow(interval int, newAggregator func() Aggregator) *RowWindow {
+	return NewRowWindowAB(interval, interval, newAggregator)
+}
+
+
+// NewRowWindowAB returns a window of intervalA rows for A and intervalB
+// rows for B. records are output once the longer window is full
+func NewRowWindowAB(intervalA, intervalB int, newAggregator func() Aggregator) *RowWindow {
+	if newAggregator == nil {
+		newAggregator = aggregators["mean"]
+	}
+	interval := max(intervalA, intervalB)
+	return &RowWindow{
+		cbufA: make([]float64, interval),
+		cbufB: make([]float64, interval),
+		rows:  make([][]string, interval),
+		aggA:  newAggregator(),
+		aggB:  newAggregator(),
+		lenA:  intervalA,
+		lenB:  intervalB,
+	}
+}
+
+
+// the record whose value the aggregator of a window of k rows takes when
+// record m is added and record out output, or -1. the aggregator holds
+// the values of the window starting at the record output
+func windowRecord(k, m, out int) int {
+	switch {
+	case m < k:
+		return m
+	case out > 0:
+		return out + k - 1
+	}
+	return -1
+}
+
+
+// Add adds a record to the window. once the window is full, returns the
+// oldest buffered record with the statistics over the window
+func (w *RowWindow) Add(record []string, a, b float64) ([]Result, error) {
+	interval := len(w.cbufA)
+
+	// once full, the oldest values make way for the new
+	out := w.n - interval + 1
+	if out > 0 {
+		j := (out - 1) % interval
+		w.aggA.Remove(w.cbufA[j])
+		w.aggB.Remove(w.cbufB[j])
+	}
+	i := w.n % interval
+	w.cbufA[i] = a
+	w.cbufB[i] = b
+	w.bytes += recordSize(record) - recordSize(w.rows[i])
+	w.rows[i] = record
+	if j := windowRecord(w.lenA, w.n, out); j >= 0 {
+		w.aggA.Add(w.cbufA[j%interval])
+	}
+	if j := windowRecord(w.lenB, w.n, out); j >= 0 {
+		w.aggB.Add(w.cbufB[j%interval])
+	}
+
+	w.n++
+	if w.n < interval {
+		return nil, nil
+	}
+	w.result[0] = Result{w.rows[w.n%interval], w.aggA.Value(), w.aggB.Value()}
+	return w.result[:], nil
+}
+
+
+// exported form of a RowWindow's state
+type rowWindowState struct {
+	CbufA []float64       `json:"cbuf_a"`
+	CbufB []float64       `json:"cbuf_b"`
+	Rows  [][]string      `json:"rows"`
+	AggA  json.RawMessage `json:"agg_a,omitempty"`
+	AggB  json.RawMessage `json:"agg_b,omitempty"`
+	N     int             `json:"n"`
+}
+
+func (w *RowWindow) MarshalJSON() ([]byte, error) {
+	s := rowWindowState{CbufA: w.cbufA, CbufB: w.cbufB, Rows: w.rows, N: w.n}
+	var err error
+	if s.AggA, err = marshalAggregator(w.aggA); err != nil {
+		return nil, err
+	}
+	if s.AggB, err = marshalAggregator(w.aggB); err != nil {
+		return nil, err
+	}
+	return json.Marshal(s)
+}
+
+func (w *RowWindow) UnmarshalJSON(data []byte) error {
+	var s rowWindowState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if len(s.CbufA) != len(w.cbufA) || len(s.CbufB) != len(w.cbufB) || len(s.Rows) != len(w.rows) {
+		return fmt.Errorf("window state has interval %d, not %d", len(s.CbufA), len(w.cbufA))
+	}
+	w.cbufA, w.cbufB, w.rows, w.n = s.CbufA, s.CbufB, s.Rows, s.N
+	w.bytes = 0
+	for _, record := range w.rows {
+		w.bytes += recordSize(record)
+	}
+
+	if err := restoreAggregator(w.aggA, s.AggA, w.windowValues(w.cbufA, w.lenA)); err != nil {
+		return err
+	}
+	return restoreAggregator(w.aggB, s.AggB, w.windowValues(w.cbufB, w.lenB))
+}
+
+
+// the values of a column's buffer in its window of k rows, starting at the
+// last record output, or the first record
+func (w *RowWindow) windowValues(cbuf []float64, k int) []float64 {
+	interval := len(cbuf)
+	first := max(w.n-interval, 0)
+	last := min(first+k, w.n)
+	values := make([]float64, 0, last-first)
+	for j := first; j < last; j++ {
+		values = append(values, cbuf[j%interval])
+	}
+	return values
+}
+
+
+// the approximate memory held by the window: its buffers of values and
+// records, allowing for aggregators keeping the values, and the records
+func (w *RowWindow) memSize() int64 {
+	return int64(len(w.rows))*(8+8+24+16) + w.bytes
+}
+
+
+// Pending returns the buffered records not yet output, i.e. the most
+// recent interval-1
+func (w *RowWindow) Pending() [][]string {
+	interval := len(w.rows)
+	first := 0
+	if w.n >= interval {
+		first = w.n - interval + 1
+	}
+	records := make([][]string, 0, interval)
+	for i := first; i < w.n; i++ {
+		records = append(records, w.rows[i%interval])
+	}
+	return records
+}
@@ -0,0 +1,89 @@
+// rule.go: rules deciding the Result column
+//
+// a Rule gives the Result value of an output row from its window
+// statistics. rules are registered by name, and the built-in is:
+//     threshold  "1" if A is below -1 and B below -1500, otherwise "0"
+// ThresholdRule gives the threshold rule with other thresholds, and
+// LabelRule a rule of ordered levels with labels other than "1" and "0"
+// site specific rules can be registered, e.g. by a plugin
+
+
+package rollingavg
+
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+
+// Rule returns the Result value for a pair of window statistics
+type Rule func(avga, avgb float64) string
+
+
+var rules = map[string]Rule{
+	"threshold": ResultValue,
+}
+
+
+// ThresholdRule returns a rule giving "1" if A is below thresholda and B
+// below thresholdb, otherwise "0". ResultValue is ThresholdRule(-1, -1500)
+func ThresholdRule(thresholda, thresholdb float64) Rule {
+	return func(avga, avgb float64) string {
+		if avga < thresholda && avgb < thresholdb {
+			return "1"
+		}
+		return "0"
+	}
+}
+
+
+// Level is a level of a LabelRule, whose label is given when Match is true
+type Level struct {
+	Label string
+	Match func(avga, avgb float64) bool
+}
+
+
+// LabelRule returns a rule giving the label of the first of the levels
+// matching the window statistics, or otherwise if none do, e.g. levels
+// "critical" and "warning", otherwise "normal"
+func LabelRule(levels []Level, otherwise string) Rule {
+	return func(avga, avgb float64) string {
+		for _, l := range levels {
+			if l.Match(avga, avgb) {
+				return l.Label
+			}
+		}
+		return otherwise
+	}
+}
+
+
+// RegisterRule makes a rule available by name, replacing any registered
+// with the same name. it is not safe to call concurrently with LookupRule
+func RegisterRule(name string, rule Rule) {
+	rules[name] = rule
+}
+
+
+// LookupRule returns the named rule
+func LookupRule(name string) (Rule, error) {
+	rule, ok := rules[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown rule: %s (have %s)", name, strings.Join(RuleNames(), ", "))
+	}
+	return rule, nil
+}
+
+
+// RuleNames returns the names of the registered rules, sorted
+func RuleNames() []string {
+	names := make([]string, 0, len(rules))
+	for name := range rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,260 @@
+// spill.go: bounded memory, by spilling windows to disk
+//
+// with MaxMemory set, a Processor keeps track of the approximate memory
+// held by its windows, mostly by their buffered records. when it exceeds
+// MaxMemory, the windows of the least recently used series are saved, as
+// JSON, to a temporary spill file, and dropped from memory, until it is
+// under three quarters of MaxMemory. a spilled window is read back when
+// its series has another record. the spill file is compacted when most
+// of it is windows since read back, and removed by Close.
+// a single window larger than MaxMemory, e.g. of a huge number of rows,
+// can't be spilled, and is kept in memory. when the windows of the series
+// whose records are interleaved are larger than MaxMemory, they thrash,
+// each spilled and read back for every few records.
+// Windows only holds the windows in memory, EachWindow visits them all
+
+
+package rollingavg
+
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+
+// spilled windows, and the memory use of those in memory
+type spillStore struct {
+	file    *os.File
+	entries map[string]spillEntry
+	size    int64 // bytes written to the file
+	live    int64 // bytes of the entries
+
+	// the series in memory, most recently used first, and their windows'
+	// approximate sizes
+	lru   *list.List
+	elems map[string]*list.Element
+	sizes map[string]int64
+	total int64
+
+	spills int
+}
+
+
+// where a spilled window is in the spill file
+type spillEntry struct {
+	offset int64
+	length int64
+}
+
+
+// windows that report the approximate memory they hold
+type memSizer interface {
+	memSize() int64
+}
+
+
+// the approximate memory held by a record, its strings and slice
+func recordSize(record []string) int64 {
+	if record == nil {
+		return 0
+	}
+	n := int64(24 + 16*len(record))
+	for _, s := range record {
+		n += int64(len(s))
+	}
+	return n
+}
+
+
+// the window of a series, read back from the spill file if it was spilled,
+// or nil if the series has no window
+func (p *Processor) window(key string) (Window, error) {
+	if w, found := p.Windows[key]; found {
+		return w, nil
+	}
+	if p.spill == nil {
+		return nil, nil
+	}
+	e, found := p.spill.entries[key]
+	if !found {
+		return nil, nil
+	}
+	data := make([]byte, e.length)
+	if _, err := p.spill.file.ReadAt(data, e.offset); err != nil {
+		return nil, fmt.Errorf("error reading spilled window: %w", err)
+	}
+	w := p.NewWindow()
+	if err := json.Unmarshal(data, w); err != nil {
+		return nil, fmt.Errorf("error reading spilled window: %w", err)
+	}
+	return w, nil
+}
+
+
+// read back the spilled window of a series, if it was spilled
+func (p *Processor) unspill(key string) (Window, error) {
+	w, err := p.window(key)
+	if err != nil || w == nil {
+		return w, err
+	}
+	if e, found := p.spill.entries[key]; found {
+		delete(p.spill.entries, key)
+		p.spill.live -= e.length
+		p.Windows[key] = w
+	}
+	return w, nil
+}
+
+
+// update the memory use of a series' window after a record was added to
+// it, and spill the least recently used windows if over MaxMemory
+func (p *Processor) track(key string, w Window) error {
+	s := p.spill
+	if s == nil {
+		s = &spillStore{entries: make(map[string]spillEntry), lru: list.New(),
+			elems: make(map[string]*list.Element), sizes: make(map[string]int64)}
+		p.spill = s
+	}
+	if e, found := s.elems[key]; found {
+		s.lru.MoveToFront(e)
+	} else {
+		s.elems[key] = s.lru.PushFront(key)
+	}
+	if ms, ok := w.(memSizer); ok {
+		size := ms.memSize()
+		s.total += size - s.sizes[key]
+		s.sizes[key] = size
+	}
+	if s.total <= p.MaxMemory {
+		return nil
+	}
+
+	// spill from the least recently used, other than this series
+	for s.total > p.MaxMemory/4*3 && s.lru.Len() > 1 {
+		e := s.lru.Back()
+		if err := p.spillWindow(e.Value.(string)); err != nil {
+			return err
+		}
+	}
+	if s.live < s.size/2 && s.size > 64<<20 {
+		return s.compact(p.SpillDir)
+	}
+	return nil
+}
+
+
+// save a series' window to the spill file, and drop it from memory
+func (p *Processor) spillWindow(key string) error {
+	s := p.spill
+	data, err := json.Marshal(p.Windows[key])
+	if err != nil {
+		return fmt.Errorf("error spilling window: %w", err)
+	}
+	if s.file == nil {
+		if s.file, err = os.CreateTemp(p.SpillDir, "rollingavg-spill-*"); err != nil {
+			return fmt.Errorf("error creating spill file: %w", err)
+		}
+	}
+	if _, err := s.file.WriteAt(data, s.size); err != nil {
+		return fmt.Errorf("error spilling window: %w", err)
+	}
+	s.entries[key] = spillEntry{s.size, int64(len(data))}
+	s.size += int64(len(data))
+	s.live += int64(len(data))
+	s.spills++
+
+	s.lru.Remove(s.elems[key])
+	delete(s.elems, key)
+	s.total -= s.sizes[key]
+	delete(s.sizes, key)
+	delete(p.Windows, key)
+	return nil
+}
+
+
+// copy the spilled windows to a new spill file, leaving out those since
+// read back
+func (s *spillStore) compact(dir string) error {
+	file, err := os.CreateTemp(dir, "rollingavg-spill-*")
+	if err != nil {
+		return fmt.Errorf("error creating spill file: %w", err)
+	}
+	var offset int64
+	for key, e := range s.entries {
+		if _, err := io.Copy(io.NewOffsetWriter(file, offset), io.NewSectionReader(s.file, e.offset, e.length)); err != nil {
+			file.Close()
+			os.Remove(file.Name())
+			return fmt.Errorf("error compacting spill file: %w", err)
+		}
+		s.entries[key] = spillEntry{offset, e.length}
+		offset += e.length
+	}
+	s.file.Close()
+	os.Remove(s.file.Name())
+	s.file, s.size, s.live = file, offset, offset
+	return nil
+}
+
+
+// EachWindow calls fn with each series' window, in key order, including
+// those spilled to disk, which are read back for the call but not kept
+// in memory. stops at, and returns, the first error from fn
+func (p *Processor) EachWindow(fn func(key string, w Window) error) error {
+	keys := make([]string, 0, p.NumSeries())
+	for key := range p.Windows {
+		keys = append(keys, key)
+	}
+	if p.spill != nil {
+		for key := range p.spill.entries {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		w, err := p.window(key)
+		if err != nil {
+			return err
+		}
+		if err := fn(key, w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+
+// NumSeries returns the number of series with windows, including those
+// spilled to disk
+func (p *Processor) NumSeries() int {
+	n := len(p.Windows)
+	if p.spill != nil {
+		n += len(p.spill.entries)
+	}
+	return n
+}
+
+
+// Spills returns the number of times windows have been spilled to disk
+func (p *Processor) Spills() int {
+	if p.spill == nil {
+		return 0
+	}
+	return p.spill.spills
+}
+
+
+// Close removes the spill file, if any
+func (p *Processor) Close() error {
+	if p.spill == nil || p.spill.file == nil {
+		return nil
+	}
+	p.spill.file.Close()
+	err := os.Remove(p.spill.file.Name())
+	p.spill.file = nil
+	return err
+}
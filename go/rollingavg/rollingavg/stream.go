@@ -0,0 +1,96 @@
+// stream.go: channel based streaming API
+//
+// Stream runs rows sent on a channel through rolling windows, sending
+// each output row on the returned channel as soon as its window is
+// complete, so callers can plug rolling averaging into their own
+// pipelines without files, e.g.
+//     in := make(chan rollingavg.Row)
+//     out := rollingavg.Stream(in, rollingavg.Options{Window: 10, Columns: header})
+//     go func() { for _, v := range rows { in <- rollingavg.Row{Values: v} }; close(in) }()
+//     for row := range out { ... }
+// StreamContext stops processing when its context is cancelled
+
+
+package rollingavg
+
+
+import (
+	"context"
+)
+
+// Row is a row streamed through Stream. input rows only have Values,
+// output rows also have the statistics of their window and the Result.
+// if processing fails, the last output row has only Err set
+type Row struct {
+	Values []string
+	AvgA   float64
+	AvgB   float64
+	Result string
+	Err    error
+}
+
+
+// Stream processes the rows received from in, with the input columns
+// given by opts.Columns, returning a channel of output rows, which is
+// closed once in is closed and all complete rows have been sent.
+// after an error, the rest of in is received and discarded
+func Stream(in <-chan Row, opts Options) <-chan Row {
+	return StreamContext(context.Background(), in, opts)
+}
+
+
+// StreamContext is Stream, except that when ctx is cancelled, processing
+// stops, and the output channel is closed without waiting for in to be
+// closed, so senders should also stop on ctx being cancelled
+func StreamContext(ctx context.Context, in <-chan Row, opts Options) <-chan Row {
+	out := make(chan Row)
+	go func() {
+		defer close(out)
+		send := func(row Row) bool {
+			select {
+			case out <- row:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		p, err := NewProcessor(opts.Columns, opts)
+		if err != nil && !send(Row{Err: err}) {
+			return
+		}
+		rule := ResultValue
+		if p != nil && p.Rule != nil {
+			rule = p.Rule
+		}
+		for {
+			var row Row
+			var ok bool
+			select {
+			case row, ok = <-in:
+			case <-ctx.Done():
+				return
+			}
+			if !ok {
+				return
+			}
+			if err != nil {
+				continue
+			}
+			var results []Result
+			results, err = p.Add(row.Values)
+			if err != nil {
+				if !send(Row{Err: err}) {
+					return
+				}
+				continue
+			}
+			for _, r := range results {
+				if !send(Row{Values: r.Record, AvgA: r.AvgA, AvgB: r.AvgB, Result: rule(r.AvgA, r.AvgB)}) {
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
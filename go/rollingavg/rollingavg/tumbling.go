@@ -0,0 +1,148 @@
+// tumbling.go: tumbling windows, which don't overlap
+//
+// windows otherwise slide, each record being output with the statistics
+// of the window starting at it. a TumblingWindow instead outputs one
+// record per window, the first of a run of windows that don't overlap,
+// each starting with the first record after the last one's end, so each
+// record is in exactly one window, e.g. for report style summaries of
+// every 100 rows or every month. the records within an output window are
+// dropped, and don't count as pending.
+// a TumblingWindow wraps a RowWindow, whose windows of A and B are of the
+// same length, or a CalendarWindow, and outputs the same statistics as
+// they do for the records it outputs
+
+
+package rollingavg
+
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+
+// TumblingWindow outputs the results of a sliding window's records that
+// start windows that don't overlap
+type TumblingWindow struct {
+	Window
+
+	// the row window's length, or the calendar window
+	rows     int
+	calendar *CalendarWindow
+
+	// for row windows, the results left to skip of the records in the last
+	// window output, and for calendar windows, the last window's end
+	skip int
+	end  time.Time
+
+	// the results returned by Add, reused to save allocating them
+	results []Result
+}
+
+
+// NewTumblingWindow returns a tumbling window of w, a RowWindow or a
+// CalendarWindow, whose aggregators keep its statistics
+func NewTumblingWindow(w Window) (*TumblingWindow, error) {
+	switch w := w.(type) {
+	case *RowWindow:
+		if w.lenA != w.lenB {
+			return nil, fmt.Errorf("tumbling row windows of A and B must be of the same length")
+		}
+		return &TumblingWindow{Window: w, rows: w.lenA}, nil
+	case *CalendarWindow:
+		return &TumblingWindow{Window: w, calendar: w}, nil
+	}
+	return nil, fmt.Errorf("only row and calendar windows can tumble, not %T", w)
+}
+
+
+// whether a record is in the last window output, and otherwise the end of
+// the window it starts
+func (w *TumblingWindow) inLast(record []string) (bool, time.Time) {
+	if w.calendar == nil {
+		return false, time.Time{}
+	}
+	// the calendar window has checked records have a time column
+	t, err := ParseTime(record[w.calendar.tcol])
+	if err != nil {
+		return false, time.Time{}
+	}
+	return t.Before(w.end), w.calendar.windowEnd(t)
+}
+
+
+// Add adds a record to the window, returning the records that start
+// windows, once they're complete, with their statistics
+func (w *TumblingWindow) Add(record []string, a, b float64) ([]Result, error) {
+	results, err := w.Window.Add(record, a, b)
+	w.results = w.results[:0]
+	for _, r := range results {
+		if w.skip > 0 {
+			w.skip--
+			continue
+		}
+		in, end := w.inLast(r.Record)
+		if in {
+			continue
+		}
+		w.results = append(w.results, r)
+		if w.calendar == nil {
+			w.skip = w.rows - 1
+		} else {
+			w.end = end
+		}
+	}
+	return w.results, err
+}
+
+
+// Pending returns the buffered records not yet output, and not in the
+// last window output
+func (w *TumblingWindow) Pending() [][]string {
+	pending := w.Window.Pending()
+	if w.calendar == nil {
+		return pending[min(w.skip, len(pending)):]
+	}
+	var records [][]string
+	for _, record := range pending {
+		if in, _ := w.inLast(record); !in {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+
+// exported form of a TumblingWindow's state
+type tumblingWindowState struct {
+	Window json.RawMessage `json:"window"`
+	Skip   int             `json:"skip,omitempty"`
+	End    time.Time       `json:"end,omitempty"`
+}
+
+func (w *TumblingWindow) MarshalJSON() ([]byte, error) {
+	data, err := w.Window.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(tumblingWindowState{data, w.skip, w.end})
+}
+
+func (w *TumblingWindow) UnmarshalJSON(data []byte) error {
+	var s tumblingWindowState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	w.skip, w.end = s.Skip, s.End
+	return w.Window.UnmarshalJSON(s.Window)
+}
+
+
+// the approximate memory held by the window being wrapped
+func (w *TumblingWindow) memSize() int64 {
+	if ms, ok := w.Window.(memSizer); ok {
+		return ms.memSize()
+	}
+	return 0
+}
@@ -0,0 +1,409 @@
+// rollingavg.proto: gRPC service for streaming rolling averages
+//
+// a client opens a Process stream, sends a Config, then the input rows.
+// each output row is streamed back, with its rolling averages, as soon
+// as its window is complete, as with the rollingavg command.
+//
+// generated code: rollingavg.pb.go and rollingavg_grpc.pb.go, regenerate with
+//     protoc --go_out=. --go_opt=paths=source_relative \
+//         --go-grpc_out=. --go-grpc_opt=paths=source_relative rollingavg.proto
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        v5.28.3
+// source: rollingavg.proto
+
+package rollingavgpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// processing options, as for the rollingavg command line
+type Config struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// names of the columns of each row, as in a CSV header row.
+	// the first two columns are averaged
+	Columns []string `protobuf:"bytes,1,rep,name=columns,proto3" json:"columns,omitempty"`
+	// window length, default 23
+	Window int32 `protobuf:"varint,2,opt,name=window,proto3" json:"window,omitempty"`
+	// unit of the window length: rows (default), bdays or months
+	WindowUnit string `protobuf:"bytes,3,opt,name=window_unit,json=windowUnit,proto3" json:"window_unit,omitempty"`
+	// column (name or index) grouping rows into independent series
+	GroupBy string `protobuf:"bytes,4,opt,name=group_by,json=groupBy,proto3" json:"group_by,omitempty"`
+	// timestamp column (name or index) for calendar windows, default 3
+	TimeColumn string `protobuf:"bytes,5,opt,name=time_column,json=timeColumn,proto3" json:"time_column,omitempty"`
+	// window statistic: mean (default), median, min, max, stddev, geomean or harmmean
+	Stat          string `protobuf:"bytes,6,opt,name=stat,proto3" json:"stat,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Config) Reset() {
+	*x = Config{}
+	mi := &file_rollingavg_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Config) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Config) ProtoMessage() {}
+
+func (x *Config) ProtoReflect() protoreflect.Message {
+	mi := &file_rollingavg_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Config.ProtoReflect.Descriptor instead.
+func (*Config) Descriptor() ([]byte, []int) {
+	return file_rollingavg_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Config) GetColumns() []string {
+	if x != nil {
+		return x.Columns
+	}
+	return nil
+}
+
+func (x *Config) GetWindow() int32 {
+	if x != nil {
+		return x.Window
+	}
+	return 0
+}
+
+func (x *Config) GetWindowUnit() string {
+	if x != nil {
+		return x.WindowUnit
+	}
+	return ""
+}
+
+func (x *Config) GetGroupBy() string {
+	if x != nil {
+		return x.GroupBy
+	}
+	return ""
+}
+
+func (x *Config) GetTimeColumn() string {
+	if x != nil {
+		return x.TimeColumn
+	}
+	return ""
+}
+
+func (x *Config) GetStat() string {
+	if x != nil {
+		return x.Stat
+	}
+	return ""
+}
+
+// an input row, with a value for each column
+type Row struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Values        []string               `protobuf:"bytes,1,rep,name=values,proto3" json:"values,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Row) Reset() {
+	*x = Row{}
+	mi := &file_rollingavg_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Row) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Row) ProtoMessage() {}
+
+func (x *Row) ProtoReflect() protoreflect.Message {
+	mi := &file_rollingavg_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Row.ProtoReflect.Descriptor instead.
+func (*Row) Descriptor() ([]byte, []int) {
+	return file_rollingavg_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Row) GetValues() []string {
+	if x != nil {
+		return x.Values
+	}
+	return nil
+}
+
+type ProcessRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Msg:
+	//
+	//	*ProcessRequest_Config
+	//	*ProcessRequest_Row
+	Msg           isProcessRequest_Msg `protobuf_oneof:"msg"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ProcessRequest) Reset() {
+	*x = ProcessRequest{}
+	mi := &file_rollingavg_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProcessRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProcessRequest) ProtoMessage() {}
+
+func (x *ProcessRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_rollingavg_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProcessRequest.ProtoReflect.Descriptor instead.
+func (*ProcessRequest) Descriptor() ([]byte, []int) {
+	return file_rollingavg_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ProcessRequest) GetMsg() isProcessRequest_Msg {
+	if x != nil {
+		return x.Msg
+	}
+	return nil
+}
+
+func (x *ProcessRequest) GetConfig() *Config {
+	if x != nil {
+		if x, ok := x.Msg.(*ProcessRequest_Config); ok {
+			return x.Config
+		}
+	}
+	return nil
+}
+
+func (x *ProcessRequest) GetRow() *Row {
+	if x != nil {
+		if x, ok := x.Msg.(*ProcessRequest_Row); ok {
+			return x.Row
+		}
+	}
+	return nil
+}
+
+type isProcessRequest_Msg interface {
+	isProcessRequest_Msg()
+}
+
+type ProcessRequest_Config struct {
+	Config *Config `protobuf:"bytes,1,opt,name=config,proto3,oneof"`
+}
+
+type ProcessRequest_Row struct {
+	Row *Row `protobuf:"bytes,2,opt,name=row,proto3,oneof"`
+}
+
+func (*ProcessRequest_Config) isProcessRequest_Msg() {}
+
+func (*ProcessRequest_Row) isProcessRequest_Msg() {}
+
+// an input row with its rolling averages, or other window statistic
+type ProcessResponse struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Values   []string               `protobuf:"bytes,1,rep,name=values,proto3" json:"values,omitempty"`
+	AverageA float64                `protobuf:"fixed64,2,opt,name=average_a,json=averageA,proto3" json:"average_a,omitempty"`
+	AverageB float64                `protobuf:"fixed64,3,opt,name=average_b,json=averageB,proto3" json:"average_b,omitempty"`
+	// "1" if both averages are below their thresholds, otherwise "0"
+	Result        string `protobuf:"bytes,4,opt,name=result,proto3" json:"result,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ProcessResponse) Reset() {
+	*x = ProcessResponse{}
+	mi := &file_rollingavg_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProcessResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProcessResponse) ProtoMessage() {}
+
+func (x *ProcessResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_rollingavg_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProcessResponse.ProtoReflect.Descriptor instead.
+func (*ProcessResponse) Descriptor() ([]byte, []int) {
+	return file_rollingavg_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ProcessResponse) GetValues() []string {
+	if x != nil {
+		return x.Values
+	}
+	return nil
+}
+
+func (x *ProcessResponse) GetAverageA() float64 {
+	if x != nil {
+		return x.AverageA
+	}
+	return 0
+}
+
+func (x *ProcessResponse) GetAverageB() float64 {
+	if x != nil {
+		return x.AverageB
+	}
+	return 0
+}
+
+func (x *ProcessResponse) GetResult() string {
+	if x != nil {
+		return x.Result
+	}
+	return ""
+}
+
+var File_rollingavg_proto protoreflect.FileDescriptor
+
+const file_rollingavg_proto_rawDesc = "" +
+	"\n" +
+	"\x10rollingavg.proto\x12\rrollingavg.v1\"\xab\x01\n" +
+	"\x06Config\x12\x18\n" +
+	"\acolumns\x18\x01 \x03(\tR\acolumns\x12\x16\n" +
+	"\x06window\x18\x02 \x01(\x05R\x06window\x12\x1f\n" +
+	"\vwindow_unit\x18\x03 \x01(\tR\n" +
+	"windowUnit\x12\x19\n" +
+	"\bgroup_by\x18\x04 \x01(\tR\agroupBy\x12\x1f\n" +
+	"\vtime_column\x18\x05 \x01(\tR\n" +
+	"timeColumn\x12\x12\n" +
+	"\x04stat\x18\x06 \x01(\tR\x04stat\"\x1d\n" +
+	"\x03Row\x12\x16\n" +
+	"\x06values\x18\x01 \x03(\tR\x06values\"p\n" +
+	"\x0eProcessRequest\x12/\n" +
+	"\x06config\x18\x01 \x01(\v2\x15.rollingavg.v1.ConfigH\x00R\x06config\x12&\n" +
+	"\x03row\x18\x02 \x01(\v2\x12.rollingavg.v1.RowH\x00R\x03rowB\x05\n" +
+	"\x03msg\"{\n" +
+	"\x0fProcessResponse\x12\x16\n" +
+	"\x06values\x18\x01 \x03(\tR\x06values\x12\x1b\n" +
+	"\taverage_a\x18\x02 \x01(\x01R\baverageA\x12\x1b\n" +
+	"\taverage_b\x18\x03 \x01(\x01R\baverageB\x12\x16\n" +
+	"\x06result\x18\x04 \x01(\tR\x06result2Z\n" +
+	"\n" +
+	"RollingAvg\x12L\n" +
+	"\aProcess\x12\x1d.rollingavg.v1.ProcessRequest\x1a\x1e.rollingavg.v1.ProcessResponse(\x010\x01BEZCgithub.com/jaleephd/misc-data-processing/go/rollingavg/rollingavgpbb\x06proto3"
+
+var (
+	file_rollingavg_proto_rawDescOnce sync.Once
+	file_rollingavg_proto_rawDescData []byte
+)
+
+func file_rollingavg_proto_rawDescGZIP() []byte {
+	file_rollingavg_proto_rawDescOnce.Do(func() {
+		file_rollingavg_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_rollingavg_proto_rawDesc), len(file_rollingavg_proto_rawDesc)))
+	})
+	return file_rollingavg_proto_rawDescData
+}
+
+var file_rollingavg_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_rollingavg_proto_goTypes = []any{
+	(*Config)(nil),          // 0: rollingavg.v1.Config
+	(*Row)(nil),             // 1: rollingavg.v1.Row
+	(*ProcessRequest)(nil),  // 2: rollingavg.v1.ProcessRequest
+	(*ProcessResponse)(nil), // 3: rollingavg.v1.ProcessResponse
+}
+var file_rollingavg_proto_depIdxs = []int32{
+	0, // 0: rollingavg.v1.ProcessRequest.config:type_name -> rollingavg.v1.Config
+	1, // 1: rollingavg.v1.ProcessRequest.row:type_name -> rollingavg.v1.Row
+	2, // 2: rollingavg.v1.RollingAvg.Process:input_type -> rollingavg.v1.ProcessRequest
+	3, // 3: rollingavg.v1.RollingAvg.Process:output_type -> rollingavg.v1.ProcessResponse
+	3, // [3:4] is the sub-list for method output_type
+	2, // [2:3] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_rollingavg_proto_init() }
+func file_rollingavg_proto_init() {
+	if File_rollingavg_proto != nil {
+		return
+	}
+	file_rollingavg_proto_msgTypes[2].OneofWrappers = []any{
+		(*ProcessRequest_Config)(nil),
+		(*ProcessRequest_Row)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_rollingavg_proto_rawDesc), len(file_rollingavg_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   4,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_rollingavg_proto_goTypes,
+		DependencyIndexes: file_rollingavg_proto_depIdxs,
+		MessageInfos:      file_rollingavg_proto_msgTypes,
+	}.Build()
+	File_rollingavg_proto = out.File
+	file_rollingavg_proto_goTypes = nil
+	file_rollingavg_proto_depIdxs = nil
+}
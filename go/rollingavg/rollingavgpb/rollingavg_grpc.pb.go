@@ -0,0 +1,131 @@
+// rollingavg.proto: gRPC service for streaming rolling averages
+//
+// a client opens a Process stream, sends a Config, then the input rows.
+// each output row is streamed back, with its rolling averages, as soon
+// as its window is complete, as with the rollingavg command.
+//
+// generated code: rollingavg.pb.go and rollingavg_grpc.pb.go, regenerate with
+//     protoc --go_out=. --go_opt=paths=source_relative \
+//         --go-grpc_out=. --go-grpc_opt=paths=source_relative rollingavg.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             v5.28.3
+// source: rollingavg.proto
+
+package rollingavgpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	RollingAvg_Process_FullMethodName = "/rollingavg.v1.RollingAvg/Process"
+)
+
+// RollingAvgClient is the client API for RollingAvg service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type RollingAvgClient interface {
+	// the first request must be a Config, the rest Rows.
+	// the stream ends once the client has finished sending, and all the
+	// rows with complete windows have been returned
+	Process(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[ProcessRequest, ProcessResponse], error)
+}
+
+type rollingAvgClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewRollingAvgClient(cc grpc.ClientConnInterface) RollingAvgClient {
+	return &rollingAvgClient{cc}
+}
+
+func (c *rollingAvgClient) Process(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[ProcessRequest, ProcessResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &RollingAvg_ServiceDesc.Streams[0], RollingAvg_Process_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ProcessRequest, ProcessResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type RollingAvg_ProcessClient = grpc.BidiStreamingClient[ProcessRequest, ProcessResponse]
+
+// RollingAvgServer is the server API for RollingAvg service.
+// All implementations must embed UnimplementedRollingAvgServer
+// for forward compatibility.
+type RollingAvgServer interface {
+	// the first request must be a Config, the rest Rows.
+	// the stream ends once the client has finished sending, and all the
+	// rows with complete windows have been returned
+	Process(grpc.BidiStreamingServer[ProcessRequest, ProcessResponse]) error
+	mustEmbedUnimplementedRollingAvgServer()
+}
+
+// UnimplementedRollingAvgServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedRollingAvgServer struct{}
+
+func (UnimplementedRollingAvgServer) Process(grpc.BidiStreamingServer[ProcessRequest, ProcessResponse]) error {
+	return status.Error(codes.Unimplemented, "method Process not implemented")
+}
+func (UnimplementedRollingAvgServer) mustEmbedUnimplementedRollingAvgServer() {}
+func (UnimplementedRollingAvgServer) testEmbeddedByValue()                    {}
+
+// UnsafeRollingAvgServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to RollingAvgServer will
+// result in compilation errors.
+type UnsafeRollingAvgServer interface {
+	mustEmbedUnimplementedRollingAvgServer()
+}
+
+func RegisterRollingAvgServer(s grpc.ServiceRegistrar, srv RollingAvgServer) {
+	// If the following call panics, it indicates UnimplementedRollingAvgServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&RollingAvg_ServiceDesc, srv)
+}
+
+func _RollingAvg_Process_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(RollingAvgServer).Process(&grpc.GenericServerStream[ProcessRequest, ProcessResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type RollingAvg_ProcessServer = grpc.BidiStreamingServer[ProcessRequest, ProcessResponse]
+
+// RollingAvg_ServiceDesc is the grpc.ServiceDesc for RollingAvg service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var RollingAvg_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "rollingavg.v1.RollingAvg",
+	HandlerType: (*RollingAvgServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Process",
+			Handler:       _RollingAvg_Process_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "rollingavg.proto",
+}
@@ -0,0 +1,192 @@
+// rotate.go: rotate the output CSV by size or time
+//
+// so that long running follow mode runs don't produce one unbounded file,
+// output can be split into parts once a part reaches a size, or has been
+// open for a time. each part starts with the header row.
+// part filenames are made from a pattern, in which
+//     {dir}   is the directory of the output file
+//     {name}  is the output filename without its extension
+//     {ext}   is the output filename extension, including the "."
+//     {n}     is the part number, from 0, zero padded to 4 digits
+//     {time}  is the time the part was started, as YYYYMMDDThhmmss
+// so the default pattern "{dir}/{name}.{n}{ext}" writes out.csv as
+// out.0000.csv, out.0001.csv, ...
+// sizes are of the uncompressed CSV text
+
+
+package main
+
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultRotatePattern = "{dir}/{name}.{n}{ext}"
+
+
+// parse a size in bytes, with an optional K, M or G (binary) suffix
+func parseSize(s string) (int64, error) {
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		mult = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		mult = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+	return n * mult, nil
+}
+
+
+// a size flag value, accepting K, M and G suffixes
+type sizeFlag int64
+
+func (s *sizeFlag) String() string {
+	return strconv.FormatInt(int64(*s), 10)
+}
+
+func (s *sizeFlag) Set(value string) error {
+	n, err := parseSize(value)
+	*s = sizeFlag(n)
+	return err
+}
+
+
+// writes CSV records to a sequence of output files, starting a new file
+// when the current one reaches maxSize bytes, or maxRows rows, or has been
+// open every duration. a zero maxSize, maxRows or every disables that limit.
+// the first record written is taken as the header, and repeated in each part
+type rotatingCSV struct {
+	outfilename string
+	pattern     string
+	compression string
+	maxSize     int64
+	maxRows     int
+	every       time.Duration
+	header      []string
+	part        int
+	size        int64
+	rows        int
+	started     time.Time
+	cur         *csvOutput
+	prov        *provenance
+	err         error
+}
+
+
+func newRotatingCSV(outfilename, pattern, compression string, maxSize int64, every time.Duration, prov *provenance) *rotatingCSV {
+	if outfilename == "" || isRemote(outfilename) {
+		fatal("output rotation requires a local output file")
+	}
+	return &rotatingCSV{
+		outfilename: outfilename,
+		pattern:     pattern,
+		compression: compression,
+		maxSize:     maxSize,
+		every:       every,
+		prov:        prov,
+	}
+}
+
+
+// make the filename of the current part from the pattern
+func (r *rotatingCSV) partName() string {
+	ext := filepath.Ext(r.outfilename)
+	base := filepath.Base(r.outfilename)
+	rep := strings.NewReplacer(
+		"{dir}", filepath.Dir(r.outfilename),
+		"{name}", strings.TrimSuffix(base, ext),
+		"{ext}", ext,
+		"{n}", fmt.Sprintf("%04d", r.part),
+		"{time}", r.started.Format("20060102T150405"))
+	return filepath.Clean(rep.Replace(r.pattern))
+}
+
+
+// close the current part, if any, and start the next with the header
+func (r *rotatingCSV) rotate() error {
+	if r.cur != nil {
+		if err := r.cur.Close(); err != nil {
+			return err
+		}
+		r.part++
+	}
+
+	r.started = time.Now()
+	r.size, r.rows = 0, 0
+	name := r.partName()
+	slog.Debug("start output part", "file", name)
+	r.cur = newCSVOutput(name, r.compression, r.prov)
+	return r.write(r.header)
+}
+
+
+// write a record to the current part, tracking its size
+func (r *rotatingCSV) write(record []string) error {
+	for _, v := range record {
+		r.size += int64(len(v)) + 1
+	}
+	return r.cur.Write(record)
+}
+
+
+func (r *rotatingCSV) Write(record []string) error {
+	if r.err != nil {
+		return r.err
+	}
+	if r.header == nil {
+		r.header = make([]string, len(record))
+		copy(r.header, record)
+		r.err = r.rotate()
+		return r.err
+	}
+
+	if (r.maxSize > 0 && r.size >= r.maxSize) || (r.maxRows > 0 && r.rows >= r.maxRows) ||
+		(r.every > 0 && time.Since(r.started) >= r.every) {
+		if r.err = r.rotate(); r.err != nil {
+			return r.err
+		}
+	}
+	r.err = r.write(record)
+	r.rows++
+	return r.err
+}
+
+
+func (r *rotatingCSV) Flush() {
+	if r.cur != nil {
+		r.cur.Flush()
+	}
+}
+
+
+func (r *rotatingCSV) Error() error {
+	if r.err == nil && r.cur != nil {
+		return r.cur.Error()
+	}
+	return r.err
+}
+
+
+func (r *rotatingCSV) Close() error {
+	if r.cur == nil {
+		return r.err
+	}
+	if err := r.cur.Close(); err != nil && r.err == nil {
+		r.err = err
+	}
+	return r.err
+}
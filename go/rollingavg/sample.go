@@ -0,0 +1,141 @@
+// sample.go: extract a sample of CSV rows
+//
+// representative subsets of huge files, e.g. for trying window parameters,
+// invoked as the sample subcommand, see commands.go:
+//     mdp sample [-v] -rate p | -every n [-offset i] | -n nrows [-seed seed]
+//         [-f inputfile]... [-o outputfile] [inputfile...]
+// with -rate, each row is kept at random with probability p, streaming.
+// with -every, every nth row is kept, starting from row -offset (default
+// 0), streaming.
+// with -n, a uniform random sample of that many rows is kept, by reservoir
+// sampling, so only the sample is held in memory, and output in input
+// order at the end.
+// random samples are the same for the same -seed (default 1)
+
+
+package main
+
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"flag"
+	"io"
+	"log/slog"
+	"math/rand"
+	"sort"
+)
+
+
+// a row of a reservoir sample, and its position in the input
+type sampledRow struct {
+	record []string
+	n      int
+}
+
+
+func runSample(args []string) {
+	fs := flag.NewFlagSet("sample", flag.ExitOnError)
+	rate := fs.Float64("rate", 0, "probability of keeping each row, for random sampling")
+	every := fs.Int("every", 0, "keep every nth row, for systematic sampling")
+	offset := fs.Int("offset", 0, "the first row kept by -every, from 0")
+	size := fs.Int("n", 0, "number of rows kept, for reservoir sampling")
+	seed := fs.Int64("seed", 1, "seed of random sampling")
+	commonFlags(fs, "the sampled rows")
+	fs.Parse(args)
+	infilenames = append(infilenames, fs.Args()...)
+	setupLogging()
+
+	modes := 0
+	for _, set := range []bool{*rate != 0, *every != 0, *size != 0} {
+		if set {
+			modes++
+		}
+	}
+	switch {
+	case modes != 1:
+		fatal("exactly one of -rate, -every or -n is required")
+	case *rate < 0 || *rate > 1:
+		fatal("invalid sampling rate", "rate", *rate)
+	case *every < 0 || *offset < 0 || *every > 0 && *offset >= *every:
+		fatal("invalid -every or -offset", "every", *every, "offset", *offset)
+	case *size < 0:
+		fatal("invalid sample size", "n", *size)
+	}
+
+	slog.Debug("sample CSV rows",
+		"inputs", infilenames, "output", outfilename, "rate", *rate, "every", *every, "n", *size, "seed", *seed)
+
+	infile := openInputs(context.Background(), infilenames, false, nil)
+	defer infile.Close()
+
+	oufl := createOutput(outfilename, "")
+	outfile := csv.NewWriter(bufio.NewWriter(oufl))
+
+	header, err := infile.Read()
+	if err != nil {
+		fatal("error reading header from csv", "err", err)
+	}
+	if err := outfile.Write(header); err != nil {
+		fatal("error writing record to csv", "err", err)
+	}
+
+	rnd := rand.New(rand.NewSource(*seed))
+	write := func(record []string) {
+		if verboseFlag {
+			slog.Debug("write record", "record", record)
+		}
+		if err := outfile.Write(record); err != nil {
+			fatal("error writing record to csv", "err", err)
+		}
+	}
+	var reservoir []sampledRow
+	n, kept := 0, 0
+	for {
+		record, err := infile.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fatal("error reading record from csv", "err", err)
+		}
+		switch {
+		case *rate != 0:
+			if rnd.Float64() < *rate {
+				write(record)
+				kept++
+			}
+		case *every != 0:
+			if n%*every == *offset {
+				write(record)
+				kept++
+			}
+		default:
+			// each row seen is in the sample with equal probability
+			if len(reservoir) < *size {
+				reservoir = append(reservoir, sampledRow{record, n})
+			} else if j := rnd.Intn(n + 1); j < *size {
+				reservoir[j] = sampledRow{record, n}
+			}
+		}
+		n++
+	}
+
+	if *size != 0 {
+		sort.Slice(reservoir, func(i, j int) bool { return reservoir[i].n < reservoir[j].n })
+		for _, r := range reservoir {
+			write(r.record)
+		}
+		kept = len(reservoir)
+	}
+	slog.Debug("sampled records", "records", n, "kept", kept)
+
+	outfile.Flush()
+	if err := outfile.Error(); err != nil {
+		fatal("error writing csv", "err", err)
+	}
+	if err := oufl.Close(); err != nil {
+		fatal("error closing destination csv", "err", err)
+	}
+}
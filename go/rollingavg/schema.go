@@ -0,0 +1,197 @@
+// schema.go: infer the schema of a CSV
+//
+// to bootstrap validation and conversion of a new dataset, invoked as the
+// schema subcommand, see commands.go:
+//     mdp schema [-v] [-sample nrows] [-bounds] [-format yaml|column-types]
+//         [-f inputfile]... [-o outputfile] [inputfile...]
+// each column's type is inferred from its values in the first -sample
+// (default 1000, 0 for all) rows: int if they're all integers, float if
+// they're all numbers, time if they're all in the Date Time layout,
+// otherwise string. empty values are ignored, but a column with none is
+// required, and a numeric or time column whose values never decrease is
+// monotonic. with -bounds, numeric columns are given the min and max of
+// their values, which the rest of the data may exceed unless all the rows
+// are sampled.
+// the schema is output as a csvcheck schema file, see csvcheck.go, or with
+// -format column-types, as a -column-types list for columnar output, see
+// arrow.go, e.g.
+//     X:int64,Y:int64,Z:int64,Time:timestamp
+
+
+package main
+
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+	"gopkg.in/yaml.v3"
+)
+
+
+// the values seen of a column, narrowing its type
+type columnInference struct {
+	name       string
+	seen       int // non-empty values
+	empty      bool
+	int, float bool
+	time       bool
+	min, max   float64
+	monotonic  bool
+	last       float64
+}
+
+
+// narrow the column's type by a value
+func (c *columnInference) add(v string) {
+	if v == "" {
+		c.empty = true
+		return
+	}
+	if c.seen == 0 {
+		c.int, c.float, c.time, c.monotonic = true, true, true, true
+	}
+	var f float64
+	numeric := false
+	if c.int {
+		if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+			f, numeric = float64(i), true
+		} else {
+			c.int = false
+		}
+	}
+	if c.float && !numeric {
+		if x, err := rollingavg.ParseFloat(v); err == nil {
+			f, numeric = x, true
+		} else {
+			c.float = false
+		}
+	}
+	if c.time {
+		if t, err := rollingavg.ParseTime(v); err == nil && !numeric {
+			f = float64(t.UnixNano()) / float64(time.Second)
+		} else {
+			c.time = false
+		}
+	}
+	if c.int || c.float || c.time {
+		if c.seen == 0 || f < c.min {
+			c.min = f
+		}
+		if c.seen == 0 || f > c.max {
+			c.max = f
+		}
+		if c.seen > 0 && f < c.last {
+			c.monotonic = false
+		}
+		c.last = f
+	}
+	c.seen++
+}
+
+
+// the inferred schema column
+func (c *columnInference) column(bounds bool) schemaColumn {
+	col := schemaColumn{Name: c.name, Type: "string", Required: c.seen > 0 && !c.empty}
+	switch {
+	case c.seen == 0:
+		return col
+	case c.int:
+		col.Type = "int"
+	case c.float:
+		col.Type = "float"
+	case c.time:
+		col.Type = "time"
+	default:
+		return col
+	}
+	col.Monotonic = c.monotonic && c.seen > 1
+	if bounds && col.Type != "time" {
+		lo, hi := c.min, c.max
+		col.Min, col.Max = &lo, &hi
+	}
+	return col
+}
+
+
+func runSchema(args []string) {
+	fs := flag.NewFlagSet("schema", flag.ExitOnError)
+	sample := fs.Int("sample", 1000, "number of rows the types are inferred from, or 0 for all")
+	bounds := fs.Bool("bounds", false, "give numeric columns the min and max of their values")
+	format := fs.String("format", "yaml", "output format: yaml, a csvcheck schema, or column-types")
+	commonFlags(fs, "the schema")
+	fs.Parse(args)
+	infilenames = append(infilenames, fs.Args()...)
+	setupLogging()
+
+	switch {
+	case *sample < 0:
+		fatal("invalid sample size", "sample", *sample)
+	case *format != "yaml" && *format != "column-types":
+		fatal("invalid output format", "format", *format)
+	}
+
+	slog.Debug("infer CSV schema",
+		"inputs", infilenames, "output", outfilename, "sample", *sample, "format", *format)
+
+	infile := openInputs(context.Background(), infilenames, false, nil)
+	defer infile.Close()
+
+	header, err := infile.Read()
+	if err != nil {
+		fatal("error reading header from csv", "err", err)
+	}
+	cols := make([]*columnInference, len(header))
+	for i, name := range header {
+		cols[i] = &columnInference{name: name}
+	}
+
+	n := 0
+	for *sample == 0 || n < *sample {
+		record, err := infile.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fatal("error reading record from csv", "err", err)
+		}
+		for i, c := range cols {
+			c.add(field(record, i))
+		}
+		n++
+	}
+	slog.Debug("sampled records", "records", n)
+
+	var schema csvSchema
+	for _, c := range cols {
+		schema.Columns = append(schema.Columns, c.column(*bounds))
+	}
+
+	var out []byte
+	if *format == "yaml" {
+		if out, err = yaml.Marshal(&schema); err != nil {
+			fatal("error encoding schema", "err", err)
+		}
+	} else {
+		arrowTypes := map[string]string{"int": "int64", "float": "double", "string": "string", "time": "timestamp"}
+		items := make([]string, len(schema.Columns))
+		for i, c := range schema.Columns {
+			items[i] = c.Name + ":" + arrowTypes[c.Type]
+		}
+		out = []byte(strings.Join(items, ",") + "\n")
+	}
+
+	oufl := createOutput(outfilename, "")
+	if _, err := oufl.Write(out); err != nil {
+		fatal("error writing schema", "err", err)
+	}
+	if err := oufl.Close(); err != nil {
+		fatal("error closing schema", "err", err)
+	}
+}
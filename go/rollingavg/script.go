@@ -0,0 +1,124 @@
+// script.go: per row Starlark script hook
+//
+// with -script, each output row is passed, before it is written, to the
+// transform function of a Starlark (Python like) script, for bespoke
+// transformations that flags can't express. transform is given the row
+// as a dict of output column name to value, all as strings, including
+// the averages and Result, with aliased columns also under their -alias
+// names, see aliases.go, e.g.
+//     def transform(row):
+//         if float(row["Average A"]) > 100:
+//             return None                 # drop the row
+//         row["Result"] = "1" if row["X"] == "0" else row["Result"]
+//         return row
+// it returns the row to write, or None to drop it. values are converted
+// with str(), keys that aren't output columns are ignored, and missing
+// columns are written empty. dropped rows are counted as skipped, for the
+// exit status, see exitcode.go, and with -audit, dropped and modified
+// rows are logged, see audit.go.
+// the script's top level code runs once, so can set up shared values
+
+
+package main
+
+
+import (
+	"fmt"
+	"log/slog"
+
+	"go.starlark.net/starlark"
+)
+
+
+// an output that passes each record through a script before writing it
+type scriptOutput struct {
+	recordWriteCloser
+	thread    *starlark.Thread
+	transform starlark.Callable
+	header    []string
+	n         int
+
+	// the -alias names of the output columns, by index
+	aliases map[int][]string
+}
+
+
+// load the script, which must define transform
+func newScriptOutput(out recordWriteCloser, filename string) *scriptOutput {
+	thread := &starlark.Thread{
+		Name:  "rollingavg",
+		Print: func(_ *starlark.Thread, msg string) { slog.Info(msg, "script", filename) },
+	}
+	globals, err := starlark.ExecFile(thread, filename, nil, nil)
+	if err != nil {
+		fatal("error loading script", "err", err)
+	}
+	transform, ok := globals["transform"].(starlark.Callable)
+	if !ok {
+		fatal("script does not define a transform function", "file", filename)
+	}
+	return &scriptOutput{recordWriteCloser: out, thread: thread, transform: transform}
+}
+
+
+// the first record is the header, which is written as is
+func (s *scriptOutput) Write(record []string) error {
+	if s.header == nil {
+		s.header = append([]string{}, record...)
+		s.aliases = headerAliases(s.header)
+		return s.recordWriteCloser.Write(record)
+	}
+	s.n++
+
+	row := starlark.NewDict(len(record))
+	for i, name := range s.header {
+		if i < len(record) {
+			row.SetKey(starlark.String(name), starlark.String(record[i]))
+			for _, alias := range s.aliases[i] {
+				row.SetKey(starlark.String(alias), starlark.String(record[i]))
+			}
+		}
+	}
+	result, err := starlark.Call(s.thread, s.transform, starlark.Tuple{row}, nil)
+	if err != nil {
+		return fmt.Errorf("script failed on row %d: %w", s.n, err)
+	}
+	if result == starlark.None {
+		if verboseFlag {
+			slog.Debug("script dropped record", "record", record)
+		}
+		rowsSkipped.Add(1)
+		audit.row(s.n, "dropped", "script returned None", record)
+		return nil
+	}
+	out, ok := result.(*starlark.Dict)
+	if !ok {
+		return fmt.Errorf("script returned %s, not a dict or None, on row %d", result.Type(), s.n)
+	}
+
+	outrec := make([]string, len(s.header))
+	for i, name := range s.header {
+		outrec[i] = scriptValue(out, name)
+		// a value set by an alias, rather than the column name
+		for _, alias := range s.aliases[i] {
+			if v := scriptValue(out, alias); i < len(record) && v != record[i] {
+				outrec[i] = v
+			}
+		}
+	}
+	audit.modified(s.n, s.header, record, outrec)
+	return s.recordWriteCloser.Write(outrec)
+}
+
+
+// the string value of a key of a returned row, or "" if missing
+func scriptValue(row *starlark.Dict, key string) string {
+	v, found, err := row.Get(starlark.String(key))
+	if err != nil || !found {
+		return ""
+	}
+	if str, ok := v.(starlark.String); ok {
+		return string(str)
+	}
+	return v.String()
+}
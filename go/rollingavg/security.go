@@ -0,0 +1,212 @@
+// security.go: TLS and authentication of the network servers and clients
+//
+// the -serve HTTP, -grpc-addr gRPC, -flight-addr Arrow Flight, -ws-addr
+// WebSocket, -sse-addr and -dashboard-addr servers are served over TLS with
+// -tls-cert and -tls-key,
+// PEM certificate and key files, and with -tls-client-ca, only to clients
+// with a certificate signed by a CA in that PEM file, i.e. mutual TLS, e.g.
+//     rollingavg -serve :8443 -tls-cert server.pem -tls-key server.key -tls-client-ca clients.pem
+// with -auth-token, requests must have an "Authorization: Bearer token"
+// header, or gRPC metadata, and with -auth-basic user:password, HTTP basic
+// authentication, either being enough with both, others being refused with
+// 401 Unauthorized, or gRPC's Unauthenticated. secrets are best set by the
+// ROLLAVG_AUTH_TOKEN and ROLLAVG_AUTH_BASIC environment variables, see
+// env.go, rather than the command line. the -metrics-addr, -health-addr and
+// -pprof-addr servers, for local monitoring, aren't secured.
+//
+// the Kafka and MQTT clients connect to their brokers over TLS with
+// -broker-tls, verifying them by the system's CAs, or with -broker-ca, by
+// those in that PEM file, and presenting the -broker-cert and -broker-key
+// client certificate, if given, e.g.
+//     rollingavg -kafka-brokers k1:9093 -kafka-topic events -broker-ca ca.pem -broker-user svc -broker-password $PW
+// with -broker-user and -broker-password, they authenticate, to Kafka by
+// SASL PLAIN, and to MQTT by its user name and password. for MQTT over TLS,
+// the -mqtt-broker URL is ssl://host:port
+
+
+package main
+
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+
+var tlsCert string
+var tlsKey string
+var tlsClientCA string
+var authToken string
+var authBasic string
+var brokerTLS bool
+var brokerCA string
+var brokerCert string
+var brokerKey string
+var brokerUser string
+var brokerPassword string
+
+
+// load a PEM file of CA certificates
+func loadCAs(filename string) *x509.CertPool {
+	pem, err := os.ReadFile(filename)
+	if err != nil {
+		fatal("error reading CA file", "file", filename, "err", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		fatal("no CA certificates in file", "file", filename)
+	}
+	return pool
+}
+
+
+// the TLS config of the servers, nil without -tls-cert
+func serverTLSConfig() *tls.Config {
+	if tlsCert == "" && tlsKey == "" {
+		if tlsClientCA != "" {
+			fatal("-tls-client-ca requires -tls-cert and -tls-key")
+		}
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(tlsCert, tlsKey)
+	if err != nil {
+		fatal("error loading TLS certificate", "tls-cert", tlsCert, "tls-key", tlsKey, "err", err)
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if tlsClientCA != "" {
+		cfg.ClientCAs = loadCAs(tlsClientCA)
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg
+}
+
+
+// listen for TCP connections on addr, over TLS if configured
+func listenServer(addr string) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if cfg := serverTLSConfig(); cfg != nil {
+		ln = tls.NewListener(ln, cfg)
+	}
+	return ln, nil
+}
+
+
+// serve HTTP on addr, over TLS and requiring authentication if configured
+func serveHTTP(addr string, h http.Handler) error {
+	ln, err := listenServer(addr)
+	if err != nil {
+		return err
+	}
+	return http.Serve(ln, requireAuth(h))
+}
+
+
+// whether an Authorization header value has the -auth-token or
+// -auth-basic credentials
+func authorized(header string) bool {
+	if token, ok := strings.CutPrefix(header, "Bearer "); ok && authToken != "" {
+		return subtle.ConstantTimeCompare([]byte(token), []byte(authToken)) == 1
+	}
+	if creds, ok := strings.CutPrefix(header, "Basic "); ok && authBasic != "" {
+		userpass, err := base64.StdEncoding.DecodeString(creds)
+		return err == nil && subtle.ConstantTimeCompare(userpass, []byte(authBasic)) == 1
+	}
+	return false
+}
+
+
+// refuse requests without the credentials, with -auth-token or -auth-basic
+func requireAuth(h http.Handler) http.Handler {
+	if authToken == "" && authBasic == "" {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r.Header.Get("Authorization")) {
+			if authBasic != "" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="rollingavg"`)
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+
+// the options of the gRPC server for TLS and authentication, if configured
+func grpcServerOptions() []grpc.ServerOption {
+	var opts []grpc.ServerOption
+	if cfg := serverTLSConfig(); cfg != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(cfg)))
+	}
+	if authToken != "" || authBasic != "" {
+		opts = append(opts, grpc.StreamInterceptor(grpcAuth), grpc.UnaryInterceptor(grpcAuthUnary))
+	}
+	return opts
+}
+
+
+// refuse streams without the credentials in their authorization metadata
+func grpcAuth(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if !grpcAuthorized(ss.Context()) {
+		return status.Error(codes.Unauthenticated, "unauthorized")
+	}
+	return handler(srv, ss)
+}
+
+
+// refuse calls without the credentials in their authorization metadata
+func grpcAuthUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if !grpcAuthorized(ctx) {
+		return nil, status.Error(codes.Unauthenticated, "unauthorized")
+	}
+	return handler(ctx, req)
+}
+
+
+func grpcAuthorized(ctx context.Context) bool {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		if authorized(v) {
+			return true
+		}
+	}
+	return false
+}
+
+
+// the TLS config of the Kafka and MQTT clients, nil without -broker-tls,
+// -broker-ca or -broker-cert
+func brokerTLSConfig() *tls.Config {
+	if !brokerTLS && brokerCA == "" && brokerCert == "" {
+		return nil
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if brokerCA != "" {
+		cfg.RootCAs = loadCAs(brokerCA)
+	}
+	if brokerCert != "" || brokerKey != "" {
+		cert, err := tls.LoadX509KeyPair(brokerCert, brokerKey)
+		if err != nil {
+			fatal("error loading broker client certificate", "broker-cert", brokerCert, "broker-key", brokerKey, "err", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg
+}
@@ -0,0 +1,144 @@
+// serve.go: HTTP server mode for on-demand processing
+//
+// with -serve, an HTTP server is run rather than processing files.
+// POSTing a CSV body to / returns the processed CSV, with the rolling
+// averages of its rows, so other services can use rollingavg without
+// running it themselves, e.g.
+//     curl --data-binary @test.csv 'http://localhost:8080/?n=10&group-by=ID'
+// query parameters override the corresponding command line options:
+//     n            window length
+//     window-unit  rows, bdays, months, minutes, hours or days
+//     group-by     column to group rows into independent series
+//     time         timestamp column for calendar windows
+//     stat         window statistic, e.g. mean or median
+//     rule         rule for the Result column, with the command line's
+//                  -threshold-a and -threshold-b, or -labels
+// the holidays file, if any, is loaded once when the server starts, and
+// time windows are aligned by -window-align and -window-tz, see calendar.go.
+// the body is processed in full before replying, and an invalid body or
+// parameter is replied to with 400 Bad Request, and the reason.
+// with -tls-cert, it's served over TLS, and with -auth-token or -auth-basic,
+// requests must be authenticated, see security.go
+
+
+package main
+
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+
+// run the HTTP server on addr until it fails
+func runServe(addr string) {
+	holidays := make(rollingavg.Holidays)
+	if holidayfile != "" {
+		holidays = loadHolidays(holidayfile)
+	}
+	loc := windowLocation()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		serveRollingAvg(w, r, holidays, loc)
+	})
+	ln, err := listenServer(addr)
+	if err != nil {
+		fatal("error listening for HTTP", "err", err)
+	}
+	serviceReady()
+	slog.Debug("serving rolling averages", "addr", addr)
+	fatal("HTTP server failed", "err", http.Serve(ln, requireAuth(mux)))
+}
+
+
+// process the CSV request body, replying with the output CSV
+func serveRollingAvg(w http.ResponseWriter, r *http.Request, holidays rollingavg.Holidays, loc *time.Location) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "POST a CSV to process", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	param := func(name, def string) string {
+		if v := q.Get(name); v != "" {
+			return v
+		}
+		return def
+	}
+	n, err := strconv.Atoi(param("n", strconv.Itoa(nrows)))
+	if err != nil || n <= 0 {
+		http.Error(w, "invalid window length: "+q.Get("n"), http.StatusBadRequest)
+		return
+	}
+	opts := rollingavg.Options{
+		Window:     n,
+		WindowUnit: param("window-unit", windowUnit),
+		Stat:       param("stat", statName),
+		Rule:       param("rule", ruleName),
+		GroupBy:    param("group-by", groupBy),
+		TimeColumn: param("time", timeCol),
+		Holidays:   holidays,
+		Align:      windowAlign,
+		Location:   loc,
+	}
+
+	ctx, span := telemetry.start(r.Context(), "rollingavg.request",
+		attribute.String("rollingavg.protocol", "http"))
+	var buf bytes.Buffer
+	if err := serveProcess(ctx, r.Body, &buf, opts); err != nil {
+		telemetry.failed(span, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	span.End()
+	w.Header().Set("Content-Type", "text/csv")
+	w.Write(buf.Bytes())
+}
+
+
+// process a CSV body to w, as rollingavg.ProcessContext, but with the
+// Result rule of the command line's thresholds or labels
+func serveProcess(ctx context.Context, body io.Reader, w io.Writer, opts rollingavg.Options) error {
+	in := csv.NewReader(body)
+	header, err := in.Read()
+	if err != nil {
+		return fmt.Errorf("error reading header: %w", err)
+	}
+	p, err := rollingavg.NewProcessor(header, opts)
+	if err != nil {
+		return err
+	}
+	if p.Rule, err = ruleByName(opts.Rule); err != nil {
+		return err
+	}
+	p.OnRead = func(n int, record []string) { telemetry.rowsRead(1) }
+	p.OnWrite = func(group string, r rollingavg.Result, outrec []string) { telemetry.rowsWritten(1) }
+
+	out := csv.NewWriter(w)
+	if p.WindowLengths {
+		header = append(header[:len(header):len(header)], rollingavg.WindowLengthColumn)
+	}
+	if err := out.Write(rollingavg.OutputHeaderAB(header, opts.Stat, opts.StatB)); err != nil {
+		return fmt.Errorf("error writing header: %w", err)
+	}
+	in.ReuseRecord = true
+	p.ReuseRecords = true
+	_, err = p.RunContext(ctx, in, out)
+	out.Flush()
+	if err != nil {
+		return err
+	}
+	return out.Error()
+}
@@ -0,0 +1,242 @@
+// sessions.go: roll CSV rows up to per-session summaries
+//
+// for event-style data, where rows come in bursts, invoked as the sessions
+// subcommand, see commands.go:
+//     mdp sessions [-v] -gap duration [-time col] [-group-by col] [-f inputfile]... [-o outputfile] [inputfile...]
+// rows are grouped into sessions, windows of activity, a new session
+// starting after an inactivity gap of more than -gap, e.g. 30m, between a
+// row's timestamp and the previous row's. for each session outputs its
+// first and last timestamps, duration in seconds, row count, and the mean,
+// min and max of every numeric column (other than the time column), e.g.
+//     Start,End,Duration,Count,X Mean,X Min,X Max,...
+//     2015-11-12 15:44:40.861,2015-11-12 15:44:43.198,2.337,29,28.59,21,39,...
+// numeric columns are determined from the first data row, and values of
+// them that aren't numbers are left out.
+// with -group-by, each series has its own sessions, the column's value
+// being output first. sessions are output as they end, when their series'
+// next row is after the gap, and those still open at the end of the input
+// in order of their starts. rows must be in time order per series, a row
+// out of order starting a new session
+
+
+package main
+
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"flag"
+	"io"
+	"log/slog"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+// summary of a session's rows
+type sessionSummary struct {
+	key        string
+	start, end time.Time
+	count      int
+	cols       []colSummary
+	nums       []int // the number of values of each column
+}
+
+
+func newSessionSummary(key string, t time.Time, ncols int) *sessionSummary {
+	s := &sessionSummary{key: key, start: t, end: t, cols: make([]colSummary, ncols), nums: make([]int, ncols)}
+	for i := range s.cols {
+		s.cols[i].min = math.Inf(1)
+		s.cols[i].max = math.Inf(-1)
+	}
+	return s
+}
+
+
+// the session's output row, of the group-by column if grouped
+func (s *sessionSummary) record(grouped bool) []string {
+	var outrec []string
+	if grouped {
+		outrec = append(outrec, s.key)
+	}
+	outrec = append(outrec, s.start.Format(timeLayout+".999"), s.end.Format(timeLayout+".999"),
+		strconv.FormatFloat(s.end.Sub(s.start).Seconds(), 'f', -1, 64), strconv.Itoa(s.count))
+	for i, c := range s.cols {
+		if s.nums[i] == 0 {
+			outrec = append(outrec, "", "", "")
+			continue
+		}
+		outrec = append(outrec,
+			strconv.FormatFloat(c.sum/float64(s.nums[i]), 'f', -1, 64),
+			strconv.FormatFloat(c.min, 'f', -1, 64),
+			strconv.FormatFloat(c.max, 'f', -1, 64))
+	}
+	return outrec
+}
+
+
+func runSessions(args []string) {
+	fs := flag.NewFlagSet("sessions", flag.ExitOnError)
+	gap := fs.Duration("gap", 0, "inactivity gap, e.g. 30m, after which a new session starts")
+	timecol := fs.String("time", "3", "timestamp column (name or index)")
+	groupcol := fs.String("group-by", "", "column (name or index) of series with their own sessions")
+	commonFlags(fs, "the session summaries")
+	fs.Parse(args)
+	infilenames = append(infilenames, fs.Args()...)
+	setupLogging()
+
+	if *gap <= 0 {
+		fatal("a positive -gap is required", "gap", *gap)
+	}
+
+	slog.Debug("summarise CSV rows by session",
+		"inputs", infilenames, "output", outfilename, "gap", *gap, "time_column", *timecol, "group_by", *groupcol)
+
+	infile := openInputs(context.Background(), infilenames, false, nil)
+	defer infile.Close()
+
+	oufl := createOutput(outfilename, "")
+	outfile := csv.NewWriter(bufio.NewWriter(oufl))
+
+	header, err := infile.Read()
+	if err != nil {
+		fatal("error reading header from csv", "err", err)
+	}
+	header = append([]string(nil), header...)
+	tcol := findColumn(header, *timecol)
+	if tcol < 0 {
+		fatal("time column not found in header", "column", *timecol)
+	}
+	gcol := -1
+	if *groupcol != "" {
+		if gcol = findColumn(header, *groupcol); gcol < 0 {
+			fatal("group-by column not found in header", "column", *groupcol)
+		}
+	}
+
+	genSessions(infile, outfile, header, tcol, gcol, *gap)
+
+	outfile.Flush()
+	if err := outfile.Error(); err != nil {
+		fatal("error writing csv", "err", err)
+	}
+	if err := oufl.Close(); err != nil {
+		fatal("error closing destination csv", "err", err)
+	}
+}
+
+
+// summarise incsv rows per session, and write one row per session to outcsv
+func genSessions(incsv recordReader, outcsv *csv.Writer, header []string, tcol, gcol int, gap time.Duration) {
+	var numcols []int
+	open := make(map[string]*sessionSummary)
+	n, sessions := 0, 0
+	write := func(s *sessionSummary) {
+		outrec := s.record(gcol >= 0)
+		if verboseFlag {
+			slog.Debug("write record", "record", outrec)
+		}
+		if err := outcsv.Write(outrec); err != nil {
+			fatal("error writing record to csv", "err", err)
+		}
+		sessions++
+	}
+	for {
+		record, err := incsv.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fatal("error reading record from csv", "err", err)
+		}
+
+		if verboseFlag {
+			slog.Debug("read record", "n", n, "record", record)
+		}
+
+		// numeric columns are those that parse as numbers in the first row,
+		// and the header is written once they're known
+		if numcols == nil {
+			numcols = []int{}
+			for i, v := range record {
+				if _, err := rollingavg.ParseFloat(v); i != tcol && i != gcol && err == nil {
+					numcols = append(numcols, i)
+				}
+			}
+			slog.Debug("numeric columns", "columns", numcols)
+			writeSessionHeader(outcsv, header, numcols, gcol)
+		}
+
+		t, err := rollingavg.ParseTime(field(record, tcol))
+		if err != nil {
+			fatal("invalid timestamp in csv", "err", err)
+		}
+		key := ""
+		if gcol >= 0 {
+			key = field(record, gcol)
+		}
+
+		s := open[key]
+		if s != nil && (t.Sub(s.end) > gap || t.Before(s.end)) {
+			write(s)
+			s = nil
+		}
+		if s == nil {
+			s = newSessionSummary(key, t, len(numcols))
+			open[key] = s
+		}
+		s.end = t
+		s.count++
+		for i, c := range numcols {
+			v, err := rollingavg.ParseFloat(field(record, c))
+			if err != nil {
+				continue
+			}
+			s.nums[i]++
+			s.cols[i].sum += v
+			s.cols[i].min = math.Min(s.cols[i].min, v)
+			s.cols[i].max = math.Max(s.cols[i].max, v)
+		}
+		n++
+	}
+	if numcols == nil {
+		writeSessionHeader(outcsv, header, nil, gcol)
+	}
+
+	// the sessions still open, in order of their starts
+	last := make([]*sessionSummary, 0, len(open))
+	for _, s := range open {
+		last = append(last, s)
+	}
+	sort.Slice(last, func(i, j int) bool {
+		if !last[i].start.Equal(last[j].start) {
+			return last[i].start.Before(last[j].start)
+		}
+		return last[i].key < last[j].key
+	})
+	for _, s := range last {
+		write(s)
+	}
+
+	slog.Debug("summarised sessions", "records", n, "sessions", sessions)
+}
+
+
+func writeSessionHeader(outcsv *csv.Writer, header []string, numcols []int, gcol int) {
+	var outrec []string
+	if gcol >= 0 {
+		outrec = append(outrec, header[gcol])
+	}
+	outrec = append(outrec, "Start", "End", "Duration", "Count")
+	for _, c := range numcols {
+		outrec = append(outrec, header[c]+" Mean", header[c]+" Min", header[c]+" Max")
+	}
+	if err := outcsv.Write(outrec); err != nil {
+		fatal("error writing record to csv", "err", err)
+	}
+}
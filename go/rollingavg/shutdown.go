@@ -0,0 +1,126 @@
+// shutdown.go: graceful shutdown on SIGINT or SIGTERM, and the tail rows
+//
+// an interrupt (^C) or SIGTERM stops processing cleanly: the rows read so
+// far are processed, the tail rows output as given by -tail, the output
+// flushed and closed, with -checkpoint the checkpoint saved, and a summary
+// of the run logged. rollingavg then exits with status 128 plus the
+// signal number, 130 for SIGINT or 143 for SIGTERM, so that an interrupted
+// run can be told apart from a completed (0) or failed (1) one.
+// in batch and watch modes, no further files are processed.
+// a second signal exits at once.
+//
+// the tail rows, left at the end of the input without a complete window,
+// are output as given by -tail, however processing ends:
+//     drop     not output (default)
+//     partial  output with statistics over the rows remaining after them
+//     empty    output with empty statistic and Result columns
+// -tail can't be used with -append or -checkpoint, which carry the tail
+// rows over to the next run
+
+
+package main
+
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+// the signal that stopped processing, set before the context is cancelled
+var stopSignal os.Signal
+
+
+// a context cancelled by SIGINT or SIGTERM, after which they are no longer
+// caught, so that a second one exits at once
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-sigs:
+			slog.Info("received signal, stopping", "signal", sig.String())
+			stopSignal = sig
+			serviceStopping()
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(sigs)
+	}()
+	return ctx, cancel
+}
+
+
+// exit with the status for the signal that cancelled ctx, if it was
+func exitIfStopped(ctx context.Context) {
+	if ctx.Err() == nil {
+		return
+	}
+	if sig, ok := stopSignal.(syscall.Signal); ok {
+		os.Exit(128 + int(sig))
+	}
+}
+
+
+// check that the -tail policy is valid with the current options
+func checkTailPolicy() {
+	switch tailPolicy {
+	case "drop":
+	case "partial", "empty":
+		if appendFlag || checkpointfile != "" {
+			fatal("-tail can't be used with -append or -checkpoint", "policy", tailPolicy)
+		}
+	default:
+		fatal("invalid tail policy", "policy", tailPolicy)
+	}
+}
+
+
+// output the tail rows left in p's windows as given by -tail, returning
+// the number written
+func writeTailRows(p *rollingavg.Processor, outcsv recordWriter) int {
+	if tailPolicy == "drop" {
+		return 0
+	}
+	tail, err := p.Tail()
+	if err != nil {
+		fatal("error processing tail rows", "err", err)
+	}
+	if windowColumnsSet() {
+		addTailColumns(p, tail)
+	}
+	for _, r := range tail {
+		outrec := rollingavg.OutputRow(r, p.Rule)
+		if tailPolicy == "empty" {
+			outrec = append(outrec[:len(r.Record):len(r.Record)], "", "", "")
+		}
+		if verboseFlag {
+			slog.Debug("write tail record", "record", outrec)
+		}
+		resultWritten(outrec[len(outrec)-1])
+		if err := outcsv.Write(outrec); err != nil {
+			fatal("error writing record to csv", "err", err)
+		}
+	}
+	return len(tail)
+}
+
+
+// log a summary of a run, at info level when it was interrupted, otherwise
+// at debug level
+func logSummary(ctx context.Context, counts rollingavg.Counts, tail int, groups int, elapsed time.Duration) {
+	level, msg := slog.LevelDebug, "run completed"
+	if ctx.Err() != nil {
+		level, msg = slog.LevelInfo, "run interrupted"
+	}
+	slog.Log(context.Background(), level, msg,
+		"elapsed", elapsed.Round(time.Millisecond), "records_read", counts.Read, "rows_written", counts.Written,
+		"tail_rows", tail, "pending_rows", counts.Pending, "groups", groups)
+}
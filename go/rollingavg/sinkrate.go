@@ -0,0 +1,54 @@
+// sinkrate.go: rate limiting of network outputs
+//
+// with -sink-rate r, rows are sent to a Kafka or PostgreSQL output, see
+// kafka.go and postgres.go, at no more than r rows a second on average,
+// e.g. so that a backfill doesn't overwhelm the systems downstream, e.g.
+//     rollingavg -pg-conn $PG -pg-table avgs -pg-batch 500 -sink-rate 2000 history.csv
+// the rows are sent in batches, of -pg-batch or -kafka-batch rows, each
+// batch being sent once the rows before it have had their time, so the
+// rate is kept over batches rather than within one. reading waits while
+// sending does, unless -output-queue is used, see outqueue.go
+
+
+package main
+
+
+import (
+	"time"
+)
+
+
+var sinkRate float64
+
+
+// paces the batches of rows sent to an output, nil when unlimited
+type sinkPacer struct {
+	rate float64 // rows a second
+	next time.Time
+}
+
+
+// a pacer of -sink-rate rows a second, or nil without it
+func newSinkPacer() *sinkPacer {
+	if sinkRate < 0 {
+		fatal("invalid sink rate", "sink-rate", sinkRate)
+	}
+	if sinkRate == 0 {
+		return nil
+	}
+	return &sinkPacer{rate: sinkRate}
+}
+
+
+// wait until a batch of n rows can be sent
+func (p *sinkPacer) wait(n int) {
+	if p == nil || n == 0 {
+		return
+	}
+	now := time.Now()
+	if d := p.next.Sub(now); d > 0 {
+		time.Sleep(d)
+		now = p.next
+	}
+	p.next = now.Add(time.Duration(float64(n) / p.rate * float64(time.Second)))
+}
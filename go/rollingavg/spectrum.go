@@ -0,0 +1,284 @@
+// spectrum.go: FFT amplitude spectra and periodograms of a CSV column
+//
+// to identify periodicities in sensor data, invoked as the spectrum
+// subcommand, see commands.go:
+//     mdp spectrum [-v] -c col [-window nrows [-hop nrows]] [-detrend none|mean|linear]
+//         [-taper none|hann] [-time col] [-top k] [-f inputfile]... [-o outputfile] [inputfile...]
+// the numeric values of the -c column are transformed by an FFT, over all
+// the rows, or with -window, each window of that many rows, starting every
+// -hop rows (default -window, i.e. not overlapping), and the one sided
+// spectrum output, a row per frequency, e.g.
+//     Start,Frequency,Period,Amplitude,Power
+//     2015-01-01 00:00:00,0.0625,16,3,4608
+// where start is the window's first timestamp, or row number without a
+// -time column, frequency is in cycles per second of the -time column's
+// mean interval, or without timestamps, per row, period is its inverse,
+// amplitude is that of the sinusoid of that frequency, and power is the
+// periodogram's spectral density.
+// values are detrended, by default removing their mean, so the 0 frequency
+// isn't dominant, and tapered, by default not, before the transform, and
+// zero padded to a power of 2 rows, so the frequencies are of the padded
+// length. a last window of fewer rows isn't transformed.
+// with -top, only the k frequencies of most power of each window are
+// output, strongest first
+
+
+package main
+
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"flag"
+	"io"
+	"log/slog"
+	"math"
+	"math/cmplx"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+// the in place radix 2 FFT of x, whose length is a power of 2
+func fft(x []complex128) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		w := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			wk := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				u, v := x[start+k], x[start+k+size/2]*wk
+				x[start+k], x[start+k+size/2] = u+v, u-v
+				wk *= w
+			}
+		}
+	}
+}
+
+
+// detrend values in place, by their mean or least squares line
+func detrend(values []float64, method string) {
+	switch method {
+	case "mean":
+		mean := 0.0
+		for _, v := range values {
+			mean += v
+		}
+		mean /= float64(len(values))
+		for i := range values {
+			values[i] -= mean
+		}
+	case "linear":
+		var fit lineFit
+		for i, v := range values {
+			fit.add(float64(i), v)
+		}
+		if a, b, ok := fit.line(); ok {
+			for i := range values {
+				values[i] -= a + b*float64(i)
+			}
+		}
+	}
+}
+
+
+// a frequency of a spectrum
+type spectralLine struct {
+	freq  float64
+	amp   float64
+	power float64
+}
+
+
+// the one sided spectrum of values sampled at rate per unit, with the
+// method of detrending, and the taper
+func spectrum(values []float64, rate float64, detrending, taper string) []spectralLine {
+	n := len(values)
+	detrend(values, detrending)
+	// the window function's sum, and sum of squares, for the amplitudes
+	// and power of a tapered window
+	wsum, wsq := float64(n), float64(n)
+	if taper == "hann" && n > 1 {
+		wsum, wsq = 0, 0
+		for i := range values {
+			w := 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(n-1))
+			values[i] *= w
+			wsum += w
+			wsq += w * w
+		}
+	}
+	size := 1
+	for size < n {
+		size <<= 1
+	}
+	x := make([]complex128, size)
+	for i, v := range values {
+		x[i] = complex(v, 0)
+	}
+	fft(x)
+
+	lines := make([]spectralLine, size/2+1)
+	for k := range lines {
+		mag := cmplx.Abs(x[k])
+		amp, power := mag/wsum, mag*mag/(rate*wsq)
+		// one sided, folding in the negative frequencies
+		if k > 0 && k < size/2 {
+			amp *= 2
+			power *= 2
+		}
+		lines[k] = spectralLine{float64(k) * rate / float64(size), amp, power}
+	}
+	return lines
+}
+
+
+func runSpectrum(args []string) {
+	fs := flag.NewFlagSet("spectrum", flag.ExitOnError)
+	column := fs.String("c", "", "column (name or index) to transform")
+	window := fs.Int("window", 0, "rows of each window to transform (default all the rows)")
+	hop := fs.Int("hop", 0, "rows between the starts of windows (default -window)")
+	detrending := fs.String("detrend", "mean", "detrending of each window: none, mean or linear")
+	taper := fs.String("taper", "none", "taper of each window: none or hann")
+	timecol := fs.String("time", "3", "timestamp column (name or index), giving the sampling rate")
+	top := fs.Int("top", 0, "output only this many frequencies of most power per window (default all)")
+	commonFlags(fs, "the spectra")
+	fs.Parse(args)
+	infilenames = append(infilenames, fs.Args()...)
+	setupLogging()
+
+	switch {
+	case *column == "":
+		fatal("-c column is required")
+	case *window < 0 || *window == 1 || *hop < 0:
+		fatal("invalid window, expected at least 2 rows", "window", *window, "hop", *hop)
+	case *detrending != "none" && *detrending != "mean" && *detrending != "linear":
+		fatal("invalid detrending", "detrend", *detrending)
+	case *taper != "none" && *taper != "hann":
+		fatal("invalid taper", "taper", *taper)
+	}
+	if *hop == 0 {
+		*hop = *window
+	}
+
+	slog.Debug("spectrum of CSV column",
+		"inputs", infilenames, "output", outfilename, "column", *column, "window", *window, "hop", *hop)
+
+	infile := openInputs(context.Background(), infilenames, false, nil)
+	defer infile.Close()
+
+	oufl := createOutput(outfilename, "")
+	outfile := csv.NewWriter(bufio.NewWriter(oufl))
+
+	header, err := infile.Read()
+	if err != nil {
+		fatal("error reading header from csv", "err", err)
+	}
+	col := findColumn(header, *column)
+	if col < 0 {
+		fatal("column not found in header", "column", *column)
+	}
+	tcol := findColumn(header, *timecol)
+	if err := outfile.Write([]string{"Start", "Frequency", "Period", "Amplitude", "Power"}); err != nil {
+		fatal("error writing record to csv", "err", err)
+	}
+
+	// the values of the rows, and their starts, timestamps or row numbers,
+	// and timestamps, if they have them, for the windows not yet transformed
+	var values []float64
+	var starts []string
+	var times []time.Time
+	rows, written := 0, 0
+	format := func(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+	transform := func(n int) {
+		// the sampling rate, per second of the timestamps' mean interval
+		rate := 1.0
+		if !times[0].IsZero() && !times[n-1].IsZero() {
+			if d := times[n-1].Sub(times[0]).Seconds(); d > 0 {
+				rate = float64(n-1) / d
+			}
+		}
+		lines := spectrum(append([]float64(nil), values[:n]...), rate, *detrending, *taper)
+		if *top > 0 {
+			sort.SliceStable(lines, func(i, j int) bool { return lines[i].power > lines[j].power })
+			lines = lines[:min(*top, len(lines))]
+		}
+		for _, l := range lines {
+			period := ""
+			if l.freq > 0 {
+				period = format(1 / l.freq)
+			}
+			if err := outfile.Write([]string{starts[0], format(l.freq), period, format(l.amp), format(l.power)}); err != nil {
+				fatal("error writing record to csv", "err", err)
+			}
+			written++
+		}
+	}
+	// drop the first hop values, after their window's transform, skipping
+	// any more of them yet to be read
+	skip := 0
+	advance := func() {
+		k := min(*hop, len(values))
+		values, starts, times = values[k:], starts[k:], times[k:]
+		skip = *hop - k
+	}
+
+	for {
+		record, err := infile.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fatal("error reading record from csv", "err", err)
+		}
+		rows++
+		v, err := rollingavg.ParseFloat(field(record, col))
+		if err != nil {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		start := strconv.Itoa(rows)
+		var t time.Time
+		if tcol >= 0 {
+			if t, err = rollingavg.ParseTime(field(record, tcol)); err == nil {
+				start = field(record, tcol)
+			}
+		}
+		values = append(values, v)
+		starts = append(starts, start)
+		times = append(times, t)
+		if *window > 0 && len(values) == *window {
+			transform(*window)
+			advance()
+		}
+	}
+	// the whole series. a last partial window isn't transformed
+	if *window == 0 && len(values) > 1 {
+		transform(len(values))
+	}
+	slog.Debug("spectrum records", "rows", rows, "records", written)
+
+	outfile.Flush()
+	if err := outfile.Error(); err != nil {
+		fatal("error writing csv", "err", err)
+	}
+	if err := oufl.Close(); err != nil {
+		fatal("error closing destination csv", "err", err)
+	}
+}
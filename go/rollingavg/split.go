@@ -0,0 +1,206 @@
+// split.go: shard output rows into one file per day or month
+//
+// each output row goes to a file for the day or month of its timestamp,
+// for partitioned downstream storage. files are created, with the
+// header row, when their first row is written.
+// shard filenames are made from a pattern, in which
+//     {dir}   is the directory of the output file
+//     {name}  is the output filename without its extension
+//     {ext}   is the output filename extension, including the "."
+//     {date}  is the day (YYYY-MM-DD) or month (YYYY-MM) of the rows
+// so the default pattern "{dir}/{name}.{date}{ext}" writes out.csv as
+// out.2015-11-12.csv, out.2015-11-13.csv, ...
+// only the shards most recently written are kept open, up to
+// maxOpenShards, so time-ordered rows over many days don't run out of
+// file descriptors. a row for a shard closed since is appended to it,
+// which -manifest and -column-stats, summarising each file once, don't
+// allow
+
+
+package main
+
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+const defaultSplitPattern = "{dir}/{name}.{date}{ext}"
+
+// how many shards are kept open at once
+const maxOpenShards = 16
+
+
+// writes CSV records to one output file per day or month of the time column
+// the first record written is taken as the header, and starts each shard
+type splitCSV struct {
+	outfilename string
+	pattern     string
+	compression string
+	layout      string
+	timecol     string
+	tcol        int
+	header      []string
+	shards      map[string]*csvOutput // the open shards, by date
+	used        []string              // the dates of the open shards, least recently written first
+	created     map[string]bool       // the dates of the shards created
+	prov        *provenance
+	err         error
+}
+
+
+// period is "day" or "month", timecol names the timestamp column
+func newSplitCSV(outfilename, pattern, compression, period, timecol string, prov *provenance) *splitCSV {
+	if outfilename == "" || isRemote(outfilename) {
+		fatal("splitting output by date requires a local output file")
+	}
+	layout := "2006-01-02"
+	switch period {
+	case "day":
+	case "month":
+		layout = "2006-01"
+	default:
+		fatal("invalid split period", "period", period)
+	}
+	return &splitCSV{
+		outfilename: outfilename,
+		pattern:     pattern,
+		compression: compression,
+		layout:      layout,
+		timecol:     timecol,
+		shards:      make(map[string]*csvOutput),
+		created:     make(map[string]bool),
+		prov:        prov,
+	}
+}
+
+
+// make the filename of the shard for date from the pattern
+func (s *splitCSV) shardName(date string) string {
+	ext := filepath.Ext(s.outfilename)
+	base := filepath.Base(s.outfilename)
+	rep := strings.NewReplacer(
+		"{dir}", filepath.Dir(s.outfilename),
+		"{name}", strings.TrimSuffix(base, ext),
+		"{ext}", ext,
+		"{date}", date)
+	return filepath.Clean(rep.Replace(s.pattern))
+}
+
+
+// write the record to the shard for its date, creating it if needed
+func (s *splitCSV) write(record []string) error {
+	if s.tcol >= len(record) {
+		return fmt.Errorf("record missing time column: %v", record)
+	}
+	t, err := rollingavg.ParseTime(record[s.tcol])
+	if err != nil {
+		return err
+	}
+	date := t.Format(s.layout)
+
+	shard, found := s.shards[date]
+	if found {
+		i := slices.Index(s.used, date)
+		s.used = slices.Delete(s.used, i, i+1)
+	} else {
+		if len(s.shards) >= maxOpenShards {
+			if err := s.closeShard(s.used[0]); err != nil {
+				return err
+			}
+		}
+		if shard, err = s.openShard(date); err != nil {
+			return err
+		}
+		s.shards[date] = shard
+	}
+	s.used = append(s.used, date)
+	return shard.Write(record)
+}
+
+
+// create the shard for date, with the header row, or if it was created and
+// closed since, reopen it to append to
+func (s *splitCSV) openShard(date string) (*csvOutput, error) {
+	name := s.shardName(date)
+	if !s.created[date] {
+		slog.Debug("start output shard", "file", name)
+		shard := newCSVOutput(name, s.compression, s.prov)
+		s.created[date] = true
+		return shard, shard.Write(s.header)
+	}
+	if manifest != nil || columnStatsMode != "" {
+		return nil, fmt.Errorf("output shard %s reopened for rows out of date order, which -manifest and -column-stats don't allow", name)
+	}
+	slog.Debug("reopen output shard", "file", name)
+	// concatenated gzip members and zstd frames are still valid streams
+	fl, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return newCSVOutputFile(compressOutput(fl, name, s.compression)), nil
+}
+
+
+// close the shard for date, to be reopened if more rows are written to it
+func (s *splitCSV) closeShard(date string) error {
+	shard := s.shards[date]
+	delete(s.shards, date)
+	s.used = slices.DeleteFunc(s.used, func(d string) bool { return d == date })
+	slog.Debug("close output shard", "date", date)
+	return shard.Close()
+}
+
+
+func (s *splitCSV) Write(record []string) error {
+	if s.err != nil {
+		return s.err
+	}
+	if s.header == nil {
+		s.header = make([]string, len(record))
+		copy(s.header, record)
+		s.tcol = findColumn(s.header, s.timecol)
+		if s.tcol < 0 {
+			s.err = fmt.Errorf("time column not found in header: %s", s.timecol)
+		}
+		return s.err
+	}
+	s.err = s.write(record)
+	return s.err
+}
+
+
+func (s *splitCSV) Flush() {
+	for _, shard := range s.shards {
+		shard.Flush()
+	}
+}
+
+
+func (s *splitCSV) Error() error {
+	if s.err != nil {
+		return s.err
+	}
+	for _, shard := range s.shards {
+		if err := shard.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+
+func (s *splitCSV) Close() error {
+	for _, shard := range s.shards {
+		if err := shard.Close(); err != nil && s.err == nil {
+			s.err = err
+		}
+	}
+	return s.err
+}
@@ -0,0 +1,63 @@
+// sse.go: stream output rows as server-sent events
+//
+// with -sse-addr, an HTTP listener serves an event stream on /events,
+// sending each output row, as it is computed, to every connected client
+// as a "row" event whose data is the row as a JSON object. this is simpler
+// than WebSockets for browser dashboards, using EventSource:
+//     new EventSource("http://host:8082/events").addEventListener("row", ...)
+// most useful when streaming, e.g. with -follow, -kafka-topic or -listen.
+// clients only receive rows computed after they connect
+
+
+package main
+
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+
+// start serving the event stream on addr, publishing the rows of b
+func startSSE(addr string, b *rowBroadcaster) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		serveSSE(w, r, b)
+	})
+	go func() {
+		fatal("SSE listener failed", "err", serveHTTP(addr, mux))
+	}()
+}
+
+
+// send published rows to a client until it disconnects
+func serveSSE(w http.ResponseWriter, r *http.Request, b *rowBroadcaster) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	slog.Debug("SSE client connected", "client", r.RemoteAddr)
+
+	rows := b.subscribe()
+	defer b.unsubscribe(rows)
+
+	for {
+		select {
+		case row := <-rows:
+			if _, err := fmt.Fprintf(w, "event: row\ndata: %s\n\n", row); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			slog.Debug("SSE client disconnected", "client", r.RemoteAddr)
+			return
+		}
+	}
+}
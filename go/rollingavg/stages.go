@@ -0,0 +1,334 @@
+// stages.go: transform stages of a pipeline configuration
+//
+// rather than piping rows through csvfilter, aggregate and so on, with
+// intermediate files, a -config file's stages transform the input rows in
+// the same streaming pass as the rolling statistic and rule, in the order
+// they're listed, e.g.
+//     stages:
+//       - filter: 'X > -500 && time >= 2015-11-12'
+//       - derive:
+//           column: Mag
+//           expr: math.sqrt(row["X"]*row["X"] + row["Y"]*row["Y"])
+//       - resample: 1m
+// the stages are
+//     filter    keep the rows matching an expression, as csvfilter -e
+//     drop      drop the rows matching an expression, as csvfilter -drop
+//     derive    add a column of a Starlark expression of the row, a dict of
+//               column name, or -alias, to value, numbers as floats and
+//               others strings, with the math module, see script.go
+//     resample  roll the rows up to one per interval of the -time column,
+//               e.g. 30s, 5m or 1h, per -group-by series, with the mean of
+//               the numeric columns and the last value of the others, the
+//               time being the interval's start. rows must be in time order
+//               per series, a row out of order starting a new interval
+//     ewm       add exponentially weighted moving statistics of columns, as
+//               -ewm col:alpha,..., see ewm.go
+// the stages' output rows are then averaged, with the A and B columns, the
+// time column and any -group-by column named as in the stages' output.
+// stages hold rows back, so can't be used with -checkpoint or -parallel
+
+
+package main
+
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.starlark.net/lib/math"
+	"go.starlark.net/starlark"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+// a stage of a pipeline configuration, one of whose settings is given
+type pipelineStage struct {
+	Filter string `yaml:"filter"`
+	Drop   string `yaml:"drop"`
+	Derive *struct {
+		Column string `yaml:"column"`
+		Expr   string `yaml:"expr"`
+	} `yaml:"derive"`
+	Resample string `yaml:"resample"`
+	EWM      string `yaml:"ewm"`
+}
+
+
+// the stages of the -config file
+var pipelineStages []pipelineStage
+
+
+// wrap in with the stages, returning records, starting with the header,
+// as transformed by them
+func applyStages(in recordReader, stages []pipelineStage) recordReader {
+	for i, s := range stages {
+		n := 0
+		if s.Filter != "" {
+			n++
+			in = &filterStage{in: in, expr: s.Filter}
+		}
+		if s.Drop != "" {
+			n++
+			in = &filterStage{in: in, expr: s.Drop, drop: true}
+		}
+		if s.Derive != nil {
+			n++
+			in = newDeriveStage(in, s.Derive.Column, s.Derive.Expr)
+		}
+		if s.Resample != "" {
+			n++
+			interval, err := time.ParseDuration(s.Resample)
+			if err != nil || interval <= 0 {
+				fatal("invalid resample interval", "stage", i+1, "interval", s.Resample)
+			}
+			in = &resampleStage{in: in, interval: interval, buckets: make(map[string]*resampleBucket)}
+		}
+		if s.EWM != "" {
+			n++
+			in = newEWMStage(in, s.EWM)
+		}
+		if n != 1 {
+			fatal("a stage must be one of filter, drop, derive, resample or ewm", "stage", i+1)
+		}
+	}
+	return in
+}
+
+
+// read the header of a stage's input
+func readStageHeader(in recordReader) []string {
+	header, err := in.Read()
+	if err != nil {
+		fatal("error reading header from csv", "err", err)
+	}
+	return append([]string(nil), header...)
+}
+
+
+// keeps, or drops, the records matching an expression
+type filterStage struct {
+	in    recordReader
+	expr  string
+	drop  bool
+	match func([]string) bool
+}
+
+
+func (f *filterStage) Read() ([]string, error) {
+	if f.match == nil {
+		header := readStageHeader(f.in)
+		match, err := compileFilter(f.expr, header, findColumn(header, timeCol))
+		if err != nil {
+			fatal("invalid filter expression", "expr", f.expr, "err", err)
+		}
+		f.match = match
+		return header, nil
+	}
+	for {
+		record, err := f.in.Read()
+		if err != nil || f.match(record) != f.drop {
+			return record, err
+		}
+	}
+}
+
+
+// adds a column of an expression of each record
+type deriveStage struct {
+	in     recordReader
+	column string
+	thread *starlark.Thread
+	derive starlark.Callable
+	header []string
+	n      int
+
+	// the -alias names of the header's columns, by index
+	aliases map[int][]string
+}
+
+
+// compile the expression, as the body of a function of the row
+func newDeriveStage(in recordReader, column, expr string) *deriveStage {
+	if column == "" || expr == "" {
+		fatal("derive stages need a column and an expr")
+	}
+	thread := &starlark.Thread{Name: "derive " + column}
+	src := "def derive(row):\n    return (" + strings.ReplaceAll(expr, "\n", " ") + ")\n"
+	globals, err := starlark.ExecFile(thread, "derive "+column, src, starlark.StringDict{"math": math.Module})
+	if err != nil {
+		fatal("invalid derive expression", "column", column, "err", err)
+	}
+	return &deriveStage{in: in, column: column, thread: thread, derive: globals["derive"].(starlark.Callable)}
+}
+
+
+func (d *deriveStage) Read() ([]string, error) {
+	if d.header == nil {
+		d.header = readStageHeader(d.in)
+		d.aliases = headerAliases(d.header)
+		return append(d.header[:len(d.header):len(d.header)], d.column), nil
+	}
+	record, err := d.in.Read()
+	if err != nil {
+		return record, err
+	}
+	d.n++
+	row := starlark.NewDict(len(record))
+	for i, name := range d.header {
+		v := field(record, i)
+		var value starlark.Value = starlark.String(v)
+		if f, err := rollingavg.ParseFloat(v); err == nil {
+			value = starlark.Float(f)
+		}
+		row.SetKey(starlark.String(name), value)
+		for _, alias := range d.aliases[i] {
+			row.SetKey(starlark.String(alias), value)
+		}
+	}
+	result, err := starlark.Call(d.thread, d.derive, starlark.Tuple{row}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("derive %s failed on row %d: %w", d.column, d.n, err)
+	}
+	var value string
+	switch v := result.(type) {
+	case starlark.String:
+		value = string(v)
+	case starlark.Float:
+		value = strconv.FormatFloat(float64(v), 'f', -1, 64)
+	case starlark.NoneType:
+	default:
+		value = v.String()
+	}
+	return append(record[:len(record):len(record)], value), nil
+}
+
+
+// rolls records up to one per interval, per series
+type resampleStage struct {
+	in       recordReader
+	interval time.Duration
+	header   []string
+	tcol     int
+	gcol     int
+	buckets  map[string]*resampleBucket
+	order    []string
+	ready    [][]string
+	done     bool
+}
+
+
+// the records of a series in an interval, summarised
+type resampleBucket struct {
+	start time.Time
+	vals  []int
+	sums  []float64
+	nums  []int
+	last  []string
+}
+
+
+func (s *resampleStage) Read() ([]string, error) {
+	if s.header == nil {
+		s.header = readStageHeader(s.in)
+		s.tcol = findColumn(s.header, timeCol)
+		if s.tcol < 0 {
+			fatal("time column not found in header", "column", timeCol)
+		}
+		s.gcol = -1
+		if groupBy != "" {
+			if s.gcol = findColumn(s.header, groupBy); s.gcol < 0 {
+				fatal("group-by column not found in header", "column", groupBy)
+			}
+		}
+		return s.header, nil
+	}
+	for len(s.ready) == 0 {
+		if s.done {
+			return nil, io.EOF
+		}
+		record, err := s.in.Read()
+		if err == io.EOF {
+			s.done = true
+			for _, key := range s.order {
+				s.ready = append(s.ready, s.buckets[key].record(s.header, s.tcol))
+			}
+			slog.Debug("resampled rows", "interval", s.interval, "series", len(s.order))
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		s.add(record)
+	}
+	record := s.ready[0]
+	s.ready = s.ready[1:]
+	return record, nil
+}
+
+
+// add a record to its series' interval, readying the series' last interval
+// if the record starts another
+func (s *resampleStage) add(record []string) {
+	t, err := rollingavg.ParseTime(field(record, s.tcol))
+	if err != nil {
+		fatal("invalid timestamp in csv", "err", err)
+	}
+	start := t.Truncate(s.interval)
+	key := ""
+	if s.gcol >= 0 {
+		key = field(record, s.gcol)
+	}
+	b := s.buckets[key]
+	switch {
+	case b == nil:
+		s.order = append(s.order, key)
+	case !b.start.Equal(start):
+		s.ready = append(s.ready, b.record(s.header, s.tcol))
+	default:
+		b.addRecord(record)
+		return
+	}
+	n := len(s.header)
+	b = &resampleBucket{start: start, vals: make([]int, n), sums: make([]float64, n), nums: make([]int, n), last: make([]string, n)}
+	s.buckets[key] = b
+	b.addRecord(record)
+}
+
+
+func (b *resampleBucket) addRecord(record []string) {
+	for i := range b.last {
+		v := field(record, i)
+		if v == "" {
+			continue
+		}
+		b.vals[i]++
+		b.last[i] = v
+		if f, err := rollingavg.ParseFloat(v); err == nil {
+			b.sums[i] += f
+			b.nums[i]++
+		}
+	}
+}
+
+
+// the interval's record: the mean of columns whose values are all numbers,
+// and the last value of the others
+func (b *resampleBucket) record(header []string, tcol int) []string {
+	record := make([]string, len(header))
+	for i := range record {
+		switch {
+		case i == tcol:
+			record[i] = b.start.Format(rollingavg.TimeLayout)
+		case b.nums[i] > 0 && b.nums[i] == b.vals[i]:
+			record[i] = strconv.FormatFloat(b.sums[i]/float64(b.nums[i]), 'f', -1, 64)
+		default:
+			record[i] = b.last[i]
+		}
+	}
+	return record
+}
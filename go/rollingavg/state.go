@@ -0,0 +1,171 @@
+// state.go: skipping inputs already processed, by their content
+//
+// with -batch or -watch, -state file keeps, in a JSON state file, the
+// SHA-256 hashes of the contents of the inputs processed, with the
+// parameters they were processed with, so that an input is skipped if one
+// with the same content has already been processed, with the same
+// parameters and version, into its output, which still exists, e.g.
+//     rollingavg -batch data/ -state data/.rollingavg-state.json -n 50
+// makes re-running the batch safe, however the files were touched or
+// copied back, only new or changed files, or all of them if the parameters
+// changed, being processed. the parameters are the flags not at their
+// defaults, as for -manifest, see manifest.go, other than those only
+// choosing the inputs, or how the run is logged, monitored or reported.
+// skipped batch files are reported as unchanged, and skipped watched files
+// are moved to the done directory, as if processed. the state file is
+// saved after each file is processed, so an interrupted run loses nothing
+
+
+package main
+
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+
+var statefile string
+
+// the inputs processed, nil without -state
+var processed *processedInputs
+
+
+// flags that don't change the outputs of processing an input
+var stateIgnoredFlags = map[string]bool{
+	"batch": true, "watch": true, "done-dir": true, "jobs": true, "state": true,
+	"v": true, "log-level": true, "log-format": true, "progress": true, "progress-every": true,
+	"report": true, "manifest": true, "metrics-addr": true, "health-addr": true, "pprof-addr": true,
+	"cpuprofile": true, "memprofile": true, "otel": true, "daemon": true,
+}
+
+
+// an input processed
+type processedInput struct {
+	Input     string    `json:"input"`
+	Output    string    `json:"output"`
+	Processed time.Time `json:"processed"`
+}
+
+
+// the state file, of the inputs processed, by the hashes of their
+// contents and parameters, and their outputs
+type processedInputs struct {
+	Inputs map[string]processedInput `json:"inputs"`
+
+	mu       sync.Mutex
+	filename string
+	params   string // the hash of the parameters
+}
+
+
+// load the state file, if it exists
+func startProcessed(filename string) {
+	if batchGlob == "" && watchDir == "" {
+		fatal("-state requires -batch or -watch")
+	}
+	processed = &processedInputs{Inputs: make(map[string]processedInput), filename: filename, params: parametersHash()}
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		fatal("error reading state file", "err", err)
+	}
+	if err := json.Unmarshal(data, processed); err != nil {
+		fatal("error reading state file", "file", filename, "err", err)
+	}
+	slog.Debug("loaded state", "file", filename, "inputs", len(processed.Inputs))
+}
+
+
+// the hash of the version and the parameters that change the outputs
+func parametersHash() string {
+	params := runParameters()
+	names := make([]string, 0, len(params))
+	for name := range params {
+		if !stateIgnoredFlags[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	fmt.Fprintf(h, "version=%s\n", APP_VERSION)
+	for _, name := range names {
+		fmt.Fprintf(h, "%s=%s\n", name, params[name])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+
+// the key of an input and its output in the state, the hashes of the
+// input's content and the parameters, and the output, or "" without -state
+func (p *processedInputs) key(infilename, outfilename string) string {
+	if p == nil {
+		return ""
+	}
+	fl, err := os.Open(infilename)
+	if err != nil {
+		fatal("error opening source csv", "err", err)
+	}
+	defer fl.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, fl); err != nil {
+		fatal("error reading source csv", "err", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)) + "/" + p.params + "/" + filepath.Clean(outfilename)
+}
+
+
+// whether the input of the key has been processed into its output, which
+// still exists
+func (p *processedInputs) done(key string) bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	in, ok := p.Inputs[key]
+	p.mu.Unlock()
+	if !ok {
+		return false
+	}
+	if _, err := os.Stat(in.Output); err != nil {
+		return false
+	}
+	slog.Debug("already processed", "input", in.Input, "output", in.Output, "processed", in.Processed)
+	return true
+}
+
+
+// record the input of the key as processed, and save the state
+func (p *processedInputs) add(key string, infilename, outfilename string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Inputs[key] = processedInput{Input: infilename, Output: outfilename, Processed: time.Now()}
+	data, err := json.Marshal(p)
+	if err != nil {
+		fatal("error saving state", "err", err)
+	}
+	// replace the state file atomically, so a crash mid-write leaves the last one
+	tmp := p.filename + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		fatal("error writing state file", "err", err)
+	}
+	if err := os.Rename(tmp, p.filename); err != nil {
+		fatal("error writing state file", "err", err)
+	}
+}
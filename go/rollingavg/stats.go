@@ -0,0 +1,131 @@
+// stats.go: window statistics of each of A and B
+//
+// -stat gives the one window statistic of both A and B. -stats instead
+// gives each its own statistics, computed in the same pass, as
+// A:stat,...;B:stat,..., e.g.
+//     -stats "A:mean,stddev;B:median"
+// the first statistic of each is output in place of -stat's, named for
+// it, e.g. Average A and Median B, and is the one the -rule is of. the
+// rest are output as extra window columns, see windowcols.go, e.g.
+//     X,Y,Z,Time,Stddev A,Average A,Median B,Result
+// a column left out has the -stat statistic. extra statistics are over
+// the rows the window buffers, so with -nb, only the column with the
+// longer window can have them
+
+
+package main
+
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+var statsSpec string
+
+
+// the statistics of A and B, by -stats or -stat
+func abStats() (a, b []string) {
+	a, b = []string{statName}, []string{statName}
+	if statsSpec == "" {
+		return a, b
+	}
+	for _, item := range strings.Split(statsSpec, ";") {
+		col, list, ok := strings.Cut(item, ":")
+		if !ok || list == "" {
+			fatal("invalid column statistics, expected A:stat,...;B:stat,...", "stats", item)
+		}
+		stats := strings.Split(list, ",")
+		for i, stat := range stats {
+			stats[i] = strings.TrimSpace(stat)
+			if _, err := rollingavg.LookupAggregator(stats[i]); err != nil {
+				fatal("invalid column statistic", "err", err)
+			}
+		}
+		switch strings.TrimSpace(col) {
+		case "A":
+			a = stats
+		case "B":
+			b = stats
+		default:
+			fatal("column statistics are of A or B", "column", col)
+		}
+	}
+	return a, b
+}
+
+
+// the statistics output in place of -stat's for A and B
+func mainStats() (string, string) {
+	a, b := abStats()
+	return a[0], b[0]
+}
+
+
+// whether -stats gives any extra statistics
+func extraStatsSet() bool {
+	a, b := abStats()
+	return len(a) > 1 || len(b) > 1
+}
+
+
+// the extra window columns of the statistics of A and B after their first
+func extraStatsColumns() []windowColumn {
+	a, b := abStats()
+	if nrowsB != 0 && nrowsB != nrows && (len(a) > 1 && nrows < nrowsB || len(b) > 1 && nrowsB < nrows) {
+		fatal("with -nb, only the column with the longer window can have extra -stats")
+	}
+	var cols []windowColumn
+	for i, stats := range [][]string{a, b} {
+		for _, stat := range stats[1:] {
+			newAggregator, _ := rollingavg.LookupAggregator(stat)
+			cols = append(cols, windowColumn{i, rollingavg.StatColumn(stat) + " " + "AB"[i:i+1],
+				func() windowStat { return &aggregatorStat{agg: newAggregator()} }})
+		}
+	}
+	return cols
+}
+
+
+// a window statistic of a column's values, kept by an aggregator
+type aggregatorStat struct {
+	agg rollingavg.Aggregator
+	n   int
+}
+
+
+func (s *aggregatorStat) add(v string) {
+	if f, err := rollingavg.ParseFloat(v); err == nil {
+		s.agg.Add(f)
+		s.n++
+	}
+}
+
+
+func (s *aggregatorStat) remove(v string) {
+	if f, err := rollingavg.ParseFloat(v); err == nil {
+		s.agg.Remove(f)
+		s.n--
+	}
+}
+
+
+func (s *aggregatorStat) value() string {
+	if s.n == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(s.agg.Value(), 'f', -1, 64)
+}
+
+
+// the statistics of A and B, for -dry-run
+func statsSummary() string {
+	a, b := abStats()
+	if statsSpec == "" {
+		return statName
+	}
+	return "A " + strings.Join(a, ", ") + "; B " + strings.Join(b, ", ")
+}
@@ -0,0 +1,114 @@
+// timerange.go: restricting processing to a time range
+//
+// -from and -to restrict processing to the input rows whose -time column
+// timestamps are in the range, from inclusive and to exclusive, e.g.
+//     -from "2015-11-12 15:00" -to 2015-11-13
+// times may leave out the seconds, or the time of day, and either may be
+// left out for an open range. rows outside the range are dropped before
+// they're averaged, so windows don't span the range's bounds, without
+// counting as skipped rows, see exitcode.go, as they're not wanted. a row
+// whose timestamp can't be parsed is invalid input, and stops the run.
+// with -index, large input files can be seeked to the range rather than
+// scanned, see index.go
+
+
+package main
+
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+var fromTime, toTime string
+
+
+// the layouts of -from and -to times, besides that of the timestamps
+var rangeLayouts = []string{"2006-01-02 15:04", "2006-01-02"}
+
+
+// parse a -from or -to time, which may leave out the seconds, or the time
+// of day
+func parseRangeTime(flagName, s string) time.Time {
+	t, err := rollingavg.ParseTime(s)
+	for _, layout := range rangeLayouts {
+		if err == nil {
+			break
+		}
+		t, err = time.Parse(layout, s)
+	}
+	if err != nil {
+		fatal("invalid time", "flag", flagName, "time", s)
+	}
+	return t
+}
+
+
+// the -from and -to times, zero if not given
+func timeRange() (from, to time.Time) {
+	if fromTime != "" {
+		from = parseRangeTime("from", fromTime)
+	}
+	if toTime != "" {
+		to = parseRangeTime("to", toTime)
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		fatal("-from time isn't before -to time", "from", fromTime, "to", toTime)
+	}
+	return from, to
+}
+
+
+// whether t is in the range from to to, either of which may be zero
+func inTimeRange(t, from, to time.Time) bool {
+	return (from.IsZero() || !t.Before(from)) && (to.IsZero() || t.Before(to))
+}
+
+
+// a record source of the records of in within a time range
+type timeRangeReader struct {
+	in       recordReader
+	tcol     int
+	from, to time.Time
+	outside  int
+	n        int // rows read
+}
+
+
+func newTimeRangeReader(in recordReader, header []string) *timeRangeReader {
+	tcol := findColumn(header, timeCol)
+	if tcol < 0 {
+		fatal("time column not found in header", "column", timeCol)
+	}
+	r := &timeRangeReader{in: in, tcol: tcol}
+	r.from, r.to = timeRange()
+	return r
+}
+
+
+func (r *timeRangeReader) Read() ([]string, error) {
+	for {
+		record, err := r.in.Read()
+		if err != nil {
+			if err == io.EOF {
+				slog.Debug("rows outside time range", "outside", r.outside)
+			}
+			return record, err
+		}
+		r.n++
+		t, err := rollingavg.ParseTime(field(record, r.tcol))
+		switch {
+		case err != nil:
+			return nil, fmt.Errorf("row %d: invalid timestamp for -from and -to: %w", r.n, err)
+		case inTimeRange(t, r.from, r.to):
+			return record, nil
+		default:
+			r.outside++
+		}
+	}
+}
@@ -0,0 +1,225 @@
+// trend.go: linear trend fits of CSV columns
+//
+// for detrending before rolling analysis, invoked as the trend subcommand,
+// see commands.go:
+//     mdp trend [-v] -c col,... [-x time|row] [-time col] [-segment nrows|duration] [-replace]
+//         [-f inputfile]... [-o outputfile] [inputfile...]
+// a least squares line is fitted to each of the -c columns (names, indexes
+// or index ranges, as for csvcut), against the -time column's timestamps,
+// or with -x row, the row numbers, and its fitted value and residual, the
+// value less the fitted value, are output as columns named for it, e.g.
+// "X fit" and "X residual", following the input columns, or with -replace,
+// the residual in its place, e.g.
+//     mdp trend -c X,Y -replace in.csv | rollingavg
+// the trend is global, over all the rows, or with -segment, piecewise, a
+// line per segment of a number of rows, e.g. 1000, or of the time
+// intervals of a duration, e.g. 24h, fitted to the segment's rows alone.
+// the rows of each segment, or all of them for a global trend, are held in
+// memory until it's fitted, so large inputs are best segmented.
+// values that aren't numbers, and those of segments with fewer than two
+// values, or times, to fit, are output empty
+
+
+package main
+
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"flag"
+	"io"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+// the sums of a least squares line fit
+type lineFit struct {
+	n                int
+	sx, sy, sxx, sxy float64
+}
+
+
+func (f *lineFit) add(x, y float64) {
+	f.n++
+	f.sx += x
+	f.sy += y
+	f.sxx += x * x
+	f.sxy += x * y
+}
+
+
+// the line's intercept and slope, or false if it can't be fitted
+func (f *lineFit) line() (float64, float64, bool) {
+	n := float64(f.n)
+	d := n*f.sxx - f.sx*f.sx
+	if f.n < 2 || d == 0 {
+		return 0, 0, false
+	}
+	slope := (n*f.sxy - f.sx*f.sy) / d
+	return (f.sy - slope*f.sx) / n, slope, true
+}
+
+
+// the rows of a segment, with their x values, or false where there's none
+type trendSegment struct {
+	records [][]string
+	xs      []float64
+	hasX    []bool
+}
+
+
+func runTrend(args []string) {
+	fs := flag.NewFlagSet("trend", flag.ExitOnError)
+	columns := fs.String("c", "", "comma separated columns (names, indexes or index ranges) to fit trends to")
+	xaxis := fs.String("x", "time", "what the trends are of: time, the -time column's timestamps, or row, the row numbers")
+	timecol := fs.String("time", "3", "timestamp column (name or index)")
+	segment := fs.String("segment", "", "fit a line per segment of this many rows, or of the time intervals of this duration, e.g. 24h (default one global line)")
+	replace := fs.Bool("replace", false, "replace the columns by their residuals, rather than adding fit and residual columns")
+	commonFlags(fs, "the rows with trend columns")
+	fs.Parse(args)
+	infilenames = append(infilenames, fs.Args()...)
+	setupLogging()
+
+	var segRows int
+	var segTime time.Duration
+	if *segment != "" {
+		var err error
+		if segRows, err = strconv.Atoi(*segment); err != nil {
+			segTime, err = time.ParseDuration(*segment)
+			if err != nil || segTime <= 0 {
+				fatal("invalid segment, expected a number of rows or a duration", "segment", *segment)
+			}
+		} else if segRows < 2 {
+			fatal("segments must be of at least 2 rows", "segment", *segment)
+		}
+	}
+	switch {
+	case *columns == "":
+		fatal("-c columns are required")
+	case *xaxis != "time" && *xaxis != "row":
+		fatal("invalid trend x axis, expected time or row", "x", *xaxis)
+	}
+
+	slog.Debug("fit trends to CSV columns",
+		"inputs", infilenames, "output", outfilename, "columns", *columns, "x", *xaxis, "segment", *segment)
+
+	infile := openInputs(context.Background(), infilenames, false, nil)
+	defer infile.Close()
+
+	oufl := createOutput(outfilename, "")
+	outfile := csv.NewWriter(bufio.NewWriter(oufl))
+
+	header, err := infile.Read()
+	if err != nil {
+		fatal("error reading header from csv", "err", err)
+	}
+	header = append([]string(nil), header...)
+	cols := parseColumnList(header, *columns)
+	tcol := findColumn(header, *timecol)
+	if (*xaxis == "time" || segTime > 0) && tcol < 0 {
+		fatal("time column not found in header", "column", *timecol)
+	}
+
+	outrec := append([]string(nil), header...)
+	if !*replace {
+		for _, c := range cols {
+			outrec = append(outrec, header[c]+" fit", header[c]+" residual")
+		}
+	}
+	if err := outfile.Write(outrec); err != nil {
+		fatal("error writing record to csv", "err", err)
+	}
+
+	n := 0
+	// fit the segment's lines, and write its rows with their trends
+	flush := func(seg *trendSegment) {
+		fits := make([]lineFit, len(cols))
+		for i, record := range seg.records {
+			for j, c := range cols {
+				if v, err := rollingavg.ParseFloat(field(record, c)); err == nil && seg.hasX[i] {
+					fits[j].add(seg.xs[i], v)
+				}
+			}
+		}
+		for i, record := range seg.records {
+			outrec = append(outrec[:0], record...)
+			for j, c := range cols {
+				fit, residual := "", ""
+				v, err := rollingavg.ParseFloat(field(record, c))
+				if a, b, ok := fits[j].line(); ok && err == nil && seg.hasX[i] {
+					f := a + b*seg.xs[i]
+					fit = strconv.FormatFloat(f, 'f', -1, 64)
+					residual = strconv.FormatFloat(v-f, 'f', -1, 64)
+				}
+				if *replace {
+					if c < len(outrec) {
+						outrec[c] = residual
+					}
+				} else {
+					outrec = append(outrec, fit, residual)
+				}
+			}
+			if verboseFlag {
+				slog.Debug("write record", "n", n, "record", outrec)
+			}
+			if err := outfile.Write(outrec); err != nil {
+				fatal("error writing record to csv", "err", err)
+			}
+			n++
+		}
+		*seg = trendSegment{}
+	}
+
+	var seg trendSegment
+	var segStart, t0 time.Time
+	rows := 0
+	for {
+		record, err := infile.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fatal("error reading record from csv", "err", err)
+		}
+		var t time.Time
+		var terr error
+		if tcol >= 0 {
+			t, terr = rollingavg.ParseTime(field(record, tcol))
+		}
+		switch {
+		case segRows > 0 && len(seg.records) == segRows:
+			flush(&seg)
+		case segTime > 0 && terr == nil && !t.Truncate(segTime).Equal(segStart):
+			flush(&seg)
+			segStart = t.Truncate(segTime)
+		}
+
+		// times are in seconds from the first, for the precision of the fit
+		x, hasX := float64(rows), true
+		if *xaxis == "time" {
+			if terr == nil && t0.IsZero() {
+				t0 = t
+			}
+			x, hasX = t.Sub(t0).Seconds(), terr == nil
+		}
+		seg.records = append(seg.records, append([]string(nil), record...))
+		seg.xs = append(seg.xs, x)
+		seg.hasX = append(seg.hasX, hasX)
+		rows++
+	}
+	flush(&seg)
+	slog.Debug("fitted trends", "records", n)
+
+	outfile.Flush()
+	if err := outfile.Error(); err != nil {
+		fatal("error writing csv", "err", err)
+	}
+	if err := oufl.Close(); err != nil {
+		fatal("error closing destination csv", "err", err)
+	}
+}
@@ -0,0 +1,42 @@
+// tumbling.go: tumbling or sliding windows
+//
+// windows slide by default, each row being output with the statistics of
+// the window starting at it. with -window-mode tumbling, the windows don't
+// overlap, so one row is output per window, its first, with the
+// statistics of the window, the next window starting with the row after
+// its end, e.g. for a summary of every 100 rows, or with -window-unit
+// months, of each month from the first row's time, or with -window-align,
+// of each calendar month, see calendar.go, e.g.
+//     rollingavg -n 100 -window-mode tumbling in.csv
+// the other rows of each window aren't output. with -tail partial or
+// empty, a last partial window is output as one row, of its first.
+// windows are tumbled per series with -group-by. tumbling windows are of
+// rows or time, but not -adaptive, and with rows, A and B
+// windows of the same length, so -nb can't be used. they aren't wrapped for
+// extra window columns, so -mode, -distinct, -count-above, -window-bounds,
+// extra -stats, -anchor and -window-sample can't be used, nor can -parallel
+// or -append, which assume the windows slide
+
+
+package main
+
+
+var windowMode string
+
+
+// check that the -window-mode is valid with the current options
+func checkWindowMode() {
+	switch {
+	case windowMode == "sliding":
+	case windowMode != "tumbling":
+		fatal("invalid window mode, expected sliding or tumbling", "window-mode", windowMode)
+	case adaptiveMin > 0:
+		fatal("-adaptive windows can't tumble")
+	case nrowsB != 0 && nrowsB != nrows:
+		fatal("tumbling windows can't be used with -nb")
+	case windowsWrapped():
+		fatal("tumbling windows can't be used with -mode, -distinct, -count-above, -window-bounds, extra -stats, -anchor or -window-sample")
+	case parallelWorkers > 1 || appendFlag:
+		fatal("tumbling windows can't be used with -parallel or -append")
+	}
+}
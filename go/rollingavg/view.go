@@ -0,0 +1,348 @@
+// view.go: interactive terminal viewer of the rolling averages
+//
+// for exploring a new dataset, invoked as the view subcommand, see
+// commands.go:
+//     mdp view [-v] [-n nrows] [-group-by col] [-window-unit rows|bdays|months|minutes|hours|days]
+//         [-window-align] [-window-tz zone] [-stat stat] [-rule rule] [-time col]
+//         [-f inputfile]... [-o outputfile] [inputfile...]
+// the input is read into memory and its output rows, as for rollavg,
+// shown a page at a time in the terminal, with the rows whose Result isn't
+// "0" highlighted. the keys are
+//     j, k, up, down       scroll a row
+//     space, b, pgdn, pgup scroll a page
+//     g, G                 go to the first or last row
+//     n, N                 go to the next or previous highlighted row
+//     +, -                 lengthen or shorten the window, recomputing the rows
+//     1-9                  hide or show the nth column, 0 shows all
+//     w                    write the rows to -o, e.g. once the window suits
+//     q                    quit
+// the keys are read from, and the rows shown on, /dev/tty, so the input
+// can be piped in
+
+
+package main
+
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+// the widest a column is shown
+const viewColumnWidth = 24
+
+
+// the state of the viewer
+type viewer struct {
+	tty     *os.File
+	header  []string   // the input header
+	records [][]string // the input records
+	opts    rollingavg.Options
+	outhdr  []string   // the output header
+	rows    [][]string // the output rows, for the current window
+	flagged []int      // the highlighted rows
+	hidden  []bool     // the hidden output columns
+	top     int        // the first row shown
+	status  string     // a message shown until the next key
+}
+
+
+func runView(args []string) {
+	fs := flag.NewFlagSet("view", flag.ExitOnError)
+	window := fs.Int("n", 23, "initial window length, in -window-unit")
+	group := fs.String("group-by", "", "column (name or index) grouping rows into independent series")
+	unit := fs.String("window-unit", "rows", "unit of the window length: rows, bdays, months, minutes, hours or days")
+	stat := fs.String("stat", "mean", "window statistic")
+	rule := fs.String("rule", "threshold", "rule for the Result column")
+	tcol := fs.String("time", "3", "timestamp column (name or index) for calendar windows")
+	fs.BoolVar(&windowAlign, "window-align", false, "align time windows to the boundaries of their unit, e.g. the top of the hour")
+	fs.StringVar(&windowTZ, "window-tz", "UTC", "timezone of the boundaries of -window-align windows")
+	commonFlags(fs, "the rows, when written with w")
+	fs.Parse(args)
+	infilenames = append(infilenames, fs.Args()...)
+	setupLogging()
+
+	if *window < 1 {
+		fatal("invalid window length", "n", *window)
+	}
+	if windowAlign && *unit == "rows" {
+		fatal("-window-align requires a -window-unit of time")
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		fatal("view requires a terminal", "err", err)
+	}
+	defer tty.Close()
+
+	slog.Debug("view rolling averages",
+		"inputs", infilenames, "window", *window, "unit", *unit, "stat", *stat, "rule", *rule)
+
+	infile := openInputs(context.Background(), infilenames, false, nil)
+	header, err := infile.Read()
+	if err != nil {
+		fatal("error reading header from csv", "err", err)
+	}
+	v := &viewer{
+		tty:    tty,
+		header: append([]string(nil), header...),
+		opts: rollingavg.Options{
+			Window:     *window,
+			WindowUnit: *unit,
+			Stat:       *stat,
+			Rule:       *rule,
+			GroupBy:    *group,
+			TimeColumn: *tcol,
+			Align:      windowAlign,
+			Location:   windowLocation(),
+		},
+	}
+	for {
+		record, err := infile.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fatal("error reading record from csv", "err", err)
+		}
+		v.records = append(v.records, append([]string(nil), record...))
+	}
+	infile.Close()
+	if err := v.compute(); err != nil {
+		fatal("error processing csv", "err", err)
+	}
+	v.hidden = make([]bool, len(v.outhdr))
+	slog.Debug("read records", "records", len(v.records), "rows", len(v.rows))
+
+	state, err := term.MakeRaw(int(tty.Fd()))
+	if err != nil {
+		fatal("error setting up terminal", "err", err)
+	}
+	// the alternate screen, without the cursor, restored on quitting
+	fmt.Fprint(tty, "\x1b[?1049h\x1b[?25l")
+	defer func() {
+		fmt.Fprint(tty, "\x1b[?25h\x1b[?1049l")
+		term.Restore(int(tty.Fd()), state)
+	}()
+	v.run()
+}
+
+
+// compute the output rows of the records for the current window
+func (v *viewer) compute() error {
+	p, err := rollingavg.NewProcessor(v.header, v.opts)
+	if err != nil {
+		return err
+	}
+	if p.Rule, err = ruleByName(v.opts.Rule); err != nil {
+		return err
+	}
+	rows := make([][]string, 0, len(v.records))
+	var flagged []int
+	for _, record := range v.records {
+		results, err := p.Add(record)
+		if err != nil {
+			return err
+		}
+		for _, r := range results {
+			row := rollingavg.OutputRow(r, p.Rule)
+			if result := row[len(row)-1]; result != "0" && result != "" {
+				flagged = append(flagged, len(rows))
+			}
+			rows = append(rows, row)
+		}
+	}
+	v.outhdr = rollingavg.OutputHeader(v.header, v.opts.Stat)
+	v.rows, v.flagged = rows, flagged
+	return nil
+}
+
+
+// the terminal size, and the number of rows shown on a page
+func (v *viewer) size() (width, height, page int) {
+	width, height, err := term.GetSize(int(v.tty.Fd()))
+	if err != nil {
+		width, height = 80, 24
+	}
+	// less the header and status lines
+	return width, height, max(height-2, 1)
+}
+
+
+// handle keys until quitting
+func (v *viewer) run() {
+	buf := make([]byte, 16)
+	for {
+		v.draw()
+		n, err := v.tty.Read(buf)
+		if err != nil {
+			return
+		}
+		_, _, page := v.size()
+		v.status = ""
+		switch key := string(buf[:n]); key {
+		case "q", "\x03":
+			return
+		case "j", "\x1b[B", "\r":
+			v.top++
+		case "k", "\x1b[A":
+			v.top--
+		case " ", "\x1b[6~":
+			v.top += page
+		case "b", "\x1b[5~":
+			v.top -= page
+		case "g", "\x1b[H":
+			v.top = 0
+		case "G", "\x1b[F":
+			v.top = len(v.rows) - page
+		case "n":
+			v.nextFlagged(1)
+		case "N":
+			v.nextFlagged(-1)
+		case "+", "=":
+			v.resize(v.opts.Window + 1)
+		case "-":
+			v.resize(v.opts.Window - 1)
+		case "0":
+			clear(v.hidden)
+		case "w":
+			v.write()
+		default:
+			if c := key[0]; n == 1 && c >= '1' && c <= '9' && int(c-'1') < len(v.hidden) {
+				v.hidden[c-'1'] = !v.hidden[c-'1']
+			}
+		}
+		v.top = max(min(v.top, len(v.rows)-page), 0)
+	}
+}
+
+
+// go to the next highlighted row after, or with dir -1 before, the top row
+func (v *viewer) nextFlagged(dir int) {
+	if dir > 0 {
+		for _, i := range v.flagged {
+			if i > v.top {
+				v.top = i
+				return
+			}
+		}
+	} else {
+		for j := len(v.flagged) - 1; j >= 0; j-- {
+			if v.flagged[j] < v.top {
+				v.top = v.flagged[j]
+				return
+			}
+		}
+	}
+	v.status = "no more highlighted rows"
+}
+
+
+// recompute the rows for another window length, keeping the previous rows
+// if it's invalid
+func (v *viewer) resize(window int) {
+	if window < 1 {
+		return
+	}
+	prev := v.opts.Window
+	v.opts.Window = window
+	if err := v.compute(); err != nil {
+		v.opts.Window = prev
+		v.status = err.Error()
+		return
+	}
+	slog.Debug("recomputed rows", "window", window, "rows", len(v.rows))
+}
+
+
+// write the rows to -o
+func (v *viewer) write() {
+	if outfilename == "" {
+		v.status = "no output file, -o"
+		return
+	}
+	oufl := createOutput(outfilename, "")
+	outfile := csv.NewWriter(bufio.NewWriter(oufl))
+	outfile.Write(v.outhdr)
+	outfile.WriteAll(v.rows)
+	if err := outfile.Error(); err != nil {
+		v.status = "error writing csv: " + err.Error()
+	} else if err := oufl.Close(); err != nil {
+		v.status = "error closing destination csv: " + err.Error()
+	} else {
+		v.status = fmt.Sprintf("wrote %d rows to %s", len(v.rows), outfilename)
+	}
+}
+
+
+// draw the page of rows from the top row, with the header and status lines
+func (v *viewer) draw() {
+	width, _, page := v.size()
+	end := min(v.top+page, len(v.rows))
+
+	// the columns shown, as wide as their widest value on the page
+	var cols, widths []int
+	for c, name := range v.outhdr {
+		if v.hidden[c] {
+			continue
+		}
+		w := utf8.RuneCountInString(name)
+		for _, row := range v.rows[v.top:end] {
+			w = max(w, utf8.RuneCountInString(field(row, c)))
+		}
+		cols, widths = append(cols, c), append(widths, min(w, viewColumnWidth))
+	}
+	line := func(record []string) string {
+		var b strings.Builder
+		for i, c := range cols {
+			s := []rune(field(record, c))
+			if len(s) > widths[i] {
+				s = append(s[:widths[i]-1], '~')
+			}
+			fmt.Fprintf(&b, "%-*s ", widths[i], string(s))
+		}
+		// clipped to the terminal
+		s := []rune(b.String())
+		return string(s[:min(len(s), width)])
+	}
+
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	b.WriteString("\x1b[1;4m" + line(v.outhdr) + "\x1b[0m\r\n")
+	flagged := v.flagged
+	for i := v.top; i < end; i++ {
+		for len(flagged) > 0 && flagged[0] < i {
+			flagged = flagged[1:]
+		}
+		if len(flagged) > 0 && flagged[0] == i {
+			b.WriteString("\x1b[7m" + line(v.rows[i]) + "\x1b[0m\r\n")
+		} else {
+			b.WriteString(line(v.rows[i]) + "\r\n")
+		}
+	}
+	for i := end - v.top; i < page; i++ {
+		b.WriteString("~\r\n")
+	}
+
+	status := v.status
+	if status == "" {
+		status = fmt.Sprintf("rows %d-%d of %d, %d highlighted, window %d %s, %d without a window | q quit, +/- window, 1-9 columns, n/N highlighted",
+			min(v.top+1, end), end, len(v.rows), len(v.flagged),
+			v.opts.Window, v.opts.WindowUnit, len(v.records)-len(v.rows))
+	}
+	s := []rune(status)
+	b.WriteString("\x1b[7m" + string(s[:min(len(s), width)]) + "\x1b[0m")
+	io.WriteString(v.tty, b.String())
+}
@@ -0,0 +1,175 @@
+// watch.go: watch a directory and process new CSV files as they appear
+//
+// each new CSV file in the watched directory is processed, once it has
+// stopped changing for the settle time, into an output file named by the
+// batch output pattern (see batch.go), and the input is then moved into
+// the done directory. CSV files already in the directory when watching
+// starts are processed first.
+// output files written by the watcher are not themselves processed, nor,
+// on later runs, those named by the pattern from inputs since moved to the
+// done directory. a file that fails to process doesn't stop the watcher,
+// it's logged, its partial output removed, and it's moved to the failed
+// subdirectory of the watched directory, so it isn't processed again.
+// with -state, files already processed, with the same content and
+// parameters, are moved to the done directory without processing them
+// again, see state.go
+
+
+package main
+
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// how long a new file must be unchanged before it is processed
+const watchSettle = 2 * time.Second
+
+
+// watch dir for new CSV files, processing each and moving it to donedir
+func runWatch(ctx context.Context, dir string, pattern string, donedir string) {
+	if donedir == "" {
+		donedir = filepath.Join(dir, "done")
+	}
+	if err := os.MkdirAll(donedir, 0755); err != nil {
+		fatal("error creating done directory", "err", err)
+	}
+	faileddir := filepath.Join(dir, "failed")
+	if err := os.MkdirAll(faileddir, 0755); err != nil {
+		fatal("error creating failed directory", "err", err)
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		fatal("error creating directory watcher", "err", err)
+	}
+	defer watcher.Close()
+	if err := watcher.Add(dir); err != nil {
+		fatal("error watching directory", "err", err)
+	}
+
+	serviceReady()
+	slog.Debug("watching directory", "dir", dir, "done_dir", donedir)
+
+	// files written by the watcher, which aren't to be processed
+	outputs := make(map[string]bool)
+	// files seen changing, and when they last changed
+	pending := make(map[string]time.Time)
+
+	// whether a file is an output, of this run, or of an input in the
+	// directory or already moved to the done directory
+	isOutput := func(in string) bool {
+		if outputs[in] {
+			return true
+		}
+		inputs := batchInputs(dir)
+		for _, done := range batchInputs(donedir) {
+			inputs = append(inputs, filepath.Join(dir, filepath.Base(done)))
+		}
+		return isBatchOutput(in, pattern, inputs)
+	}
+
+	process := func(in string) {
+		if isOutput(in) {
+			slog.Debug("skip watch output file", "file", in)
+			return
+		}
+		out := batchOutputName(in, pattern)
+		if out == filepath.Clean(in) {
+			fatal("watch output would overwrite input", "file", in)
+		}
+		outputs[out] = true
+		key := processed.key(in, out)
+		movedir := donedir
+		if !processed.done(key) {
+			slog.Debug("watch process", "input", in, "output", out)
+			err := runWatchFile(ctx, in, out)
+			if ctx.Err() != nil {
+				// leave the partly processed file to be processed again
+				return
+			}
+			if err != nil {
+				slog.Error("error processing watched file", "file", in, "err", err)
+				os.Remove(out)
+				movedir = faileddir
+			} else {
+				processed.add(key, in, out)
+			}
+		}
+
+		moved := filepath.Join(movedir, filepath.Base(in))
+		if err := os.Rename(in, moved); err != nil {
+			fatal("error moving watched file", "dir", movedir, "err", err)
+		}
+	}
+
+	for _, in := range batchInputs(dir) {
+		if ctx.Err() != nil {
+			return
+		}
+		process(in)
+	}
+
+	ticker := time.NewTicker(watchSettle / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if !strings.EqualFold(filepath.Ext(event.Name), ".csv") {
+				continue
+			}
+			if event.Op&(fsnotify.Create|fsnotify.Write) != 0 {
+				pending[filepath.Clean(event.Name)] = time.Now()
+			}
+			if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+				delete(pending, filepath.Clean(event.Name))
+			}
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Warn("directory watch error", "err", err)
+
+		case now := <-ticker.C:
+			for in, changed := range pending {
+				if now.Sub(changed) >= watchSettle {
+					delete(pending, in)
+					process(in)
+				}
+			}
+		}
+	}
+}
+
+
+// process one watched input file, returning any error that would
+// otherwise have been fatal
+func runWatchFile(ctx context.Context, in string, out string) (err error) {
+	fatalPanics = true
+	defer func() {
+		fatalPanics = false
+		if r := recover(); r != nil {
+			ferr, ok := r.(fatalError)
+			if !ok {
+				panic(r)
+			}
+			err = ferr
+		}
+	}()
+	runRollingAvg(ctx, []string{in}, out)
+	return nil
+}
@@ -0,0 +1,180 @@
+// webhook.go: POST alerts on Result triggers to a webhook
+//
+// with -webhook-url, each output row whose Result is a trigger, not "0" or
+// the quiet label of -labels, see labels.go, is POSTed to
+// the URL as a JSON alert, so detections can feed incident tooling, e.g.
+//     {"rule":"threshold","group":"s1","result":"1","stat_a":-1.5,
+//      "stat_b":-1600,"row":{"X":-2,"Y":-1650,...,"Result":1}}
+// where group is the -group-by value, stat_a and stat_b the window
+// statistics, and row the output row, as a JSON object keyed by the output
+// header.
+// with -webhook-debounce, after an alert for a series, its triggers for
+// that long are not alerted, but counted, as suppressed in its next alert.
+// alerts are sent in the background, in order, and one that fails is
+// logged, after any -retries, see retry.go. if they can't be sent as fast as they're
+// triggered, up to 1000 are queued, and any more dropped with a warning.
+// at the end of the run, the queued alerts are sent before exiting
+
+
+package main
+
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+var webhookURL string
+var webhookDebounce time.Duration
+
+// the alerter of Result triggers, nil when no webhook is configured
+var alerts *webhookAlerter
+
+// the most alerts queued to send
+const webhookQueue = 1000
+
+
+// the JSON payload of an alert
+type webhookAlert struct {
+	Rule       string          `json:"rule"`
+	Group      string          `json:"group,omitempty"`
+	Result     string          `json:"result"`
+	StatA      float64         `json:"stat_a"`
+	StatB      float64         `json:"stat_b"`
+	Row        json.RawMessage `json:"row"`
+	Suppressed int             `json:"suppressed,omitempty"`
+}
+
+
+// debounces triggers, and sends their alerts in the background
+type webhookAlerter struct {
+	url      string
+	debounce time.Duration
+	client   *http.Client
+
+	mu         sync.Mutex
+	last       map[string]time.Time // when each series was last alerted
+	suppressed map[string]int       // the series' triggers since then
+	queue      chan []byte
+	done       chan struct{}
+}
+
+
+// start sending alerts to url
+func startWebhook(url string, debounce time.Duration) {
+	alerts = &webhookAlerter{
+		url:        url,
+		debounce:   debounce,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		last:       make(map[string]time.Time),
+		suppressed: make(map[string]int),
+		queue:      make(chan []byte, webhookQueue),
+		done:       make(chan struct{}),
+	}
+	go alerts.send()
+}
+
+
+// send queued alerts until the queue is closed
+func (a *webhookAlerter) send() {
+	defer close(a.done)
+	for body := range a.queue {
+		err := withRetries(context.Background(), "sending webhook alert", func() error {
+			resp, err := a.client.Post(a.url, "application/json", bytes.NewReader(body))
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				return fmt.Errorf("HTTP status %s", resp.Status)
+			}
+			return nil
+		})
+		if err != nil {
+			slog.Warn("error sending webhook alert", "url", a.url, "err", err)
+		}
+	}
+}
+
+
+// alert a trigger of a series, unless debounced
+func (a *webhookAlerter) trigger(alert webhookAlert) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	if last, ok := a.last[alert.Group]; ok && a.debounce > 0 && now.Sub(last) < a.debounce {
+		a.suppressed[alert.Group]++
+		return
+	}
+	a.last[alert.Group] = now
+	alert.Suppressed = a.suppressed[alert.Group]
+	delete(a.suppressed, alert.Group)
+
+	body, err := json.Marshal(alert)
+	if err != nil {
+		slog.Warn("error encoding webhook alert", "err", err)
+		return
+	}
+	select {
+	case a.queue <- body:
+	default:
+		slog.Warn("webhook alert queue full, dropping alert", "group", alert.Group)
+	}
+}
+
+
+// send the queued alerts, and stop
+func (a *webhookAlerter) close() {
+	if a == nil {
+		return
+	}
+	close(a.queue)
+	<-a.done
+}
+
+
+// an output that also alerts each record written whose Result is
+// triggered, to the webhook and the notification sinks, see notify.go
+type alertOutput struct {
+	recordWriteCloser
+	header   []string
+	groupCol int
+}
+
+
+func newAlertOutput(out recordWriteCloser) *alertOutput {
+	return &alertOutput{recordWriteCloser: out, groupCol: -1}
+}
+
+
+func (o *alertOutput) Write(record []string) error {
+	if o.header == nil {
+		o.header = append([]string{}, record...)
+		if groupBy != "" {
+			o.groupCol = findColumn(o.header, groupBy)
+		}
+	} else if n := len(record); n >= 3 && triggered(record[n-1]) {
+		row, _ := encodeMessage("json", o.header, record)
+		alert := webhookAlert{Rule: ruleName, Result: record[n-1], Row: row}
+		alert.StatA, _ = rollingavg.ParseFloat(record[n-3])
+		alert.StatB, _ = rollingavg.ParseFloat(record[n-2])
+		if o.groupCol >= 0 {
+			alert.Group = field(record, o.groupCol)
+		}
+		alerts.trigger(alert)
+		notifier.trigger(alert)
+	}
+	return o.recordWriteCloser.Write(record)
+}
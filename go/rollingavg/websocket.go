@@ -0,0 +1,74 @@
+// websocket.go: stream output rows to WebSocket clients
+//
+// with -ws-addr, an HTTP listener accepts WebSocket connections on /rows,
+// and pushes each output row, as it is computed, to every connected client
+// as a JSON object text message, e.g. for live dashboards. this is most
+// useful when streaming, e.g. with -follow, -kafka-topic or -listen.
+// connections are accepted from any origin.
+// clients only receive rows computed after they connect
+
+
+package main
+
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+)
+
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+
+// start serving WebSocket connections on addr, publishing the rows of b
+func startWebSocket(addr string, b *rowBroadcaster) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rows", func(w http.ResponseWriter, r *http.Request) {
+		serveWebSocket(w, r, b)
+	})
+	go func() {
+		fatal("WebSocket listener failed", "err", serveHTTP(addr, mux))
+	}()
+}
+
+
+// send published rows to a client until it disconnects
+func serveWebSocket(w http.ResponseWriter, r *http.Request, b *rowBroadcaster) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has already replied with the error
+		return
+	}
+	defer conn.Close()
+	slog.Debug("WebSocket client connected", "client", r.RemoteAddr)
+
+	rows := b.subscribe()
+	defer b.unsubscribe(rows)
+
+	// clients don't send anything, but reading notices them closing
+	closed := make(chan struct{})
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				close(closed)
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case row := <-rows:
+			if err := conn.WriteMessage(websocket.TextMessage, row); err != nil {
+				return
+			}
+		case <-closed:
+			slog.Debug("WebSocket client disconnected", "client", r.RemoteAddr)
+			return
+		}
+	}
+}
@@ -0,0 +1,264 @@
+// windowcols.go: extra output columns of statistics over the windows
+//
+// besides the window statistic of A and B, statistics of other columns
+// over each row's window can be output, as columns following the input
+// columns: the most frequent value of categorical columns with -mode, see
+// mode.go, the number of distinct values with -distinct, see distinct.go,
+// the number of values above thresholds with -count-above, see
+// above.go, then the window's first and last timestamps with
+// -window-bounds, see bounds.go, and extra statistics of A and B with
+// -stats, see stats.go, e.g.
+//     X,Y,Status,Time,Mode Status,Count X>30,Window Start,Window End,Average A,...
+// the window is the same as that of the averages, rows or calendar
+// periods, per series with -group-by, and for -tail rows their partial
+// windows.
+// windows are wrapped to keep the statistics, and with -anchor, see
+// anchor.go, or -window-sample, see windowsample.go, the rows, so these
+// can't be used with -parallel or -max-mem, which manage the windows
+// themselves
+
+
+package main
+
+
+import (
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+// a statistic of a column's values in a window
+type windowStat interface {
+	add(v string)
+	remove(v string)
+	value() string
+}
+
+
+// an extra output column, of a statistic of an input column
+type windowColumn struct {
+	col     int
+	name    string
+	newStat func() windowStat
+}
+
+
+// whether any extra window columns are output
+func windowColumnsSet() bool {
+	return modeCols != "" || distinctCols != "" || countAbove != "" || windowBounds || extraStatsSet()
+}
+
+
+// whether windows are wrapped, for extra window columns, -anchor or
+// -window-sample
+func windowsWrapped() bool {
+	return windowColumnsSet() || anchor != "start" || windowSample > 0
+}
+
+
+// the extra window columns for an input header
+func windowColumns(header []string) []windowColumn {
+	var cols []windowColumn
+	if modeCols != "" {
+		cols = append(cols, modeColumns(header)...)
+	}
+	if distinctCols != "" {
+		cols = append(cols, distinctColumns(header)...)
+	}
+	if countAbove != "" {
+		cols = append(cols, countAboveColumns(header)...)
+	}
+	if windowBounds {
+		cols = append(cols, windowBoundsColumns(header)...)
+	}
+	if extraStatsSet() {
+		cols = append(cols, extraStatsColumns()...)
+	}
+	return cols
+}
+
+
+// the output header for an input header, with any extra window columns
+func outputHeader(header []string) []string {
+	if adaptiveMin > 0 {
+		header = append(header[:len(header):len(header)], rollingavg.WindowLengthColumn)
+	}
+	if windowColumnsSet() {
+		header = header[:len(header):len(header)]
+		for _, c := range windowColumns(header) {
+			header = append(header, c.name)
+		}
+	}
+	statA, statB := mainStats()
+	return rollingavg.OutputHeaderAB(header, statA, statB)
+}
+
+
+// a window that also keeps statistics of other columns of the records it
+// buffers, adding them to the records of its results, and with -anchor, the
+// records, outputting the statistics with the anchor row instead
+type columnsWindow struct {
+	rollingavg.Window
+	cols     []windowColumn
+	anchored bool
+
+	// the sampler of the rows of each output row's window, or nil
+	sample *inputSampler
+
+	// whether a record's window includes the record that completes it, as
+	// for row windows, but not calendar windows
+	includeNew bool
+
+	// whether a record isn't buffered, e.g. a calendar window skipping
+	// non-business days
+	skip func(record []string) bool
+
+	// the values of the buffered records, oldest first, their statistics,
+	// and when anchored or sampled, the records
+	values  [][]string
+	stats   []windowStat
+	records [][]string
+	results []rollingavg.Result
+}
+
+
+// wrap the processor's windows to keep the statistics of extra columns,
+// and the rows for -anchor, and -window-sample of the inputs
+func wrapWindows(p *rollingavg.Processor, inputs []string, header []string, holidays rollingavg.Holidays) {
+	cols := windowColumns(header)
+	tcol := findColumn(header, timeCol)
+	var sample *inputSampler
+	if sampler != nil {
+		sample = sampler.begin(inputs, header)
+	}
+	newWindow := p.NewWindow
+	p.NewWindow = func() rollingavg.Window {
+		w := &columnsWindow{Window: newWindow(), cols: cols, anchored: anchor != "start", sample: sample}
+		w.reset()
+		switch {
+		case windowUnit == "rows":
+			w.includeNew = true
+		case windowUnit == "bdays":
+			w.skip = func(record []string) bool {
+				t, err := rollingavg.ParseTime(field(record, tcol))
+				return err == nil && !holidays.IsBusinessDay(t)
+			}
+		}
+		return w
+	}
+}
+
+
+// clear the statistics
+func (w *columnsWindow) reset() {
+	w.values, w.records = nil, nil
+	w.stats = newStats(w.cols)
+}
+
+
+func newStats(cols []windowColumn) []windowStat {
+	stats := make([]windowStat, len(cols))
+	for i, c := range cols {
+		stats[i] = c.newStat()
+	}
+	return stats
+}
+
+
+func (w *columnsWindow) push(record []string) {
+	values := make([]string, len(w.cols))
+	for i, c := range w.cols {
+		values[i] = field(record, c.col)
+		w.stats[i].add(values[i])
+	}
+	w.values = append(w.values, values)
+	if w.anchored || w.sample != nil {
+		w.records = append(w.records, record)
+	}
+}
+
+
+func (w *columnsWindow) pop() {
+	for i, v := range w.values[0] {
+		w.stats[i].remove(v)
+	}
+	w.values = w.values[1:]
+	if w.anchored || w.sample != nil {
+		w.records = w.records[1:]
+	}
+}
+
+
+func (w *columnsWindow) Add(record []string, a, b float64) ([]rollingavg.Result, error) {
+	results, err := w.Window.Add(record, a, b)
+	if err != nil || w.skip != nil && w.skip(record) {
+		return results, err
+	}
+	if w.includeNew {
+		w.push(record)
+	}
+	w.results = w.results[:0]
+	for _, r := range results {
+		record := r.Record
+		if w.anchored {
+			record = w.records[anchorRow(len(w.records))]
+		}
+		outrec := make([]string, len(record), len(record)+len(w.cols))
+		copy(outrec, record)
+		for _, s := range w.stats {
+			outrec = append(outrec, s.value())
+		}
+		w.results = append(w.results, rollingavg.Result{Record: outrec, AvgA: r.AvgA, AvgB: r.AvgB})
+		if w.sample != nil {
+			w.sample.write(w.records)
+		}
+		w.pop()
+	}
+	if !w.includeNew {
+		w.push(record)
+	}
+	return w.results, nil
+}
+
+
+// restore the window's state, and the values of its buffered records
+func (w *columnsWindow) UnmarshalJSON(data []byte) error {
+	if err := w.Window.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	w.reset()
+	for _, record := range w.Window.Pending() {
+		w.push(record)
+	}
+	return nil
+}
+
+
+// the statistics of the partial windows of the buffered records, oldest
+// first
+func (w *columnsWindow) tailValues() [][]string {
+	values := make([][]string, len(w.values))
+	stats := newStats(w.cols)
+	// each record's partial window is itself and the records after it
+	for i := len(w.values) - 1; i >= 0; i-- {
+		values[i] = make([]string, len(w.cols))
+		for j, v := range w.values[i] {
+			stats[j].add(v)
+			values[i][j] = stats[j].value()
+		}
+	}
+	return values
+}
+
+
+// add the statistics of their partial windows to the records of tail
+// results, which are in series key order, as from Tail
+func addTailColumns(p *rollingavg.Processor, tail []rollingavg.Result) {
+	i := 0
+	p.EachWindow(func(key string, win rollingavg.Window) error {
+		for _, values := range win.(*columnsWindow).tailValues() {
+			tail[i].Record = append(tail[i].Record[:len(tail[i].Record):len(tail[i].Record)], values...)
+			i++
+		}
+		return nil
+	})
+}
@@ -0,0 +1,125 @@
+// windowsample.go: random samples of the rows of each window
+//
+// for spot-checking what the statistics are built from, -window-sample k
+// writes a uniform random sample of k of the input rows of each output
+// row's window, or all of them if there are fewer, to the
+// -window-sample-file, a CSV of
+//     Input,Row,X,Y,Z,Time
+// where Input is the input file, or files, of the row, - for stdin, Row is
+// the output row number, from 1, as computed, as for -audit, see audit.go,
+// and the rest the sampled row, in input order, e.g.
+//     rollingavg -n 100 -window-sample 5 -window-sample-file samples.csv in.csv
+// the rows are chosen by selection sampling of the window's rows, so each
+// is equally likely to be sampled, the samples being the same for the same
+// input. windows are wrapped to keep their rows, see windowcols.go, so the
+// rows of -tail partial windows aren't sampled, and -window-sample can't
+// be used with -parallel or -max-mem.
+// with -batch or -watch, the rows of all the files are written, numbered
+// within their file, and with -jobs, the rows of the files processed at
+// once are interleaved, each file's sample being the same as if it was
+// processed alone
+
+
+package main
+
+
+import (
+	"encoding/csv"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+
+var windowSample int
+var windowSampleFile string
+
+// the sampler of the run, nil without -window-sample
+var sampler *windowSampler
+
+
+// the sample file, shared by the inputs processed at once
+type windowSampler struct {
+	mu     sync.Mutex
+	k      int
+	fl     io.WriteCloser
+	out    *csv.Writer
+	header bool
+}
+
+
+// the sampling of the windows of an input
+type inputSampler struct {
+	s     *windowSampler
+	input string
+	rnd   *rand.Rand
+	n     int // output rows sampled
+}
+
+
+// create the sample file
+func startWindowSample(filename string, k int) {
+	if k < 0 {
+		fatal("invalid window sample size", "window-sample", k)
+	}
+	if filename == "" {
+		fatal("-window-sample requires a -window-sample-file")
+	}
+	fl := createOutput(filename, "")
+	sampler = &windowSampler{k: k, fl: fl, out: csv.NewWriter(fl)}
+}
+
+
+// start sampling the windows of the inputs with the given header, writing
+// the header of the sample file if it's the first
+func (s *windowSampler) begin(inputs []string, header []string) *inputSampler {
+	input := strings.Join(inputs, " ")
+	if input == "" {
+		input = "-"
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.header {
+		s.header = true
+		if err := s.out.Write(append([]string{"Input", "Row"}, header...)); err != nil {
+			fatal("error writing window sample", "err", err)
+		}
+	}
+	return &inputSampler{s: s, input: input, rnd: rand.New(rand.NewSource(1))}
+}
+
+
+// write a sample of the records of the next output row's window
+func (in *inputSampler) write(records [][]string) {
+	in.n++
+	row := strconv.Itoa(in.n)
+	need := in.s.k
+	in.s.mu.Lock()
+	defer in.s.mu.Unlock()
+	for i, record := range records {
+		// each record is kept with the chance of those left filling the sample
+		if in.rnd.Intn(len(records)-i) >= need {
+			continue
+		}
+		need--
+		if err := in.s.out.Write(append([]string{in.input, row}, record...)); err != nil {
+			fatal("error writing window sample", "err", err)
+		}
+	}
+}
+
+
+func (s *windowSampler) close() {
+	if s == nil {
+		return
+	}
+	s.out.Flush()
+	if err := s.out.Error(); err != nil {
+		fatal("error writing window sample", "err", err)
+	}
+	if err := s.fl.Close(); err != nil {
+		fatal("error closing window sample", "err", err)
+	}
+}
@@ -0,0 +1,207 @@
+// xcorr.go: cross-correlation of two columns at lags
+//
+// to find lead/lag relationships, e.g. between a sensor and its upstream
+// one, invoked as the xcorr subcommand, see commands.go:
+//     mdp xcorr [-v] -a col -b col [-max-lag nrows] [-time col] [-b-file file [-b-time col]]
+//         [-f inputfile]... [-o outputfile] [inputfile...]
+// the Pearson correlation of the -a column's value of each row with the
+// -b column's value lag rows later is output for each lag from -max-lag
+// to max-lag (default 10), e.g.
+//     Lag,Correlation,Pairs
+//     -1,0.12,999
+//     0,0.35,1000
+//     1,0.91,999
+// so a peak at a positive lag is of B lagging A, and at a negative lag of
+// B leading A. pairs is the number of pairs of numeric values correlated,
+// and the correlation of lags without two pairs, or of values without any
+// spread, is empty.
+// with -b-file, the -b column is of another CSV, whose rows are aligned to
+// the input's by the exactly matching timestamps of its -b-time column and
+// the input's -time column. for other matching, join the files first, see
+// csvjoin.go.
+// the values are held in memory
+
+
+package main
+
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"flag"
+	"io"
+	"log/slog"
+	"math"
+	"strconv"
+
+	"github.com/jaleephd/misc-data-processing/go/rollingavg/rollingavg"
+)
+
+
+// the Pearson correlation of a[t] with b[t+lag], over the t of both
+// numeric, NaN being missing, and the number of pairs, or NaN if there
+// are too few pairs, or no spread
+func laggedCorrelation(a, b []float64, lag int) (float64, int) {
+	var n int
+	var sa, sb, saa, sbb, sab float64
+	for t := max(0, -lag); t < len(a) && t+lag < len(b); t++ {
+		x, y := a[t], b[t+lag]
+		if math.IsNaN(x) || math.IsNaN(y) {
+			continue
+		}
+		n++
+		sa += x
+		sb += y
+		saa += x * x
+		sbb += y * y
+		sab += x * y
+	}
+	if n < 2 {
+		return math.NaN(), n
+	}
+	fn := float64(n)
+	cov := sab - sa*sb/fn
+	va, vb := saa-sa*sa/fn, sbb-sb*sb/fn
+	if va <= 0 || vb <= 0 {
+		return math.NaN(), n
+	}
+	return cov / math.Sqrt(va*vb), n
+}
+
+
+// the numeric value of a field, or NaN
+func numericField(record []string, col int) float64 {
+	v, err := rollingavg.ParseFloat(field(record, col))
+	if err != nil {
+		return math.NaN()
+	}
+	return v
+}
+
+
+// read the values of a column of a CSV file by timestamp
+func readValuesByTime(filename, col, timecol string) map[string]float64 {
+	in := openInputs(context.Background(), []string{filename}, false, nil)
+	defer in.Close()
+	header, err := in.Read()
+	if err != nil {
+		fatal("error reading header from csv", "file", filename, "err", err)
+	}
+	c, tcol := findColumn(header, col), findColumn(header, timecol)
+	if c < 0 || tcol < 0 {
+		fatal("column or time column not found in header", "file", filename, "column", col, "time", timecol)
+	}
+	values := make(map[string]float64)
+	for {
+		record, err := in.Read()
+		if err == io.EOF {
+			return values
+		}
+		if err != nil {
+			fatal("error reading record from csv", "file", filename, "err", err)
+		}
+		values[field(record, tcol)] = numericField(record, c)
+	}
+}
+
+
+// write lagged correlations, from -maxLag to maxLag, of a with b
+func writeCorrelations(outfile *csv.Writer, a, b []float64, maxLag int) {
+	if err := outfile.Write([]string{"Lag", "Correlation", "Pairs"}); err != nil {
+		fatal("error writing record to csv", "err", err)
+	}
+	for lag := -maxLag; lag <= maxLag; lag++ {
+		r, n := laggedCorrelation(a, b, lag)
+		corr := ""
+		if !math.IsNaN(r) {
+			corr = strconv.FormatFloat(r, 'f', -1, 64)
+		}
+		if err := outfile.Write([]string{strconv.Itoa(lag), corr, strconv.Itoa(n)}); err != nil {
+			fatal("error writing record to csv", "err", err)
+		}
+	}
+}
+
+
+func runXCorr(args []string) {
+	fs := flag.NewFlagSet("xcorr", flag.ExitOnError)
+	acol := fs.String("a", "", "column (name or index) of the A values")
+	bcol := fs.String("b", "", "column (name or index) of the B values, of the input or -b-file")
+	maxLag := fs.Int("max-lag", 10, "largest lag, in rows, to correlate at, before and after")
+	timecol := fs.String("time", "3", "timestamp column (name or index), aligning -b-file")
+	bfile := fs.String("b-file", "", "CSV file of the -b column, its rows aligned to the input's by timestamp")
+	btime := fs.String("b-time", "3", "timestamp column (name or index) of the -b-file")
+	commonFlags(fs, "the correlations at each lag")
+	fs.Parse(args)
+	infilenames = append(infilenames, fs.Args()...)
+	setupLogging()
+
+	switch {
+	case *acol == "" || *bcol == "":
+		fatal("-a and -b columns are required")
+	case *maxLag < 0:
+		fatal("invalid maximum lag", "max-lag", *maxLag)
+	}
+
+	slog.Debug("cross-correlate CSV columns",
+		"inputs", infilenames, "output", outfilename, "a", *acol, "b", *bcol, "b_file", *bfile, "max_lag", *maxLag)
+
+	var bByTime map[string]float64
+	if *bfile != "" {
+		bByTime = readValuesByTime(*bfile, *bcol, *btime)
+	}
+
+	infile := openInputs(context.Background(), infilenames, false, nil)
+	defer infile.Close()
+
+	oufl := createOutput(outfilename, "")
+	outfile := csv.NewWriter(bufio.NewWriter(oufl))
+
+	header, err := infile.Read()
+	if err != nil {
+		fatal("error reading header from csv", "err", err)
+	}
+	ac := findColumn(header, *acol)
+	if ac < 0 {
+		fatal("column not found in header", "column", *acol)
+	}
+	bc, tcol := -1, -1
+	if bByTime == nil {
+		if bc = findColumn(header, *bcol); bc < 0 {
+			fatal("column not found in header", "column", *bcol)
+		}
+	} else if tcol = findColumn(header, *timecol); tcol < 0 {
+		fatal("time column not found in header", "column", *timecol)
+	}
+
+	var a, b []float64
+	for {
+		record, err := infile.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fatal("error reading record from csv", "err", err)
+		}
+		a = append(a, numericField(record, ac))
+		if bByTime == nil {
+			b = append(b, numericField(record, bc))
+		} else if v, ok := bByTime[field(record, tcol)]; ok {
+			b = append(b, v)
+		} else {
+			b = append(b, math.NaN())
+		}
+	}
+	slog.Debug("read values", "rows", len(a))
+
+	writeCorrelations(outfile, a, b, *maxLag)
+
+	outfile.Flush()
+	if err := outfile.Error(); err != nil {
+		fatal("error writing csv", "err", err)
+	}
+	if err := oufl.Close(); err != nil {
+		fatal("error closing destination csv", "err", err)
+	}
+}
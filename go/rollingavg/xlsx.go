@@ -0,0 +1,112 @@
+// xlsx.go: Excel workbook input
+//
+// input files with a .xlsx extension are read from the sheet selected by
+// -sheet, either by name or 0-based index (default the first sheet).
+// the first row of the sheet is the header. cell values are read raw,
+// without Excel's number formatting, so numbers aren't mangled by locale
+// specific formats. date cells in the time column (see -time) are
+// converted from Excel's serial date numbers to the Date Time layout.
+// short rows are padded with empty values to the width of the header
+
+
+package main
+
+
+import (
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+
+func isXLSX(filename string) bool {
+	return strings.EqualFold(filepath.Ext(filename), ".xlsx")
+}
+
+
+// reads the rows of a worksheet as CSV records, header first
+type xlsxReader struct {
+	f       *excelize.File
+	rows    *excelize.Rows
+	width   int
+	timecol string
+	tcol    int
+}
+
+
+// open the workbook read from fl, selecting sheet by name or index
+func newXLSXReader(fl io.Reader, sheet string, timecol string) *xlsxReader {
+	f, err := excelize.OpenReader(fl, excelize.Options{RawCellValue: true})
+	if err != nil {
+		fatal("error opening xlsx input", "err", err)
+	}
+
+	sheets := f.GetSheetList()
+	name := ""
+	for _, s := range sheets {
+		if s == sheet {
+			name = s
+		}
+	}
+	if name == "" {
+		i := 0
+		if sheet != "" {
+			i, err = strconv.Atoi(sheet)
+			if err != nil {
+				i = -1
+			}
+		}
+		if i < 0 || i >= len(sheets) {
+			fatal("sheet not found in xlsx input", "sheet", sheet)
+		}
+		name = sheets[i]
+	}
+
+	rows, err := f.Rows(name)
+	if err != nil {
+		fatal("error reading xlsx sheet", "err", err)
+	}
+	return &xlsxReader{f: f, rows: rows, timecol: timecol, tcol: -1}
+}
+
+
+func (r *xlsxReader) Read() ([]string, error) {
+	if !r.rows.Next() {
+		if err := r.rows.Error(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	record, err := r.rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	// the first row is the header, which sets the width of all rows
+	if r.width == 0 {
+		r.width = len(record)
+		r.tcol = findColumn(record, r.timecol)
+		return record, nil
+	}
+
+	for len(record) < r.width {
+		record = append(record, "")
+	}
+	if r.tcol >= 0 {
+		if serial, err := strconv.ParseFloat(record[r.tcol], 64); err == nil {
+			if t, err := excelize.ExcelDateToTime(serial, false); err == nil {
+				record[r.tcol] = t.Format(timeLayout + ".000")
+			}
+		}
+	}
+	return record, nil
+}
+
+
+func (r *xlsxReader) Close() error {
+	r.rows.Close()
+	return r.f.Close()
+}